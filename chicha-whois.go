@@ -3,39 +3,166 @@ package main
 import (
     "bufio"
     "bytes"
+    "compress/bzip2"
     "compress/gzip"
+    "crypto/sha256"
+    "crypto/tls"
     "encoding/binary"
+    "encoding/csv"
+    "encoding/gob"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
     "fmt"
+    "hash"
     "io"
+    "maps"
+    "math"
     "math/bits"
+    "mime"
+    "mime/multipart"
     "net"
     "net/http"
+    "net/http/cookiejar"
+    "net/netip"
+    "net/smtp"
+    "net/textproto"
+    "net/url"
     "os"
+    "os/exec"
+    "os/user"
     "path/filepath"
+    "regexp"
+    "regexp/syntax"
+    "runtime"
+    "runtime/debug"
+    "slices"
     "sort"
+    "strconv"
     "strings"
+    "sync"
+    "sync/atomic"
+    "time"
 )
 
-// version    - The current application version. Set to "dev" by default.
-// ripedbPath - The file path to the cached RIPE DB file (determined at runtime).
+// version      - The current application version. Set to "dev" by default.
+// ripedbPath   - The file path to the cached RIPE DB file (determined at runtime).
+// quiet        - Suppresses per-CIDR diagnostics (server mode would otherwise flood its log).
+// reproducible - Omits timestamps from generated files, so identical data gives byte-identical files.
+// offline      - Never downloads the database; commands fail if the cache is missing.
+// forceUpdate  - Downloads a fresh database before the command runs, even if a cache exists.
+// lowMemory    - Streams extractions through sorted temporary files instead of holding them in memory.
+// retries      - Set by --retries: how often a failed database download is retried (0 disables retries).
+// httpTimeout  - Set by --timeout: limit for connecting to the mirror and for its response to begin.
+// cidrStrategy - Set by --cidr-strategy: how inetnum ranges become CIDRs (exact, cover or trim; see eachRangePrefix).
+// maxMemory    - Set by --max-memory: extractions larger than this many bytes use the low-memory path (0 = no cap).
+// workers      - Parallelism of database parsing, downloading and batch generation.
+// customDB     - Set by --db: ripedbPath is a user-supplied dump that is never downloaded or replaced.
+// sources      - Set by --sources: data sources merged by precedence for country extraction; empty means RIPE only.
+// maxPrefix    - Set by --ignore-smaller-than: generators drop prefixes longer than /maxPrefix (0 keeps all).
+// granularity  - Set by --granularity: generators widen prefixes longer than /granularity to it (0 keeps them).
+// aggregate    - Set by --aggregate: generators merge adjacent, sibling and overlapping prefixes (see aggregatePrefixes).
+// crlf         - Set by --crlf: generated files use Windows line endings.
+// fileMode     - Set by --mode: permissions of generated files (0 leaves the default 0644).
+// ownerUID     - Set by --owner: owner and group of generated files (-1 leaves them unchanged).
+// backups      - Set by --backups: number of dated copies of overwritten outputs to keep (0 disables them).
+// exitCode     - Set by --exit-code: exit with status 1 on errors, 2 if any output changed and 0 if none did.
+// checksums    - Set by --checksums: every generated file gets a PATH.sha256 in sha256sum format.
+// signer       - Set by --sign: the tool and key used to sign generated files (empty Tool disables signing).
+// statusFile   - Set by --status-file: written after each database update for monitoring (empty disables it).
+// jsonStatus   - Set by --json-errors and --machine: status messages, warnings and errors go to stderr as JSON lines.
+// streamResults - Set by --stream: generators and -search write results while the database is read.
+// withIPv4     - Set by -4, -6 and -46 together with withIPv6: the address families of the country lists
+// withIPv6       written by -dns-acl and -ovpn (IPv4 only by default); see checkAddressFamilies.
+// includePlaceholder - Set by --include-placeholder: placeholder codes such as EU and ZZ can be selected and
+//              get their own files in the -*-all batches (see placeholderCountries).
+// excludeItems - Set by --exclude (repeatable): address space removed from generated lists (see resolveSetOperations).
+// intersectItems - Set by --intersect (repeatable): address space generated lists are limited to.
+// invert       - Set by --invert: generated lists hold the complement of the selection (see invertPrefixes).
+// mrtPath      - Set by --mrt: MRT RIB dump used instead of RIPEstat for announced prefixes (empty uses RIPEstat).
+// cacheDir     - Directory for the downloaded database and temporary files.
 var (
-    version    = "dev"
-    ripedbPath string
+    version      = "dev"
+    ripedbPath   string
+    customDB     bool
+    cacheDir     string
+    quiet        bool
+    reproducible bool
+    offline      bool
+    forceUpdate  bool
+    lowMemory    bool
+    maxMemory    int64
+    cidrStrategy = "exact"
+    retries      = 3
+    httpTimeout  = 60 * time.Second
+    workers      = runtime.NumCPU()
+    sources      []sourceSpec
+    maxPrefix    int
+    granularity  int
+    aggregate    bool
+    invert       bool
+    crlf         bool
+    fileMode     os.FileMode
+    ownerUID     = -1
+    ownerGID     = -1
+    backups      int
+    exitCode     bool
+    checksums    bool
+    signer       signerSpec
+    statusFile   string
+    mrtPath      string
+    jsonStatus   bool
+    withIPv4     = true
+    withIPv6     bool
+
+    streamResults      bool
+    includePlaceholder bool
+    excludeItems       []string
+    intersectItems     []string
 )
 
+// defaultCacheDir returns the cache directory: ~/.ripe.db.cache, or on Windows
+// %LOCALAPPDATA%\chicha-whois unless a cache from an earlier version already exists in the profile.
+func defaultCacheDir(homeDir string) string {
+    legacy := filepath.Join(homeDir, ".ripe.db.cache")
+    if runtime.GOOS != "windows" {
+        return legacy
+    }
+    local := os.Getenv("LOCALAPPDATA")
+    if local == "" {
+        return legacy
+    }
+    if _, err := os.Stat(legacy); err == nil {
+        return legacy
+    }
+    return filepath.Join(local, "chicha-whois")
+}
+
+// ripeDBURL is the public location of the RIPE NCC inetnum split dump.
+const ripeDBURL = "https://ftp.ripe.net/ripe/dbase/split/ripe.db.inetnum.gz"
+
 // ProgressReader is a wrapper around an io.Reader that displays progress while reading bytes.
 type ProgressReader struct {
     Reader    io.Reader // Underlying reader (for example, the HTTP response body).
     Total     int64     // Total size of the data to read (for showing progress percentage).
     Progress  int64     // Number of bytes read so far.
     Operation string    // Description of the current operation, e.g., "Downloading".
+    lastPrint time.Time // When progress was last printed.
 }
 
+// progressInterval limits how often progress lines are redrawn; every write to a Windows
+// console is slow, so redrawing on each read would throttle the download itself.
+const progressInterval = 200 * time.Millisecond
+
 // Read updates ProgressReader's Progress count and prints progress information.
 func (pr *ProgressReader) Read(p []byte) (int, error) {
     n, err := pr.Reader.Read(p)
     pr.Progress += int64(n)
 
+    if err == nil && pr.Progress != pr.Total && time.Since(pr.lastPrint) < progressInterval {
+        return n, err
+    }
+    pr.lastPrint = time.Now()
     if pr.Total > 0 {
         percent := float64(pr.Progress) / float64(pr.Total) * 100
         fmt.Printf("\r%s... %.2f%%", pr.Operation, percent)
@@ -46,15 +173,45 @@ func (pr *ProgressReader) Read(p []byte) (int, error) {
 }
 
 func main() {
+    // Registered first so that it runs last, after the report has been written; it is the only place
+    // the process exits with a status, so every deferred summary is written first.
+    defer exitWithStatus()
+
     // Attempt to determine the current user's home directory.
     homeDir, err := os.UserHomeDir()
     if err != nil {
-        fmt.Println("Error getting home directory:", err)
+        errorln("Error getting home directory:", err)
+        return
+    }
+
+    // Build the default path to the RIPE DB cache file (--db may point elsewhere).
+    cacheDir = defaultCacheDir(homeDir)
+    ripedbPath = filepath.Join(cacheDir, "ripe.db.inetnum")
+
+    // Options such as --lang or --reproducible may appear anywhere; strip them before dispatching the command.
+    lang = detectLanguage()
+    args, err := parseGlobalOptions(os.Args)
+    if err != nil {
+        errorln(err)
         return
     }
+    os.Args = args
+
+    // With --json-errors, the status messages end with a summary line. The option is checked on exit
+    // because a -preset may set it.
+    defer func() {
+        if jsonStatus {
+            writeStatusSummary()
+        }
+    }()
+
+    // The addresses --cidr-strategy added or left out are reported after the command's own output.
+    defer printCIDRCoverage()
 
-    // Build the default path to the RIPE DB cache file.
-    ripedbPath = filepath.Join(homeDir, ".ripe.db.cache/ripe.db.inetnum")
+    // With --report (given here or by a -preset), a JSON summary of what this run generated is written on exit.
+    report.Command = strings.Join(os.Args[1:], " ")
+    report.StartedAt = time.Now().UTC()
+    defer writeRunReport()
 
     // Check if any arguments were provided.
     if len(os.Args) < 2 {
@@ -65,14 +222,94 @@ func main() {
     // The first argument is the command.
     cmd := os.Args[1]
 
+    // Subcommands (chicha-whois acl -f RU) run the flag command they stand for (-dns-acl-f RU).
+    if _, ok := subcommands[cmd]; ok {
+        args, ok := expandSubcommand(os.Args)
+        if !ok {
+            return
+        }
+        os.Args = args
+        cmd = os.Args[1]
+    }
+
+    // -preset NAME runs a command line stored in the configuration file, followed by its hook. The
+    // stored line may carry global options (--report, -46, ...), so it is parsed like the real one.
+    if cmd == "-preset" {
+        args, hook, ok := expandPreset(os.Args[2:])
+        if !ok {
+            return
+        }
+        if args, err = parseGlobalOptions(args); err != nil {
+            errorln(err)
+            return
+        }
+        os.Args = args
+        cmd = os.Args[1]
+        defer hook()
+    }
+
+    // -6 and -46 would be silently ignored by the IPv4-only commands.
+    if !checkAddressFamilies(cmd) {
+        return
+    }
+
+    // --exclude and --intersect are resolved once, before any list is generated.
+    if !resolveSetOperations() {
+        return
+    }
+
     switch cmd {
     case "-h", "--help":
         // Print usage message.
         usage()
 
     case "-l":
-        // Print known country codes (and their full names).
-        showAvailableCountryCodes()
+        // Print the country codes present in the database (and their full names),
+        // optionally only those whose code or name contains the given text.
+        filter := ""
+        if len(os.Args) > 2 {
+            filter = strings.Join(os.Args[2:], " ")
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        showAvailableCountryCodes(filter)
+
+    //--------------------------------------------------------------------
+    // -healthcheck: check the cache and output targets for cron wrappers and liveness probes
+    //--------------------------------------------------------------------
+    case "-healthcheck":
+        opts := healthcheckOptions{MaxAge: 48 * time.Hour, MinBlocks: 1000}
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            if !slices.Contains(commandOptions(cmd), arg) {
+                unknownOption(arg)
+                return
+            }
+            if missingValue(i) {
+                return
+            }
+            value := os.Args[i+1]
+            i++
+            switch arg {
+            case "-max-age":
+                if opts.MaxAge, err = time.ParseDuration(value); err != nil || opts.MaxAge < 0 {
+                    errorf("Invalid -max-age: %s\n", value)
+                    return
+                }
+            case "-min-blocks":
+                if opts.MinBlocks, err = strconv.Atoi(value); err != nil || opts.MinBlocks < 0 {
+                    errorf("Invalid -min-blocks: %s\n", value)
+                    return
+                }
+            case "-o":
+                opts.Targets = append(opts.Targets, value)
+            default:
+                unknownOption(arg)
+                return
+            }
+        }
+        exitStatus = runHealthcheck(opts)
 
     case "-v", "--version":
         // Print application version.
@@ -80,49 +317,157 @@ func main() {
 
     case "-u":
         // Update / download and decompress the RIPE database into the local cache.
-        updateRIPEdb()
+        if offline {
+            errorln("-u downloads the database and cannot be used with --offline.")
+            return
+        }
+        if customDB {
+            errorln("-u updates the downloaded cache and cannot be used with --db.")
+            return
+        }
+        // Optionally a comma-separated list of object types, each kept in its own cache file.
+        objectTypes := []string{"inetnum"}
+        if len(os.Args) > 2 {
+            objectTypes, err = parseObjectTypes(os.Args[2])
+            if err != nil {
+                errorln(err)
+                return
+            }
+        }
+        updateRIPEObjects(objectTypes)
 
     //--------------------------------------------------------------------
     // Old flags that write output to files (left unchanged)
     //--------------------------------------------------------------------
-    case "-dns-acl":
-        // Generate an unfiltered BIND ACL file for the provided country code.
-        if len(os.Args) > 2 {
-            countryCode := os.Args[2]
-            ensureRIPEdb()
-            createBindACL(countryCode)
-        } else {
-            usage()
+    case "-dns-acl", "-dns-acl-f":
+        // Generate a BIND ACL file; -dns-acl-f removes nested subnets.
+        // Several countries (CC or CC=ACLNAME) may be given to emit several ACLs in one run.
+        opts := bindACLOptions{Filtered: cmd == "-dns-acl-f"}
+        var aclName string
+        var specs []aclSpec
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-split":
+                opts.Split = true
+            case "-views":
+                opts.Views = true
+            case "-chunk":
+                if missingValue(i) {
+                    return
+                }
+                n, err := strconv.Atoi(os.Args[i+1])
+                if err != nil || n < 1 {
+                    errorf("Invalid chunk size: %s\n", os.Args[i+1])
+                    return
+                }
+                opts.Chunk = n
+                i++
+            case "-name", "-o":
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-name" {
+                    aclName = os.Args[i+1]
+                } else {
+                    opts.Output = os.Args[i+1]
+                }
+                i++
+            default:
+                countryArg, name, _ := strings.Cut(arg, "=")
+                countryCode, ok := resolveCountryArg(countryArg)
+                if !ok {
+                    return
+                }
+                specs = append(specs, aclSpec{CountryCode: countryCode, Name: name})
+            }
         }
-
-    case "-dns-acl-f":
-        // Generate a filtered BIND ACL (remove nested subnets) for the provided country code.
-        if len(os.Args) > 2 {
-            countryCode := os.Args[2]
-            ensureRIPEdb()
-            createBindACLFiltered(countryCode)
-        } else {
-            usage()
+        if len(specs) == 0 {
+            commandUsage()
+            return
+        }
+        if aclName != "" {
+            if len(specs) != 1 {
+                errorln("-name can only be used with a single country; use CC=NAME for several ACLs.")
+                return
+            }
+            specs[0].Name = aclName
         }
+        if !ensureRIPEdb() {
+            return
+        }
+        createBindACLs(specs, opts)
 
     case "-ovpn":
         // Generate an unfiltered OpenVPN route list for the given country code.
         if len(os.Args) > 2 {
-            countryCode := os.Args[2]
-            ensureRIPEdb()
-            createOpenVPNExclude(countryCode)
+            countryCode, ok := resolveCountryArg(os.Args[2])
+            if !ok {
+                return
+            }
+            if len(os.Args) > 3 {
+                unexpectedArgument(os.Args[3])
+                return
+            }
+            if !ensureRIPEdb() {
+                return
+            }
+            createOpenVPNExclude(countryCode, false)
         } else {
-            usage()
+            commandUsage()
         }
 
     case "-ovpn-f":
         // Generate a filtered OpenVPN route list (remove nested subnets) for the given country code.
         if len(os.Args) > 2 {
-            countryCode := os.Args[2]
-            ensureRIPEdb()
-            createOpenVPNExcludeFiltered(countryCode)
+            countryCode, ok := resolveCountryArg(os.Args[2])
+            if !ok {
+                return
+            }
+            if len(os.Args) > 3 {
+                unexpectedArgument(os.Args[3])
+                return
+            }
+            if !ensureRIPEdb() {
+                return
+            }
+            createOpenVPNExclude(countryCode, true)
         } else {
-            usage()
+            commandUsage()
+        }
+
+    case "-dns-acl-all", "-ovpn-all":
+        // Write one file per country found in the database, reading the database only once.
+        filtered := false
+        outDir := ""
+        for i := 2; i < len(os.Args); i++ {
+            switch os.Args[i] {
+            case "-f":
+                filtered = true
+            case "-o":
+                if missingValue(i) {
+                    return
+                }
+                outDir = os.Args[i+1]
+                i++
+            default:
+                unknownOption(os.Args[i])
+                return
+            }
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        if cmd == "-dns-acl-all" {
+            if outDir == "" {
+                outDir = filepath.Join(homeDir, "acl_all")
+            }
+            createAllCountryFiles(bindACLLines, "//", "acl_%s.conf", filtered, outDir)
+        } else {
+            if outDir == "" {
+                outDir = filepath.Join(homeDir, "openvpn_all")
+            }
+            createAllCountryFiles(openVPNRouteLines(true), "#", "openvpn_exclude_%s.txt", filtered, outDir)
         }
 
     //--------------------------------------------------------------------
@@ -130,43 +475,59 @@ func main() {
     // filter nested subnets, and print to the screen in various formats
     //--------------------------------------------------------------------
     case "-search":
-        if len(os.Args) < 3 {
-            usage()
-            return
-        }
-
-        // Make sure the RIPE DB file is available.
-        ensureRIPEdb()
-
         // Default output mode: just print the found ranges in plain text.
         outputMode := "print"
+        useCache := true
+        sortKey := ""
 
-        // We look for optional sub-flags: -dns, -ovpn, or -ovpn-push.
-        // Once we find something that doesn't match those sub-flags,
-        // we assume it's the actual search parameter (CC:keywords).
+        // Options (-dns, -ovpn, -ovpn-push, -dnsmasq, -nftset, -adguard, -no-cache, --sort) may come before or after the search
+        // parameter (CC:keywords); anything else starting with "-" is an unknown option, and there
+        // is exactly one parameter.
         var searchIndex int
         for i := 2; i < len(os.Args); i++ {
             arg := os.Args[i]
             switch arg {
-            case "-dns":
-                outputMode = "dns"
-            case "-ovpn":
-                outputMode = "ovpn"
-            case "-ovpn-push":
-                outputMode = "ovpn-push"
+            case "-dns", "-ovpn", "-ovpn-push", "-dnsmasq", "-nftset", "-adguard":
+                mode := strings.TrimPrefix(arg, "-")
+                if outputMode != "print" && outputMode != mode {
+                    errorf("Options -%s and %s of -search cannot be combined.\n", outputMode, arg)
+                    return
+                }
+                outputMode = mode
+            case "-no-cache":
+                useCache = false
+            case "--sort", "-sort":
+                if missingValue(i) {
+                    return
+                }
+                sortKey = os.Args[i+1]
+                i++
+                if !slices.Contains(searchSortKeys, sortKey) {
+                    errorf("Invalid sort key %q (use %s)\n", sortKey, strings.Join(searchSortKeys, ", "))
+                    return
+                }
             default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                if searchIndex != 0 {
+                    unexpectedArgument(arg)
+                    return
+                }
                 // This must be the search parameter (e.g. "RU:ok.ru,vk.ru")
                 searchIndex = i
-                break
-            }
-            if searchIndex != 0 {
-                break
             }
         }
 
         // If we never found the search parameter, show usage and exit.
         if searchIndex == 0 {
-            usage()
+            commandUsage()
+            return
+        }
+
+        // Make sure the RIPE DB file is available.
+        if !ensureRIPEdb() {
             return
         }
 
@@ -194,759 +555,17519 @@ func main() {
         for i := range keywords {
             keywords[i] = strings.TrimSpace(keywords[i])
         }
+        if _, err := newSearchMatcher(keywords); err != nil {
+            errorf("Invalid search keyword %v\n", err)
+            return
+        }
+
+        // The country part may also be a full or partial country name.
+        if countryCode != "" {
+            resolved, ok := resolveCountryArg(countryCode)
+            if !ok {
+                return
+            }
+            countryCode = resolved
+        }
 
-        fmt.Printf("Performing a RIPE database search:\n  Country code: '%s', Keywords: %v\n",
+        statusf("Performing a RIPE database search:\n  Country code: '%s', Keywords: %v\n",
             countryCode, keywords)
 
-        // Extract matching CIDRs.
-        ipRanges := extractCIDRsByKeywordsAndCountry(countryCode, keywords, ripedbPath, false)
-        if len(ipRanges) == 0 {
-            fmt.Println("Nothing found for the specified criteria.")
+        // With --stream, matches are printed as the database is read; the list is then in database
+        // order and may contain duplicates and nested subnets, and no result is cached.
+        if streamResults && sortKey == "" && !invert {
+            printHeader, printLine, printFooter := searchPrinter(outputMode, countryCode, keywords, "", nil)
+            found := false
+            ok := eachSearchMatch(countryCode, keywords, ripedbPath, false, func(cidr string, _ searchAttrs) {
+                for _, piece := range setOperation(cidr) {
+                    if !found {
+                        printHeader()
+                        found = true
+                    }
+                    printLine(piece)
+                }
+            })
+            if !ok {
+                return
+            }
+            if !found {
+                statusln("Nothing found for the specified criteria.")
+                return
+            }
+            printFooter()
             return
         }
 
-        // Remove duplicates.
-        ipRanges = removeDuplicates(ipRanges)
-        // Filter out nested subnets (always).
-        ipRanges = filterRedundantCIDRs(ipRanges)
-        // Sort them in ascending order.
-        sort.Strings(ipRanges)
-
-        // Print to the console based on the chosen format.
-        switch outputMode {
-        case "dns":
-            // DNS BIND ACL format, but print to the console instead of writing a file.
-            aclName := countryCode
-            if aclName == "" {
-                aclName = "search"
-            }
-            fmt.Printf("\nacl \"%s\" {\n", aclName)
+        // Extract matching CIDRs, deduplicated, with nested subnets filtered out and sorted,
+        // or the cached result of the same query against the same database.
+        ipRanges, attrs, cached := cachedSearchPrefixList(countryCode, keywords, useCache)
+        if cached {
+            statusln("Using the cached result of this search (the database has not changed).")
+        }
+        // --invert, --exclude and --intersect apply to the result, not to what is cached.
+        if invert {
+            // The complement consists of no database blocks, so it has no attributes.
+            ipRanges, attrs = invertPrefixes(ipRanges, false), map[string]searchAttrs{}
+        }
+        if setOperationsActive() {
+            var kept []string
             for _, cidr := range ipRanges {
-                fmt.Printf("  %s;\n", cidr)
+                for _, piece := range setOperation(cidr) {
+                    if a, ok := attrs[cidr]; ok && piece != cidr {
+                        attrs[piece] = a
+                    }
+                    kept = append(kept, piece)
+                }
             }
-            fmt.Println("};")
+            ipRanges = kept
+        }
+        if len(ipRanges) == 0 {
+            statusln("Nothing found for the specified criteria.")
+            return
+        }
+        if sortKey != "" {
+            sortSearchResults(ipRanges, attrs, sortKey)
+        }
 
-        case "ovpn":
-            // OpenVPN client-style format (using net_gateway).
-            cc := countryCode
-            if cc == "" {
-                cc = "SEARCH"
-            }
-            fmt.Println("# Redirect all traffic through VPN")
-            fmt.Println("redirect-gateway def1")
-            fmt.Println()
-            fmt.Printf("# Exclude %s IP ranges from the VPN\n", strings.ToUpper(cc))
+        // Print to the console based on the chosen format.
+        printHeader, printLine, printFooter := searchPrinter(outputMode, countryCode, keywords, sortKey, attrs)
+        printHeader()
+        for _, cidr := range ipRanges {
+            printLine(cidr)
+        }
+        printFooter()
 
-            for _, cidr := range ipRanges {
-                startIP, netmask, err := cidrToRoute(cidr)
-                if err != nil {
-                    continue
+    case "-search-index":
+        // Build (or remove) the optional trigram index used by -search.
+        remove := false
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-remove":
+                remove = true
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                } else {
+                    unexpectedArgument(arg)
                 }
-                line := fmt.Sprintf("route %s %s net_gateway", startIP, netmask)
-                fmt.Println(line)
+                return
             }
+        }
+        runSearchIndex(remove)
+
+    case "-object":
+        // Print an object from the cache together with the objects it references.
+        if len(os.Args) < 4 {
+            commandUsage()
+            return
+        }
+        showObject(os.Args[2], strings.Join(os.Args[3:], " "))
 
-        case "ovpn-push":
-            // OpenVPN server-style format (push directives).
-            cc := countryCode
-            if cc == "" {
-                cc = "SEARCH"
+    case "-route-audit":
+        // Compare inetnum and route objects of a country or an ASN.
+        if len(os.Args) < 3 {
+            commandUsage()
+            return
+        }
+        target := strings.ToUpper(os.Args[2])
+        if !asnRe.MatchString(target) {
+            countryCode, ok := resolveCountryArg(os.Args[2])
+            if !ok {
+                return
             }
-            fmt.Println("# Redirect all traffic through VPN (server pushes these directives)")
-            fmt.Println("push \"redirect-gateway def1\"")
-            fmt.Println()
-            fmt.Printf("# Exclude %s IP ranges from the VPN (pushed to clients)\n", strings.ToUpper(cc))
+            target = countryCode
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        auditRoutes(target)
 
-            for _, cidr := range ipRanges {
-                startIP, netmask, err := cidrToRoute(cidr)
-                if err != nil {
-                    continue
+    case "-rpsl-filter":
+        // Evaluate an RPSL filter against the cached route, as-set and inetnum objects.
+        prefixListName, output, filterText := "", "", ""
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-prefix-list", "-o":
+                if missingValue(i) {
+                    return
                 }
-                line := fmt.Sprintf("push \"route %s %s net_gateway\"", startIP, netmask)
-                fmt.Println(line)
+                if arg == "-o" {
+                    output = os.Args[i+1]
+                } else {
+                    prefixListName = os.Args[i+1]
+                }
+                i++
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                filterText = strings.TrimSpace(filterText + " " + arg)
             }
+        }
+        if filterText == "" {
+            commandUsage()
+            return
+        }
+        createRPSLFilterList(filterText, prefixListName, output)
 
-        default:
-            // If no format specified, just print the final CIDR list.
-            fmt.Println("Found CIDR ranges (after filtering):")
-            for _, cidr := range ipRanges {
-                fmt.Println(" ", cidr)
+    case "-announced", "-announced-country":
+        // List the prefixes announced by ASNs, or by the ASNs of a country, according to RIPEstat.
+        filtered := false
+        output := ""
+        var targets []string
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-f":
+                filtered = true
+            case "-o":
+                if missingValue(i) {
+                    return
+                }
+                output = os.Args[i+1]
+                i++
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                targets = append(targets, arg)
             }
         }
+        if len(targets) == 0 {
+            commandUsage()
+            return
+        }
+        if cmd == "-announced" {
+            for i, target := range targets {
+                targets[i] = strings.ToUpper(target)
+                if !asnRe.MatchString(targets[i]) {
+                    errorf("Invalid ASN: %s (expected e.g. AS12389)\n", target)
+                    return
+                }
+            }
+            createAnnouncedList(targets, "", filtered, output)
+            return
+        }
+        if len(targets) != 1 {
+            commandUsage()
+            return
+        }
+        countryCode, ok := resolveCountryArg(targets[0])
+        if !ok {
+            return
+        }
+        createAnnouncedList(nil, countryCode, filtered, output)
 
-    default:
-        usage()
-    }
-}
-
-// usage prints a help message describing all command-line options.
-func usage() {
-    fmt.Println(`Usage: chicha-whois <option>
+    case "-mrt-origins":
+        // Print the prefix-to-origin table of an MRT RIB dump.
+        output, path := "", ""
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-o":
+                if missingValue(i) {
+                    return
+                }
+                output = os.Args[i+1]
+                i++
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                if path != "" {
+                    unexpectedArgument(arg)
+                    return
+                }
+                path = arg
+            }
+        }
+        if path == "" {
+            commandUsage()
+            return
+        }
+        createMRTOriginTable(path, output)
 
-Options:
-  -h, --help               Show this help message
-  -v, --version            Show application version
-  -u                       Update local RIPE NCC database cache
-  -l                       List available country codes
+    case "-announced-compare":
+        // Compare the space registered to a country with what its ASNs announce.
+        output, countryArg := "", ""
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-o":
+                if missingValue(i) {
+                    return
+                }
+                output = os.Args[i+1]
+                i++
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                if countryArg != "" {
+                    unexpectedArgument(arg)
+                    return
+                }
+                countryArg = arg
+            }
+        }
+        countryCode, ok := resolveCountryArg(countryArg)
+        if !ok {
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        compareAnnounced(countryCode, output)
 
-  # Generate DNS Bind ACL (unfiltered / filtered) [writes output to a file]
-  -dns-acl COUNTRYCODE     Generate unfiltered DNS ACL file for BIND
-  -dns-acl-f COUNTRYCODE   Generate filtered DNS ACL file for BIND (removes nested subnets)
+    case "-geofeed":
+        // Merge RFC 8805 geofeeds with the country's inetnum data and export the result.
+        var opts geofeedOptions
+        countryArg := ""
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-url", "-prefer", "-region", "-city", "-o":
+                if missingValue(i) {
+                    return
+                }
+                value := os.Args[i+1]
+                i++
+                switch arg {
+                case "-url":
+                    opts.URLs = append(opts.URLs, value)
+                case "-prefer":
+                    if value != "geofeed" && value != "ripe" {
+                        errorf("Invalid -prefer value %q: use geofeed or ripe\n", value)
+                        return
+                    }
+                    opts.PreferRIPE = value == "ripe"
+                case "-region":
+                    opts.Region = value
+                case "-city":
+                    opts.City = value
+                case "-o":
+                    opts.Output = value
+                }
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                if countryArg != "" {
+                    unexpectedArgument(arg)
+                    return
+                }
+                countryArg = arg
+            }
+        }
+        countryCode, ok := resolveCountryArg(countryArg)
+        if !ok {
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createGeofeedExport(countryCode, opts)
 
-  # Generate OpenVPN exclude-route list (unfiltered / filtered) [writes output to a file]
-  -ovpn COUNTRYCODE        Generate unfiltered OpenVPN routes
-  -ovpn-f COUNTRYCODE      Generate filtered OpenVPN routes (removes nested subnets)
+    case "-geofeeds":
+        // List the geofeed URLs announced in the cached objects, optionally fetching and validating them.
+        countryCode, output, fetch := "", "", false
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-fetch":
+                fetch = true
+            case "-country", "-o":
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-o" {
+                    output = os.Args[i+1]
+                } else {
+                    resolved, ok := resolveCountryArg(os.Args[i+1])
+                    if !ok {
+                        return
+                    }
+                    countryCode = resolved
+                }
+                i++
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                } else {
+                    unexpectedArgument(arg)
+                }
+                return
+            }
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        listGeofeeds(countryCode, fetch, output)
 
-  # New: Search by country code (optional) AND/OR keywords, filter subnets, print results to screen
+    case "-compare":
+        // Diff the RIPE country mapping against another source (GeoLite2, CSV, RIR stats, geofeed).
+        countryCode, output, sourceArg := "", "", ""
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-country", "-o":
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-o" {
+                    output = os.Args[i+1]
+                } else {
+                    resolved, ok := resolveCountryArg(os.Args[i+1])
+                    if !ok {
+                        return
+                    }
+                    countryCode = resolved
+                }
+                i++
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                if sourceArg != "" {
+                    unexpectedArgument(arg)
+                    return
+                }
+                sourceArg = arg
+            }
+        }
+        if sourceArg == "" {
+            commandUsage()
+            return
+        }
+        specs, err := parseSources(sourceArg)
+        if err != nil {
+            errorln(err)
+            return
+        }
+        if len(specs) != 1 || specs[0].Kind == "ripe" {
+            errorf("-compare needs one non-RIPE source, e.g. geolite2:PATH\n")
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        compareSources(specs[0], countryCode, output)
+
+    case "-merge-report":
+        // List the ranges on which the --sources disagree.
+        if len(sources) == 0 {
+            errorf("-merge-report needs --sources\n")
+            return
+        }
+        output := ""
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; {
+            case arg == "-o":
+                if missingValue(i) {
+                    return
+                }
+                output = os.Args[i+1]
+                i++
+            case strings.HasPrefix(arg, "-"):
+                unknownOption(arg)
+                return
+            default:
+                unexpectedArgument(arg)
+                return
+            }
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        writeMergeReport(output)
+
+    case "-rdns":
+        // List reverse delegations (domain objects) of a country or prefix, or write stub/forward zones.
+        zoneType, output, target := "", "", ""
+        bind := false
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-stub", "-forward":
+                zoneType = strings.TrimPrefix(arg, "-")
+            case "-bind":
+                bind = true
+            case "-unbound":
+                bind = false
+            case "-o":
+                if missingValue(i) {
+                    return
+                }
+                output = os.Args[i+1]
+                i++
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                if target != "" {
+                    unexpectedArgument(arg)
+                    return
+                }
+                target = arg
+            }
+        }
+        if target == "" {
+            commandUsage()
+            return
+        }
+        if _, _, err := net.ParseCIDR(target); err != nil {
+            countryCode, ok := resolveCountryArg(target)
+            if !ok {
+                return
+            }
+            target = countryCode
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        reverseDNS(target, zoneType, bind, output)
+
+    case "-contact":
+        // Search person and role objects by nic-hdl, name or e-mail.
+        full := false
+        blocksCountry, query := "", ""
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-full":
+                full = true
+            case "-blocks":
+                if missingValue(i) {
+                    return
+                }
+                countryCode, ok := resolveCountryArg(os.Args[i+1])
+                if !ok {
+                    return
+                }
+                blocksCountry = countryCode
+                i++
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                if query != "" {
+                    unexpectedArgument(arg)
+                    return
+                }
+                query = arg
+            }
+        }
+        if query == "" {
+            commandUsage()
+            return
+        }
+        if blocksCountry != "" && !ensureRIPEdb() {
+            return
+        }
+        searchContacts(query, full, blocksCountry)
+
+    case "-lookup":
+        // Print the country and most specific block of addresses ("-" reads them from stdin).
+        asJSON := false
+        var addresses []string
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-json":
+                asJSON = true
+            case "-":
+                scanner := bufio.NewScanner(os.Stdin)
+                for scanner.Scan() {
+                    if line := strings.TrimSpace(scanner.Text()); line != "" {
+                        addresses = append(addresses, line)
+                    }
+                }
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                addresses = append(addresses, arg)
+            }
+        }
+        if len(addresses) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        lookupAddresses(addresses, asJSON)
+
+    case "-abuse":
+        // Print the abuse mailbox responsible for an address or prefix.
+        if len(os.Args) < 3 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        showAbuseContact(os.Args[2])
+
+    case "-csv":
+        // Export the inetnum blocks of a country as CSV (or TSV), optionally with abuse mailboxes.
+        var opts csvExportOptions
+        useProfile := false
+        countryArg, profileName := "", ""
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-abuse":
+                opts.Abuse = true
+            case "-tsv":
+                opts.TSV = true
+            case "-o", "-profile", "-fields":
+                if missingValue(i) {
+                    return
+                }
+                switch arg {
+                case "-o":
+                    opts.Output = os.Args[i+1]
+                case "-profile":
+                    useProfile, profileName = true, os.Args[i+1]
+                case "-fields":
+                    for _, field := range strings.Split(os.Args[i+1], ",") {
+                        field = strings.ToLower(strings.TrimSpace(field))
+                        if !slices.Contains(csvExportFields, field) {
+                            errorf("Unknown CSV field %q (use %s)\n", field, strings.Join(csvExportFields, ", "))
+                            return
+                        }
+                        opts.Fields = append(opts.Fields, field)
+                    }
+                }
+                i++
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                if countryArg != "" {
+                    unexpectedArgument(arg)
+                    return
+                }
+                countryArg = arg
+            }
+        }
+        countryCode, ok := resolveCountryArg(countryArg)
+        if !ok {
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        if useProfile && (opts.Fields != nil || opts.TSV) {
+            errorln("-fields and -tsv cannot be combined with -profile; set the columns and delimiter in the profile.")
+            return
+        }
+        if useProfile {
+            createProfileCSVExport(countryCode, profileName, opts.Abuse, opts.Output)
+        } else {
+            createCSVExport(countryCode, opts)
+        }
+
+    case "-phpipam":
+        // Export the inetnum blocks of a country as a phpIPAM subnet import file.
+        section, field := "Customers", "country"
+        output, countryArg := "", ""
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-section", "-field", "-o":
+                if missingValue(i) {
+                    return
+                }
+                switch arg {
+                case "-section":
+                    section = os.Args[i+1]
+                case "-field":
+                    field = os.Args[i+1]
+                case "-o":
+                    output = os.Args[i+1]
+                }
+                i++
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                if countryArg != "" {
+                    unexpectedArgument(arg)
+                    return
+                }
+                countryArg = arg
+            }
+        }
+        countryCode, ok := resolveCountryArg(countryArg)
+        if !ok {
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createPhpIPAMExport(countryCode, section, field, output)
+
+    //--------------------------------------------------------------------
+    // -logstats: per-country summary of a web server access log (stdin)
+    //--------------------------------------------------------------------
+    case "-logstats":
+        if !ensureRIPEdb() {
+            return
+        }
+        summarizeAccessLog(os.Stdin)
+
+    //--------------------------------------------------------------------
+    // -crowdsec: CrowdSec decision import file (JSON or CSV, scope=range)
+    //--------------------------------------------------------------------
+    case "-crowdsec":
+        // Optional sub-flags come first, the country code is the last argument.
+        format := "json"
+        duration := "24h"
+        decisionType := "ban"
+        var countryCode string
+        for i := 2; i < len(os.Args); i++ {
+            switch os.Args[i] {
+            case "-csv":
+                format = "csv"
+            case "-json":
+                format = "json"
+            case "-duration", "-type":
+                if missingValue(i) {
+                    return
+                }
+                if os.Args[i] == "-duration" {
+                    duration = os.Args[i+1]
+                } else {
+                    decisionType = os.Args[i+1]
+                }
+                i++
+            default:
+                if strings.HasPrefix(os.Args[i], "-") {
+                    unknownOption(os.Args[i])
+                    return
+                }
+                if countryCode != "" {
+                    unexpectedArgument(os.Args[i])
+                    return
+                }
+                countryCode = os.Args[i]
+            }
+        }
+        if countryCode == "" {
+            commandUsage()
+            return
+        }
+        countryCode, ok := resolveCountryArg(countryCode)
+        if !ok {
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createCrowdSecDecisions(countryCode, format, duration, decisionType)
+
+    //--------------------------------------------------------------------
+    // -redis: load the filtered prefixes into Redis with an atomic swap
+    //--------------------------------------------------------------------
+    case "-redis":
+        opts := redisExportOptions{Addr: "127.0.0.1:6379"}
+        var countryCode string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-ranges":
+                opts.Ranges = true
+            case "-addr", "-password", "-db", "-key":
+                if missingValue(i) {
+                    return
+                }
+                value := os.Args[i+1]
+                i++
+                switch arg {
+                case "-addr":
+                    opts.Addr = value
+                case "-password":
+                    opts.Password = value
+                case "-db":
+                    opts.DB = value
+                case "-key":
+                    opts.Key = value
+                }
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                if countryCode != "" {
+                    unexpectedArgument(arg)
+                    return
+                }
+                countryCode = arg
+            }
+        }
+        if countryCode == "" {
+            commandUsage()
+            return
+        }
+        countryCode, ok := resolveCountryArg(countryCode)
+        if !ok {
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        exportToRedis(countryCode, opts)
+
+    //--------------------------------------------------------------------
+    // -pgsql: COPY-ready PostgreSQL dump (optionally loaded via psql)
+    //--------------------------------------------------------------------
+    case "-pgsql":
+        table := "ripe_country_prefixes"
+        var connString, countryCode string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-table", "-load":
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-table" {
+                    table = os.Args[i+1]
+                } else {
+                    connString = os.Args[i+1]
+                }
+                i++
+            default:
+                if strings.HasPrefix(arg, "-") {
+                    unknownOption(arg)
+                    return
+                }
+                if countryCode != "" {
+                    unexpectedArgument(arg)
+                    return
+                }
+                countryCode = arg
+            }
+        }
+        if countryCode == "" {
+            commandUsage()
+            return
+        }
+        countryCode, ok := resolveCountryArg(countryCode)
+        if !ok {
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createPostgresDump(countryCode, table, connString)
+
+    //--------------------------------------------------------------------
+    // -clickhouse: TSV data + SQL schema (table and ip_trie dictionary)
+    //--------------------------------------------------------------------
+    case "-clickhouse":
+        table := "ripe_country_ranges"
+        var countryCode string
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; {
+            case arg == "-table":
+                if missingValue(i) {
+                    return
+                }
+                table = os.Args[i+1]
+                i++
+            case strings.HasPrefix(arg, "-"):
+                unknownOption(arg)
+                return
+            case countryCode != "":
+                unexpectedArgument(arg)
+                return
+            default:
+                countryCode = arg
+            }
+        }
+        if countryCode == "" {
+            commandUsage()
+            return
+        }
+        countryCode, ok := resolveCountryArg(countryCode)
+        if !ok {
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createClickHouseExport(countryCode, table)
+
+    //--------------------------------------------------------------------
+    // -pf: pf anchor with a table of the country set (optionally loaded with pfctl)
+    //--------------------------------------------------------------------
+    case "-pf":
+        opts := pfAnchorOptions{Anchor: "chicha-whois", Action: "block"}
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-reload":
+                opts.Reload = true
+            case "-block", "-pass":
+                opts.Action = strings.TrimPrefix(arg, "-")
+            case "-anchor", "-table", "-table-file", "-o":
+                if missingValue(i) {
+                    return
+                }
+                value := os.Args[i+1]
+                i++
+                switch arg {
+                case "-anchor":
+                    opts.Anchor = value
+                case "-table":
+                    opts.Table = value
+                case "-table-file":
+                    opts.TableFile = value
+                case "-o":
+                    opts.Output = value
+                }
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createPFAnchor(codes, opts)
+
+    //--------------------------------------------------------------------
+    // -ipfw: ipfw table script with an atomic swap through a spare table
+    //--------------------------------------------------------------------
+    case "-ipfw":
+        table, spare := 1, 0
+        var output string
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-table", "-swap-table":
+                if missingValue(i) {
+                    return
+                }
+                n, err := strconv.Atoi(os.Args[i+1])
+                if err != nil || n < 0 {
+                    errorf("Invalid table number: %s\n", os.Args[i+1])
+                    return
+                }
+                if arg == "-table" {
+                    table = n
+                } else {
+                    spare = n
+                }
+                i++
+            case "-o":
+                if missingValue(i) {
+                    return
+                }
+                output = os.Args[i+1]
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if spare == 0 {
+            spare = table + 1
+        }
+        if spare == table {
+            errorln("The swap table must differ from the table.")
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createIPFWScript(codes, table, spare, output)
+
+    //--------------------------------------------------------------------
+    // -edgeos: config.gateway.json fragment with one network-group per country
+    //--------------------------------------------------------------------
+    case "-edgeos":
+        prefix := "chicha_"
+        var output string
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-prefix", "-o":
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-prefix" {
+                    prefix = os.Args[i+1]
+                } else {
+                    output = os.Args[i+1]
+                }
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createEdgeOSConfig(codes, prefix, output)
+
+    //--------------------------------------------------------------------
+    // -junos: set-style prefix-lists (and optionally a firewall filter) for Juniper devices
+    //--------------------------------------------------------------------
+    case "-junos":
+        opts := junosOptions{Action: "discard"}
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-prefix", "-filter", "-action", "-o":
+                if missingValue(i) {
+                    return
+                }
+                value := os.Args[i+1]
+                switch arg {
+                case "-prefix":
+                    opts.Prefix = value
+                case "-filter":
+                    opts.Filter = value
+                case "-action":
+                    if value != "discard" && value != "accept" {
+                        errorf("Invalid action: %s (expected discard or accept)\n", value)
+                        return
+                    }
+                    opts.Action = value
+                case "-o":
+                    opts.Output = value
+                }
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createJunOSConfig(codes, opts)
+
+    //--------------------------------------------------------------------
+    // -bird, -frr: prefix set/list and optional blackhole routes for routing daemons
+    //--------------------------------------------------------------------
+    case "-bird", "-frr":
+        opts := routingOptions{Daemon: strings.TrimPrefix(cmd, "-")}
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-blackhole":
+                opts.Blackhole = true
+            case "-name", "-o":
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-name" {
+                    opts.Name = os.Args[i+1]
+                } else {
+                    opts.Output = os.Args[i+1]
+                }
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createRoutingConfig(codes, opts)
+
+    //--------------------------------------------------------------------
+    // -nginx: allow/deny block or geo map for the nginx access and geo modules
+    //--------------------------------------------------------------------
+    case "-nginx":
+        opts := nginxOptions{Mode: "allow", Variable: "country"}
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-mode", "-var", "-o":
+                if missingValue(i) {
+                    return
+                }
+                value := os.Args[i+1]
+                switch arg {
+                case "-mode":
+                    if value != "allow" && value != "deny" && value != "geo" {
+                        errorf("Invalid mode: %s (expected allow, deny or geo)\n", value)
+                        return
+                    }
+                    opts.Mode = value
+                case "-var":
+                    opts.Variable = strings.TrimPrefix(value, "$")
+                case "-o":
+                    opts.Output = value
+                }
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createNginxConfig(codes, opts)
+
+    //--------------------------------------------------------------------
+    // -squid: src (or dst) acl for the Squid proxy, inline or in a list file
+    //--------------------------------------------------------------------
+    case "-squid":
+        opts := squidOptions{Type: "src"}
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-dst":
+                opts.Type = "dst"
+            case "-name", "-list", "-o":
+                if missingValue(i) {
+                    return
+                }
+                value := os.Args[i+1]
+                switch arg {
+                case "-name":
+                    opts.Name = value
+                case "-list":
+                    opts.ListFile = value
+                case "-o":
+                    opts.Output = value
+                }
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createSquidConfig(codes, opts)
+
+    //--------------------------------------------------------------------
+    // -postfix: cidr lookup table for smtpd client restrictions
+    //--------------------------------------------------------------------
+    case "-postfix":
+        action, output := "REJECT country-blocked", ""
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-action", "-o":
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-action" {
+                    action = strings.TrimSpace(os.Args[i+1])
+                    if action == "" || strings.ContainsAny(action, "\r\n") {
+                        errorf("Invalid action: %q (expected one line, e.g. \"REJECT country-blocked\")\n", os.Args[i+1])
+                        return
+                    }
+                } else {
+                    output = os.Args[i+1]
+                }
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createPostfixTable(codes, action, output)
+
+    //--------------------------------------------------------------------
+    // -yaml, -ansible: prefix lists as YAML or as an Ansible vars file
+    //--------------------------------------------------------------------
+    case "-yaml", "-ansible":
+        opts := yamlOptions{Ansible: cmd == "-ansible"}
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch {
+            case arg == "-o" || arg == "-var" && opts.Ansible:
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-var" {
+                    opts.Variable = os.Args[i+1]
+                    if !ansibleVarRe.MatchString(opts.Variable) {
+                        errorf("Invalid Ansible variable name: %s\n", opts.Variable)
+                        return
+                    }
+                } else {
+                    opts.Output = os.Args[i+1]
+                }
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createYAMLExport(codes, opts)
+
+    //--------------------------------------------------------------------
+    // -unifi-push: create or update a UniFi firewall address group
+    //--------------------------------------------------------------------
+    case "-unifi-push":
+        var controllerName, groupName string
+        var dryRun bool
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-dry-run":
+                dryRun = true
+            case "-controller", "-group":
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-controller" {
+                    controllerName = os.Args[i+1]
+                } else {
+                    groupName = os.Args[i+1]
+                }
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        pushUniFi(codes, controllerName, groupName, dryRun)
+
+    //--------------------------------------------------------------------
+    // -opnsense-push: create or update an OPNsense alias and reconfigure
+    //--------------------------------------------------------------------
+    case "-opnsense-push":
+        var firewallName, aliasName string
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-firewall", "-alias":
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-firewall" {
+                    firewallName = os.Args[i+1]
+                } else {
+                    aliasName = os.Args[i+1]
+                }
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        pushOPNsense(codes, firewallName, aliasName)
+
+    //--------------------------------------------------------------------
+    // -pfsense-push: create or update a pfSense alias and reload the filter
+    //--------------------------------------------------------------------
+    case "-pfsense-push":
+        var firewallName, aliasName string
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-firewall", "-alias":
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-firewall" {
+                    firewallName = os.Args[i+1]
+                } else {
+                    aliasName = os.Args[i+1]
+                }
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        pushPfSense(codes, firewallName, aliasName)
+
+    //--------------------------------------------------------------------
+    // -netbox-push: sync country prefixes into NetBox as tagged prefixes or aggregates
+    //--------------------------------------------------------------------
+    case "-netbox-push":
+        instanceName, kind, tagPrefix := "", "prefix", "country-"
+        var dryRun bool
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-dry-run":
+                dryRun = true
+            case "-instance", "-as", "-tag-prefix":
+                if missingValue(i) {
+                    return
+                }
+                value := os.Args[i+1]
+                i++
+                switch arg {
+                case "-instance":
+                    instanceName = value
+                case "-tag-prefix":
+                    tagPrefix = value
+                case "-as":
+                    if value != "prefix" && value != "aggregate" {
+                        commandUsage()
+                        return
+                    }
+                    kind = value
+                }
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        pushNetBox(codes, instanceName, kind, tagPrefix, dryRun)
+
+    //--------------------------------------------------------------------
+    // -mikrotik: RouterOS .rsc script (v6 or v7 syntax, optionally split)
+    //--------------------------------------------------------------------
+    case "-mikrotik":
+        opts := routerOSScriptOptions{Version: 7}
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-list", "-ros", "-split", "-o":
+                if missingValue(i) {
+                    return
+                }
+                value := os.Args[i+1]
+                i++
+                switch arg {
+                case "-list":
+                    opts.List = value
+                case "-o":
+                    opts.Output = value
+                case "-ros":
+                    if value != "6" && value != "7" {
+                        errorf("Unsupported RouterOS version: %s (expected 6 or 7)\n", value)
+                        return
+                    }
+                    opts.Version, _ = strconv.Atoi(value)
+                case "-split":
+                    n, err := strconv.Atoi(value)
+                    if err != nil || n < 1 {
+                        errorf("Invalid chunk size: %s\n", value)
+                        return
+                    }
+                    opts.Split = n
+                }
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        createRouterOSScript(codes, opts)
+
+    //--------------------------------------------------------------------
+    // -mikrotik-push: incremental address-list sync over the RouterOS API
+    //--------------------------------------------------------------------
+    case "-mikrotik-push":
+        var routerName, list string
+        var dryRun bool
+        var codes []string
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            switch arg {
+            case "-dry-run":
+                dryRun = true
+            case "-router", "-list":
+                if missingValue(i) {
+                    return
+                }
+                if arg == "-router" {
+                    routerName = os.Args[i+1]
+                } else {
+                    list = os.Args[i+1]
+                }
+                i++
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                codes = append(codes, countryCode)
+            }
+        }
+        if len(codes) == 0 {
+            commandUsage()
+            return
+        }
+        if !ensureRIPEdb() {
+            return
+        }
+        pushMikroTik(codes, routerName, list, dryRun)
+
+    //--------------------------------------------------------------------
+    // -serve: long-running daemon with periodic updates and HTTP endpoints
+    //--------------------------------------------------------------------
+    case "-serve":
+        opts := serverOptions{Listen: ":8080", Interval: 24 * time.Hour}
+        for i := 2; i < len(os.Args); i++ {
+            arg := os.Args[i]
+            if !slices.Contains(commandOptions(cmd), arg) {
+                unknownOption(arg)
+                return
+            }
+            if missingValue(i) {
+                return
+            }
+            value := os.Args[i+1]
+            i++
+            switch arg {
+            case "-listen":
+                opts.Listen = value
+            case "-grpc-listen":
+                opts.GRPCListen = value
+            case "-interval":
+                interval, err := time.ParseDuration(value)
+                if err != nil {
+                    errorf("Invalid interval %q: %v\n", value, err)
+                    return
+                }
+                opts.Interval = interval
+            case "-track":
+                for _, cc := range strings.Split(value, ",") {
+                    if cc = strings.ToUpper(strings.TrimSpace(cc)); cc != "" {
+                        opts.Track = append(opts.Track, cc)
+                    }
+                }
+            case "-email":
+                config, err := loadConfig()
+                if err != nil {
+                    errorln(err)
+                    return
+                }
+                settings, ok := configEntry(config.Email, value, "email")
+                if !ok {
+                    return
+                }
+                opts.Email = &settings
+            case "-notify":
+                notifiers, ok := loadNotifiers(strings.Split(value, ","))
+                if !ok {
+                    return
+                }
+                opts.Notify = notifiers
+            default:
+                unknownOption(arg)
+                return
+            }
+        }
+        runServer(opts)
+
+    case "-trend":
+        // Print or export the per-country counts recorded by -serve after each update.
+        var countries []string
+        var since time.Time
+        threshold, asCSV, output := 1.0, false, ""
+        for i := 2; i < len(os.Args); i++ {
+            switch arg := os.Args[i]; arg {
+            case "-csv":
+                asCSV = true
+            case "-since", "-threshold", "-o":
+                if missingValue(i) {
+                    return
+                }
+                value := os.Args[i+1]
+                i++
+                switch arg {
+                case "-since":
+                    age, err := time.ParseDuration(value)
+                    if err != nil {
+                        errorf("Invalid duration %q: %v\n", value, err)
+                        return
+                    }
+                    since = time.Now().Add(-age)
+                case "-threshold":
+                    percent, err := strconv.ParseFloat(value, 64)
+                    if err != nil || percent < 0 {
+                        errorf("Invalid threshold %q\n", value)
+                        return
+                    }
+                    threshold = percent
+                default:
+                    output = value
+                }
+            default:
+                countryCode, ok := resolveCountryArg(arg)
+                if !ok {
+                    return
+                }
+                countries = append(countries, countryCode)
+            }
+        }
+        printTrend(countries, since, threshold, asCSV, output)
+
+    case "-grpc-proto":
+        // Print the .proto file describing the gRPC API.
+        fmt.Print(grpcProtoDefinition)
+
+    case "-netset":
+        // Generate a FireHOL-compatible .netset file (filtered) for the given country code.
+        if len(os.Args) > 2 {
+            countryCode, ok := resolveCountryArg(os.Args[2])
+            if !ok {
+                return
+            }
+            if !ensureRIPEdb() {
+                return
+            }
+            createFireHOLNetset(countryCode)
+        } else {
+            commandUsage()
+        }
+
+    case "-p2p":
+        // Generate a PeerGuardian (.p2p) blocklist with the original inetnum ranges.
+        if len(os.Args) > 2 {
+            countryCode, ok := resolveCountryArg(os.Args[2])
+            if !ok {
+                return
+            }
+            if !ensureRIPEdb() {
+                return
+            }
+            createP2PBlocklist(countryCode)
+        } else {
+            commandUsage()
+        }
+
+    default:
+        unknownCommand(cmd)
+    }
+}
+
+// usage prints a help message describing all command-line options.
+func usage() {
+    fmt.Println(usageText)
+}
+
+// usageText is the help message; the synopsis lines ("  -command [-option ...]") also drive the
+// per-command help and the option suggestions (see usageEntries).
+const usageText = `Usage: chicha-whois <option>
+       chicha-whois <subcommand> [options]
+
+Subcommands (shorter names for the options below; options may be written -name, --name or --name=value):
+  update [TYPES]                       = -u [TYPES]
+  acl [-f] [--all] [OPTIONS] CC...     = -dns-acl, -dns-acl-f (-f/--filtered), -dns-acl-all (--all)
+  ovpn [-f] [--all] [OPTIONS] CC       = -ovpn, -ovpn-f, -ovpn-all
+  search [OPTIONS] CC:kw1,kw2          = -search
+  lookup [--json] IP... | -            = -lookup
+  serve [OPTIONS]                      = -serve
+  help [SUBCOMMAND]                    Help of one command, e.g. chicha-whois help acl
+
+Options:
+  -h, --help               Show this help message
+  -v, --version            Show application version
+  -u [TYPES]               Update local RIPE NCC database cache; TYPES is a comma-separated list of object
+                           types (inetnum,inet6num,route,route6,aut-num,organisation,domain,role,person,mntner,as-set)
+                           or "all"; each type gets its own cache file (default: inetnum).
+                           "full" downloads the combined ripe.db.gz and indexes it by object type
+
+  # Cross-object query (needs -u full or the per-type caches of the objects involved)
+  -object TYPE KEY         Print an object with the organisation, contacts, maintainers and origin it
+                           references; inetnum also lists its route objects, route its inetnum blocks
+  #   chicha-whois -object aut-num AS3333
+  #   chicha-whois -object inetnum 193.0.0.0 - 193.0.7.255
+
+  # Contact search over person and role objects (needs -u role,person or -u full)
+  -contact [-full] [-blocks COUNTRYCODE] QUERY
+                           Match nic-hdl, name or e-mail; addresses and phones are hidden and e-mails masked
+                           unless -full is given; -blocks lists the country's inetnums naming the matches
+
+  # Abuse contacts (abuse-c of the block or its organisation; needs -u role,organisation or -u full)
+  -lookup [-json] IP... | -
+                           Print the country, most specific inetnum and netname of each address
+                           ("-" reads addresses from stdin, one per line)
+  -abuse IP|PREFIX         Print the abuse mailbox of the most specific block that has one
+  -csv [-abuse] [-fields LIST] [-tsv] [-o FILE] COUNTRYCODE
+                           Generate ripe_CC.csv (inetnum, cidr, country, netname[, abuse_mailbox][, origin_asn]);
+                           -fields picks the columns from inetnum, cidr, cidrs, country, netname, descr, org,
+                           mnt-by, last-modified, status, abuse_mailbox, origin_asn; -tsv writes ripe_CC.tsv
+                           with tabs. cidr is the smallest prefix covering the block, cidrs the space-separated
+                           prefixes it becomes under --cidr-strategy
+  -csv -profile NAME [-o FILE] COUNTRYCODE
+                           Generate NAME_CC.csv with the columns of the "csv_profiles" entry NAME of the
+                           configuration file; templates use {inetnum} {start} {end} {cidr} {network} {bits}
+                           {netmask} {size} {country} {netname} {descr} {status} {abuse_mailbox} {origin_asn}
+  -phpipam [-section NAME] [-field NAME] [-o FILE] COUNTRYCODE
+                           Generate phpipam_CC.csv for the phpIPAM subnet import: Section (default Customers),
+                           Subnet, Mask, Description (netname), VLAN, VRF and the custom field NAME (default
+                           country) holding the country code; blocks are split into exact subnets
+  #   When route objects are cached (-u route or -u full), -csv, the plain -search listing and the
+  #   REST country/search responses ("origins") carry the origin ASNs of each prefix
+
+  # Route object consistency (needs -u route, and aut-num + organisation for an ASN, or -u full)
+  -route-audit COUNTRYCODE|ASN
+                           List inetnums without (or only partly with) route objects and route objects
+                           outside registered space; for an ASN, the blocks of its organisation are checked
+  -announced [-f] [-o FILE] ASN...
+  -announced-country [-f] [-o FILE] COUNTRYCODE
+                           Generate announced_NAME.txt with the IPv4 prefixes announced by the ASNs (or by all
+                           ASNs registered to the country) as seen by RIPE RIS, via the RIPEstat Data API;
+                           -f removes nested prefixes. Answers are cached and reused with --offline
+  -mrt-origins [-o FILE] MRTFILE
+                           Print the prefix-to-origin table (prefix, origin ASNs) of an MRT TABLE_DUMP_V2
+                           RIB dump (RIPE RIS bview.*.gz, RouteViews rib.*.bz2); IPv4 only
+  -announced-compare [-o FILE] COUNTRYCODE
+                           Compare registry and BGP: prefixes registered to the country that none of its ASNs
+                           announces (unannounced) and prefixes its ASNs announce that are registered to
+                           another country (registered-elsewhere) or not in the RIPE database (unregistered)
+  -rpsl-filter [-prefix-list NAME] [-o FILE] 'FILTER'
+                           Evaluate an RPSL filter (RFC 2622) against the cached route and as-set objects and
+                           print the matching prefix ranges, or Cisco/FRR prefix-list lines with -prefix-list.
+                           Terms: AS3333, AS-SET names, {1.2.0.0/16^+, ...}, ANY, country:CC (inetnum CIDRs);
+                           range operators ^- ^+ ^n ^n-m; AND, OR, NOT, parentheses. Example:
+                           -rpsl-filter 'AS-EXAMPLE^+ AND NOT {10.0.0.0/8^+, 192.168.0.0/16^+}'
+
+  # Geofeeds (RFC 8805): fetch the feeds referenced by the country's inetnums (geofeed: or "remarks: Geofeed URL")
+  -geofeed [-url URL]... [-prefer geofeed|ripe] [-region R] [-city C] [-o FILE] COUNTRYCODE
+                           Merge feeds with inetnum data and write geofeed_CC.csv (prefix,country,region,city,postal);
+                           -prefer geofeed (default) lets feeds move prefixes between countries, -prefer ripe keeps
+                           registry countries and uses feeds for region/city only; feeds are cached for --offline
+  -geofeeds [-country CC] [-fetch] [-o FILE]
+                           List the geofeed URLs of the cached inetnum (and inet6num) objects with the countries
+                           and organisations referring to them; -fetch downloads and validates every feed
+                           (entries, malformed lines, IPv4 entries outside the referring inetnums)
+
+  # Reverse DNS delegations (needs -u domain or -u full)
+  -rdns [-stub | -forward] [-unbound | -bind] [-o FILE] COUNTRYCODE|PREFIX
+                           List in-addr.arpa delegations of a country or prefix; with -stub/-forward write
+                           Unbound (default) or BIND zone configuration to a file
+  -healthcheck [-max-age 48h] [-min-blocks 1000] [-o PATH]...
+                           Check that the cache exists, was downloaded within -max-age (0 skips the check),
+                           holds at least -min-blocks inetnum blocks and that each -o file or directory is
+                           writable; never downloads. Exit status: 0 healthy, 3 cache missing, 4 stale,
+                           5 too few blocks or unreadable, 6 output not writable (the first failure wins)
+  -preset NAME [ARGS...]   Run the command stored as NAME in the "presets" section of the configuration file
+                           (command, countries, asns, keywords, output, args); ARGS are added to its flags.
+                           The optional hook is run with sh -c after the command if an output changed
+                           (always with "hook_always"), with CHICHA_WHOIS_PRESET and CHICHA_WHOIS_CHANGED set
+  -l [TEXT]                List country codes present in the database (with block/address counts),
+                           optionally only those whose code or name contains TEXT (e.g. -l germ)
+
+  Wherever COUNTRYCODE is expected, a full or partial country name (English or Russian) is accepted
+  too (e.g. -dns-acl-f germany); ambiguous names are offered for selection.
+  continent:CODE selects all countries of a continent (AF, AN, AS, EU, NA, OC, SA) as one merged list,
+  extracted in a single pass and named after the continent (-dns-acl-f continent:EU writes acl_EUROPE.conf).
+  A comma-separated list merges countries into one list: RU,BY,KZ (acl "RU_BY_KZ", also in -search RU,BY:kw);
+  countries or continents can be excluded from a selection: continent:EU,-DE,-FR (acl_EUROPE-DE-FR.conf;
+  !DE works as well), and all selects every country: all,-RU,-BY.
+
+Global options (may be placed anywhere on the command line):
+  --lang en|ru             Language of messages and country names (default: from LC_ALL/LC_MESSAGES/LANG)
+  --config FILE            Configuration file (default: ~/.config/chicha-whois/config.json, on Windows
+                           %AppData%\chicha-whois\config.json)
+  --report FILE            Write a JSON summary of the run (countries, files, durations, warnings) to FILE
+  --mrt FILE               Take announced prefixes for -announced, -announced-country and -announced-compare from
+                           this MRT RIB dump instead of RIPEstat, fully offline
+  --status-file FILE       After each database update (-u, -serve, automatic downloads) write the database age,
+                           serial and update result to FILE: Prometheus text format if FILE ends in .prom
+                           (node-exporter textfile collector), JSON otherwise
+  --reproducible           Omit timestamps from generated files so identical data gives byte-identical output
+  --db PATH                Use this inetnum dump instead of the downloaded cache (plain, .gz or .bz2;
+                           the format is detected from the file contents)
+  --workers N              Parallelism of parsing, downloading (at most 4 connections) and batch
+                           generation (default: number of CPUs)
+  --low-memory             Bound memory use of -dns-acl, -ovpn and the -all batches by streaming through
+                           sorted temporary files (output is ordered numerically instead of as text)
+  --cidr-strategy exact|cover|trim
+                           How inetnum ranges that are not a single CIDR are converted: exact (default) splits
+                           them into the minimal set of CIDRs covering them precisely, cover uses the smallest
+                           CIDR around them (the behaviour of earlier versions), trim the largest CIDR inside
+                           them; added or left-out addresses are reported
+  --retries N              Retry failed database downloads N times with exponential backoff, honouring
+                           Retry-After on 429/5xx responses (default 3, 0 disables retries)
+  --timeout DURATION       Limit for connecting to the mirror and for its response to begin (default 60s)
+  --max-memory SIZE        Cap on memory for extractions (512M, 2G, ...): lists that would not fit are sorted
+                           and filtered through temporary files as with --low-memory
+  --stream                 Write -dns-acl, -ovpn and -search results while the database is read, in database
+                           order and without the final deduplication pass (not with -f, --sort, --granularity
+                           or --sources, which need the whole list; -search results are then not cached)
+  -4, -6, -46              Address families of the -dns-acl and -ovpn lists: IPv4 (default), IPv6 from the
+                           inet6num objects (downloaded on first use) or both; IPv6 prefixes become route-ipv6
+                           directives in OpenVPN files; other commands reject -6 and -46
+  --include-placeholder    Allow selecting the RIPE placeholder codes EU and ZZ, which stand for no single
+                           country, and write files for them in the -*-all batches (skipped by default)
+  --offline                Never download the database; fail at once if the cache is missing
+  --force-update           Download a fresh database before running the command, even if a cache exists
+  --crlf                   Write generated files with Windows (CRLF) line endings
+  --mode 0640              Permissions of generated files (default 0644 for new files)
+  --owner USER[:GROUP]     Owner and/or group (:GROUP) of generated files; changing the owner requires root
+  --backups N              Before overwriting an output, keep a copy named after the date it was generated
+                           (acl_RU.conf.2025-01-01) and delete all but the N newest copies
+  --exit-code              Exit with status 2 if any output file changed, 0 if all were already up to date and
+                           1 if the run reported an error (unknown country, unreadable database, failed write)
+                           (outputs whose content is unchanged are never rewritten, so their mtime is kept)
+  --checksums              Write PATH.sha256 next to every generated file (check with: sha256sum -c PATH.sha256)
+  --json-errors            Write status messages, warnings and errors to stderr as JSON lines
+                           ({"time","level","id","message"}; id is the untranslated message) and end with a
+                           summary line {"level":"summary","errors","warnings","changed"}
+  --machine                --json-errors and --quiet: for wrappers and orchestration tools
+  --sign minisign:SECKEY | gpg[:KEYID]
+                           Sign every generated file with minisign (PATH.minisig) or gpg (PATH.asc, armored
+                           detached signature) using the installed tool; unattended runs need an unencrypted
+                           minisign key or a gpg-agent holding the passphrase
+  --ignore-smaller-than /N Drop prefixes longer than /N (e.g. /29 drops /30, /31 and /32) from generated lists
+  --granularity /N         Widen prefixes longer than /N to the enclosing /N, then drop duplicates and nested ones;
+                           the added (over-covered) addresses are reported in the summary
+  --aggregate, -aggregate  Merge adjacent, sibling and overlapping prefixes of generated lists into the smallest
+                           set of CIDRs covering the same addresses (1.2.3.0/25 + 1.2.3.128/25 -> 1.2.3.0/24)
+  --exclude ITEM           Remove address space from generated lists and -search results (repeatable). ITEM is
+                           a CIDR, @FILE (prefixes or addresses, one per line), search:CC:KEYWORDS (e.g. the
+                           networks of a provider) or a country selection, subtracted address by address
+  --intersect ITEM         Keep only the address space of ITEM (same forms as --exclude; repeatable items are
+                           merged) in generated lists and -search results
+  --invert, -invert        Replace generated lists and -search results with their complement: the CIDRs covering
+                           the rest of the IPv4 (and, with -6/-46, IPv6) space. --exclude and --intersect apply to
+                           the complement, e.g. --invert --exclude 10.0.0.0/8 keeps a LAN out of it
+  --sources LIST           Merge data sources for country extraction, highest precedence first:
+                           ripe, geofeed (feeds referenced in inetnums), geofeed:URL, csv:FILE (RFC 8805 lines,
+                           prefix or start-end), rir:FILE (RIR delegated statistics), geolite2:PATH (MaxMind
+                           GeoLite2 Country/City CSV directory or its *-Blocks-IPv4.csv; put it last as a backup);
+                           e.g. csv:fix.csv,ripe,rir:arin.txt,geolite2:/var/lib/GeoLite2-Country-CSV
+                           Country generators use the merged view; -merge-report [-o FILE] lists the conflicts
+  -merge-report [-o FILE]  List the ranges on which the --sources disagree and the source that was used
+  -compare [-country CC] [-o FILE] SOURCE
+                           List prefixes where RIPE and SOURCE (e.g. geolite2:PATH) name different countries,
+                           with a summary of the largest disagreements
+
+  # Generate DNS Bind ACL (unfiltered / filtered) [writes output to a file]
+  -dns-acl COUNTRYCODE     Generate unfiltered DNS ACL file for BIND
+  -dns-acl-f COUNTRYCODE   Generate filtered DNS ACL file for BIND (removes nested subnets)
+  #   Both accept: [-name ACLNAME] [-o PATH] [-split] [-chunk N] [-views] CC[=ACLNAME] [CC[=ACLNAME] ...]
+  #   -name sets the acl "NAME" identifier (single country), CC=NAME names each ACL of a multi-ACL run,
+  #   -o sets the output file, -split writes one file per ACL (into -o DIR) plus acl_index.conf
+  #   with include statements for named.conf; -chunk N spreads each ACL over include files
+  #   acl_NAME_1.conf, acl_NAME_2.conf, ... of at most N prefixes (ACLs NAME_1, NAME_2, ...) and the
+  #   main file includes them and defines acl "NAME" { "NAME_1"; "NAME_2"; ... };
+  #   -views adds view "NAME" { match-clients { "NAME"; }; ... }; per ACL and a final view "default"
+  #   matching any, with commented zone includes, after the ACLs (or in acl_index.conf with -split)
+
+  # Generate OpenVPN exclude-route list (unfiltered / filtered) [writes output to a file]
+  -ovpn COUNTRYCODE        Generate unfiltered OpenVPN routes
+  -ovpn-f COUNTRYCODE      Generate filtered OpenVPN routes (removes nested subnets)
+
+  # Generate files for every country in a single pass over the database [writes one file per country]
+  -dns-acl-all [-f] [-o DIR]   BIND ACL files acl_CC.conf (default DIR: ~/acl_all; -f removes nested subnets)
+  -ovpn-all [-f] [-o DIR]      OpenVPN route files openvpn_exclude_CC.txt (default DIR: ~/openvpn_all)
+
+  # New: Search by country code (optional) AND/OR keywords, filter subnets, print results to screen
   # Syntax:
-  #   chicha-whois -search [-dns | -ovpn | -ovpn-push] CC:kw1,kw2,...
+  #   chicha-whois -search [-dns | -ovpn | -ovpn-push | -dnsmasq | -nftset | -adguard] [-no-cache] [--sort size|prefix|netname|country] CC:kw1,kw2,...
+  #   -dnsmasq prints ipset=/DOMAIN/.../chicha_CC for the keywords that are domains and the ipset (ipset restore
+  #   input) with the networks found; -nftset the same as nftset= and an nftables set in table inet fw4;
+  #   -adguard a disallowed_clients list for AdGuardHome.yaml
+  #   --sort orders the results (size: largest blocks first) and lists addresses, country and netname
+  #   Results are cached per query until the database changes (at most 24h); -no-cache forces a new scan
+  #   Keywords of 3+ characters only read the parts of the database that can contain them (search index)
+  #   A keyword written as /EXPR/ is a case-insensitive regular expression (^ and $ match at line ends)
   #
   # Examples:
   #   chicha-whois -search -dns RU:ok.ru,vkontakte,mts,megafon.ru
   #   chicha-whois -search -ovpn-push :google.com,cloudflare,amazon
-  #   chicha-whois -search -ovpn UA:gmail,outlook`)
+  #   chicha-whois -search -ovpn UA:gmail,outlook
+  #   chicha-whois -search -dnsmasq RU:ok.ru,vk.com
+  #   chicha-whois -search 'RU:/^netname: *mts-/'
+  -search-index [-remove]  Build the optional trigram index that speeds up -search further (rebuilt after
+                           each update and when the database changes; -remove deletes it)
+
+  # Summarize a web server access log (nginx/Apache common or combined format) by country
+  -logstats                Read an access log from stdin and print hits/bytes per country
+  #   cat /var/log/nginx/access.log | chicha-whois -logstats
+
+  # Export CrowdSec decisions (scope=range) for "cscli decisions import -i FILE" [writes output to a file]
+  -crowdsec [-csv] [-duration 24h] [-type ban] COUNTRYCODE
+                           Generate crowdsec_CC.json (or .csv) with filtered country ranges
+
+  # Load filtered country prefixes into Redis (temporary key + atomic RENAME)
+  -redis [-addr host:port] [-password PASS] [-db N] [-key NAME] [-ranges] COUNTRYCODE
+                           Default: SET "chicha-whois:CC" with CIDR members.
+                           -ranges: sorted set "chicha-whois:CC:ranges" (member=CIDR, score=last IP as integer);
+                           look up with ZRANGEBYSCORE key <ip-as-int> +inf LIMIT 0 1 and check containment.
+
+  # Export a PostgreSQL dump (CREATE TABLE + COPY, cidr column with a GiST index) [writes output to a file]
+  -pgsql [-table NAME] [-load CONNSTRING] COUNTRYCODE
+                           Generate pgsql_CC.sql; with -load it is also applied via "psql CONNSTRING -f FILE"
+
+  # Export ClickHouse data (TabSeparated: ip_from UInt32, ip_to UInt32, prefix, country) + schema [writes output to files]
+  -clickhouse [-table NAME] COUNTRYCODE
+                           Generate clickhouse_CC.tsv and clickhouse_CC.sql (MergeTree table + IP_TRIE dictionary)
+
+  # BSD firewalls [writes output to a file]
+  -pf [-anchor NAME] [-table NAME] [-table-file FILE] [-block|-pass] [-reload] [-o FILE] CC [CC ...]
+                           Generate a pf anchor pf_CC.conf with a persistent table of the countries and a
+                           block (default) or pass rule; -reload loads it with pfctl -a NAME -f FILE
+                           (default anchor: chicha-whois, table: chicha_CC, cut with a hash suffix at the
+                           31 characters pf allows). With -table-file the prefixes go
+                           to FILE, one per line, and the anchor loads it with: table <NAME> persist file "FILE"
+  -ipfw [-table N] [-swap-table M] [-o FILE] CC [CC ...]
+                           Generate ipfw_CC.sh: fills table M (default N+1) with "ipfw table M add" and
+                           swaps it with table N (default 1) atomically; run with sh ipfw_CC.sh
+
+  # Ubiquiti EdgeRouter [writes output to a file]
+  -edgeos [-prefix NAME] [-o FILE] CC [CC ...]
+                           Generate edgeos_CC.json, a config.gateway.json fragment with a firewall network-group
+                           PREFIX_CC (default prefix: chicha_) per country
+
+  # Routing daemons, e.g. for BGP blackholing [writes output to a file]
+  -bird [-name NAME] [-blackhole] [-o FILE] CC [CC ...]
+                           Generate bird_CC.conf for BIRD 2: "define NAME = [ ... ];" prefix set of the countries
+                           (default name: chicha_CC) and, with -blackhole, a static protocol NAME_blackhole
+                           with a blackhole route per prefix; include it from bird.conf
+  -frr [-name NAME] [-blackhole] [-o FILE] CC [CC ...]
+                           Generate frr_CC.conf for FRRouting: "ip prefix-list NAME seq N permit" entries and,
+                           with -blackhole, "ip route PREFIX blackhole" per prefix; apply with vtysh -f FILE
+
+  # Juniper JunOS (MX, SRX, EX) [writes output to a file]
+  -junos [-prefix NAME] [-filter NAME] [-action discard|accept] [-o FILE] CC [CC ...]
+                           Generate junos_CC.set with "set policy-options prefix-list PREFIXCC" commands per
+                           country (replacing earlier contents); -filter adds a firewall family inet filter with
+                           one term per country that discards (default) or accepts its traffic and a final
+                           term doing the opposite; load with "load set FILE" and commit
+  -nginx [-mode allow|deny|geo] [-var NAME] [-o FILE] CC [CC ...]
+                           Generate nginx_CC.conf: "allow PREFIX;" rules ending with "deny all;" (default) for a
+                           server or location block, the reverse with -mode deny, or with -mode geo a
+                           "geo $NAME { PREFIX CC; ... }" map (default $country) for the http block
+  -squid [-name NAME] [-dst] [-list FILE] [-o FILE] CC [CC ...]
+                           Generate squid_CC.conf with "acl NAME src PREFIX" lines (default name: CC; -dst matches
+                           destinations instead); with -list the prefixes go to FILE, one per line, and the acl
+                           reads it with: acl NAME src "FILE"
+  -postfix [-action TEXT] [-o FILE] CC [CC ...]
+                           Generate postfix_CC.cidr, a cidr: table with "PREFIX TEXT" lines (default action:
+                           REJECT country-blocked) for smtpd_client_restrictions = check_client_access cidr:FILE
+  -yaml [-o FILE] CC [CC ...]
+                           Generate prefixes_CC.yaml mapping each country code to its list of prefixes
+  -ansible [-var NAME] [-o FILE] CC [CC ...]
+                           Generate ansible_CC.yml, an Ansible vars file with country_cc_cidrs per country, or
+                           one variable NAME with the prefixes of all countries (for vars_files or group_vars)
+  -unifi-push [-controller NAME] [-group NAME] [-dry-run] CC [CC ...]
+                           Create or update an IPv4 address group (default chicha_CC) on a UniFi Network
+                           controller, writing it only if the members changed; the controller (url, user,
+                           password, site, unifi_os) is taken from the "unifi" section of the configuration file
+
+  # OPNsense [changes the firewall]
+  -opnsense-push [-firewall NAME] [-alias NAME] CC [CC ...]
+                           Create or update a network alias (default chicha_CC) through the OPNsense API and
+                           apply it; the firewall (url, key, secret) is taken from the "opnsense" section of
+                           the configuration file
+
+  # pfSense [changes the firewall]
+  -pfsense-push [-firewall NAME] [-alias NAME] CC [CC ...]
+                           Create or update a network alias (default chicha_CC) through the pfSense REST API
+                           package (API v2) and apply the changes; the firewall (url, key) is taken from the
+                           "pfsense" section of the configuration file
+
+  # NetBox [changes the IPAM]
+  -netbox-push [-instance NAME] [-as prefix|aggregate] [-tag-prefix P] [-dry-run] CC [CC ...]
+                           Synchronize the country prefixes into NetBox as prefixes (default) or aggregates
+                           tagged P+cc (default tag: country-ru): missing ones are created, tagged ones no
+                           longer in the registry deleted; untagged objects are never touched. The instance
+                           (url, token, rir) is taken from the "netbox" section of the configuration file
+
+  # MikroTik RouterOS [writes output to a file / changes the router]
+  -mikrotik [-list NAME] [-ros 6|7] [-split N] [-o FILE] CC [CC ...]
+                           Generate mikrotik_CC.rsc that replaces a firewall address-list (default name
+                           chicha_CC); -ros 6 uses RouterOS 6 menu syntax (default 7: slash-separated
+                           paths); -split N writes parts of at most N entries plus a main file importing them
+  -mikrotik-push [-router NAME] [-list NAME] [-dry-run] CC [CC ...]
+                           Synchronize a firewall address-list over the RouterOS API, adding and removing only
+                           the entries that changed; the router (address, user, password, tls, list) is taken
+                           from the "mikrotik" section of the configuration file; -dry-run only shows the counts
+
+  # Run as a daemon: refresh the database periodically and expose Prometheus metrics at /metrics
+  -serve [-listen :8080] [-grpc-listen :50051] [-interval 24h] [-track RU,UA] [-email NAME] [-notify NAME,...]
+                           -interval 0 disables automatic updates; -track selects countries whose
+                           prefix/address counts are exported; -email mails a summary of the tracked
+                           countries that changed (with CC.diff attachments) after each scheduled update,
+                           using the "email" section of the configuration file; -notify posts a short
+                           message about each update to Slack/Telegram notifiers from the "notifiers" section
+                           Web UI: http://localhost:8080/ (search, browse countries, preview and download lists)
+                           REST: GET /api/v1/lookup?ip=IP, /api/v1/country/CC[?filtered=0], /api/v1/search?q=CC:kw1,kw2,
+                                 /api/v1/countries, /api/v1/formats, /api/v1/download?country=CC|q=CC:kw&format=FORMAT
+                           Lists for URL-based firewall tables (pfSense, PAN-OS EDL, FortiGate external feeds):
+                                 GET /lists/FORMAT/CC.EXT[?filtered=0], e.g. /lists/plain/RU.txt, with ETag and
+                                 Last-Modified (304 Not Modified until the list changes)
+                           gRPC (cleartext HTTP/2): service chicha.whois.v1.Whois, see -grpc-proto
+  -trend [-since 720h] [-threshold 1] [-csv] [-o FILE] [CC ...]
+                           Print the prefix/address counts recorded by -serve -track after each update, with
+                           the change since the previous update; changes above -threshold percent are marked
+                           with "!"; -csv exports the raw samples. The counts are kept in trend.sqlite in the
+                           cache directory, written and read with the sqlite3 command-line client
+  -grpc-proto              Print the protobuf definition of the gRPC service (for generating typed clients)
+
+  # Export a FireHOL netset (with source/date/entry count header) [writes output to a file]
+  -netset COUNTRYCODE      Generate ripe_country_cc.netset with filtered country ranges
+
+  # Export a PeerGuardian/P2P blocklist (CountryName:start-end) [writes output to a file]
+  -p2p COUNTRYCODE         Generate ripe_CC.p2p with the original (unconverted) inetnum ranges`
+
+//-------------------------------------------------------------------------
+// Subcommands
+//-------------------------------------------------------------------------
+
+// subcommands maps the subcommand names (chicha-whois acl RU) to the flag commands they run; acl
+// and ovpn pick their variant from -f/--filtered and --all (see expandSubcommand).
+var subcommands = map[string]string{
+    "update": "-u",
+    "acl":    "-dns-acl",
+    "ovpn":   "-ovpn",
+    "search": "-search",
+    "lookup": "-lookup",
+    "serve":  "-serve",
+    "help":   "-h",
+}
+
+// expandSubcommand turns "chicha-whois SUBCOMMAND [ARGS...]" into the flag command line it stands
+// for, so both styles share one implementation and options compose the same way in both. Options
+// may be written -name, --name or --name=value. "help SUBCOMMAND" prints the help of that command;
+// ok is false when nothing is left to run.
+func expandSubcommand(args []string) ([]string, bool) {
+    name := args[1]
+    cmd := subcommands[name]
+    filtered, all := false, false
+    var rest []string
+    for _, arg := range args[2:] {
+        if strings.HasPrefix(arg, "--") && len(arg) > 2 {
+            option, value, hasValue := strings.Cut(arg[1:], "=")
+            arg = option
+            if hasValue {
+                rest = append(rest, option, value)
+                continue
+            }
+        }
+        switch {
+        case (name == "acl" || name == "ovpn") && (arg == "-f" || arg == "-filtered"):
+            filtered = true
+        case (name == "acl" || name == "ovpn") && arg == "-all":
+            all = true
+        default:
+            rest = append(rest, arg)
+        }
+    }
+
+    switch {
+    case name == "help":
+        if len(rest) == 0 {
+            usage()
+            return nil, false
+        }
+        target, ok := subcommands[rest[0]]
+        if !ok {
+            target = rest[0]
+        }
+        os.Args = []string{args[0], target}
+        commandUsage()
+        return nil, false
+    case all:
+        cmd += "-all"
+        if filtered {
+            rest = append([]string{"-f"}, rest...)
+        }
+    case filtered:
+        cmd += "-f"
+    }
+    return append([]string{args[0], cmd}, rest...), true
+}
+
+// lookupAddresses prints the country, block and netname of each address, as tab-separated lines
+// or, with asJSON, as one JSON object per line.
+func lookupAddresses(addresses []string, asJSON bool) {
+    idx, err := buildIPIndex(ripedbPath)
+    if err != nil {
+        errorln("Error building IP index:", err)
+        return
+    }
+    for _, address := range addresses {
+        result, err := lookupAddress(idx, address)
+        if err != nil {
+            errorln(err)
+            continue
+        }
+        switch {
+        case asJSON:
+            data, _ := json.Marshal(result)
+            fmt.Println(string(data))
+        case !result.Found:
+            fmt.Printf("%s\t-\n", result.IP)
+        default:
+            fmt.Printf("%s\t%s\t%s\t%s\n", result.IP, result.Country, result.Inetnum, result.Netname)
+        }
+    }
+}
+
+//-------------------------------------------------------------------------
+// Argument checking
+//-------------------------------------------------------------------------
+
+// synopsisOptionRe matches the options named in a synopsis line of the help text; bracketOptionRe
+// matches the optional ones ("[-o FILE]", "[-dns | -ovpn]") in the comment lines of a group.
+var (
+    synopsisOptionRe = regexp.MustCompile(`(?:^|[\s\[|(])(--?[a-z][a-z0-9-]*)`)
+    bracketOptionRe  = regexp.MustCompile(`[\[|]\s*(--?[a-z][a-z0-9-]*)`)
+)
+
+// usageEntries returns the synopsis lines of the help text with the lines continuing them, keyed
+// by the command or global option they describe ("-h, --help" under both names). The "#   "
+// comment lines below a group of commands belong to each of them; a comment line with a
+// "chicha-whois -command ..." example starts a group of that command alone.
+func usageEntries() map[string][]string {
+    entries := make(map[string][]string)
+    var current, group []string
+    for _, line := range strings.Split(usageText, "\n") {
+        switch {
+        case strings.HasPrefix(line, "  -"):
+            fields := strings.Fields(line)
+            current = []string{strings.TrimSuffix(fields[0], ",")}
+            if strings.HasSuffix(fields[0], ",") && len(fields) > 1 {
+                current = append(current, fields[1])
+            }
+            group = append(group, current...)
+            for _, name := range current {
+                entries[name] = append(entries[name], line)
+            }
+        case strings.HasPrefix(line, "   ") && current != nil:
+            for _, name := range current {
+                entries[name] = append(entries[name], line)
+            }
+        case strings.HasPrefix(line, "  #   chicha-whois -"):
+            name := strings.Fields(line)[2]
+            current, group = nil, []string{name}
+            entries[name] = append(entries[name], line)
+        case strings.HasPrefix(line, "  #   ") && group != nil:
+            for _, name := range group {
+                entries[name] = append(entries[name], line)
+            }
+        default:
+            current, group = nil, nil
+        }
+    }
+    return entries
+}
+
+// commandOptions returns the options a command shows in its synopsis and comment lines.
+func commandOptions(cmd string) []string {
+    var options []string
+    for _, line := range usageEntries()[cmd] {
+        var matches [][]string
+        switch {
+        case strings.HasPrefix(line, "  -"):
+            // The synopsis ends where the description column starts.
+            synopsis, _, _ := strings.Cut(strings.TrimSpace(line), "   ")
+            matches = synopsisOptionRe.FindAllStringSubmatch(synopsis, -1)
+        case strings.HasPrefix(line, "  #"):
+            matches = bracketOptionRe.FindAllStringSubmatch(line, -1)
+        }
+        for _, m := range matches {
+            if m[1] != cmd && !slices.Contains(options, m[1]) {
+                options = append(options, m[1])
+            }
+        }
+    }
+    return options
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+    ra, rb := []rune(a), []rune(b)
+    prev := make([]int, len(rb)+1)
+    cur := make([]int, len(rb)+1)
+    for j := range prev {
+        prev[j] = j
+    }
+    for i := 1; i <= len(ra); i++ {
+        cur[0] = i
+        for j := 1; j <= len(rb); j++ {
+            cost := 1
+            if ra[i-1] == rb[j-1] {
+                cost = 0
+            }
+            cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+        }
+        prev, cur = cur, prev
+    }
+    return prev[len(rb)]
+}
+
+// closestMatch returns the candidate nearest to word, or "" if none is close enough to be a typo.
+func closestMatch(word string, candidates []string) string {
+    best, bestDistance := "", max(2, len(word)/3)+1
+    for _, candidate := range slices.Sorted(slices.Values(candidates)) {
+        if d := editDistance(word, candidate); d < bestDistance {
+            best, bestDistance = candidate, d
+        }
+    }
+    return best
+}
+
+// commandUsage prints the help of the running command only, or the whole help if it has none.
+func commandUsage() {
+    lines := usageEntries()[os.Args[1]]
+    if len(lines) == 0 {
+        usage()
+        return
+    }
+    fmt.Println(tr("Usage:"))
+    for _, line := range lines {
+        fmt.Println(line)
+    }
+    fmt.Println(tr("Run chicha-whois -h for all commands and global options."))
+}
+
+// unknownCommand reports a command that does not exist, with the closest one that does.
+func unknownCommand(cmd string) {
+    errorf("Unknown command %s.\n", cmd)
+    commands := slices.Collect(maps.Keys(subcommands))
+    for name := range usageEntries() {
+        if !strings.HasPrefix(name, "--") || name == "--help" || name == "--version" {
+            commands = append(commands, name)
+        }
+    }
+    if match := closestMatch(cmd, commands); match != "" {
+        statusf("Did you mean %s?\n", match)
+    }
+    fmt.Println(tr("Run chicha-whois -h for all commands and global options."))
+}
+
+// unknownOption reports an option the running command does not take, with the closest option it
+// or the global options do take.
+func unknownOption(arg string) {
+    errorf("Unknown option %s for %s.\n", arg, os.Args[1])
+    candidates := commandOptions(os.Args[1])
+    for name := range usageEntries() {
+        if strings.HasPrefix(name, "--") && name != "--help" && name != "--version" {
+            candidates = append(candidates, name)
+        }
+    }
+    if match := closestMatch(arg, candidates); match != "" {
+        statusf("Did you mean %s?\n", match)
+    }
+    commandUsage()
+}
+
+// unexpectedArgument reports a positional argument given to a command that takes only one.
+func unexpectedArgument(arg string) {
+    errorf("Unexpected extra argument %s for %s.\n", arg, os.Args[1])
+    commandUsage()
+}
+
+// missingValue reports whether the option at os.Args[i] lacks its value: it is the last argument,
+// or the next one is another option of the command, as in "-o -split 10". It prints why.
+func missingValue(i int) bool {
+    if i+1 < len(os.Args) && !slices.Contains(commandOptions(os.Args[1]), os.Args[i+1]) {
+        return false
+    }
+    errorf("Option %s of %s requires a value.\n", os.Args[i], os.Args[1])
+    commandUsage()
+    return true
+}
+
+// resolveCountryArg resolves a country argument of the running command; an argument that looks
+// like an option is reported as an unknown option instead of being looked up as a country name,
+// and a missing one is reported instead of offering every country.
+func resolveCountryArg(arg string) (string, bool) {
+    if strings.TrimSpace(arg) == "" {
+        errorf("Missing COUNTRYCODE for %s.\n", os.Args[1])
+        commandUsage()
+        return "", false
+    }
+    if strings.HasPrefix(arg, "-") {
+        unknownOption(arg)
+        return "", false
+    }
+    return resolveCountryCode(arg)
+}
+
+// ensureRIPEdb makes sure the RIPE DB cache is available and reports whether the command can go on.
+// A missing cache is downloaded, unless --offline is set, in which case it fails immediately;
+// --force-update downloads a fresh copy even if the cache exists.
+func ensureRIPEdb() bool {
+    _, err := os.Stat(ripedbPath)
+    missing := os.IsNotExist(err)
+    if customDB {
+        if err != nil {
+            errorf("Error opening the database given with --db: %v\n", err)
+            return false
+        }
+        return true
+    }
+    if offline {
+        if missing {
+            errorf("RIPE database cache not found at %s and --offline is set; run chicha-whois -u first.\n", ripedbPath)
+            return false
+        }
+        return true
+    }
+    if forceUpdate {
+        // Download at most once per run, even if several steps ask for the database.
+        forceUpdate = false
+        return updateRIPEdb() == nil
+    }
+    if missing {
+        statusln("RIPE database cache not found. Attempting to update...")
+        return updateRIPEdb() == nil
+    }
+    return true
+}
+
+// updateRIPEdb downloads the RIPE inetnum database from a public URL, then decompresses it.
+// Errors are printed for the CLI and also returned for callers that track update status.
+func updateRIPEdb() error {
+    return updateRIPEObject("inetnum", ripedbPath)
+}
+
+// ripeObjectTypes lists the RIPE split files that -u can download, in the order -u all fetches them.
+var ripeObjectTypes = []string{"inetnum", "inet6num", "route", "route6", "aut-num", "organisation", "domain", "role", "person", "mntner", "as-set"}
+
+// ripeObjectURL returns the download URL of the split file holding one object type,
+// or of the combined dump for "full".
+func ripeObjectURL(objectType string) string {
+    if objectType == "full" {
+        return ripeFullDBURL
+    }
+    return "https://ftp.ripe.net/ripe/dbase/split/ripe.db." + objectType + ".gz"
+}
+
+// objectCachePath returns the cache file of an object type; inetnum lives at ripedbPath
+// and "full" is the combined dump.
+func objectCachePath(objectType string) string {
+    switch objectType {
+    case "inetnum":
+        return ripedbPath
+    case "full":
+        return fullDBPath()
+    }
+    return filepath.Join(cacheDir, "ripe.db."+objectType)
+}
+
+// updateRIPEObjects downloads the given object types one after another and reports whether all succeeded.
+func updateRIPEObjects(objectTypes []string) bool {
+    var failed []string
+    for _, objectType := range objectTypes {
+        if err := updateRIPEObject(objectType, objectCachePath(objectType)); err != nil {
+            failed = append(failed, objectType)
+            continue
+        }
+        if objectType == "full" {
+            // Index right away, so the first query does not pay for it.
+            if _, err := loadObjectIndex(); err != nil {
+                statusf("Warning: unable to index the full RIPE database: %v\n", err)
+            }
+        }
+    }
+    if len(failed) > 0 {
+        errorf("Failed to update: %s\n", strings.Join(failed, ", "))
+        return false
+    }
+    return true
+}
+
+// parseObjectTypes parses the comma-separated -u argument; "all" selects every known type
+// and "full" the combined dump.
+func parseObjectTypes(arg string) ([]string, error) {
+    if arg == "all" {
+        return ripeObjectTypes, nil
+    }
+    var types []string
+    for _, objectType := range strings.Split(arg, ",") {
+        objectType = strings.ToLower(strings.TrimSpace(objectType))
+        known := objectType == "full"
+        for _, t := range ripeObjectTypes {
+            if t == objectType {
+                known = true
+            }
+        }
+        if !known {
+            return nil, fmt.Errorf("unknown object type %q (known: %s)", objectType, strings.Join(ripeObjectTypes, ", "))
+        }
+        types = append(types, objectType)
+    }
+    return types, nil
+}
+
+// updateRIPEObject downloads the split file of one object type and decompresses it into destination.
+// Updates of the inetnum cache are recorded in the --status-file.
+func updateRIPEObject(objectType, destination string) (err error) {
+    downloadURL := ripeObjectURL(objectType)
+    started := time.Now()
+    if objectType == "inetnum" && statusFile != "" {
+        defer func() { writeStatusFile(err, time.Since(started)) }()
+    }
+
+    homeDir, err := os.UserHomeDir()
+    if err != nil {
+        errorln("Error getting home directory:", err)
+        return err
+    }
+
+    // Create a temporary file for the gzip data.
+    tmpFile, err := os.CreateTemp(homeDir, "ripe.db."+objectType+"-*.gz")
+    if err != nil {
+        errorln("Error creating temporary file:", err)
+        return err
+    }
+    defer func() {
+        _ = os.Remove(tmpFile.Name())
+        statusln("Temporary file removed:", tmpFile.Name())
+    }()
+    defer tmpFile.Close()
+
+    statusf("Starting download of the RIPE database from %s\n", downloadURL)
+    statusf("Saving to temporary file: %s\n", tmpFile.Name())
+
+    // With several workers and a server that accepts byte ranges, the file is fetched in parallel segments.
+    connections := min(workers, maxDownloadConnections)
+    var size int64
+    segmented := false
+    if connections > 1 {
+        size, segmented = probeRangeDownload(downloadURL)
+    }
+    if segmented {
+        err = downloadSegments(downloadURL, tmpFile, size, connections)
+    } else {
+        err = downloadSequential(downloadURL, tmpFile)
+    }
+    if err != nil {
+        return err
+    }
+
+    // Now decompress the downloaded .gz into the cache file.
+    statusf("Extracting %s to %s\n", tmpFile.Name(), destination)
+    if err := gunzipFileWithProgress(tmpFile.Name(), destination); err != nil {
+        errorln("Error decompressing RIPE database:", err)
+        return err
+    }
+    saveDatabaseInfo(destination, downloadURL)
+    if objectType == "inetnum" && !lowMemory {
+        statusf("Building the binary database %s\n", binaryDBPath(destination))
+        if _, err := updateBinaryDatabase(destination); err != nil {
+            warnf("Warning: could not write the binary database %s: %v\n", binaryDBPath(destination), err)
+        }
+        statusf("Building the search index %s\n", searchIndexPath(destination))
+        if err := updateSearchIndex(destination); err != nil {
+            warnf("Warning: could not write the search index %s: %v\n", searchIndexPath(destination), err)
+        }
+        if err := updateTrigramIndex(destination); err != nil {
+            warnf("Warning: could not write the trigram index %s: %v\n", trigramIndexPath(destination), err)
+        }
+    }
+    if reportPath != "" {
+        reportMu.Lock()
+        report.UpdateSeconds += time.Since(started).Seconds()
+        reportMu.Unlock()
+    }
+
+    statusf("RIPE database updated successfully at %s\n", destination)
+    return nil
+}
+
+// downloadSequential fetches url into dst over a single connection, showing progress. An interrupted
+// transfer is started over, as often as --retries allows.
+func downloadSequential(url string, dst *os.File) error {
+    err := withRetries(func() error {
+        if err := dst.Truncate(0); err != nil {
+            return err
+        }
+        if _, err := dst.Seek(0, io.SeekStart); err != nil {
+            return err
+        }
+        resp, err := downloadRequest(http.MethodGet, url, nil)
+        if err != nil {
+            return err
+        }
+        defer resp.Body.Close()
+
+        totalSize := resp.ContentLength
+        if totalSize <= 0 {
+            statusln("Warning: unable to determine file size for progress display.")
+        } else {
+            statusf("Total file size: %d bytes\n", totalSize)
+        }
+
+        progressReader := &ProgressReader{
+            Reader:    resp.Body,
+            Total:     totalSize,
+            Operation: "Downloading",
+        }
+
+        // Copy the downloaded bytes to the temporary file, showing progress.
+        n, err := io.Copy(dst, progressReader)
+        fmt.Println() // New line after final progress output.
+        if err != nil {
+            return retryable(fmt.Errorf("transfer interrupted after %d bytes: %v", n, err), 0)
+        }
+        if totalSize > 0 && n != totalSize {
+            return retryable(fmt.Errorf("transfer ended after %d of %d bytes", n, totalSize), 0)
+        }
+        return nil
+    })
+    if err != nil {
+        errorf("Error downloading RIPE database: %v\n", err)
+    }
+    return err
+}
+
+// downloadUserAgent identifies chicha-whois to the RIPE mirrors, so their operators know who is downloading.
+func downloadUserAgent() string {
+    return fmt.Sprintf("chicha-whois/%s (+https://github.com/matveynator/chicha-whois)", version)
+}
+
+// downloadClient is the HTTP client of database downloads. --timeout limits connecting and waiting
+// for the response to begin, not the transfer itself, which may take long on slow links.
+var downloadClient = sync.OnceValue(func() *http.Client {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.DialContext = (&net.Dialer{Timeout: httpTimeout, KeepAlive: 30 * time.Second}).DialContext
+    transport.TLSHandshakeTimeout = httpTimeout
+    transport.ResponseHeaderTimeout = httpTimeout
+    return &http.Client{Transport: transport}
+})
+
+// retryableError is a download failure worth trying again: a network error, an interrupted transfer
+// or a 429/5xx response, which may say how long to wait (after).
+type retryableError struct {
+    err   error
+    after time.Duration
+}
+
+// Error returns the message of the underlying error.
+func (e *retryableError) Error() string {
+    return e.err.Error()
+}
+
+// retryable marks err as worth retrying, after the given delay (0 leaves it to the backoff).
+func retryable(err error, after time.Duration) error {
+    return &retryableError{err: err, after: after}
+}
+
+// downloadRequest sends a request for a database download with the chicha-whois User-Agent and returns
+// the response if its status is 200 or 206. Failures to connect and 429/5xx responses are retryable.
+func downloadRequest(method, url string, header http.Header) (*http.Response, error) {
+    req, err := http.NewRequest(method, url, nil)
+    if err != nil {
+        return nil, err
+    }
+    for name, values := range header {
+        req.Header[name] = values
+    }
+    req.Header.Set("User-Agent", downloadUserAgent())
+    resp, err := downloadClient().Do(req)
+    if err != nil {
+        return nil, retryable(err, 0)
+    }
+    if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+        return resp, nil
+    }
+    resp.Body.Close()
+    err = fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+    if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+        return nil, retryable(err, retryAfter(resp.Header.Get("Retry-After")))
+    }
+    return nil, err
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date; 0 if absent or invalid.
+func retryAfter(value string) time.Duration {
+    if value == "" {
+        return 0
+    }
+    if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+        return time.Duration(seconds) * time.Second
+    }
+    if at, err := http.ParseTime(value); err == nil {
+        return max(time.Until(at), 0)
+    }
+    return 0
+}
+
+// Backoff between download attempts: the wait doubles from retryBaseDelay and never exceeds
+// retryMaxDelay, also when a server asks for a longer Retry-After.
+const (
+    retryBaseDelay = 5 * time.Second
+    retryMaxDelay  = 5 * time.Minute
+)
+
+// withRetries runs fn until it succeeds, fails with an error that is not retryable or --retries
+// further attempts have failed, waiting with exponential backoff (or as long as the server asked)
+// in between.
+func withRetries(fn func() error) error {
+    delay := retryBaseDelay
+    for attempt := 1; ; attempt++ {
+        err := fn()
+        var retry *retryableError
+        if err == nil || !errors.As(err, &retry) || attempt > retries {
+            return err
+        }
+        wait := delay
+        if retry.after > 0 {
+            wait = retry.after
+        }
+        wait = min(wait, retryMaxDelay)
+        warnf("Warning: download failed (%v), retrying in %s (attempt %d of %d)\n", err, wait, attempt+1, retries+1)
+        time.Sleep(wait)
+        delay = min(delay*2, retryMaxDelay)
+    }
+}
+
+// maxDownloadConnections caps parallel download segments regardless of --workers, to stay polite to the mirror.
+// minSegmentedDownloadSize is the smallest file worth splitting into segments.
+const (
+    maxDownloadConnections   = 4
+    minSegmentedDownloadSize = 16 << 20
+)
+
+// probeRangeDownload reports the size of url if the server accepts byte range requests for it.
+func probeRangeDownload(url string) (int64, bool) {
+    resp, err := downloadRequest(http.MethodHead, url, nil)
+    if err != nil {
+        return 0, false
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" || resp.ContentLength < minSegmentedDownloadSize {
+        return 0, false
+    }
+    return resp.ContentLength, true
+}
+
+// sharedProgress reports the combined progress of parallel download segments.
+type sharedProgress struct {
+    mu        sync.Mutex
+    total     int64
+    done      int64
+    lastPrint time.Time
+}
+
+// add records n more bytes and prints the overall percentage.
+func (p *sharedProgress) add(n int) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.done += int64(n)
+    if p.done != p.total && time.Since(p.lastPrint) < progressInterval {
+        return
+    }
+    p.lastPrint = time.Now()
+    fmt.Printf("\rDownloading... %.2f%%", float64(p.done)/float64(p.total)*100)
+}
+
+// progressSegment counts the bytes read from one download segment. The first skip bytes were
+// already counted by an earlier attempt at the segment.
+type progressSegment struct {
+    reader   io.Reader
+    progress *sharedProgress
+    skip     int64
+}
+
+// Read reads from the segment and updates the shared progress.
+func (s *progressSegment) Read(p []byte) (int, error) {
+    n, err := s.reader.Read(p)
+    counted := n
+    if s.skip > 0 {
+        skipped := min(int64(n), s.skip)
+        s.skip -= skipped
+        counted -= int(skipped)
+    }
+    s.progress.add(counted)
+    return n, err
+}
+
+// downloadSegments fetches url into dst as several byte ranges downloaded concurrently.
+func downloadSegments(url string, dst *os.File, size int64, connections int) error {
+    statusf("Total file size: %d bytes, downloading in %d parallel segments\n", size, connections)
+    progress := &sharedProgress{total: size}
+    segmentSize := (size + int64(connections) - 1) / int64(connections)
+
+    errs := make(chan error, connections)
+    for start := int64(0); start < size; start += segmentSize {
+        end := min(start+segmentSize, size) - 1
+        go func(start, end int64) {
+            errs <- downloadSegment(url, dst, start, end, progress)
+        }(start, end)
+    }
+
+    var firstErr error
+    for start := int64(0); start < size; start += segmentSize {
+        if err := <-errs; err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    fmt.Println() // New line after final progress output.
+    if firstErr != nil {
+        errorf("Error downloading RIPE database: %v\n", firstErr)
+    }
+    return firstErr
+}
+
+// downloadSegment fetches the bytes start-end (inclusive) of url into the same offsets of dst.
+// A failed segment is fetched again, as often as --retries allows; its bytes are only counted
+// once in the progress.
+func downloadSegment(url string, dst *os.File, start, end int64, progress *sharedProgress) error {
+    var counted int64
+    return withRetries(func() error {
+        resp, err := downloadRequest(http.MethodGet, url, http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", start, end)}})
+        if err != nil {
+            return err
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusPartialContent {
+            return fmt.Errorf("unexpected HTTP status for range %d-%d: %s", start, end, resp.Status)
+        }
+        segment := &progressSegment{reader: resp.Body, progress: progress, skip: counted}
+        n, err := io.Copy(io.NewOffsetWriter(dst, start), segment)
+        counted = max(counted, n)
+        if err != nil {
+            return retryable(fmt.Errorf("range %d-%d: %v", start, end, err), 0)
+        }
+        if n != end-start+1 {
+            return retryable(fmt.Errorf("short read for range %d-%d: got %d bytes", start, end, n), 0)
+        }
+        return nil
+    })
+}
+
+// gunzipFileWithProgress decompresses a .gz file and writes the output to a destination file.
+func gunzipFileWithProgress(source, destination string) error {
+    fi, err := os.Stat(source)
+    if err != nil {
+        return err
+    }
+    compressedSize := fi.Size()
+
+    // Ensure the destination directory exists, create if needed.
+    dir := filepath.Dir(destination)
+    if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+        return fmt.Errorf("error creating directory %s: %v", dir, err)
+    }
+
+    file, err := os.Open(source)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    progressReader := &ProgressReader{
+        Reader: file,
+        Total:  compressedSize,
+    }
+
+    gz, err := gzip.NewReader(progressReader)
+    if err != nil {
+        return err
+    }
+    defer gz.Close()
+
+    // The data is decompressed next to the destination and renamed over it, so readers of the old
+    // cache (a running -serve among them) never see a truncated or half-written file.
+    out, err := os.CreateTemp(dir, filepath.Base(destination)+".tmp-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(out.Name())
+    defer out.Close()
+    if err = out.Chmod(0644); err != nil {
+        return err
+    }
+
+    if _, err = io.Copy(out, gz); err != nil {
+        return err
+    }
+    if err = out.Sync(); err != nil {
+        return err
+    }
+    if err = out.Close(); err != nil {
+        return err
+    }
+    if err = os.Rename(out.Name(), destination); err != nil {
+        return err
+    }
+    statusln("\nDecompression completed.")
+    return nil
+}
+
+//-------------------------------------------------------------------------
+// Healthcheck
+//-------------------------------------------------------------------------
+
+// Exit statuses of -healthcheck, one per failed check, so cron wrappers and liveness probes can tell
+// them apart; 2 is left to --exit-code. When several checks fail, the first one in this order wins.
+const (
+    healthMissing     = 3 // The database cache does not exist or cannot be read.
+    healthStale       = 4 // The cache is older than -max-age.
+    healthUnparsable  = 5 // The cache holds fewer than -min-blocks inetnum blocks.
+    healthNotWritable = 6 // An output target given with -o cannot be written.
+)
+
+// healthcheckOptions configures -healthcheck.
+type healthcheckOptions struct {
+    MaxAge    time.Duration // 0 skips the freshness check.
+    MinBlocks int           // 0 skips reading the cache.
+    Targets   []string      // Output files or directories that must be writable.
+}
+
+// runHealthcheck checks the cache and the output targets without downloading anything, prints one
+// line per check and returns the exit status (0 if everything is healthy).
+func runHealthcheck(opts healthcheckOptions) int {
+    status := 0
+    fail := func(code int, format string, args ...any) {
+        fmt.Printf("FAIL "+tr(format), args...)
+        if status == 0 {
+            status = code
+        }
+    }
+
+    fi, err := os.Stat(ripedbPath)
+    if err != nil {
+        fail(healthMissing, "database: %v\n", err)
+        opts.MaxAge, opts.MinBlocks = 0, 0
+    } else {
+        fmt.Printf("OK   "+tr("database: %s (%s)\n"), ripedbPath, humanBytes(fi.Size()))
+    }
+
+    if opts.MaxAge > 0 {
+        info := loadDatabaseInfo()
+        age := time.Since(info.Downloaded).Truncate(time.Second)
+        if age > opts.MaxAge {
+            fail(healthStale, "age: %s, older than %s\n", age, opts.MaxAge)
+        } else {
+            fmt.Printf("OK   "+tr("age: %s (maximum %s)\n"), age, opts.MaxAge)
+        }
+    }
+
+    if opts.MinBlocks > 0 {
+        blocks, err := countInetnumBlocks(ripedbPath, opts.MinBlocks)
+        if err != nil {
+            fail(healthUnparsable, "parse: %v\n", err)
+        } else if blocks < opts.MinBlocks {
+            fail(healthUnparsable, "parse: %d inetnum blocks, expected at least %d\n", blocks, opts.MinBlocks)
+        } else {
+            fmt.Printf("OK   "+tr("parse: at least %d inetnum blocks\n"), opts.MinBlocks)
+        }
+    }
+
+    for _, target := range opts.Targets {
+        if err := checkWritable(target); err != nil {
+            fail(healthNotWritable, "writable: %s: %v\n", target, err)
+        } else {
+            fmt.Printf("OK   "+tr("writable: %s\n"), target)
+        }
+    }
+    return status
+}
+
+// countInetnumBlocks counts the inetnum blocks of a database file, stopping as soon as limit is reached,
+// so a healthy multi-gigabyte cache is not read to the end.
+func countInetnumBlocks(dbPath string, limit int) (int, error) {
+    file, err := openDatabase(dbPath)
+    if err != nil {
+        return 0, err
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    blocks, inBlock := 0, false
+    for scanner.Scan() && blocks < limit {
+        line := scanner.Text()
+        if line == "" {
+            inBlock = false
+            continue
+        }
+        if !inBlock && strings.HasPrefix(line, "inetnum:") {
+            blocks++
+        }
+        inBlock = true
+    }
+    return blocks, scanner.Err()
+}
+
+// checkWritable reports whether a file could be generated at target: in a directory, a temporary file
+// is created and removed; an existing file is opened for writing without changing it; for a new file,
+// its directory must be writable.
+func checkWritable(target string) error {
+    fi, err := os.Stat(target)
+    switch {
+    case err == nil && fi.IsDir():
+    case err == nil:
+        file, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND, 0)
+        if err != nil {
+            return err
+        }
+        return file.Close()
+    case os.IsNotExist(err):
+        target = filepath.Dir(target)
+    default:
+        return err
+    }
+    file, err := os.CreateTemp(target, ".chicha-whois-healthcheck-*")
+    if err != nil {
+        return err
+    }
+    file.Close()
+    return os.Remove(file.Name())
+}
+
+//-------------------------------------------------------------------------
+// Generation metadata
+//-------------------------------------------------------------------------
+
+// ripeSerialURL points to the serial number of the current RIPE database snapshot.
+const ripeSerialURL = "https://ftp.ripe.net/ripe/dbase/RIPE.CURRENTSERIAL"
+
+// databaseInfo records where and when the cached database came from. It is stored next
+// to the cache so that generated files can name the exact snapshot they were built from.
+type databaseInfo struct {
+    SourceURL  string    `json:"source_url"`
+    Serial     string    `json:"serial,omitempty"`
+    Downloaded time.Time `json:"downloaded_at"`
+}
+
+// databaseInfoPath returns the path of the metadata file that accompanies a cached database file.
+func databaseInfoPath(dbPath string) string {
+    return dbPath + ".info.json"
+}
+
+// fetchRIPESerial returns the serial number of the snapshot currently published by the RIPE NCC.
+func fetchRIPESerial() (string, error) {
+    resp, err := downloadRequest(http.MethodGet, ripeSerialURL, nil)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+    body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+    if err != nil {
+        return "", err
+    }
+    serial := strings.TrimSpace(string(body))
+    if _, err := strconv.ParseUint(serial, 10, 64); err != nil {
+        return "", fmt.Errorf("unexpected serial %q", serial)
+    }
+    return serial, nil
+}
+
+// saveDatabaseInfo stores the source URL, serial and download time of a freshly downloaded database.
+// The serial is informational only, so failing to fetch it is reported but not fatal.
+func saveDatabaseInfo(dbPath, sourceURL string) {
+    info := databaseInfo{SourceURL: sourceURL, Downloaded: time.Now().UTC()}
+    serial, err := fetchRIPESerial()
+    if err != nil {
+        warnf("Warning: unable to fetch the RIPE database serial: %v\n", err)
+    } else {
+        info.Serial = serial
+    }
+    data, _ := json.MarshalIndent(info, "", "  ")
+    if err := os.WriteFile(databaseInfoPath(dbPath), data, 0644); err != nil {
+        warnf("Warning: unable to save database metadata: %v\n", err)
+    }
+}
+
+// loadDatabaseInfo returns the metadata of the cached database. Caches downloaded before the
+// metadata file existed fall back to the cache modification time and have no serial.
+func loadDatabaseInfo() databaseInfo {
+    var info databaseInfo
+    if data, err := os.ReadFile(databaseInfoPath(ripedbPath)); err == nil && json.Unmarshal(data, &info) == nil {
+        return info
+    }
+    info.SourceURL = ripeDBURL
+    if customDB {
+        if abs, err := filepath.Abs(ripedbPath); err == nil {
+            info.SourceURL = "file://" + abs
+        }
+    }
+    if fi, err := os.Stat(ripedbPath); err == nil {
+        info.Downloaded = fi.ModTime().UTC()
+    }
+    return info
+}
+
+// databaseStatus is the content of the --status-file in JSON form.
+type databaseStatus struct {
+    DatabasePath       string    `json:"database_path"`
+    DatabasePresent    bool      `json:"database_present"`
+    Serial             string    `json:"serial,omitempty"`
+    Downloaded         time.Time `json:"downloaded_at,omitzero"`
+    AgeSeconds         int64     `json:"database_age_seconds"`
+    LastUpdateResult   string    `json:"last_update_result"` // "success" or "failure".
+    LastUpdateError    string    `json:"last_update_error,omitempty"`
+    LastUpdateAt       time.Time `json:"last_update_at"`
+    LastUpdateDuration float64   `json:"last_update_duration_seconds"`
+}
+
+// writeStatusFile records the state of the cache and the result of the update that just finished in
+// the --status-file: Prometheus text format (for the node-exporter textfile collector) if the name
+// ends in .prom, JSON otherwise. The file is replaced atomically, so a collector never reads half of it.
+// The age is as of the update; alert on time() - chicha_whois_database_downloaded_timestamp_seconds.
+func writeStatusFile(updateErr error, duration time.Duration) {
+    status := databaseStatus{
+        DatabasePath:       ripedbPath,
+        LastUpdateResult:   "success",
+        LastUpdateAt:       time.Now().UTC().Truncate(time.Second),
+        LastUpdateDuration: duration.Seconds(),
+    }
+    if updateErr != nil {
+        status.LastUpdateResult = "failure"
+        status.LastUpdateError = updateErr.Error()
+    }
+    if _, err := os.Stat(ripedbPath); err == nil {
+        info := loadDatabaseInfo()
+        status.DatabasePresent = true
+        status.Serial = info.Serial
+        status.Downloaded = info.Downloaded.Truncate(time.Second)
+        status.AgeSeconds = int64(time.Since(info.Downloaded).Seconds())
+    }
+
+    var content string
+    if strings.HasSuffix(statusFile, ".prom") {
+        var b strings.Builder
+        metric := func(name, help string, value any) {
+            fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+        }
+        present, success := 0, 0
+        if status.DatabasePresent {
+            present = 1
+        }
+        if updateErr == nil {
+            success = 1
+        }
+        metric("chicha_whois_database_present", "Whether the cached RIPE database exists.", present)
+        if status.DatabasePresent {
+            metric("chicha_whois_database_downloaded_timestamp_seconds", "When the cached RIPE database was downloaded.", status.Downloaded.Unix())
+            metric("chicha_whois_database_age_seconds", "Age of the cached RIPE database when the status file was written.", status.AgeSeconds)
+            if serial, err := strconv.ParseUint(status.Serial, 10, 64); err == nil {
+                metric("chicha_whois_database_serial", "Serial of the cached RIPE database snapshot.", serial)
+            }
+        }
+        metric("chicha_whois_last_update_success", "Whether the last database update succeeded.", success)
+        metric("chicha_whois_last_update_timestamp_seconds", "When the last database update attempt finished.", status.LastUpdateAt.Unix())
+        metric("chicha_whois_last_update_duration_seconds", "Duration of the last database update attempt.", fmt.Sprintf("%.3f", status.LastUpdateDuration))
+        content = b.String()
+    } else {
+        data, _ := json.MarshalIndent(status, "", "  ")
+        content = string(data) + "\n"
+    }
+
+    tmp := statusFile + ".tmp"
+    if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+        warnf("Warning: unable to write the status file: %v\n", err)
+        return
+    }
+    if err := os.Rename(tmp, statusFile); err != nil {
+        _ = os.Remove(tmp)
+        warnf("Warning: unable to write the status file: %v\n", err)
+    }
+}
+
+// outputMeta describes a generated file for its metadata header.
+type outputMeta struct {
+    Selection string // What the file contains, e.g. "country RU (filtered)".
+    Entries   int    // Number of entries (prefixes, ranges or decisions).
+    Comment   string // Line comment marker of the format; empty if the format has no comment syntax.
+}
+
+// metadataLines returns the metadata header lines (without comment markers) for content with the given
+// SHA-256. In reproducible mode the generation and download times are left out; the serial identifies the data.
+func metadataLines(contentHash string, meta outputMeta) []string {
+    info := loadDatabaseInfo()
+    var snapshot []string
+    if info.Serial != "" {
+        snapshot = append(snapshot, "serial "+info.Serial)
+    }
+    if !info.Downloaded.IsZero() && !reproducible {
+        snapshot = append(snapshot, "downloaded "+info.Downloaded.UTC().Format(time.RFC3339))
+    }
+    source := info.SourceURL
+    if len(snapshot) > 0 {
+        source += " (" + strings.Join(snapshot, ", ") + ")"
+    }
+
+    lines := []string{fmt.Sprintf("Generated by chicha-whois %s (https://github.com/matveynator/chicha-whois)", version)}
+    if !reproducible {
+        lines = append(lines, "Generated at: "+time.Now().UTC().Format(time.RFC3339))
+    }
+    return append(lines,
+        "Source: "+source,
+        "Selection: "+strings.NewReplacer("\r", " ", "\n", " ").Replace(meta.Selection),
+        fmt.Sprintf("Entries: %d", meta.Entries),
+        "Content SHA-256: "+contentHash,
+    )
+}
+
+// metadataHeader renders the metadata lines as comments, followed by an empty comment line.
+func metadataHeader(contentHash string, meta outputMeta) string {
+    var sb strings.Builder
+    for _, line := range metadataLines(contentHash, meta) {
+        sb.WriteString(meta.Comment + " " + line + "\n")
+    }
+    sb.WriteString(meta.Comment + "\n")
+    return sb.String()
+}
+
+// sha256Hex returns the hex-encoded SHA-256 of data.
+func sha256Hex(data string) string {
+    sum := sha256.Sum256([]byte(data))
+    return hex.EncodeToString(sum[:])
+}
+
+// withMetadataHeader prepends the metadata header to content using the comment marker of the format.
+// The hash covers everything below the header, so a file can be checked by stripping the header again.
+func withMetadataHeader(content string, meta outputMeta) string {
+    if meta.Comment == "" {
+        return content
+    }
+    return metadataHeader(sha256Hex(content), meta) + content
+}
+
+// writeOutputFile writes a generated file together with its metadata. Formats without comment
+// syntax (JSON, CSV, TSV) are written unchanged and get the metadata in a PATH.meta sidecar instead.
+func writeOutputFile(path, content string, meta outputMeta) error {
+    content = lineEndings(content)
+    data := content
+    if meta.Comment != "" {
+        data = lineEndings(withMetadataHeader(content, meta))
+    }
+    if existing, ok := unchangedOutput(path, sha256Hex(content), meta.Comment); ok {
+        return keepUnchangedOutput(path, existing, meta)
+    }
+    if err := backupOutput(path); err != nil {
+        return err
+    }
+    if err := writeGeneratedFile(path, []byte(data)); err != nil {
+        return err
+    }
+    if meta.Comment == "" {
+        if err := writeMetadataSidecar(path, sha256Hex(content), meta); err != nil {
+            return err
+        }
+    }
+    return completeOutput(path, int64(len(data)), sha256Hex(data), meta, true)
+}
+
+// unchangedOutput reports whether path already holds the content with the given hash (below its
+// metadata header, which carries a timestamp) and returns the existing file.
+func unchangedOutput(path, contentHash, comment string) (string, bool) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return "", false
+    }
+    existing := string(data)
+    return existing, sha256Hex(stripMetadataHeader(existing, comment)) == contentHash
+}
+
+// stripMetadataHeader removes the header written by withMetadataHeader, if text starts with one.
+func stripMetadataHeader(text, comment string) string {
+    if comment == "" || !strings.HasPrefix(text, comment+" Generated by chicha-whois") {
+        return text
+    }
+    for offset := 0; offset < len(text); {
+        end := strings.IndexByte(text[offset:], '\n')
+        if end < 0 {
+            break
+        }
+        line := strings.TrimSuffix(text[offset:offset+end], "\r")
+        offset += end + 1
+        if line == comment {
+            return text[offset:]
+        }
+    }
+    return text
+}
+
+// keepUnchangedOutput finishes an output that was left alone because its content did not change.
+func keepUnchangedOutput(path, existing string, meta outputMeta) error {
+    if !quiet {
+        statusln("Unchanged, not rewritten:", path)
+    }
+    if err := applyFileAccess(path); err != nil {
+        return err
+    }
+    return completeOutput(path, int64(len(existing)), sha256Hex(existing), meta, false)
+}
+
+// completeOutput writes the PATH.sha256 checksum requested by --checksums and records the file in the run report.
+func completeOutput(path string, size int64, fileHash string, meta outputMeta, changed bool) error {
+    if checksums {
+        if err := writeIfChanged(path+".sha256", fileHash+"  "+filepath.Base(path)+"\n"); err != nil {
+            return err
+        }
+    }
+    if signer.Tool != "" {
+        if err := signOutput(path, changed); err != nil {
+            return err
+        }
+    }
+    reportWrittenFile(path, size, fileHash, meta, changed)
+    return nil
+}
+
+// signerSpec is the signing configuration given with --sign minisign:SECKEY or gpg[:KEYID].
+type signerSpec struct {
+    Tool string // "minisign" or "gpg".
+    Key  string // Secret key file (minisign) or key id (gpg; empty uses the default key).
+}
+
+// parseSigner parses the value of --sign.
+func parseSigner(value string) (signerSpec, error) {
+    tool, key, _ := strings.Cut(value, ":")
+    switch tool {
+    case "minisign":
+        if key == "" {
+            return signerSpec{}, fmt.Errorf("minisign needs a secret key file (minisign:PATH)")
+        }
+        return signerSpec{Tool: tool, Key: key}, nil
+    case "gpg":
+        return signerSpec{Tool: tool, Key: key}, nil
+    }
+    return signerSpec{}, fmt.Errorf("unknown signing tool %q (expected minisign:SECKEY or gpg[:KEYID])", tool)
+}
+
+// signaturePath returns where the detached signature of path is written.
+func signaturePath(path string) string {
+    if signer.Tool == "minisign" {
+        return path + ".minisig"
+    }
+    return path + ".asc"
+}
+
+// signOutput writes a detached signature of a generated file with the external minisign or gpg tool,
+// so no crypto code or key handling lives here. An unchanged file is only signed if its signature is missing.
+// Encrypted keys prompt on the terminal; for unattended runs use an unencrypted minisign key or gpg-agent.
+func signOutput(path string, changed bool) error {
+    sigPath := signaturePath(path)
+    if _, err := os.Stat(sigPath); err == nil && !changed {
+        return nil
+    }
+    var cmd *exec.Cmd
+    if signer.Tool == "minisign" {
+        cmd = exec.Command("minisign", "-S", "-s", signer.Key, "-m", path, "-x", sigPath)
+    } else {
+        args := []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", sigPath}
+        if signer.Key != "" {
+            args = append(args, "--local-user", signer.Key)
+        }
+        cmd = exec.Command("gpg", append(args, path)...)
+    }
+    cmd.Stdin = os.Stdin
+    cmd.Stdout = os.Stderr
+    cmd.Stderr = os.Stderr
+    if err := cmd.Run(); err != nil {
+        return fmt.Errorf("signing %s with %s: %v", path, signer.Tool, err)
+    }
+    return applyFileAccess(sigPath)
+}
+
+// writeIfChanged writes a small companion file unless it already has exactly this content.
+func writeIfChanged(path, content string) error {
+    if existing, err := os.ReadFile(path); err == nil && string(existing) == content {
+        return applyFileAccess(path)
+    }
+    return writeGeneratedFile(path, []byte(content))
+}
+
+// writeMetadataSidecar writes the metadata of a file without comment syntax to PATH.meta.
+func writeMetadataSidecar(path, contentHash string, meta outputMeta) error {
+    return writeGeneratedFile(path+".meta", []byte(lineEndings(strings.Join(metadataLines(contentHash, meta), "\n")+"\n")))
+}
+
+// createGeneratedFile creates the temporary file a generated file is written to, next to path. It gets
+// the permissions and ownership requested by --mode and --owner before anything is written, so the
+// output is never readable with wider permissions than requested; without --mode an existing file
+// keeps its permissions and a new one gets 0644. installGeneratedFile moves it into place.
+func createGeneratedFile(path string) (*os.File, error) {
+    perm := fileMode
+    if perm == 0 {
+        perm = 0644
+        if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+            perm = fi.Mode().Perm()
+        }
+    }
+    file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+    if err != nil {
+        return nil, err
+    }
+    err = file.Chmod(perm)
+    if err == nil && (ownerUID != -1 || ownerGID != -1) {
+        err = file.Chown(ownerUID, ownerGID)
+    }
+    if err != nil {
+        file.Close()
+        os.Remove(file.Name())
+        return nil, err
+    }
+    return file, nil
+}
+
+// installGeneratedFile closes a file from createGeneratedFile and renames it to path, so readers
+// never see a partial file; on failure the temporary file is removed.
+func installGeneratedFile(file *os.File, path string) error {
+    err := file.Close()
+    if err == nil {
+        err = os.Rename(file.Name(), path)
+    }
+    if err != nil {
+        os.Remove(file.Name())
+    }
+    return err
+}
+
+// writeGeneratedFile writes a generated file with the permissions and ownership of --mode and --owner.
+func writeGeneratedFile(path string, data []byte) error {
+    file, err := createGeneratedFile(path)
+    if err != nil {
+        return err
+    }
+    if _, err := file.Write(data); err != nil {
+        file.Close()
+        os.Remove(file.Name())
+        return err
+    }
+    return installGeneratedFile(file, path)
+}
+
+// applyFileAccess sets the permissions and ownership requested by --mode and --owner on a file that is
+// kept (an unchanged output) or written by another tool (a signature).
+func applyFileAccess(path string) error {
+    if fileMode != 0 {
+        if err := os.Chmod(path, fileMode); err != nil {
+            return err
+        }
+    }
+    if ownerUID != -1 || ownerGID != -1 {
+        if err := os.Chown(path, ownerUID, ownerGID); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// backupSuffixRe matches the date suffix that backupOutput appends to a backup copy.
+var backupSuffixRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(-\d{6})?$`)
+
+// backupOutput copies an existing output to PATH.YYYY-MM-DD (the date it was generated) before it is
+// overwritten, and removes the oldest backups beyond the --backups limit. A second backup on the same
+// day gets the time appended as well.
+func backupOutput(path string) error {
+    if backups <= 0 {
+        return nil
+    }
+    info, err := os.Stat(path)
+    if err != nil || !info.Mode().IsRegular() {
+        return nil
+    }
+    backupPath := path + "." + info.ModTime().Format("2006-01-02")
+    if _, err := os.Stat(backupPath); err == nil {
+        backupPath = path + "." + info.ModTime().Format("2006-01-02-150405")
+    }
+    if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        // Without --mode the backup keeps the mode of the file, so a private list stays private.
+        file, err := createGeneratedFile(backupPath)
+        if err != nil {
+            return err
+        }
+        if fileMode == 0 {
+            err = file.Chmod(info.Mode().Perm())
+        }
+        if err == nil {
+            _, err = file.Write(data)
+        }
+        if err != nil {
+            file.Close()
+            os.Remove(file.Name())
+            return err
+        }
+        if err := installGeneratedFile(file, backupPath); err != nil {
+            return err
+        }
+        if !quiet {
+            statusln("Previous version saved as:", backupPath)
+        }
+    }
+
+    // The date suffixes sort chronologically, so everything before the last N names is stale.
+    matches, err := filepath.Glob(path + ".*")
+    if err != nil {
+        return err
+    }
+    var existing []string
+    for _, match := range matches {
+        if backupSuffixRe.MatchString(strings.TrimPrefix(match, path+".")) {
+            existing = append(existing, match)
+        }
+    }
+    sort.Strings(existing)
+    for len(existing) > backups {
+        if err := os.Remove(existing[0]); err != nil {
+            return err
+        }
+        existing = existing[1:]
+    }
+    return nil
+}
+
+// parseByteSize parses a size such as 512M, 2G, 64MiB or a plain number of bytes (binary units).
+func parseByteSize(value string) (int64, error) {
+    number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B"), "I")
+    shift := 0
+    if n := len(number); n > 0 {
+        if i := strings.IndexByte("KMGT", number[n-1]); i >= 0 {
+            shift = 10 * (i + 1)
+            number = number[:n-1]
+        }
+    }
+    n, err := strconv.ParseInt(number, 10, 64)
+    if err != nil {
+        return 0, err
+    }
+    if n > math.MaxInt64>>shift {
+        return 0, fmt.Errorf("size %s is too large", value)
+    }
+    return n << shift, nil
+}
+
+// parseOwner resolves an --owner value of the form USER, USER:GROUP or :GROUP; names and numeric ids
+// are both accepted. A missing part is returned as -1.
+func parseOwner(value string) (int, int, error) {
+    userName, groupName, _ := strings.Cut(value, ":")
+    uid, gid := -1, -1
+    if userName != "" {
+        id, err := strconv.Atoi(userName)
+        if err != nil {
+            u, lookupErr := user.Lookup(userName)
+            if lookupErr != nil {
+                return 0, 0, lookupErr
+            }
+            if id, err = strconv.Atoi(u.Uid); err != nil {
+                return 0, 0, fmt.Errorf("user %s has no numeric id", userName)
+            }
+        }
+        uid = id
+    }
+    if groupName != "" {
+        id, err := strconv.Atoi(groupName)
+        if err != nil {
+            g, lookupErr := user.LookupGroup(groupName)
+            if lookupErr != nil {
+                return 0, 0, lookupErr
+            }
+            if id, err = strconv.Atoi(g.Gid); err != nil {
+                return 0, 0, fmt.Errorf("group %s has no numeric id", groupName)
+            }
+        }
+        gid = id
+    }
+    if uid == -1 && gid == -1 {
+        return 0, 0, fmt.Errorf("empty owner")
+    }
+    return uid, gid, nil
+}
+
+// lineEndings converts text to CRLF line endings when --crlf is set and returns it unchanged otherwise.
+func lineEndings(text string) string {
+    if !crlf {
+        return text
+    }
+    return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+}
+
+// crlfWriter inserts a carriage return before every line feed written to w.
+type crlfWriter struct {
+    w io.Writer
+}
+
+// Write converts p and reports it as fully written.
+func (c crlfWriter) Write(p []byte) (int, error) {
+    if _, err := c.w.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+        return 0, err
+    }
+    return len(p), nil
+}
+
+//-------------------------------------------------------------------------
+// Run report
+//-------------------------------------------------------------------------
+
+// runReport is the machine-readable summary of a run written by --report.
+type runReport struct {
+    Command         string          `json:"command"`
+    Version         string          `json:"version"`
+    StartedAt       time.Time       `json:"started_at"`
+    FinishedAt      time.Time       `json:"finished_at"`
+    DurationSeconds float64         `json:"duration_seconds"`
+    UpdateSeconds   float64         `json:"database_update_seconds,omitempty"`
+    Database        databaseInfo    `json:"database"`
+    Countries       map[string]int  `json:"countries"`
+    Summaries       []prefixSummary `json:"prefix_summaries"`
+    Files           []reportFile    `json:"files"`
+    Warnings        []string        `json:"warnings"`
+}
+
+// reportFile describes one file written during the run.
+type reportFile struct {
+    Path      string `json:"path"`
+    Selection string `json:"selection"`
+    Entries   int    `json:"entries"`
+    Bytes     int64  `json:"bytes"`
+    SHA256    string `json:"sha256"`
+    Changed   bool   `json:"changed"` // False if the file already had this content and was left alone.
+}
+
+// reportPath is the file given with --report; empty disables the report.
+// report collects the run summary; reportMu guards it (server mode updates from goroutines).
+// outputsChanged records, for --exit-code, whether any output was written; reportMu guards it too.
+var (
+    reportPath     string
+    report         runReport
+    reportMu       sync.Mutex
+    outputsChanged bool
+)
+
+// reportCountry records the number of entries generated for a country.
+func reportCountry(countryCode string, entries int) {
+    if reportPath == "" {
+        return
+    }
+    reportMu.Lock()
+    defer reportMu.Unlock()
+    if report.Countries == nil {
+        report.Countries = make(map[string]int)
+    }
+    report.Countries[strings.ToUpper(countryCode)] += entries
+}
+
+// reportPrefixSummary records the prefix and address counts of a generated list.
+func reportPrefixSummary(s prefixSummary) {
+    if reportPath == "" {
+        return
+    }
+    reportMu.Lock()
+    defer reportMu.Unlock()
+    report.Summaries = append(report.Summaries, s)
+}
+
+// reportWrittenFile records a generated file with its final size and SHA-256, and whether it changed.
+func reportWrittenFile(path string, size int64, fileHash string, meta outputMeta, changed bool) {
+    reportMu.Lock()
+    defer reportMu.Unlock()
+    outputsChanged = outputsChanged || changed
+    if reportPath == "" {
+        return
+    }
+    report.Files = append(report.Files, reportFile{
+        Path:      path,
+        Selection: meta.Selection,
+        Entries:   meta.Entries,
+        Bytes:     size,
+        SHA256:    fileHash,
+        Changed:   changed,
+    })
+}
+
+// exitStatus is set by commands that end with their own status (-healthcheck); it takes precedence
+// over --exit-code and is applied by exitWithStatus after the deferred work of main.
+var exitStatus int
+
+// exitWithStatus ends the process with exitStatus if it is set, and otherwise for --exit-code with
+// status 1 if an error was reported, so a failed run is never mistaken for an unchanged one, 2 if any
+// output changed and 0 if none did. Without either it returns and main ends normally.
+func exitWithStatus() {
+    if exitStatus != 0 {
+        os.Exit(exitStatus)
+    }
+    if !exitCode {
+        return
+    }
+    statusMu.Lock()
+    failed := statusCounts["error"] > 0
+    statusMu.Unlock()
+    if failed {
+        os.Exit(1)
+    }
+    reportMu.Lock()
+    changed := outputsChanged
+    reportMu.Unlock()
+    if changed {
+        os.Exit(2)
+    }
+    os.Exit(0)
+}
+
+// statusMessage is one line of the --json-errors output on stderr.
+type statusMessage struct {
+    Time    time.Time `json:"time"`
+    Level   string    `json:"level"`        // "info", "warning" or "error".
+    ID      string    `json:"id,omitempty"` // The untranslated message, stable across --lang, for matching.
+    Message string    `json:"message"`      // The message as it would have been printed.
+}
+
+// statusMu serializes status output; statusCounts counts the messages by level for the final summary.
+var (
+    statusMu     sync.Mutex
+    statusCounts = make(map[string]int)
+)
+
+// printStatus prints a status message as before, or with --json-errors writes it to stderr as a JSON line.
+func printStatus(level, id, text string) {
+    statusMu.Lock()
+    defer statusMu.Unlock()
+    statusCounts[level]++
+    if !jsonStatus {
+        fmt.Print(text)
+        return
+    }
+    data, _ := json.Marshal(statusMessage{
+        Time:    time.Now().UTC(),
+        Level:   level,
+        ID:      strings.TrimSpace(id),
+        Message: strings.TrimSpace(text),
+    })
+    fmt.Fprintln(os.Stderr, string(data))
+}
+
+// statusLevel is "warning" for messages starting with "Warning" and "info" otherwise.
+func statusLevel(format string) string {
+    if strings.HasPrefix(format, "Warning") {
+        return "warning"
+    }
+    return "info"
+}
+
+// statusf prints a localized progress or result message (fmt.Printf of tr(format)).
+func statusf(format string, args ...any) {
+    printStatus(statusLevel(format), format, fmt.Sprintf(tr(format), args...))
+}
+
+// statusln prints a localized message followed by args (fmt.Println of tr(message) and args).
+func statusln(message string, args ...any) {
+    printStatus(statusLevel(message), message, fmt.Sprintln(append([]any{tr(message)}, args...)...))
+}
+
+// errorf prints a localized error message.
+func errorf(format string, args ...any) {
+    printStatus("error", format, fmt.Sprintf(tr(format), args...))
+}
+
+// errorln prints an error (fmt.Println of args); a leading message string is localized.
+func errorln(args ...any) {
+    id := ""
+    if message, ok := args[0].(string); ok {
+        id = message
+        args = append([]any{tr(message)}, args[1:]...)
+    }
+    printStatus("error", id, fmt.Sprintln(args...))
+}
+
+// writeStatusSummary ends the --json-errors output with the number of errors and warnings and
+// whether any output changed, so a wrapper has one line to check.
+func writeStatusSummary() {
+    reportMu.Lock()
+    changed := outputsChanged
+    reportMu.Unlock()
+    statusMu.Lock()
+    defer statusMu.Unlock()
+    data, _ := json.Marshal(map[string]any{
+        "time":     time.Now().UTC(),
+        "level":    "summary",
+        "errors":   statusCounts["error"],
+        "warnings": statusCounts["warning"],
+        "changed":  changed,
+    })
+    fmt.Fprintln(os.Stderr, string(data))
+}
+
+// warnf prints a localized warning and records it, untranslated, for the run report.
+func warnf(format string, args ...any) {
+    printStatus("warning", format, fmt.Sprintf(tr(format), args...))
+    if reportPath == "" {
+        return
+    }
+    reportMu.Lock()
+    defer reportMu.Unlock()
+    report.Warnings = append(report.Warnings, strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+// writeRunReport finishes the run summary and writes it to reportPath.
+func writeRunReport() {
+    if reportPath == "" {
+        return
+    }
+    reportMu.Lock()
+    defer reportMu.Unlock()
+    report.Version = version
+    report.FinishedAt = time.Now().UTC()
+    report.DurationSeconds = report.FinishedAt.Sub(report.StartedAt).Seconds()
+    report.Database = loadDatabaseInfo()
+    // Empty collections are written as {} and [] rather than null, which is easier to consume.
+    if report.Countries == nil {
+        report.Countries = map[string]int{}
+    }
+    if report.Files == nil {
+        report.Files = []reportFile{}
+    }
+    if report.Warnings == nil {
+        report.Warnings = []string{}
+    }
+    data, err := json.MarshalIndent(report, "", "  ")
+    if err != nil {
+        errorf("Error writing run report: %v\n", err)
+        return
+    }
+    if err := os.WriteFile(reportPath, append(data, '\n'), 0644); err != nil {
+        errorf("Error writing run report: %v\n", err)
+        return
+    }
+    statusf("Run report written to %s\n", reportPath)
+}
+
+//-------------------------------------------------------------------------
+// Functions that write DNS/OVPN output to files (for older flags)
+//-------------------------------------------------------------------------
+
+// aclSpec names one ACL statement: the country to extract and the ACL identifier.
+type aclSpec struct {
+    CountryCode string // Country whose ranges go into the ACL.
+    Name        string // ACL identifier; defaults to the country code.
+}
+
+// bindACLOptions controls filtering, naming and layout of generated BIND ACL files.
+type bindACLOptions struct {
+    Filtered bool   // Remove subnets nested in larger ones.
+    Output   string // Output file (combined layout) or directory (split layout); defaults to the home directory.
+    Split    bool   // Write one file per ACL plus an index file of include statements.
+    Chunk    int    // If positive, write each ACL as include files of at most this many prefixes.
+    Views    bool   // Add a view per ACL and a default view, making a complete named.conf fragment.
+}
+
+// renderBindViews renders a view per ACL that matches its clients, followed by a default view for
+// all other clients. Once named.conf has views, every zone must be in one, so each view includes
+// its zones from a file named after it (commented out until that file exists).
+func renderBindViews(names []string) string {
+    var sb strings.Builder
+    sb.WriteString("// Views selected by the ACLs above; views are matched in order, so \"default\" comes last.\n")
+    sb.WriteString("// Move every zone of named.conf into a view, e.g. into the files included below.\n")
+    for _, name := range append(slices.Clone(names), "default") {
+        match := fmt.Sprintf("\"%s\";", name)
+        if name == "default" {
+            match = "any;"
+        }
+        fmt.Fprintf(&sb, "\nview \"%s\" {\n", name)
+        fmt.Fprintf(&sb, "  match-clients { %s };\n", match)
+        fmt.Fprintf(&sb, "  // include \"/etc/bind/zones_%s.conf\";\n", name)
+        sb.WriteString("};\n")
+    }
+    return sb.String()
+}
+
+// createBindACLs generates BIND ACL statements for one or more countries. By default all
+// statements go into a single file; with Split every ACL gets its own file and an index
+// file of include statements is written, so named.conf only needs a single include.
+// With Views, the view blocks follow the ACLs in the single file or in the index.
+func createBindACLs(specs []aclSpec, opts bindACLOptions) {
+    homeDir, _ := os.UserHomeDir()
+
+    var names, codes []string
+    for _, spec := range specs {
+        if spec.Name == "" {
+            spec.Name = spec.CountryCode
+        }
+        if strings.ContainsAny(spec.Name, "\"\n;{} /\\") {
+            errorf("Invalid ACL name: %q\n", spec.Name)
+            return
+        }
+        if opts.Views && spec.Name == "default" {
+            errorln("The ACL name default is reserved for the default view with -views.")
+            return
+        }
+        names = append(names, spec.Name)
+        codes = append(codes, spec.CountryCode)
+    }
+    if !streamable(opts.Filtered) {
+        defer prefetchCountries(codes)()
+    }
+
+    selectionSuffix := ""
+    created := "BIND ACL file created at: %s\n"
+    writeError := "Error writing BIND ACL file: %v\n"
+    if opts.Filtered {
+        selectionSuffix = " (filtered)"
+        created = "Filtered BIND ACL file created at: %s\n"
+        writeError = "Error writing filtered BIND ACL file: %v\n"
+    }
+
+    // writeACL extracts one country and appends its ACL statement to out, whose file is in dir.
+    writeACL := func(out *streamedOutput, i int, dir string) (int, bool) {
+        if opts.Filtered {
+            statusf("Creating BIND ACL file (filtered) for country code: %s\n", codes[i])
+        } else {
+            statusf("Creating BIND ACL file for country code: %s\n", codes[i])
+        }
+        var entries int
+        var ok bool
+        if opts.Chunk > 0 {
+            entries, ok = writeChunkedACL(out, dir, codes[i], names[i], opts.Filtered, opts.Chunk)
+        } else {
+            entries, ok = writeCountryPrefixes(out, codes[i], names[i], opts.Filtered, bindACLLines)
+        }
+        if ok {
+            reportCountry(codes[i], entries)
+        }
+        return entries, ok
+    }
+
+    if !opts.Split {
+        aclFilePath := opts.Output
+        if aclFilePath == "" {
+            aclFilePath = filepath.Join(homeDir, fmt.Sprintf("acl_%s.conf", strings.Join(codes, "_")))
+        }
+        // Include statements need absolute paths, because BIND resolves them against its own directory.
+        aclDir, err := filepath.Abs(filepath.Dir(aclFilePath))
+        if err != nil {
+            aclDir = filepath.Dir(aclFilePath)
+        }
+        out, err := createStreamedOutput(aclFilePath)
+        if err != nil {
+            errorf(writeError, err)
+            return
+        }
+        defer out.discard()
+
+        total := 0
+        for i := range specs {
+            if i > 0 {
+                out.WriteString("\n")
+            }
+            entries, ok := writeACL(out, i, aclDir)
+            if !ok {
+                return
+            }
+            total += entries
+        }
+        if opts.Views {
+            out.WriteString("\n" + renderBindViews(names))
+        }
+        meta := outputMeta{Selection: "countries " + strings.Join(codes, ", ") + selectionSuffix, Entries: total, Comment: "//"}
+        if len(codes) == 1 {
+            meta.Selection = "country " + codes[0] + selectionSuffix
+        }
+        if err := out.finish(meta); err != nil {
+            errorf(writeError, err)
+            return
+        }
+        statusf(created, aclFilePath)
+        return
+    }
+
+    outDir := opts.Output
+    if outDir == "" {
+        outDir = homeDir
+    }
+    if err := os.MkdirAll(outDir, 0755); err != nil {
+        errorf(writeError, err)
+        return
+    }
+    absDir, err := filepath.Abs(outDir)
+    if err != nil {
+        absDir = outDir
+    }
+
+    var includes []string
+    for i, name := range names {
+        aclFilePath := filepath.Join(absDir, fmt.Sprintf("acl_%s.conf", name))
+        out, err := createStreamedOutput(aclFilePath)
+        if err != nil {
+            errorf(writeError, err)
+            return
+        }
+        entries, ok := writeACL(out, i, absDir)
+        if !ok {
+            out.discard()
+            return
+        }
+        meta := outputMeta{Selection: "country " + codes[i] + selectionSuffix, Entries: entries, Comment: "//"}
+        err = out.finish(meta)
+        out.discard()
+        if err != nil {
+            errorf(writeError, err)
+            return
+        }
+        statusf(created, aclFilePath)
+        includes = append(includes, fmt.Sprintf("include \"%s\";", aclFilePath))
+    }
+
+    indexPath := filepath.Join(absDir, "acl_index.conf")
+    index := "// Generated by chicha-whois: include this file from named.conf\n" + strings.Join(includes, "\n") + "\n"
+    if opts.Views {
+        index += "\n" + renderBindViews(names)
+    }
+    meta := outputMeta{Selection: "ACL index for " + strings.Join(names, ", "), Entries: len(includes), Comment: "//"}
+    if err := writeOutputFile(indexPath, index, meta); err != nil {
+        errorf(writeError, err)
+        return
+    }
+    statusf("ACL index file created at: %s (add include \"%s\"; to named.conf)\n", indexPath, indexPath)
+}
+
+// createOpenVPNExclude creates an OpenVPN exclude-route file for the given country code;
+// filtered removes nested subnets.
+func createOpenVPNExclude(countryCode string, filtered bool) {
+    if filtered {
+        statusf("Creating a filtered OpenVPN exclude-route file for country code: %s\n", countryCode)
+    } else {
+        statusf("Creating an unfiltered OpenVPN exclude-route file for country code: %s\n", countryCode)
+    }
+
+    homeDir, _ := os.UserHomeDir()
+    outFilePath := filepath.Join(homeDir, fmt.Sprintf("openvpn_exclude_%s.txt", strings.ToUpper(countryCode)))
+
+    writeError := "Error writing OpenVPN exclude file: %v\n"
+    if filtered {
+        writeError = "Error writing filtered OpenVPN exclude file: %v\n"
+    }
+    out, err := createStreamedOutput(outFilePath)
+    if err != nil {
+        errorf(writeError, err)
+        return
+    }
+    defer out.discard()
+
+    entries, ok := writeCountryPrefixes(out, countryCode, countryCode, filtered, openVPNExcludeLines(filtered))
+    if !ok {
+        return
+    }
+    selection := "country " + strings.ToUpper(countryCode)
+    if filtered {
+        selection += " (filtered)"
+    }
+    if err := out.finish(outputMeta{Selection: selection, Entries: entries, Comment: "#"}); err != nil {
+        errorf(writeError, err)
+        return
+    }
+    reportCountry(countryCode, entries)
+    if filtered {
+        statusf("Filtered OpenVPN exclude-route file created at: %s\n", outFilePath)
+    } else {
+        statusf("OpenVPN exclude-route file created at: %s\n", outFilePath)
+    }
+}
+
+// writeCountryPrefixes extracts the prefixes of a country and writes them to out with a line
+// renderer. In low-memory mode the prefixes are streamed instead of collected in memory. It returns
+// the number of entries, or false (after printing why) if there is nothing to write.
+func writeCountryPrefixes(out *streamedOutput, countryCode, name string, filtered bool, r lineRenderer) (int, bool) {
+    first := true
+    entries, ok := eachCountryPrefix(countryCode, filtered, func(cidr string) error {
+        if first {
+            out.WriteString(r.Header(name))
+            first = false
+        }
+        out.WriteString(r.Line(cidr))
+        return nil
+    })
+    if ok {
+        out.WriteString(r.Footer)
+    }
+    return entries, ok
+}
+
+// eachCountryPrefix extracts the prefixes of a country in the address families selected with -4, -6
+// or -46, prints their summary and passes them to fn in output order, IPv4 before IPv6. It returns
+// the number of prefixes, or false (after printing why) if there are none or fn failed.
+func eachCountryPrefix(countryCode string, filtered bool, fn func(cidr string) error) (int, bool) {
+    if !withIPv6 {
+        return eachCountryPrefix4(countryCode, filtered, fn)
+    }
+    // An empty family is only reported, so that the other one is still written.
+    var fnErr error
+    pass := func(cidr string) error {
+        fnErr = fn(cidr)
+        return fnErr
+    }
+    entries := 0
+    if withIPv4 {
+        n, _ := eachCountryPrefix4(countryCode, filtered, pass)
+        if fnErr != nil {
+            return 0, false
+        }
+        entries += n
+    }
+    n, _ := eachCountryPrefix6(countryCode, filtered, pass)
+    if fnErr != nil {
+        return 0, false
+    }
+    entries += n
+    return entries, entries > 0
+}
+
+// eachCountryPrefix6 is eachCountryPrefix for the inet6num objects of a country, which are downloaded
+// first if they are not cached. They are always held in memory: RIPE IPv6 lists are short.
+func eachCountryPrefix6(countryCode string, filtered bool, fn func(cidr string) error) (int, bool) {
+    if !ensureInet6num() {
+        return 0, false
+    }
+    prefixes, err := extractCountryCIDRs6(countryCode)
+    if err != nil {
+        errorln("Error reading inet6num objects:", err)
+        return 0, false
+    }
+    if len(prefixes) == 0 {
+        warnf("No IPv6 ranges found for country code: %s\n", countryCode)
+        return 0, false
+    }
+    summary := prefixSummary{Name: countryCode, PrefixesBefore: len(prefixes), NetworksBefore: countNetworks6(prefixes)}
+    prefixes = removeDuplicates(prefixes)
+    if filtered {
+        prefixes = filterRedundantCIDRs(prefixes)
+    }
+    if invert {
+        prefixes = invertPrefixes(prefixes, true)
+    }
+    prefixes = applySetOperations(prefixes)
+    if aggregate {
+        prefixes = aggregateIPv6Prefixes(prefixes)
+    }
+    sortIPv6Prefixes(prefixes)
+    summary.PrefixesAfter, summary.NetworksAfter = len(prefixes), countNetworks6(prefixes)
+    reportPrefixSummary(summary)
+    printPrefixSummary(summary)
+    for _, cidr := range prefixes {
+        if err := fn(cidr); err != nil {
+            errorln(err)
+            return 0, false
+        }
+    }
+    return len(prefixes), true
+}
+
+// extractCountryCIDRs6 returns the prefixes of the inet6num objects of a country or country group.
+func extractCountryCIDRs6(countryCode string) ([]string, error) {
+    var prefixes []string
+    err := scanObjects("inet6num", func(blockLines []string) {
+        if !inCountrySelection(countryCode, strings.ToUpper(blockAttr(blockLines, "country"))) {
+            return
+        }
+        _, ipNet, err := net.ParseCIDR(strings.Join(strings.Fields(blockAttr(blockLines, "inet6num")), ""))
+        if err != nil || ipNet.IP.To4() != nil {
+            return
+        }
+        prefixes = append(prefixes, ipNet.String())
+    })
+    return prefixes, err
+}
+
+// sortIPv6Prefixes sorts IPv6 prefixes by address, larger networks first at the same address.
+func sortIPv6Prefixes(prefixes []string) {
+    sort.Slice(prefixes, func(i, j int) bool {
+        a, errA := netip.ParsePrefix(prefixes[i])
+        b, errB := netip.ParsePrefix(prefixes[j])
+        if errA != nil || errB != nil {
+            return prefixes[i] < prefixes[j]
+        }
+        if c := a.Addr().Compare(b.Addr()); c != 0 {
+            return c < 0
+        }
+        return a.Bits() < b.Bits()
+    })
+}
+
+// ensureInet6num makes sure inet6num objects are cached, downloading them unless --offline or --db is set.
+func ensureInet6num() bool {
+    if fileExists(objectCachePath("inet6num")) || fileExists(fullDBPath()) {
+        return true
+    }
+    if offline || customDB {
+        errorln("No inet6num objects in the cache; run chicha-whois -u inet6num first.")
+        return false
+    }
+    statusln("RIPE inet6num cache not found. Attempting to update...")
+    return updateRIPEObject("inet6num", objectCachePath("inet6num")) == nil
+}
+
+// eachCountryPrefix4 is eachCountryPrefix for the inetnum blocks of a country; in low-memory mode
+// they are streamed instead of collected in memory.
+func eachCountryPrefix4(countryCode string, filtered bool, fn func(cidr string) error) (int, bool) {
+    if streamable(filtered) {
+        return streamCountryPrefixes(countryCode, fn)
+    }
+    if !diskBacked(countryCode) {
+        ipRanges := extractCountryCIDRs(countryCode, ripedbPath, false)
+        if len(ipRanges) == 0 {
+            warnf("No IP ranges found for country code: %s\n", countryCode)
+            return 0, false
+        }
+        ipRanges, summary := preparePrefixes(countryCode, ipRanges, filtered)
+        printPrefixSummary(summary)
+        for _, cidr := range ipRanges {
+            if err := fn(cidr); err != nil {
+                errorln(err)
+                return 0, false
+            }
+        }
+        return len(ipRanges), true
+    }
+
+    entries := 0
+    var counter addressCounter
+    var fnErr error
+    err := streamCountryCIDRs([]string{countryCode}, filtered, func(_, cidr string) error {
+        if fnErr = fn(cidr); fnErr != nil {
+            return fnErr
+        }
+        counter.add(cidr)
+        entries++
+        return nil
+    })
+    if fnErr != nil {
+        errorln(fnErr)
+        return 0, false
+    }
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return 0, false
+    }
+    if entries == 0 {
+        warnf("No IP ranges found for country code: %s\n", countryCode)
+        return 0, false
+    }
+    summary := prefixSummary{Name: countryCode, PrefixesAfter: entries, AddressesAfter: counter.total}
+    reportPrefixSummary(summary)
+    printPrefixSummary(summary)
+    return entries, true
+}
+
+// streamable reports whether --stream can pass prefixes on as the database is read: removing nested
+// networks, widening to --granularity, --aggregate, --invert and merging --sources need the whole list first.
+func streamable(filtered bool) bool {
+    return streamResults && !filtered && granularity == 0 && !aggregate && !invert && len(sources) == 0
+}
+
+// streamCountryPrefixes is eachCountryPrefix for --stream: prefixes are passed to fn in database
+// order (by address) as they are read, with only adjacent duplicates removed, and the summary is
+// printed afterwards.
+func streamCountryPrefixes(countryCode string, fn func(cidr string) error) (int, bool) {
+    countryCode = strings.ToUpper(countryCode)
+    entries, ignored := 0, 0
+    var counter addressCounter
+    var fnErr error
+    last := ""
+    err := scanRanges(ripedbPath, false, func(r dbRange) {
+        if fnErr != nil {
+            return
+        }
+        countryFields := strings.Fields(r.Country)
+        if len(countryFields) == 0 || !inCountrySelection(countryCode, countryFields[0]) {
+            return
+        }
+        countCoverage(rangeCoverage(r.Start, r.End))
+        eachRangePrefix(r.Start, r.End, func(network uint32, prefix int) {
+            if fnErr != nil {
+                return
+            }
+            if maxPrefix > 0 && prefix > maxPrefix {
+                ignored++
+                return
+            }
+            cidr := fmt.Sprintf("%s/%d", uint32ToIP(network), prefix)
+            if cidr == last {
+                return
+            }
+            last = cidr
+            for _, piece := range setOperation(cidr) {
+                if fnErr = fn(piece); fnErr != nil {
+                    return
+                }
+                counter.add(piece)
+                entries++
+            }
+        })
+    })
+    if fnErr != nil {
+        errorln(fnErr)
+        return 0, false
+    }
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return 0, false
+    }
+    if entries == 0 {
+        warnf("No IP ranges found for country code: %s\n", countryCode)
+        return 0, false
+    }
+    summary := prefixSummary{Name: countryCode, PrefixesAfter: entries, AddressesAfter: counter.total, Ignored: ignored}
+    reportPrefixSummary(summary)
+    printPrefixSummary(summary)
+    return entries, true
+}
+
+// removeOutput removes a generated file that is no longer produced together with its companion
+// files: the metadata sidecar, the --checksums file and the --sign signatures of either tool.
+func removeOutput(path string) error {
+    if err := os.Remove(path); err != nil {
+        return err
+    }
+    for _, suffix := range []string{".meta", ".sha256", ".minisig", ".asc"} {
+        os.Remove(path + suffix)
+    }
+    return nil
+}
+
+// writeChunkedACL writes the ACL of a country as numbered files of at most size prefixes
+// (acl_NAME_1.conf, ...) in dir, each defining the ACL NAME_N, and appends to out the include
+// statements and an ACL NAME that combines the parts. Parts left over from a larger earlier run
+// are removed, so the directory always matches the master file.
+func writeChunkedACL(out *streamedOutput, dir, countryCode, name string, filtered bool, size int) (int, bool) {
+    var part *streamedOutput
+    var parts []string
+    partEntries := 0
+    finishPart := func() error {
+        if part == nil {
+            return nil
+        }
+        meta := outputMeta{Selection: fmt.Sprintf("country %s, part %d", countryCode, len(parts)), Entries: partEntries, Comment: "//"}
+        part.WriteString(bindACLLines.Footer)
+        err := part.finish(meta)
+        part.discard()
+        part = nil
+        return err
+    }
+    defer func() {
+        if part != nil {
+            part.discard()
+        }
+    }()
+
+    entries, ok := eachCountryPrefix(countryCode, filtered, func(cidr string) error {
+        if part != nil && partEntries == size {
+            if err := finishPart(); err != nil {
+                return err
+            }
+        }
+        if part == nil {
+            partName := fmt.Sprintf("%s_%d", name, len(parts)+1)
+            var err error
+            if part, err = createStreamedOutput(filepath.Join(dir, "acl_"+partName+".conf")); err != nil {
+                return err
+            }
+            parts = append(parts, partName)
+            partEntries = 0
+            part.WriteString(bindACLLines.Header(partName))
+        }
+        part.WriteString(bindACLLines.Line(cidr))
+        partEntries++
+        return nil
+    })
+    if !ok {
+        return 0, false
+    }
+    if err := finishPart(); err != nil {
+        errorln(err)
+        return 0, false
+    }
+    for n := len(parts) + 1; ; n++ {
+        if removeOutput(filepath.Join(dir, fmt.Sprintf("acl_%s_%d.conf", name, n))) != nil {
+            break
+        }
+    }
+
+    for _, partName := range parts {
+        out.WriteString(fmt.Sprintf("include \"%s\";\n", filepath.Join(dir, "acl_"+partName+".conf")))
+    }
+    out.WriteString(fmt.Sprintf("acl \"%s\" {\n", name))
+    for _, partName := range parts {
+        out.WriteString(fmt.Sprintf("  \"%s\";\n", partName))
+    }
+    out.WriteString("};\n")
+    statusf("%s: %d prefixes written to %d include files of at most %d\n", name, entries, len(parts), size)
+    return entries, true
+}
+
+// lineRenderer renders a prefix list line by line, so that it can be streamed as well as built in memory.
+type lineRenderer struct {
+    Header func(name string) string // Text before the first entry.
+    Line   func(cidr string) string // One entry including its newline; "" skips the entry.
+    Footer string                   // Text after the last entry.
+}
+
+// renderLines renders a whole prefix list with a line renderer.
+func renderLines(r lineRenderer, name string, cidrs []string) string {
+    var sb strings.Builder
+    sb.WriteString(r.Header(name))
+    for _, cidr := range cidrs {
+        sb.WriteString(r.Line(cidr))
+    }
+    sb.WriteString(r.Footer)
+    return sb.String()
+}
+
+// bindACLLines renders a BIND acl statement with one CIDR per line.
+var bindACLLines = lineRenderer{
+    Header: func(name string) string { return fmt.Sprintf("acl \"%s\" {\n", name) },
+    Line:   func(cidr string) string { return fmt.Sprintf("  %s;\n", cidr) },
+    Footer: "};\n",
+}
+
+// openVPNExcludeLines renders the server-side exclude-route file written by -ovpn and -ovpn-f.
+func openVPNExcludeLines(filtered bool) lineRenderer {
+    return lineRenderer{
+        Header: func(name string) string {
+            title := fmt.Sprintf("# Exclude %s IPs from VPN", strings.ToUpper(name))
+            if filtered {
+                title += " (filtered)"
+            }
+            return "# Redirect all traffic through VPN\npush \"redirect-gateway " + redirectGateway() + "\"\n\n" + title + "\n"
+        },
+        Line: func(cidr string) string {
+            route, err := openVPNRoute(cidr)
+            if err != nil {
+                warnf("Skipping CIDR (%s): %v\n", cidr, err)
+                return ""
+            }
+            return fmt.Sprintf("push \"%s\"\n", route)
+        },
+    }
+}
+
+// openVPNRouteLines renders exclude routes via net_gateway, either as client
+// directives or as server "push" directives.
+func openVPNRouteLines(push bool) lineRenderer {
+    return lineRenderer{
+        Header: func(name string) string {
+            var sb strings.Builder
+            if push {
+                fmt.Fprintf(&sb, "# Redirect all traffic through VPN (server pushes these directives)\npush \"redirect-gateway %s\"\n", redirectGateway())
+            } else {
+                fmt.Fprintf(&sb, "# Redirect all traffic through VPN\nredirect-gateway %s\n", redirectGateway())
+            }
+            fmt.Fprintf(&sb, "\n# Exclude %s IP ranges from the VPN\n", strings.ToUpper(name))
+            return sb.String()
+        },
+        Line: func(cidr string) string {
+            route, err := openVPNRoute(cidr)
+            if err != nil {
+                return ""
+            }
+            if push {
+                return fmt.Sprintf("push \"%s\"\n", route)
+            }
+            return route + "\n"
+        },
+    }
+}
+
+// renderBindACL renders a BIND acl statement with one CIDR per line.
+func renderBindACL(aclName string, cidrs []string) string {
+    return renderLines(bindACLLines, aclName, cidrs)
+}
+
+// renderOpenVPNRoutes renders exclude routes via net_gateway, either as client
+// directives or as server "push" directives.
+func renderOpenVPNRoutes(countryCode string, cidrs []string, push bool) string {
+    return renderLines(openVPNRouteLines(push), countryCode, cidrs)
+}
+
+//-------------------------------------------------------------------------
+// Batch generation for all countries
+//-------------------------------------------------------------------------
+
+// countryCodeRe matches the two-letter codes used for per-country file names in batch mode.
+var countryCodeRe = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// extractAllCountryCIDRs collects the CIDRs of every country in a single pass over the database.
+func extractAllCountryCIDRs(dbPath string) (map[string][]string, error) {
+    if len(sources) > 0 && dbPath == ripedbPath {
+        if _, _, err := mergedView(); err != nil {
+            return nil, err
+        }
+        byCountry := make(map[string][]string)
+        for country, segments := range mergedCountrySegments() {
+            byCountry[country] = mergedCIDRs(segments)
+        }
+        return byCountry, nil
+    }
+    perWorker := make([]map[string][]string, max(workers, 1))
+    for i := range perWorker {
+        perWorker[i] = make(map[string][]string)
+    }
+    err := scanRangesParallel(dbPath, false, func(worker int, r dbRange) {
+        if !countryCodeRe.MatchString(r.Country) {
+            return
+        }
+        perWorker[worker][r.Country] = append(perWorker[worker][r.Country], rangeCIDRs(r.Start, r.End)...)
+        countCoverage(rangeCoverage(r.Start, r.End))
+    })
+
+    byCountry := perWorker[0]
+    for _, part := range perWorker[1:] {
+        for country, cidrs := range part {
+            byCountry[country] = append(byCountry[country], cidrs...)
+        }
+    }
+    return byCountry, err
+}
+
+// createAllCountryFiles writes one file per country found in the database into outDir, rendered
+// with a line renderer. The database is read only once for all countries.
+func createAllCountryFiles(r lineRenderer, comment, fileNamePattern string, filtered bool, outDir string) {
+    if err := os.MkdirAll(outDir, 0755); err != nil {
+        errorf("Error creating output directory: %v\n", err)
+        return
+    }
+    statusf("Extracting all countries from %s\n", ripedbPath)
+
+    // Per-CIDR diagnostics for every country in the database would drown the summary; they are
+    // restored for whatever the command does afterwards.
+    defer func(saved bool) { quiet = saved }(quiet)
+    quiet = true
+    selectionSuffix := ""
+    if filtered {
+        selectionSuffix = " (filtered)"
+    }
+
+    // Per-country summaries go to the run report, only the total is printed.
+    written, total := 0, 0
+    overall := prefixSummary{Name: "all countries"}
+    if diskBacked() {
+        // Countries arrive one after another in code order, so only one file is open at a time.
+        var out *streamedOutput
+        var current string
+        var entries int
+        var counter addressCounter
+        finishCurrent := func() {
+            if out == nil {
+                return
+            }
+            summary := prefixSummary{Name: current, PrefixesAfter: entries, AddressesAfter: counter.total}
+            reportPrefixSummary(summary)
+            overall.PrefixesAfter += summary.PrefixesAfter
+            overall.AddressesAfter += summary.AddressesAfter
+            out.WriteString(r.Footer)
+            meta := outputMeta{Selection: "country " + current + selectionSuffix, Entries: entries, Comment: comment}
+            if err := out.finish(meta); err != nil {
+                warnf("Error generating file for %s: %v\n", current, err)
+            } else {
+                reportCountry(current, entries)
+                written++
+            }
+            out.discard()
+            out = nil
+        }
+        err := streamCountryCIDRs(nil, filtered, func(code, cidr string) error {
+            if isPlaceholderCountry(code) && !includePlaceholder {
+                return nil
+            }
+            if code != current {
+                finishCurrent()
+                current, entries, counter = code, 0, addressCounter{}
+                total++
+                var err error
+                if out, err = createStreamedOutput(filepath.Join(outDir, fmt.Sprintf(fileNamePattern, code))); err != nil {
+                    return err
+                }
+                out.WriteString(r.Header(code))
+            }
+            out.WriteString(r.Line(cidr))
+            counter.add(cidr)
+            entries++
+            return nil
+        })
+        if err != nil {
+            if out != nil {
+                out.discard()
+            }
+            errorf("Error generating file for %s: %v\n", current, err)
+            return
+        }
+        finishCurrent()
+        printPrefixSummary(overall)
+        reportSkippedPlaceholders()
+        statusf("%d of %d country files written to %s\n", written, total, outDir)
+        return
+    }
+
+    byCountry, err := extractAllCountryCIDRs(ripedbPath)
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+
+    codes := withoutPlaceholders(slices.Sorted(maps.Keys(byCountry)))
+
+    // Countries are filtered, rendered and written by --workers goroutines.
+    jobs := make(chan string)
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for code := range jobs {
+                ipRanges, summary := preparePrefixes(code, byCountry[code], filtered)
+                reportCountry(code, len(ipRanges))
+                mu.Lock()
+                overall.PrefixesBefore += summary.PrefixesBefore
+                overall.AddressesBefore += summary.AddressesBefore
+                overall.PrefixesAfter += summary.PrefixesAfter
+                overall.AddressesAfter += summary.AddressesAfter
+                overall.Ignored += summary.Ignored
+                overall.OverCoverage += summary.OverCoverage
+                mu.Unlock()
+
+                outFilePath := filepath.Join(outDir, fmt.Sprintf(fileNamePattern, code))
+                meta := outputMeta{Selection: "country " + code + selectionSuffix, Entries: len(ipRanges), Comment: comment}
+                if err := writeOutputFile(outFilePath, renderLines(r, code, ipRanges), meta); err != nil {
+                    warnf("Error generating file for %s: %v\n", code, err)
+                    continue
+                }
+                mu.Lock()
+                written++
+                mu.Unlock()
+            }
+        }()
+    }
+    for _, code := range codes {
+        jobs <- code
+    }
+    close(jobs)
+    wg.Wait()
+    printPrefixSummary(overall)
+    reportSkippedPlaceholders()
+    statusf("%d of %d country files written to %s\n", written, len(codes), outDir)
+}
+
+//-------------------------------------------------------------------------
+// Prefix post-processing and summaries
+//-------------------------------------------------------------------------
+
+// prefixSummary describes a generated prefix list as extracted and as written. The "before" fields
+// are left out for streamed (--low-memory) output, where the extracted list is never held. IPv6
+// space is counted in /64 networks, which fit in 64 bits where single addresses would not.
+type prefixSummary struct {
+    Name            string `json:"name"`
+    PrefixesBefore  int    `json:"prefixes_before,omitempty"`
+    AddressesBefore uint64 `json:"ipv4_addresses_before,omitempty"`
+    NetworksBefore  uint64 `json:"ipv6_networks_before,omitempty"`
+    PrefixesAfter   int    `json:"prefixes_after"`
+    AddressesAfter  uint64 `json:"ipv4_addresses_after"`
+    NetworksAfter   uint64 `json:"ipv6_networks_after,omitempty"`
+    Ignored         int    `json:"ignored_prefixes,omitempty"`   // Dropped by --ignore-smaller-than.
+    OverCoverage    uint64 `json:"ipv4_over_coverage,omitempty"` // Addresses added by --granularity.
+}
+
+// preparePrefixes turns extracted CIDRs into the list that generators write: deduplicated, without
+// prefixes under the --ignore-smaller-than limit, widened to --granularity, without nested subnets
+// if filtered (always after widening), replaced by its complement with --invert, reduced by --exclude
+// and --intersect, merged with --aggregate, and sorted.
+// The effect is recorded in the run report and returned for printing.
+func preparePrefixes(name string, ipRanges []string, filtered bool) ([]string, prefixSummary) {
+    summary := prefixSummary{Name: name, PrefixesBefore: len(ipRanges), AddressesBefore: countAddresses(ipRanges),
+        NetworksBefore: countNetworks6(ipRanges)}
+    ipRanges = removeDuplicates(ipRanges)
+    ipRanges, summary.Ignored = dropSmallPrefixes(ipRanges)
+    kept := countAddresses(ipRanges)
+    if granularity > 0 {
+        ipRanges = widenPrefixes(ipRanges)
+    }
+    if filtered || granularity > 0 {
+        ipRanges = filterRedundantCIDRs(ipRanges)
+    }
+    if granularity > 0 {
+        summary.OverCoverage = countAddresses(ipRanges) - kept
+    }
+    if invert {
+        ipRanges = invertPrefixes(ipRanges, false)
+    }
+    ipRanges = applySetOperations(ipRanges)
+    if aggregate {
+        ipRanges = aggregatePrefixes(ipRanges)
+    }
+    sort.Strings(ipRanges)
+    summary.PrefixesAfter = len(ipRanges)
+    summary.AddressesAfter = countAddresses(ipRanges)
+    summary.NetworksAfter = countNetworks6(ipRanges)
+    reportPrefixSummary(summary)
+    return ipRanges, summary
+}
+
+// dropSmallPrefixes removes the prefixes longer than --ignore-smaller-than and returns how many were dropped.
+func dropSmallPrefixes(cidrs []string) ([]string, int) {
+    if maxPrefix == 0 {
+        return cidrs, 0
+    }
+    kept := cidrs[:0]
+    for _, cidr := range cidrs {
+        if _, length, found := strings.Cut(cidr, "/"); found {
+            if n, err := strconv.Atoi(length); err == nil && n > maxPrefix {
+                continue
+            }
+        }
+        kept = append(kept, cidr)
+    }
+    return kept, len(cidrs) - len(kept)
+}
+
+// widenPrefixes replaces every prefix longer than --granularity with the enclosing prefix of that length
+// and removes the duplicates this creates.
+func widenPrefixes(cidrs []string) []string {
+    widened := make([]string, 0, len(cidrs))
+    for _, cidr := range cidrs {
+        _, ipNet, err := net.ParseCIDR(cidr)
+        if err != nil || ipNet.IP.To4() == nil {
+            widened = append(widened, cidr)
+            continue
+        }
+        if ones, _ := ipNet.Mask.Size(); ones > granularity {
+            network := binary.BigEndian.Uint32(ipNet.IP.To4()) &^ (1<<(32-granularity) - 1)
+            cidr = fmt.Sprintf("%s/%d", uint32ToIP(network), granularity)
+        }
+        widened = append(widened, cidr)
+    }
+    return removeDuplicates(widened)
+}
+
+// printPrefixSummary prints how many prefixes and addresses a generated list has, and had before
+// filtering. A list without IPv4 space is described by its IPv6 /64 networks alone.
+func printPrefixSummary(s prefixSummary) {
+    ipv6Only := s.AddressesBefore == 0 && s.AddressesAfter == 0 && (s.NetworksBefore > 0 || s.NetworksAfter > 0)
+    switch {
+    case ipv6Only && s.PrefixesBefore == 0:
+        statusf("%s: %d prefixes, %d IPv6 /64 networks\n", s.Name, s.PrefixesAfter, s.NetworksAfter)
+    case ipv6Only:
+        statusf("%s: %d prefixes (%d IPv6 /64 networks) extracted, %d prefixes (%d IPv6 /64 networks) written\n",
+            s.Name, s.PrefixesBefore, s.NetworksBefore, s.PrefixesAfter, s.NetworksAfter)
+    case s.PrefixesBefore == 0:
+        statusf("%s: %d prefixes, %d IPv4 addresses\n", s.Name, s.PrefixesAfter, s.AddressesAfter)
+    default:
+        statusf("%s: %d prefixes (%d IPv4 addresses) extracted, %d prefixes (%d IPv4 addresses) written\n",
+            s.Name, s.PrefixesBefore, s.AddressesBefore, s.PrefixesAfter, s.AddressesAfter)
+    }
+    if !ipv6Only && (s.NetworksBefore > 0 || s.NetworksAfter > 0) {
+        statusf("  IPv6 /64 networks: %d extracted, %d written\n", s.NetworksBefore, s.NetworksAfter)
+    }
+    if s.PrefixesBefore == 0 {
+        return
+    }
+    if s.Ignored > 0 {
+        statusf("  %d prefixes smaller than /%d ignored\n", s.Ignored, maxPrefix)
+    }
+    if s.OverCoverage > 0 {
+        statusf("  %d addresses added by rounding to /%d\n", s.OverCoverage, granularity)
+    }
+}
+
+// countAddresses returns the number of distinct IPv4 addresses covered by a list of CIDRs.
+func countAddresses(cidrs []string) uint64 {
+    intervals := make([]addrInterval, 0, len(cidrs))
+    for _, cidr := range cidrs {
+        if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.IP.To4() != nil {
+            intervals = append(intervals, addrInterval{binary.BigEndian.Uint32(ipNet.IP.To4()), binary.BigEndian.Uint32(lastIP(ipNet))})
+        }
+    }
+    var total uint64
+    for _, iv := range mergeIntervals(intervals) {
+        total += uint64(iv.End-iv.Start) + 1
+    }
+    return total
+}
+
+// countNetworks6 returns the number of distinct IPv6 /64 networks covered, even partly, by a list of
+// CIDRs. A list that covers the whole IPv6 space (2^64 networks) saturates at math.MaxUint64.
+func countNetworks6(cidrs []string) uint64 {
+    type span struct{ start, end uint64 }
+    var spans []span
+    for _, cidr := range cidrs {
+        prefix, err := netip.ParsePrefix(cidr)
+        if err != nil || !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+            continue
+        }
+        addr := prefix.Masked().Addr().As16()
+        start := binary.BigEndian.Uint64(addr[:8])
+        end := start
+        if prefix.Bits() < 64 {
+            end |= 1<<(64-prefix.Bits()) - 1
+        }
+        spans = append(spans, span{start, end})
+    }
+    sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+    var total uint64
+    for i := 0; i < len(spans); {
+        start, end := spans[i].start, spans[i].end
+        for i++; i < len(spans) && (end == math.MaxUint64 || spans[i].start <= end+1); i++ {
+            end = max(end, spans[i].end)
+        }
+        sum, carry := bits.Add64(total, end-start, 1)
+        if carry != 0 {
+            return math.MaxUint64
+        }
+        total = sum
+    }
+    return total
+}
+
+// addressCounter counts distinct addresses of CIDRs that arrive in ascending order of their first
+// address, as streamed output does.
+type addressCounter struct {
+    next  uint64 // First address not yet counted.
+    total uint64
+}
+
+// add counts the addresses of a CIDR not already covered by earlier ones.
+func (c *addressCounter) add(cidr string) {
+    _, ipNet, err := net.ParseCIDR(cidr)
+    if err != nil || ipNet.IP.To4() == nil {
+        return
+    }
+    start := uint64(binary.BigEndian.Uint32(ipNet.IP.To4()))
+    end := uint64(binary.BigEndian.Uint32(lastIP(ipNet)))
+    if end < c.next {
+        return
+    }
+    c.total += end + 1 - max(start, c.next)
+    c.next = end + 1
+}
+
+//-------------------------------------------------------------------------
+// Streamed output and low-memory extraction
+//-------------------------------------------------------------------------
+
+// streamedOutput writes a generated file whose body is produced incrementally. The body is
+// buffered in a temporary file next to the target, because the metadata header at the top
+// needs the entry count and the hash of the whole body.
+type streamedOutput struct {
+    path string
+    body *os.File
+    w    *bufio.Writer
+    hash hash.Hash
+}
+
+// createStreamedOutput starts a streamed file that will end up at path.
+func createStreamedOutput(path string) (*streamedOutput, error) {
+    body, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+    if err != nil {
+        return nil, err
+    }
+    h := sha256.New()
+    var w io.Writer = io.MultiWriter(body, h)
+    if crlf {
+        w = crlfWriter{w}
+    }
+    return &streamedOutput{path: path, body: body, w: bufio.NewWriter(w), hash: h}, nil
+}
+
+// WriteString appends text to the body; write errors are reported by finish.
+func (o *streamedOutput) WriteString(s string) {
+    o.w.WriteString(s)
+}
+
+// finish writes the file with its metadata header (or PATH.meta sidecar) to its final path.
+func (o *streamedOutput) finish(meta outputMeta) error {
+    if err := o.w.Flush(); err != nil {
+        return err
+    }
+    contentHash := hex.EncodeToString(o.hash.Sum(nil))
+    if existing, ok := unchangedOutput(o.path, contentHash, meta.Comment); ok {
+        return keepUnchangedOutput(o.path, existing, meta)
+    }
+    if _, err := o.body.Seek(0, io.SeekStart); err != nil {
+        return err
+    }
+
+    if err := backupOutput(o.path); err != nil {
+        return err
+    }
+    out, err := createGeneratedFile(o.path)
+    if err != nil {
+        return err
+    }
+    fileHash := sha256.New()
+    w := io.MultiWriter(out, fileHash)
+    var size int64
+    if meta.Comment != "" {
+        n, _ := io.WriteString(w, lineEndings(metadataHeader(contentHash, meta)))
+        size += int64(n)
+    }
+    n, err := io.Copy(w, o.body)
+    size += n
+    if err != nil {
+        out.Close()
+        os.Remove(out.Name())
+        return err
+    }
+    if err := installGeneratedFile(out, o.path); err != nil {
+        return err
+    }
+    if meta.Comment == "" {
+        if err := writeMetadataSidecar(o.path, contentHash, meta); err != nil {
+            return err
+        }
+    }
+    return completeOutput(o.path, size, hex.EncodeToString(fileHash.Sum(nil)), meta, true)
+}
+
+// discard removes the temporary body; it is safe to call after finish.
+func (o *streamedOutput) discard() {
+    o.body.Close()
+    os.Remove(o.body.Name())
+}
+
+// cidrRecord is the compact form of an IPv4 CIDR used by the low-memory mode (7 bytes on disk).
+type cidrRecord struct {
+    Country [2]byte
+    Start   uint32
+    Prefix  uint8
+}
+
+// less orders records by country, then address, then larger networks first, which is
+// the order the streaming redundancy filter relies on.
+func (r cidrRecord) less(o cidrRecord) bool {
+    if r.Country != o.Country {
+        return string(r.Country[:]) < string(o.Country[:])
+    }
+    if r.Start != o.Start {
+        return r.Start < o.Start
+    }
+    return r.Prefix < o.Prefix
+}
+
+// lowMemoryRunSize is the number of records sorted in memory before a run is spilled to disk (about 12 MB).
+const lowMemoryRunSize = 1 << 20
+
+// inMemoryPrefixBytes is roughly what one prefix costs while an extraction is deduplicated,
+// filtered and sorted in memory (the string, its parsed form and the deduplication map).
+const inMemoryPrefixBytes = 256
+
+// sorterRunSize returns the run size of cidrSorter: lowMemoryRunSize, or less so that the buffer
+// (12 bytes a record) stays within an eighth of a small --max-memory.
+func sorterRunSize() int {
+    if maxMemory > 0 {
+        return int(min(lowMemoryRunSize, max(maxMemory/(8*12), 1<<16)))
+    }
+    return lowMemoryRunSize
+}
+
+// overMemoryCap reports whether holding n prefixes in memory would exceed --max-memory.
+func overMemoryCap(n int) bool {
+    return maxMemory > 0 && int64(n)*inMemoryPrefixBytes > maxMemory
+}
+
+// diskBacked reports whether the extraction of country selections (all countries if none) takes the
+// low-memory path: with --low-memory, or when their blocks, as counted by the country summary, would
+// exceed --max-memory in memory.
+func diskBacked(selections ...string) bool {
+    if lowMemory {
+        return true
+    }
+    if maxMemory == 0 || len(sources) > 0 {
+        return false
+    }
+    summary, err := loadCountrySummary(ripedbPath)
+    if err != nil {
+        return false
+    }
+    blocks := 0
+    for country, stat := range summary.Countries {
+        fields := strings.Fields(country)
+        if len(selections) == 0 || len(fields) > 0 && slices.ContainsFunc(selections, func(selection string) bool { return inCountrySelection(selection, fields[0]) }) {
+            blocks += stat.Blocks
+        }
+    }
+    if !overMemoryCap(blocks) {
+        return false
+    }
+    statusf("%d blocks would exceed --max-memory %s in memory; using temporary files\n", blocks, humanBytes(maxMemory))
+    return true
+}
+
+// filterCIDRsOnDisk is filterRedundantCIDRs and removeDuplicates for lists too large for --max-memory:
+// the prefixes go through a cidrSorter and the kept ones replace the list in place, ordered by address.
+func filterCIDRsOnDisk(cidrs []string) ([]string, error) {
+    var sorter cidrSorter
+    defer sorter.close()
+    for _, cidr := range cidrs {
+        _, ipNet, err := net.ParseCIDR(cidr)
+        if err != nil || ipNet.IP.To4() == nil {
+            errorf("Error parsing CIDR %s: %v\n", cidr, err)
+            continue
+        }
+        ones, _ := ipNet.Mask.Size()
+        if err := sorter.add(cidrRecord{Start: binary.BigEndian.Uint32(ipNet.IP.To4()), Prefix: uint8(ones)}); err != nil {
+            return nil, err
+        }
+    }
+    kept := cidrs[:0]
+    var keptEnd uint64
+    err := sorter.each(func(r cidrRecord) error {
+        end := uint64(r.Start) + 1<<(32-r.Prefix) - 1
+        if len(kept) > 0 && end <= keptEnd {
+            return nil
+        }
+        keptEnd = end
+        kept = append(kept, fmt.Sprintf("%s/%d", uint32ToIP(r.Start), r.Prefix))
+        return nil
+    })
+    return kept, err
+}
+
+// cidrSorter is an external sort: records are buffered, spilled to sorted temporary
+// runs in cacheDir and merged when read back.
+type cidrSorter struct {
+    buf  []cidrRecord
+    runs []*os.File
+}
+
+// add buffers a record, spilling a sorted run once the buffer is full.
+func (s *cidrSorter) add(r cidrRecord) error {
+    s.buf = append(s.buf, r)
+    if len(s.buf) >= sorterRunSize() {
+        return s.spill()
+    }
+    return nil
+}
+
+// spill sorts the buffered records and writes them to a new temporary run.
+func (s *cidrSorter) spill() error {
+    sort.Slice(s.buf, func(i, j int) bool { return s.buf[i].less(s.buf[j]) })
+    if err := os.MkdirAll(cacheDir, 0755); err != nil {
+        return err
+    }
+    run, err := os.CreateTemp(cacheDir, "cidr-run-*.tmp")
+    if err != nil {
+        return err
+    }
+    s.runs = append(s.runs, run)
+    w := bufio.NewWriter(run)
+    var rec [7]byte
+    for _, r := range s.buf {
+        copy(rec[:2], r.Country[:])
+        binary.BigEndian.PutUint32(rec[2:6], r.Start)
+        rec[6] = r.Prefix
+        w.Write(rec[:])
+    }
+    s.buf = s.buf[:0]
+    return w.Flush()
+}
+
+// each calls fn for every distinct record in sorted order, merging the spilled runs.
+func (s *cidrSorter) each(fn func(cidrRecord) error) error {
+    if len(s.runs) == 0 {
+        sort.Slice(s.buf, func(i, j int) bool { return s.buf[i].less(s.buf[j]) })
+        for i, r := range s.buf {
+            if i > 0 && r == s.buf[i-1] {
+                continue
+            }
+            if err := fn(r); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    if len(s.buf) > 0 {
+        if err := s.spill(); err != nil {
+            return err
+        }
+    }
+
+    // There are only a handful of runs, so the smallest head is found by a linear scan.
+    readers := make([]*bufio.Reader, len(s.runs))
+    heads := make([]cidrRecord, len(s.runs))
+    alive := make([]bool, len(s.runs))
+    next := func(i int) error {
+        var rec [7]byte
+        if _, err := io.ReadFull(readers[i], rec[:]); err != nil {
+            alive[i] = false
+            if err == io.EOF {
+                return nil
+            }
+            return err
+        }
+        heads[i] = cidrRecord{Country: [2]byte{rec[0], rec[1]}, Start: binary.BigEndian.Uint32(rec[2:6]), Prefix: rec[6]}
+        alive[i] = true
+        return nil
+    }
+    for i, run := range s.runs {
+        if _, err := run.Seek(0, io.SeekStart); err != nil {
+            return err
+        }
+        readers[i] = bufio.NewReader(run)
+        if err := next(i); err != nil {
+            return err
+        }
+    }
+
+    var last cidrRecord
+    first := true
+    for {
+        min := -1
+        for i := range heads {
+            if alive[i] && (min < 0 || heads[i].less(heads[min])) {
+                min = i
+            }
+        }
+        if min < 0 {
+            return nil
+        }
+        r := heads[min]
+        if err := next(min); err != nil {
+            return err
+        }
+        if !first && r == last {
+            continue
+        }
+        first, last = false, r
+        if err := fn(r); err != nil {
+            return err
+        }
+    }
+}
+
+// close removes the temporary runs.
+func (s *cidrSorter) close() {
+    for _, run := range s.runs {
+        run.Close()
+        os.Remove(run.Name())
+    }
+}
+
+// streamCountryCIDRs extracts the CIDRs of the given countries (all countries if codes is empty)
+// with bounded memory and calls fn for each one, grouped by country in code order and sorted by
+// address. With filtered, nested networks are dropped on the fly: in this order a network is
+// redundant exactly when it ends inside the last network kept for its country. With --aggregate,
+// networks are merged into address spans that are split into CIDRs when the next one does not touch them.
+// With --invert, each country gets the complement of its prefixes instead, still in address order.
+func streamCountryCIDRs(codes []string, filtered bool, fn func(countryCode, cidr string) error) error {
+    // --exclude and --intersect only split prefixes, so the order is kept.
+    if setOperationsActive() {
+        emit := fn
+        fn = func(countryCode, cidr string) error {
+            for _, piece := range setOperation(cidr) {
+                if err := emit(countryCode, piece); err != nil {
+                    return err
+                }
+            }
+            return nil
+        }
+    }
+    if !invert {
+        return scanCountryCIDRs(codes, filtered, fn)
+    }
+
+    // The complement of a country is made of the gaps between its prefixes, which come in address
+    // order; next is the first address after the prefixes seen so far.
+    emit := fn
+    current, next := "", uint64(0)
+    fill := func(end uint64) error {
+        if next >= end {
+            return nil
+        }
+        for _, cidr := range splitRangeToCIDRs(uint32(next), uint32(end-1)) {
+            if err := emit(current, cidr); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    err := scanCountryCIDRs(codes, filtered, func(countryCode, cidr string) error {
+        p, err := netip.ParsePrefix(cidr)
+        if err != nil {
+            return err
+        }
+        if countryCode != current {
+            if current != "" {
+                if err := fill(1 << 32); err != nil {
+                    return err
+                }
+            }
+            current, next = countryCode, 0
+        }
+        start, end := prefixInterval(p)
+        if err := fill(uint64(start)); err != nil {
+            return err
+        }
+        next = max(next, uint64(end)+1)
+        return nil
+    })
+    if err != nil || current == "" {
+        return err
+    }
+    return fill(1 << 32)
+}
+
+// scanCountryCIDRs is streamCountryCIDRs without --invert and the set operations.
+func scanCountryCIDRs(codes []string, filtered bool, fn func(countryCode, cidr string) error) error {
+    // Widened prefixes overlap, so --granularity always drops the nested ones.
+    filtered = filtered || granularity > 0
+    // Members of a country group are labelled with the group, so its prefixes are sorted and filtered
+    // together; group labels are a zero byte followed by the index of the group.
+    wanted := make(map[string][2]byte)
+    groupNames := make(map[[2]byte]string)
+    for _, code := range codes {
+        code = strings.ToUpper(code)
+        if _, ok := countryGroups[code]; !ok {
+            wanted[code] = [2]byte{code[0], code[1]}
+            continue
+        }
+        label := [2]byte{0, byte(len(groupNames))}
+        groupNames[label] = code
+        for _, member := range countryMembers(code) {
+            wanted[member] = label
+        }
+    }
+
+    var sorter cidrSorter
+    defer sorter.close()
+    var addErr error
+    err := scanRanges(ripedbPath, false, func(r dbRange) {
+        if addErr != nil {
+            return
+        }
+        country := r.Country
+        if !countryCodeRe.MatchString(country) {
+            return
+        }
+        label, ok := wanted[country]
+        if len(wanted) == 0 {
+            label = [2]byte{country[0], country[1]}
+        } else if !ok {
+            return
+        }
+        countCoverage(rangeCoverage(r.Start, r.End))
+        eachRangePrefix(r.Start, r.End, func(network uint32, prefix int) {
+            if addErr != nil || maxPrefix > 0 && prefix > maxPrefix {
+                return
+            }
+            if granularity > 0 && prefix > granularity {
+                network, prefix = network&^(1<<(32-granularity)-1), granularity
+            }
+            addErr = sorter.add(cidrRecord{Country: label, Start: network, Prefix: uint8(prefix)})
+        })
+    })
+    if err != nil {
+        return err
+    }
+    if addErr != nil {
+        return addErr
+    }
+
+    labelName := func(label [2]byte) string {
+        if name, ok := groupNames[label]; ok {
+            return name
+        }
+        return string(label[:])
+    }
+
+    var country [2]byte
+    var keptEnd uint64
+    kept := false
+    if aggregate {
+        // span is the merged address span of the current country that is not written yet.
+        var span addrInterval
+        flush := func() error {
+            if !kept {
+                return nil
+            }
+            for _, cidr := range splitRangeToCIDRs(span.Start, span.End) {
+                if err := fn(labelName(country), cidr); err != nil {
+                    return err
+                }
+            }
+            return nil
+        }
+        err := sorter.each(func(r cidrRecord) error {
+            end := uint32(uint64(r.Start) + 1<<(32-r.Prefix) - 1)
+            if r.Country == country && kept && uint64(r.Start) <= uint64(span.End)+1 {
+                span.End = max(span.End, end)
+                return nil
+            }
+            if err := flush(); err != nil {
+                return err
+            }
+            country, span, kept = r.Country, addrInterval{r.Start, end}, true
+            return nil
+        })
+        if err != nil {
+            return err
+        }
+        return flush()
+    }
+    return sorter.each(func(r cidrRecord) error {
+        if r.Country != country {
+            country, kept = r.Country, false
+        }
+        end := uint64(r.Start) + 1<<(32-r.Prefix) - 1
+        if filtered {
+            if kept && end <= keptEnd {
+                return nil
+            }
+            keptEnd, kept = end, true
+        }
+        return fn(labelName(r.Country), fmt.Sprintf("%s/%d", uint32ToIP(r.Start), r.Prefix))
+    })
+}
+
+//-------------------------------------------------------------------------
+// Parsing CIDRs and converting net.IPMask to dotted notation
+//-------------------------------------------------------------------------
+
+// cidrToRoute parses a string like "192.168.0.0/24" into "192.168.0.0" and "255.255.255.0".
+func cidrToRoute(cidr string) (string, string, error) {
+    _, ipNet, err := net.ParseCIDR(cidr)
+    if err != nil {
+        return "", "", fmt.Errorf("failed to parse CIDR: %v", err)
+    }
+    networkAddr := ipNet.IP.To4()
+    if networkAddr == nil {
+        return "", "", fmt.Errorf("IPv6 prefixes have no dotted netmask; use openVPNRoute")
+    }
+    netmask := ipMaskToDotted(ipNet.Mask)
+    return networkAddr.String(), netmask, nil
+}
+
+// openVPNRoute returns the OpenVPN directive that sends a prefix around the VPN via the local gateway:
+// "route NETWORK NETMASK net_gateway" for IPv4 and "route-ipv6 PREFIX net_gateway_ipv6" for IPv6.
+func openVPNRoute(cidr string) (string, error) {
+    if strings.Contains(cidr, ":") {
+        _, ipNet, err := net.ParseCIDR(cidr)
+        if err != nil {
+            return "", fmt.Errorf("failed to parse CIDR: %v", err)
+        }
+        return fmt.Sprintf("route-ipv6 %s net_gateway_ipv6", ipNet), nil
+    }
+    startIP, netmask, err := cidrToRoute(cidr)
+    if err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("route %s %s net_gateway", startIP, netmask), nil
+}
+
+// redirectGateway returns the flags of the redirect-gateway directive: IPv6 traffic is only sent
+// through the VPN (and IPv6 exclusions matter) if IPv6 prefixes are selected.
+func redirectGateway() string {
+    if withIPv6 {
+        return "def1 ipv6"
+    }
+    return "def1"
+}
+
+// ipMaskToDotted converts a net.IPMask (e.g., /24) to its dotted-decimal string (e.g., "255.255.255.0").
+func ipMaskToDotted(mask net.IPMask) string {
+    if len(mask) != 4 {
+        return ""
+    }
+    return fmt.Sprintf("%d.%d.%d.%d", mask[0], mask[1], mask[2], mask[3])
+}
+
+//-------------------------------------------------------------------------
+// Searching by country code or keywords
+//-------------------------------------------------------------------------
+
+// countryPrefetch holds the CIDRs collected by prefetchCountries, by country selection.
+var (
+    countryPrefetchMu sync.Mutex
+    countryPrefetch   = make(map[string][]string)
+)
+
+// prefetchCountries reads the database once for a command that writes several countries and
+// dispatches every block to the selections (codes or groups) it belongs to, so that the following
+// extractCountryCIDRs calls for them need no scan of their own. The returned function releases the
+// collected lists. Nothing is collected for a single selection, with --sources (the merged view is
+// already computed once) or when the lists should not be held in memory.
+func prefetchCountries(codes []string) func() {
+    var selections []string
+    for _, code := range codes {
+        if code = strings.ToUpper(code); !slices.Contains(selections, code) {
+            selections = append(selections, code)
+        }
+    }
+    if len(selections) < 2 || len(sources) > 0 || countryCacheValid(ripedbPath) {
+        return func() {}
+    }
+    if countryCacheAllowed(ripedbPath) {
+        // The full scan that fills the per-country cache serves all selections at once; if the cache
+        // could not be written, the selections are collected by a scan of their own.
+        if _, _, ok := buildCountryCache(ripedbPath); ok && countryCacheValid(ripedbPath) {
+            return func() {}
+        }
+    }
+    if diskBacked(selections...) {
+        return func() {}
+    }
+
+    perWorker := make([]map[string][]string, max(workers, 1))
+    for i := range perWorker {
+        perWorker[i] = make(map[string][]string)
+    }
+    err := scanRangesParallel(ripedbPath, false, func(worker int, r dbRange) {
+        countryFields := strings.Fields(r.Country)
+        if len(countryFields) == 0 {
+            return
+        }
+        var cidrs []string
+        for _, selection := range selections {
+            if !inCountrySelection(selection, countryFields[0]) {
+                continue
+            }
+            if cidrs == nil {
+                cidrs = rangeCIDRs(r.Start, r.End)
+                countCoverage(rangeCoverage(r.Start, r.End))
+            }
+            perWorker[worker][selection] = append(perWorker[worker][selection], cidrs...)
+        }
+    })
+    if err != nil {
+        // The countries are then extracted one by one, which reports the error.
+        return func() {}
+    }
+
+    countryPrefetchMu.Lock()
+    defer countryPrefetchMu.Unlock()
+    for _, selection := range selections {
+        var cidrs []string
+        for _, part := range perWorker {
+            cidrs = append(cidrs, part[selection]...)
+        }
+        countryPrefetch[selection] = cidrs
+    }
+    return func() {
+        countryPrefetchMu.Lock()
+        defer countryPrefetchMu.Unlock()
+        for _, selection := range selections {
+            delete(countryPrefetch, selection)
+        }
+    }
+}
+
+// countryCacheDir returns the directory of per-country CIDR lists next to the database file.
+func countryCacheDir(dbPath string) string {
+    return dbPath + ".cidrs"
+}
+
+// countryCacheStamp identifies the dump a per-country cache is built from: the snapshot serial and
+// the size and modification time of the file, plus the --cidr-strategy of its lists. It is empty if
+// the dump is missing.
+func countryCacheStamp(dbPath string) string {
+    fi, err := os.Stat(dbPath)
+    if err != nil {
+        return ""
+    }
+    return fmt.Sprintf("%s %d %d %s\n", loadDatabaseInfo().Serial, fi.Size(), fi.ModTime().UnixNano(), cidrStrategy)
+}
+
+// countryCacheValid reports whether the per-country cache was built from the current dump.
+func countryCacheValid(dbPath string) bool {
+    stamp, err := os.ReadFile(filepath.Join(countryCacheDir(dbPath), "STAMP"))
+    return err == nil && dbPath == ripedbPath && string(stamp) == countryCacheStamp(dbPath)
+}
+
+// countryCacheAllowed reports whether a full scan may collect every country to fill the per-country
+// cache: not for --sources, which have their own merged view, nor when memory is to be saved.
+func countryCacheAllowed(dbPath string) bool {
+    return dbPath == ripedbPath && len(sources) == 0 && !lowMemory && maxMemory == 0
+}
+
+// cachedCountryCIDRs returns the CIDRs of a country selection from the per-country cache, or false
+// if the cache is missing or was built from another version of the dump.
+func cachedCountryCIDRs(dbPath, selection string) ([]string, bool) {
+    if !countryCacheValid(dbPath) {
+        return nil, false
+    }
+    // What --cidr-strategy added or left out is counted as if the lists had been extracted.
+    var coverage map[string]coverageCount
+    if data, err := os.ReadFile(filepath.Join(countryCacheDir(dbPath), "COVERAGE")); err == nil {
+        _ = json.Unmarshal(data, &coverage)
+    }
+    var cidrs []string
+    for _, code := range countryMembers(selection) {
+        if !countryCodeRe.MatchString(code) {
+            return nil, false
+        }
+        countCoverage(coverage[code])
+        data, err := os.ReadFile(filepath.Join(countryCacheDir(dbPath), code+".txt"))
+        if os.IsNotExist(err) {
+            // Countries without blocks have no list.
+            continue
+        }
+        if err != nil {
+            return nil, false
+        }
+        cidrs = append(cidrs, strings.Fields(string(data))...)
+    }
+    return cidrs, true
+}
+
+// buildCountryCache collects the CIDRs of every country in one pass over the database and stores
+// them as the per-country cache, one CC.txt per country, so that later extractions until the next
+// update read only the list they need. It also returns what --cidr-strategy added to or left out of
+// each country (kept in the cache as COVERAGE). The cache is only an optimisation: failing to write
+// it is a warning and the collected lists are still returned. It returns false if the database could
+// not be read.
+func buildCountryCache(dbPath string) (map[string][]string, map[string]coverageCount, bool) {
+    // The stamp is taken before the dump is read, so a dump replaced meanwhile invalidates the cache.
+    stamp := countryCacheStamp(dbPath)
+    perWorker := make([]map[string][]string, max(workers, 1))
+    perWorkerCoverage := make([]map[string]coverageCount, len(perWorker))
+    for i := range perWorker {
+        perWorker[i] = make(map[string][]string)
+        perWorkerCoverage[i] = make(map[string]coverageCount)
+    }
+    err := scanRangesParallel(dbPath, false, func(worker int, r dbRange) {
+        countryFields := strings.Fields(r.Country)
+        if len(countryFields) == 0 || !countryCodeRe.MatchString(countryFields[0]) {
+            return
+        }
+        country := countryFields[0]
+        perWorker[worker][country] = append(perWorker[worker][country], rangeCIDRs(r.Start, r.End)...)
+        if c := rangeCoverage(r.Start, r.End); c != (coverageCount{}) {
+            total := perWorkerCoverage[worker][country]
+            perWorkerCoverage[worker][country] = coverageCount{total.Added + c.Added, total.Dropped + c.Dropped}
+        }
+    })
+    if err != nil {
+        return nil, nil, false
+    }
+    byCountry, coverage := perWorker[0], perWorkerCoverage[0]
+    for i, part := range perWorker[1:] {
+        for country, cidrs := range part {
+            byCountry[country] = append(byCountry[country], cidrs...)
+        }
+        for country, c := range perWorkerCoverage[i+1] {
+            total := coverage[country]
+            coverage[country] = coverageCount{total.Added + c.Added, total.Dropped + c.Dropped}
+        }
+    }
+
+    if stamp != "" {
+        if err := writeCountryCache(dbPath, stamp, byCountry, coverage); err != nil {
+            warnf("Warning: could not write the country cache %s: %v\n", countryCacheDir(dbPath), err)
+        }
+    }
+    return byCountry, coverage, true
+}
+
+// writeCountryCache writes the per-country lists to a temporary directory, which then replaces the
+// previous cache; the stamp is written last, so an interrupted write is never taken for a valid cache.
+func writeCountryCache(dbPath, stamp string, byCountry map[string][]string, coverage map[string]coverageCount) error {
+    dir := countryCacheDir(dbPath)
+    tmpDir, err := os.MkdirTemp(filepath.Dir(dir), filepath.Base(dir)+"-*.tmp")
+    if err != nil {
+        return err
+    }
+    defer os.RemoveAll(tmpDir)
+    if err := os.Chmod(tmpDir, 0755); err != nil {
+        return err
+    }
+    for country, cidrs := range byCountry {
+        if err := os.WriteFile(filepath.Join(tmpDir, country+".txt"), []byte(strings.Join(cidrs, "\n")+"\n"), 0644); err != nil {
+            return err
+        }
+    }
+    data, err := json.Marshal(coverage)
+    if err != nil {
+        return err
+    }
+    if err := os.WriteFile(filepath.Join(tmpDir, "COVERAGE"), data, 0644); err != nil {
+        return err
+    }
+    if err := os.WriteFile(filepath.Join(tmpDir, "STAMP"), []byte(stamp), 0644); err != nil {
+        return err
+    }
+    if err := os.RemoveAll(dir); err != nil {
+        return err
+    }
+    return os.Rename(tmpDir, dir)
+}
+
+// extractCountryCIDRs returns a list of CIDRs for inetnum blocks that match the given country code exactly.
+// The database is parsed by --workers goroutines, so the order of the result is not defined.
+func extractCountryCIDRs(countryCode, dbPath string, debugPrint bool) []string {
+    countryCode = strings.ToUpper(countryCode)
+    if len(sources) > 0 && dbPath == ripedbPath {
+        return mergedCIDRs(selectionSegments(mergedCountrySegments(), countryCode))
+    }
+    if dbPath == ripedbPath && !debugPrint {
+        countryPrefetchMu.Lock()
+        cidrs, ok := countryPrefetch[countryCode]
+        countryPrefetchMu.Unlock()
+        if ok {
+            // Callers filter the list in place, and a country may be written in several formats.
+            return slices.Clone(cidrs)
+        }
+        if cidrs, ok := cachedCountryCIDRs(dbPath, countryCode); ok {
+            return cidrs
+        }
+        if countryCacheAllowed(dbPath) {
+            if byCountry, coverage, ok := buildCountryCache(dbPath); ok {
+                var cidrs []string
+                for _, code := range countryMembers(countryCode) {
+                    cidrs = append(cidrs, byCountry[code]...)
+                    countCoverage(coverage[code])
+                }
+                return cidrs
+            }
+        }
+    }
+    perWorker := make([][]string, max(workers, 1))
+
+    err := scanRangesParallel(dbPath, false, func(worker int, r dbRange) {
+        countryFields := strings.Fields(r.Country)
+        if len(countryFields) == 0 || !inCountrySelection(countryCode, countryFields[0]) {
+            return
+        }
+        // This block matches the specified country code.
+        if debugPrint {
+            fmt.Printf("Found inetnum entry: %s - %s\n", uint32ToIP(r.Start), uint32ToIP(r.End))
+        }
+
+        cidrs := rangeCIDRs(r.Start, r.End)
+        countCoverage(rangeCoverage(r.Start, r.End))
+        if debugPrint {
+            fmt.Printf("Converted to CIDR: %s\n", strings.Join(cidrs, " "))
+        }
+        perWorker[worker] = append(perWorker[worker], cidrs...)
+    })
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return nil
+    }
+
+    var ipRanges []string
+    for _, part := range perWorker {
+        ipRanges = append(ipRanges, part...)
+    }
+    return ipRanges
+}
+
+// extractCIDRsByKeywordsAndCountry searches the RIPE DB for inetnum blocks that optionally match a country code
+// and contain at least one of the provided keywords. 
+// When attrs is not nil, it receives the netname and country of the block each CIDR came from.
+func extractCIDRsByKeywordsAndCountry(countryCode string, keywords []string, dbPath string, debugPrint bool, attrs map[string]searchAttrs) []string {
+    var ipRanges []string
+    ok := eachSearchMatch(countryCode, keywords, dbPath, debugPrint, func(cidr string, blockAttrs searchAttrs) {
+        ipRanges = append(ipRanges, cidr)
+        if _, seen := attrs[cidr]; attrs != nil && !seen {
+            attrs[cidr] = blockAttrs
+        }
+    })
+    if !ok {
+        return nil
+    }
+    return ipRanges
+}
+
+// eachSearchMatch calls fn, as the database is read, with every CIDR of the inetnum blocks that match
+// a search and the netname and country of the block. It returns false (after printing why) on errors.
+func eachSearchMatch(countryCode string, keywords []string, dbPath string, debugPrint bool, fn func(cidr string, attrs searchAttrs)) bool {
+    // Convert country code to uppercase for matching "country: XX".
+    countryCode = strings.ToUpper(countryCode)
+
+    // Keywords are matched case-insensitively against the lower case block text.
+    matcher, err := newSearchMatcher(keywords)
+    if err != nil {
+        errorf("Invalid search keyword %v\n", err)
+        return false
+    }
+
+    // With keywords, the search indexes let most of the dump be skipped (see scanSearchBlocks).
+    err = scanSearchBlocks(dbPath, matcher, func(blockLines []string) {
+        var inetnumLine, countryLine, netnameLine string
+        for _, line := range blockLines {
+            trimLine := strings.TrimSpace(line)
+            if strings.HasPrefix(trimLine, "inetnum:") {
+                inetnumLine = trimLine
+            } else if strings.HasPrefix(trimLine, "country:") {
+                countryLine = trimLine
+            } else if strings.HasPrefix(trimLine, "netname:") {
+                netnameLine = trimLine
+            }
+        }
+
+        // addBlock passes on the CIDRs of a matching block.
+        addBlock := func() {
+            country := ""
+            if fields := strings.Fields(countryLine); len(fields) >= 2 {
+                country = strings.ToUpper(fields[1])
+            }
+            blockAttrs := searchAttrs{Netname: strings.TrimSpace(strings.TrimPrefix(netnameLine, "netname:")), Country: country}
+            for _, cidr := range inetnumToCIDR(inetnumLine, debugPrint) {
+                fn(cidr, blockAttrs)
+            }
+        }
+
+        // If a country code was specified, check if the block matches it.
+        if countryCode != "" {
+            if countryLine == "" {
+                // No country line in this block, skip it.
+                return
+            }
+            fields := strings.Fields(countryLine)
+            if len(fields) < 2 || !inCountrySelection(countryCode, strings.ToUpper(fields[1])) {
+                // The country code in this block doesn't match the desired one.
+                return
+            }
+        }
+
+        // If no keywords were given, we accept the block if it has an inetnum line.
+        if len(keywords) == 0 {
+            if inetnumLine != "" {
+                addBlock()
+            }
+            return
+        }
+
+        // Otherwise, we check if the block contains any of the keywords (case-insensitive).
+        blockTextLower := strings.ToLower(strings.Join(blockLines, "\n"))
+        if matcher.match(blockTextLower) && inetnumLine != "" {
+            addBlock()
+        }
+    })
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return false
+    }
+    return true
+}
+
+// inetnumToCIDR parses a line like "inetnum: 1.2.3.0 - 1.2.3.255" and converts it to a CIDR range if possible.
+func inetnumToCIDR(inetnumLine string, debugPrint bool) []string {
+    var result []string
+    parts := strings.Fields(inetnumLine)
+    if len(parts) < 2 {
+        return result
+    }
+
+    ipRangeStr := strings.Join(parts[1:], " ")
+    ipRangeParts := strings.Split(ipRangeStr, "-")
+    if len(ipRangeParts) == 2 {
+        start := strings.TrimSpace(ipRangeParts[0])
+        end := strings.TrimSpace(ipRangeParts[1])
+
+        if debugPrint {
+            fmt.Printf("Found inetnum entry: %s - %s\n", start, end)
+        }
+
+        startIP, endIP := net.ParseIP(start).To4(), net.ParseIP(end).To4()
+        if startIP == nil || endIP == nil {
+            errorf("Error: Invalid IP range: %s - %s\n", start, end)
+            return result
+        }
+        result = rangeCIDRs(binary.BigEndian.Uint32(startIP), binary.BigEndian.Uint32(endIP))
+        countCoverage(rangeCoverage(binary.BigEndian.Uint32(startIP), binary.BigEndian.Uint32(endIP)))
+        if debugPrint {
+            fmt.Printf("Converted to CIDR: %s\n", strings.Join(result, " "))
+        }
+    }
+    return result
+}
+
+// rangeToCIDR returns the smallest CIDR (network address and prefix length) covering start-end.
+func rangeToCIDR(start, end uint32) (uint32, int) {
+    prefixLength := 32 - bits.Len32(start^end)
+    network := start &^ uint32((uint64(1)<<(32-prefixLength))-1)
+    return network, prefixLength
+}
+
+// rangeCIDR returns the smallest CIDR covering start-end in a.b.c.d/n notation.
+func rangeCIDR(start, end uint32) string {
+    network, prefixLength := rangeToCIDR(start, end)
+    return fmt.Sprintf("%s/%d", uint32ToIP(network).String(), prefixLength)
+}
+
+// cidrStrategies are the values of --cidr-strategy.
+var cidrStrategies = []string{"exact", "cover", "trim"}
+
+// coverageCount is the number of addresses the cover strategy added around inetnum ranges and the
+// trim strategy left out of them.
+type coverageCount struct {
+    Added   uint64 `json:"added"`
+    Dropped uint64 `json:"dropped"`
+}
+
+// cidrCoverage sums the coverageCount of the ranges extracted during the run, for the summary
+// printed at its end.
+var cidrCoverage struct {
+    added, dropped atomic.Uint64
+}
+
+// eachRangePrefix calls fn with the prefixes an inetnum range becomes under --cidr-strategy: exact
+// (the default) splits the range into the minimal set of CIDRs that cover it precisely, cover uses
+// the smallest single CIDR around it, as earlier versions did, and trim the largest CIDR inside it.
+func eachRangePrefix(start, end uint32, fn func(network uint32, prefix int)) {
+    switch cidrStrategy {
+    case "exact", "":
+        for cur := uint64(start); cur <= uint64(end); {
+            block := alignedBlock(cur, end)
+            fn(uint32(cur), 32-bits.TrailingZeros64(block))
+            cur += block
+        }
+    case "trim":
+        best, block := largestBlockInside(start, end)
+        fn(best, 32-bits.TrailingZeros64(block))
+    case "cover":
+        fn(rangeToCIDR(start, end))
+    }
+}
+
+// largestBlockInside returns the start and size of the largest CIDR inside start-end.
+func largestBlockInside(start, end uint32) (uint32, uint64) {
+    var best, bestBlock uint64
+    for cur := uint64(start); cur <= uint64(end); {
+        block := alignedBlock(cur, end)
+        if block > bestBlock {
+            best, bestBlock = cur, block
+        }
+        cur += block
+    }
+    return uint32(best), bestBlock
+}
+
+// rangeCoverage returns what --cidr-strategy adds around or leaves out of a range.
+func rangeCoverage(start, end uint32) coverageCount {
+    size := uint64(end-start) + 1
+    switch cidrStrategy {
+    case "trim":
+        _, block := largestBlockInside(start, end)
+        return coverageCount{Dropped: size - block}
+    case "cover":
+        _, prefix := rangeToCIDR(start, end)
+        return coverageCount{Added: uint64(1)<<(32-prefix) - size}
+    }
+    return coverageCount{}
+}
+
+// countCoverage adds to the run's coverage summary.
+func countCoverage(c coverageCount) {
+    if c.Added > 0 {
+        cidrCoverage.added.Add(c.Added)
+    }
+    if c.Dropped > 0 {
+        cidrCoverage.dropped.Add(c.Dropped)
+    }
+}
+
+// alignedBlock returns the size of the largest CIDR that starts at cur and ends at or before end.
+func alignedBlock(cur uint64, end uint32) uint64 {
+    block := uint64(1) << bits.TrailingZeros32(uint32(cur))
+    for cur+block-1 > uint64(end) {
+        block >>= 1
+    }
+    return block
+}
+
+// rangeCIDRs returns the CIDRs an inetnum range becomes under --cidr-strategy (see eachRangePrefix).
+func rangeCIDRs(start, end uint32) []string {
+    var cidrs []string
+    eachRangePrefix(start, end, func(network uint32, prefix int) {
+        cidrs = append(cidrs, fmt.Sprintf("%s/%d", uint32ToIP(network), prefix))
+    })
+    return cidrs
+}
+
+// printCIDRCoverage prints how many addresses --cidr-strategy added to or left out of the inetnum
+// ranges during the run, if any.
+func printCIDRCoverage() {
+    if added := cidrCoverage.added.Load(); added > 0 {
+        statusf("CIDR strategy cover: %d addresses outside the inetnum ranges were included\n", added)
+    }
+    if dropped := cidrCoverage.dropped.Load(); dropped > 0 {
+        statusf("CIDR strategy trim: %d addresses of the inetnum ranges were left out\n", dropped)
+    }
+}
+
+//-------------------------------------------------------------------------
+// Utility functions to filter out nested subnets, remove duplicates, etc.
+//-------------------------------------------------------------------------
+
+// filterRedundantCIDRs removes subnets that are fully contained inside larger subnets.
+func filterRedundantCIDRs(cidrs []string) []string {
+    var parsedCIDRs []*net.IPNet
+    for _, cidrStr := range cidrs {
+        _, ipNet, err := net.ParseCIDR(cidrStr)
+        if err != nil {
+            errorf("Error parsing CIDR %s: %v\n", cidrStr, err)
+            continue
+        }
+        parsedCIDRs = append(parsedCIDRs, ipNet)
+    }
+
+    // Sort by prefix length ascending (bigger networks first), then by IP address ascending.
+    sort.Slice(parsedCIDRs, func(i, j int) bool {
+        onesI, bitsI := parsedCIDRs[i].Mask.Size()
+        onesJ, bitsJ := parsedCIDRs[j].Mask.Size()
+
+        // IPv4 networks (32 bits) sort before IPv6 ones (128 bits).
+        if bitsI != bitsJ {
+            return bitsI < bitsJ
+        }
+        if onesI != onesJ {
+            return onesI < onesJ
+        }
+        return bytes.Compare(parsedCIDRs[i].IP, parsedCIDRs[j].IP) < 0
+    })
+
+    var keptCIDRs []*net.IPNet
+    for _, candidate := range parsedCIDRs {
+        redundant := false
+        for _, keeper := range keptCIDRs {
+            if cidrContains(keeper, candidate) {
+                redundant = true
+                if !quiet {
+                    statusf("Filtered out redundant CIDR: %s (contained in %s)\n",
+                        candidate.String(), keeper.String())
+                }
+                break
+            }
+        }
+        if !redundant {
+            keptCIDRs = append(keptCIDRs, candidate)
+        }
+    }
+
+    var results []string
+    for _, net := range keptCIDRs {
+        results = append(results, net.String())
+    }
+    return results
+}
+
+// aggregatePrefixes merges overlapping, adjacent and sibling prefixes (1.2.3.0/25 and 1.2.3.128/25
+// become 1.2.3.0/24) into the smallest set of CIDRs covering exactly the same addresses.
+func aggregatePrefixes(cidrs []string) []string {
+    var intervals []addrInterval
+    var result, ipv6 []string
+    for _, cidr := range cidrs {
+        _, ipNet, err := net.ParseCIDR(cidr)
+        switch {
+        case err != nil:
+            result = append(result, cidr)
+        case ipNet.IP.To4() == nil:
+            ipv6 = append(ipv6, cidr)
+        default:
+            intervals = append(intervals, addrInterval{binary.BigEndian.Uint32(ipNet.IP.To4()), binary.BigEndian.Uint32(lastIP(ipNet))})
+        }
+    }
+    for _, iv := range mergeIntervals(intervals) {
+        result = append(result, splitRangeToCIDRs(iv.Start, iv.End)...)
+    }
+    return append(result, aggregateIPv6Prefixes(ipv6)...)
+}
+
+// aggregateIPv6Prefixes is aggregatePrefixes for IPv6 prefixes. In address order, nested prefixes
+// follow the one containing them, and a prefix can only be merged with the one right before it
+// (repeatedly, as the merged parent may in turn complete a pair).
+func aggregateIPv6Prefixes(cidrs []string) []string {
+    var prefixes []netip.Prefix
+    for _, cidr := range cidrs {
+        if p, err := netip.ParsePrefix(cidr); err == nil {
+            prefixes = append(prefixes, p.Masked())
+        }
+    }
+    slices.SortFunc(prefixes, func(a, b netip.Prefix) int {
+        if c := a.Addr().Compare(b.Addr()); c != 0 {
+            return c
+        }
+        return a.Bits() - b.Bits()
+    })
+    var merged []netip.Prefix
+    for _, p := range prefixes {
+        if n := len(merged); n > 0 && merged[n-1].Overlaps(p) {
+            continue
+        }
+        merged = append(merged, p)
+        for n := len(merged); n >= 2; n = len(merged) {
+            lower, upper := merged[n-2], merged[n-1]
+            if lower.Bits() != upper.Bits() || lower.Bits() == 0 {
+                break
+            }
+            parent, _ := lower.Addr().Prefix(lower.Bits() - 1)
+            if parent.Addr() != lower.Addr() || !parent.Contains(upper.Addr()) {
+                break
+            }
+            merged = append(merged[:n-2], parent)
+        }
+    }
+    result := make([]string, 0, len(merged))
+    for _, p := range merged {
+        result = append(result, p.String())
+    }
+    return result
+}
+
+//-------------------------------------------------------------------------
+// Set operations on CIDR lists (--exclude, --intersect)
+//-------------------------------------------------------------------------
+
+// cidrSet is a set of addresses for the difference and intersection of prefix lists, as sorted,
+// disjoint intervals of each family, so that a prefix is subtracted in one pass over them.
+type cidrSet struct {
+    v4 []addrInterval
+    v6 []addrInterval6
+}
+
+// addrInterval6 is an inclusive range of IPv6 addresses.
+type addrInterval6 struct {
+    Start, End netip.Addr
+}
+
+// newCIDRSet builds the set of addresses covered by cidrs, whose union it is.
+func newCIDRSet(cidrs []string) *cidrSet {
+    set := &cidrSet{}
+    var intervals []addrInterval
+    for _, cidr := range aggregatePrefixes(cidrs) {
+        p, err := netip.ParsePrefix(cidr)
+        if err != nil {
+            continue
+        }
+        if p.Addr().Is4() {
+            start, end := prefixInterval(p)
+            intervals = append(intervals, addrInterval{start, end})
+            continue
+        }
+        start, end := prefixInterval6(p)
+        set.v6 = append(set.v6, addrInterval6{start, end})
+    }
+    set.v4 = mergeIntervals(intervals)
+    // Aggregated prefixes are disjoint, so sorting is all the IPv6 intervals need.
+    slices.SortFunc(set.v6, func(a, b addrInterval6) int { return a.Start.Compare(b.Start) })
+    return set
+}
+
+// prefixInterval6 returns the first and last address of an IPv6 prefix.
+func prefixInterval6(p netip.Prefix) (netip.Addr, netip.Addr) {
+    start := p.Masked().Addr()
+    a := start.As16()
+    for i := p.Bits(); i < 128; i++ {
+        a[i/8] |= 0x80 >> (i % 8)
+    }
+    return start, netip.AddrFrom16(a)
+}
+
+// splitRange6 returns the exact IPv6 prefixes covering start-end in address order, the IPv6
+// counterpart of splitRangeToCIDRs.
+func splitRange6(start, end netip.Addr) []string {
+    var result []string
+    for cur := start; cur.IsValid() && cur.Compare(end) <= 0; {
+        // The largest prefix that starts at cur and ends at or before end.
+        var p netip.Prefix
+        var last netip.Addr
+        for bits := 0; bits <= 128; bits++ {
+            p = netip.PrefixFrom(cur, bits)
+            if p.Masked().Addr() != cur {
+                continue
+            }
+            if _, last = prefixInterval6(p); last.Compare(end) <= 0 {
+                break
+            }
+        }
+        result = append(result, p.String())
+        cur = last.Next() // Invalid after the last address, which ends the loop.
+    }
+    return result
+}
+
+// prefixInterval returns the first and last address of an IPv4 prefix.
+func prefixInterval(p netip.Prefix) (uint32, uint32) {
+    a := p.Masked().Addr().As4()
+    start := binary.BigEndian.Uint32(a[:])
+    return start, uint32(uint64(start) + 1<<(32-p.Bits()) - 1)
+}
+
+// subtract returns the parts of cidr that are not in the set, as exact CIDRs in address order.
+func (s *cidrSet) subtract(cidr string) []string {
+    p, err := netip.ParsePrefix(cidr)
+    if err != nil {
+        return []string{cidr}
+    }
+    if p.Addr().Is6() {
+        return s.subtract6(p)
+    }
+    start, end := prefixInterval(p)
+    var result []string
+    cur := uint64(start)
+    i := sort.Search(len(s.v4), func(i int) bool { return s.v4[i].End >= start })
+    for ; i < len(s.v4) && s.v4[i].Start <= end && cur <= uint64(end); i++ {
+        if uint64(s.v4[i].Start) > cur {
+            result = append(result, splitRangeToCIDRs(uint32(cur), s.v4[i].Start-1)...)
+        }
+        cur = uint64(s.v4[i].End) + 1
+    }
+    if cur <= uint64(end) {
+        result = append(result, splitRangeToCIDRs(uint32(cur), end)...)
+    }
+    return result
+}
+
+// intersect returns the parts of cidr that are in the set, as exact CIDRs in address order.
+func (s *cidrSet) intersect(cidr string) []string {
+    p, err := netip.ParsePrefix(cidr)
+    if err != nil {
+        return nil
+    }
+    p = p.Masked()
+    var result []string
+    if p.Addr().Is6() {
+        start, end := prefixInterval6(p)
+        i := sort.Search(len(s.v6), func(i int) bool { return s.v6[i].End.Compare(start) >= 0 })
+        for ; i < len(s.v6) && s.v6[i].Start.Compare(end) <= 0; i++ {
+            result = append(result, splitRange6(maxAddr(start, s.v6[i].Start), minAddr(end, s.v6[i].End))...)
+        }
+        return result
+    }
+    start, end := prefixInterval(p)
+    i := sort.Search(len(s.v4), func(i int) bool { return s.v4[i].End >= start })
+    for ; i < len(s.v4) && s.v4[i].Start <= end; i++ {
+        result = append(result, splitRangeToCIDRs(max(start, s.v4[i].Start), min(end, s.v4[i].End))...)
+    }
+    return result
+}
+
+// subtract6 is subtract for an IPv6 prefix.
+func (s *cidrSet) subtract6(p netip.Prefix) []string {
+    start, end := prefixInterval6(p)
+    var result []string
+    cur := start
+    i := sort.Search(len(s.v6), func(i int) bool { return s.v6[i].End.Compare(start) >= 0 })
+    for ; i < len(s.v6) && s.v6[i].Start.Compare(end) <= 0 && cur.IsValid(); i++ {
+        if s.v6[i].Start.Compare(cur) > 0 {
+            result = append(result, splitRange6(cur, s.v6[i].Start.Prev())...)
+        }
+        cur = s.v6[i].End.Next()
+    }
+    if cur.IsValid() && cur.Compare(end) <= 0 {
+        result = append(result, splitRange6(cur, end)...)
+    }
+    return result
+}
+
+// minAddr and maxAddr return the lower and the higher of two addresses.
+func minAddr(a, b netip.Addr) netip.Addr {
+    if a.Compare(b) <= 0 {
+        return a
+    }
+    return b
+}
+
+func maxAddr(a, b netip.Addr) netip.Addr {
+    if a.Compare(b) >= 0 {
+        return a
+    }
+    return b
+}
+
+// invertPrefixes returns the complement of cidrs in the IPv4 space, or in the IPv6 space if ipv6 is
+// set: exact CIDRs in address order covering every address that none of cidrs covers.
+func invertPrefixes(cidrs []string, ipv6 bool) []string {
+    if ipv6 {
+        return newCIDRSet(cidrs).subtract("::/0")
+    }
+    return newCIDRSet(cidrs).subtract("0.0.0.0/0")
+}
+
+// excludeSet and intersectSet hold the address space of --exclude and --intersect once resolved
+// (nil if the option is not given).
+var excludeSet, intersectSet *cidrSet
+
+// setOperationsActive reports whether --exclude or --intersect change the generated lists.
+func setOperationsActive() bool {
+    return excludeSet != nil || intersectSet != nil
+}
+
+// setOperation returns what is left of one prefix after --intersect and --exclude.
+func setOperation(cidr string) []string {
+    pieces := []string{cidr}
+    if intersectSet != nil {
+        pieces = intersectSet.intersect(cidr)
+    }
+    if excludeSet == nil {
+        return pieces
+    }
+    var result []string
+    for _, piece := range pieces {
+        result = append(result, excludeSet.subtract(piece)...)
+    }
+    return result
+}
+
+// applySetOperations is setOperation for a whole list.
+func applySetOperations(cidrs []string) []string {
+    if !setOperationsActive() {
+        return cidrs
+    }
+    var result []string
+    for _, cidr := range cidrs {
+        result = append(result, setOperation(cidr)...)
+    }
+    return result
+}
+
+// resolveSetOperations builds excludeSet and intersectSet from the items of --exclude and --intersect.
+// An item is a CIDR, @FILE (one CIDR or address per line, # starts a comment), search:CC:KEYWORDS
+// (the blocks a -search finds, such as the networks of a provider) or a country selection, whose
+// registered space is taken address by address, so a block of another country nested in it counts too.
+func resolveSetOperations() bool {
+    build := func(items []string) (*cidrSet, bool) {
+        if len(items) == 0 {
+            return nil, true
+        }
+        var cidrs []string
+        for _, item := range items {
+            list, ok := setOperationItem(strings.TrimSpace(item))
+            if !ok {
+                return nil, false
+            }
+            cidrs = append(cidrs, list...)
+        }
+        return newCIDRSet(cidrs), true
+    }
+    var ok bool
+    if excludeSet, ok = build(excludeItems); !ok {
+        return false
+    }
+    intersectSet, ok = build(intersectItems)
+    return ok
+}
+
+// setOperationItem returns the prefixes of one --exclude or --intersect item (see resolveSetOperations).
+func setOperationItem(item string) ([]string, bool) {
+    if p, err := netip.ParsePrefix(item); err == nil {
+        return []string{p.Masked().String()}, true
+    }
+    if path, found := strings.CutPrefix(item, "@"); found {
+        cidrs, err := readCIDRFile(path)
+        if err != nil {
+            errorf("Error reading %s: %v\n", path, err)
+            return nil, false
+        }
+        return cidrs, true
+    }
+    if !ensureRIPEdb() {
+        return nil, false
+    }
+    if query, found := strings.CutPrefix(item, "search:"); found {
+        countryCode, keywordList, _ := strings.Cut(query, ":")
+        var keywords []string
+        for _, kw := range strings.Split(keywordList, ",") {
+            if kw = strings.TrimSpace(kw); kw != "" {
+                keywords = append(keywords, kw)
+            }
+        }
+        if countryCode = strings.TrimSpace(countryCode); countryCode != "" {
+            resolved, ok := resolveCountryCode(countryCode)
+            if !ok {
+                return nil, false
+            }
+            countryCode = resolved
+        }
+        cidrs, _, _ := cachedSearchPrefixList(countryCode, keywords, true)
+        statusf("%s: %d prefixes\n", item, len(cidrs))
+        return cidrs, true
+    }
+    countryCode, ok := resolveCountryCode(item)
+    if !ok {
+        return nil, false
+    }
+    cidrs := extractCountryCIDRs(countryCode, ripedbPath, false)
+    if withIPv6 && ensureInet6num() {
+        ipv6, err := extractCountryCIDRs6(countryCode)
+        if err != nil {
+            errorln("Error reading inet6num objects:", err)
+            return nil, false
+        }
+        cidrs = append(cidrs, ipv6...)
+    }
+    statusf("%s: %d prefixes\n", item, len(cidrs))
+    return cidrs, true
+}
+
+// readCIDRFile reads a list of prefixes, one per line; single addresses become /32 or /128 and
+// everything after # is a comment.
+func readCIDRFile(path string) ([]string, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var cidrs []string
+    for n, line := range strings.Split(string(data), "\n") {
+        line, _, _ = strings.Cut(line, "#")
+        if line = strings.TrimSpace(line); line == "" {
+            continue
+        }
+        if p, err := netip.ParsePrefix(line); err == nil {
+            cidrs = append(cidrs, p.Masked().String())
+            continue
+        }
+        addr, err := netip.ParseAddr(line)
+        if err != nil {
+            return nil, fmt.Errorf("line %d: not a prefix or address: %q", n+1, line)
+        }
+        cidrs = append(cidrs, netip.PrefixFrom(addr, addr.BitLen()).String())
+    }
+    return cidrs, nil
+}
+
+// cidrContains checks if 'inner' is fully contained within 'outer'.
+func cidrContains(outer, inner *net.IPNet) bool {
+    if !outer.Contains(inner.IP) {
+        return false
+    }
+    innerLast := lastIP(inner)
+    return outer.Contains(innerLast)
+}
+
+// lastIP calculates the broadcast (last) address in a subnet range, for IPv4 and IPv6.
+func lastIP(ipNet *net.IPNet) net.IP {
+    ip := ipNet.IP.To4()
+    if ip == nil || len(ipNet.Mask) == net.IPv6len {
+        ip = ipNet.IP.To16()
+    }
+    mask := ipNet.Mask
+    if ip == nil || len(mask) != len(ip) {
+        return nil
+    }
+    network := ip.Mask(mask)
+    broadcast := make(net.IP, len(network))
+
+    for i := 0; i < len(network); i++ {
+        broadcast[i] = network[i] | ^mask[i]
+    }
+    return broadcast
+}
+
+// cidrAddressCount returns the number of IPv4 addresses covered by a CIDR (0 if it cannot be parsed).
+func cidrAddressCount(cidr string) uint64 {
+    _, ipNet, err := net.ParseCIDR(cidr)
+    if err != nil {
+        return 0
+    }
+    ones, bits := ipNet.Mask.Size()
+    if bits != 32 {
+        return 0
+    }
+    return uint64(1) << uint(bits-ones)
+}
+
+// removeDuplicates removes duplicate strings from a slice while preserving order.
+func removeDuplicates(elements []string) []string {
+    seen := make(map[string]bool)
+    var result []string
+    for _, e := range elements {
+        if !seen[e] {
+            seen[e] = true
+            result = append(result, e)
+        }
+    }
+    return result
+}
+
+//-------------------------------------------------------------------------
+// List of available country codes
+//-------------------------------------------------------------------------
+
+// countryNames maps ISO 3166-1 alpha-2 country codes to their English names.
+// Which codes are actually in use is derived from the database (see loadCountrySummary).
+var countryNames = map[string]string{
+    "AD": "Andorra", "AE": "United Arab Emirates", "AF": "Afghanistan", "AG": "Antigua and Barbuda",
+    "AI": "Anguilla", "AL": "Albania", "AM": "Armenia", "AO": "Angola", "AQ": "Antarctica",
+    "AR": "Argentina", "AS": "American Samoa", "AT": "Austria", "AU": "Australia", "AW": "Aruba",
+    "AX": "Aland Islands", "AZ": "Azerbaijan", "BA": "Bosnia and Herzegovina", "BB": "Barbados",
+    "BD": "Bangladesh", "BE": "Belgium", "BF": "Burkina Faso", "BG": "Bulgaria", "BH": "Bahrain",
+    "BI": "Burundi", "BJ": "Benin", "BL": "Saint Barthelemy", "BM": "Bermuda", "BN": "Brunei",
+    "BO": "Bolivia", "BQ": "Caribbean Netherlands", "BR": "Brazil", "BS": "Bahamas", "BT": "Bhutan",
+    "BV": "Bouvet Island", "BW": "Botswana", "BY": "Belarus", "BZ": "Belize", "CA": "Canada",
+    "CC": "Cocos (Keeling) Islands", "CD": "DR Congo", "CF": "Central African Republic",
+    "CG": "Congo", "CH": "Switzerland", "CI": "Cote d'Ivoire", "CK": "Cook Islands", "CL": "Chile",
+    "CM": "Cameroon", "CN": "China", "CO": "Colombia", "CR": "Costa Rica", "CU": "Cuba",
+    "CV": "Cabo Verde", "CW": "Curacao", "CX": "Christmas Island", "CY": "Cyprus",
+    "CZ": "Czech Republic", "DE": "Germany", "DJ": "Djibouti", "DK": "Denmark", "DM": "Dominica",
+    "DO": "Dominican Republic", "DZ": "Algeria", "EC": "Ecuador", "EE": "Estonia", "EG": "Egypt",
+    "EH": "Western Sahara", "ER": "Eritrea", "ES": "Spain", "ET": "Ethiopia", "FI": "Finland",
+    "FJ": "Fiji", "FK": "Falkland Islands", "FM": "Micronesia", "FO": "Faroe Islands",
+    "FR": "France", "GA": "Gabon", "GB": "United Kingdom", "GD": "Grenada", "GE": "Georgia",
+    "GF": "French Guiana", "GG": "Guernsey", "GH": "Ghana", "GI": "Gibraltar", "GL": "Greenland",
+    "GM": "Gambia", "GN": "Guinea", "GP": "Guadeloupe", "GQ": "Equatorial Guinea", "GR": "Greece",
+    "GS": "South Georgia and the South Sandwich Islands", "GT": "Guatemala", "GU": "Guam",
+    "GW": "Guinea-Bissau", "GY": "Guyana", "HK": "Hong Kong",
+    "HM": "Heard Island and McDonald Islands", "HN": "Honduras", "HR": "Croatia", "HT": "Haiti",
+    "HU": "Hungary", "ID": "Indonesia", "IE": "Ireland", "IL": "Israel", "IM": "Isle of Man",
+    "IN": "India", "IO": "British Indian Ocean Territory", "IQ": "Iraq", "IR": "Iran",
+    "IS": "Iceland", "IT": "Italy", "JE": "Jersey", "JM": "Jamaica", "JO": "Jordan", "JP": "Japan",
+    "KE": "Kenya", "KG": "Kyrgyzstan", "KH": "Cambodia", "KI": "Kiribati", "KM": "Comoros",
+    "KN": "Saint Kitts and Nevis", "KP": "North Korea", "KR": "South Korea", "KW": "Kuwait",
+    "KY": "Cayman Islands", "KZ": "Kazakhstan", "LA": "Laos", "LB": "Lebanon", "LC": "Saint Lucia",
+    "LI": "Liechtenstein", "LK": "Sri Lanka", "LR": "Liberia", "LS": "Lesotho", "LT": "Lithuania",
+    "LU": "Luxembourg", "LV": "Latvia", "LY": "Libya", "MA": "Morocco", "MC": "Monaco",
+    "MD": "Moldova", "ME": "Montenegro", "MF": "Saint Martin", "MG": "Madagascar",
+    "MH": "Marshall Islands", "MK": "North Macedonia", "ML": "Mali", "MM": "Myanmar",
+    "MN": "Mongolia", "MO": "Macao", "MP": "Northern Mariana Islands", "MQ": "Martinique",
+    "MR": "Mauritania", "MS": "Montserrat", "MT": "Malta", "MU": "Mauritius", "MV": "Maldives",
+    "MW": "Malawi", "MX": "Mexico", "MY": "Malaysia", "MZ": "Mozambique", "NA": "Namibia",
+    "NC": "New Caledonia", "NE": "Niger", "NF": "Norfolk Island", "NG": "Nigeria",
+    "NI": "Nicaragua", "NL": "Netherlands", "NO": "Norway", "NP": "Nepal", "NR": "Nauru",
+    "NU": "Niue", "NZ": "New Zealand", "OM": "Oman", "PA": "Panama", "PE": "Peru",
+    "PF": "French Polynesia", "PG": "Papua New Guinea", "PH": "Philippines", "PK": "Pakistan",
+    "PL": "Poland", "PM": "Saint Pierre and Miquelon", "PN": "Pitcairn", "PR": "Puerto Rico",
+    "PS": "Palestine", "PT": "Portugal", "PW": "Palau", "PY": "Paraguay", "QA": "Qatar",
+    "RE": "Reunion", "RO": "Romania", "RS": "Serbia", "RU": "Russia", "RW": "Rwanda",
+    "SA": "Saudi Arabia", "SB": "Solomon Islands", "SC": "Seychelles", "SD": "Sudan",
+    "SE": "Sweden", "SG": "Singapore", "SH": "Saint Helena", "SI": "Slovenia",
+    "SJ": "Svalbard and Jan Mayen", "SK": "Slovakia", "SL": "Sierra Leone", "SM": "San Marino",
+    "SN": "Senegal", "SO": "Somalia", "SR": "Suriname", "SS": "South Sudan",
+    "ST": "Sao Tome and Principe", "SV": "El Salvador", "SX": "Sint Maarten", "SY": "Syria",
+    "SZ": "Eswatini", "TC": "Turks and Caicos Islands", "TD": "Chad",
+    "TF": "French Southern Territories", "TG": "Togo", "TH": "Thailand", "TJ": "Tajikistan",
+    "TK": "Tokelau", "TL": "Timor-Leste", "TM": "Turkmenistan", "TN": "Tunisia", "TO": "Tonga",
+    "TR": "Turkey", "TT": "Trinidad and Tobago", "TV": "Tuvalu", "TW": "Taiwan", "TZ": "Tanzania",
+    "UA": "Ukraine", "UG": "Uganda", "UM": "United States Minor Outlying Islands",
+    "US": "United States", "UY": "Uruguay", "UZ": "Uzbekistan", "VA": "Vatican City",
+    "VC": "Saint Vincent and the Grenadines", "VE": "Venezuela", "VG": "British Virgin Islands",
+    "VI": "U.S. Virgin Islands", "VN": "Vietnam", "VU": "Vanuatu", "WF": "Wallis and Futuna",
+    "WS": "Samoa", "YE": "Yemen", "YT": "Mayotte", "ZA": "South Africa", "ZM": "Zambia",
+    "ZW": "Zimbabwe",
+}
+
+// countryName returns the full name of a country code, or the upper-cased code itself if it is unknown.
+func countryName(countryCode string) string {
+    countryCode = strings.ToUpper(countryCode)
+    if name, ok := countryNames[countryCode]; ok {
+        return name
+    }
+    if name, ok := placeholderCountries[countryCode]; ok {
+        return name
+    }
+    return countryCode
+}
+
+// placeholderCountries are the non-ISO codes the RIPE database puts in the country attribute of blocks
+// that belong to no single country: EU for networks used across the European Union, ZZ for unknown
+// or unassigned space. They are kept apart from real countries unless --include-placeholder is given.
+var placeholderCountries = map[string]string{
+    "EU": "European Union",
+    "ZZ": "Unknown or unassigned",
+}
+
+// isPlaceholderCountry reports whether code is one of the placeholderCountries.
+func isPlaceholderCountry(code string) bool {
+    _, ok := placeholderCountries[strings.ToUpper(code)]
+    return ok
+}
+
+// withoutPlaceholders drops the placeholder codes from codes unless --include-placeholder is given.
+func withoutPlaceholders(codes []string) []string {
+    if includePlaceholder {
+        return codes
+    }
+    return slices.DeleteFunc(codes, isPlaceholderCountry)
+}
+
+// reportSkippedPlaceholders prints how many blocks and addresses of placeholder codes a batch left out,
+// so the gap between the batch and the database is visible.
+func reportSkippedPlaceholders() {
+    if includePlaceholder {
+        return
+    }
+    summary, err := loadCountrySummary(ripedbPath)
+    if err != nil {
+        return
+    }
+    for _, code := range slices.Sorted(maps.Keys(placeholderCountries)) {
+        if stat, ok := summary.Countries[code]; ok {
+            statusf("Skipped placeholder code %s (%s): %d blocks, %d addresses; add --include-placeholder to write them\n",
+                code, localizedCountryName(code), stat.Blocks, stat.Addresses)
+        }
+    }
+}
+
+// continents maps continent codes to the countries on them, as assigned in GeoIP databases (Turkey,
+// Cyprus and the Caucasus count as Asia). XK is the code the RIPE database uses for Kosovo.
+var continents = map[string][]string{
+    "AF": strings.Fields(`AO BF BI BJ BW CD CF CG CI CM CV DJ DZ EG EH ER ET GA GH GM GN GQ GW KE KM LR LS LY MA MG
+        ML MR MU MW MZ NA NE NG RE RW SC SD SH SL SN SO SS ST SZ TD TG TN TZ UG YT ZA ZM ZW`),
+    "AN": strings.Fields(`AQ BV GS HM TF`),
+    "AS": strings.Fields(`AE AF AM AZ BD BH BN BT CC CN CX CY GE HK ID IL IN IO IQ IR JO JP KG KH KP KR KW KZ LA LB
+        LK MM MN MO MV MY NP OM PH PK PS QA SA SG SY TH TJ TL TM TR TW UZ VN YE`),
+    "EU": strings.Fields(`AD AL AT AX BA BE BG BY CH CZ DE DK EE ES FI FO FR GB GG GI GR HR HU IE IM IS IT JE LI LT
+        LU LV MC MD ME MK MT NL NO PL PT RO RS RU SE SI SJ SK SM UA VA XK`),
+    "NA": strings.Fields(`AG AI AW BB BL BM BQ BS BZ CA CR CU CW DM DO GD GL GP GT HN HT JM KN KY LC MF MQ MS MX NI
+        PA PM PR SV SX TC TT UM US VC VG VI`),
+    "OC": strings.Fields(`AS AU CK FJ FM GU KI MH MP NC NF NR NU NZ PF PG PN PW SB TK TO TV VU WF WS`),
+    "SA": strings.Fields(`AR BO BR CL CO EC FK GF GY PE PY SR UY VE`),
+}
+
+// continentGroupNames names the group of each continent; the name replaces the country code in
+// generated file names, ACL names and headers (acl_EUROPE.conf).
+var continentGroupNames = map[string]string{
+    "AF": "AFRICA", "AN": "ANTARCTICA", "AS": "ASIA", "EU": "EUROPE",
+    "NA": "NORTH_AMERICA", "OC": "OCEANIA", "SA": "SOUTH_AMERICA",
+}
+
+// countryGroups holds the country groups selected on the command line (such as continent:EU), by group
+// name. Group names are longer than two letters, so a group can stand wherever a country code is used;
+// the extraction functions match a block if its country is a member (see inCountrySelection).
+var countryGroups = make(map[string]map[string]bool)
+
+// countryMembers returns the sorted member countries of a group, or the code itself if it is a country.
+func countryMembers(code string) []string {
+    if members, ok := countryGroups[code]; ok {
+        return slices.Sorted(maps.Keys(members))
+    }
+    return []string{code}
+}
+
+// inCountrySelection reports whether a block of the given country belongs to selection, a country
+// code or the name of a country group.
+func inCountrySelection(selection, country string) bool {
+    if members, ok := countryGroups[selection]; ok {
+        return members[country]
+    }
+    return country == selection
+}
+
+// selectionSegments returns the merged segments (see mergedCountrySegments) of a country or country group.
+func selectionSegments(byCountry map[string][]geoEntry, selection string) []geoEntry {
+    var segments []geoEntry
+    for _, code := range countryMembers(selection) {
+        segments = append(segments, byCountry[code]...)
+    }
+    if len(segments) > 0 && len(countryGroups[selection]) > 0 {
+        sort.Slice(segments, func(i, j int) bool { return segments[i].Start < segments[j].Start })
+    }
+    return segments
+}
+
+// resolveContinent registers the group of a continent:CODE argument and returns its name.
+func resolveContinent(arg string) (string, bool) {
+    code := strings.ToUpper(strings.TrimSpace(arg))
+    members, ok := continents[code]
+    if !ok {
+        codes := slices.Sorted(maps.Keys(continents))
+        errorf("Unknown continent: %s (known: %s)\n", arg, strings.Join(codes, ", "))
+        return "", false
+    }
+    name := continentGroupNames[code]
+    countryGroups[name] = make(map[string]bool)
+    for _, member := range members {
+        countryGroups[name][member] = true
+    }
+    statusf("Using continent %s (%s, %d countries)\n", code, name, len(members))
+    return name, true
+}
+
+// resolveAllCountries registers the group ALL of every country in the database (placeholder codes only
+// with --include-placeholder), the base of selections such as all,-RU,-BY.
+func resolveAllCountries() (string, bool) {
+    if !ensureRIPEdb() {
+        return "", false
+    }
+    summary, err := loadCountrySummary(ripedbPath)
+    if err != nil {
+        errorln("Error reading the RIPE database:", err)
+        return "", false
+    }
+    members := make(map[string]bool)
+    for _, code := range withoutPlaceholders(slices.Collect(maps.Keys(summary.Countries))) {
+        members[code] = true
+    }
+    countryGroups["ALL"] = members
+    statusf("Using all countries (%d)\n", len(members))
+    return "ALL", true
+}
+
+// resolveCountryExpression resolves a comma-separated selection into a country group named after it:
+// further countries are added (RU,BY,KZ becomes RU_BY_KZ) and terms with a minus are excluded
+// (continent:EU,-DE,-FR, "all of Europe except Germany and France", becomes EUROPE-DE-FR; !DE works
+// as well), from left to right. Every term may be a code, a name or a continent; excluding a continent removes all its countries.
+func resolveCountryExpression(arg string) (string, bool) {
+    terms := strings.Split(arg, ",")
+    base := strings.TrimSpace(terms[0])
+    if base == "" || strings.HasPrefix(base, "-") || strings.HasPrefix(base, "!") {
+        errorf("A country selection must start with a country or continent: %s\n", arg)
+        return "", false
+    }
+    baseCode, ok := resolveCountryCode(base)
+    if !ok {
+        return "", false
+    }
+    members := make(map[string]bool)
+    for _, code := range countryMembers(baseCode) {
+        members[code] = true
+    }
+    name := baseCode
+    for _, term := range terms[1:] {
+        term = strings.TrimSpace(term)
+        country, excluded := strings.CutPrefix(term, "-")
+        if !excluded {
+            country, excluded = strings.CutPrefix(term, "!")
+        }
+        if country == "" {
+            errorf("Expected a country such as DE or an exclusion such as -DE instead of %q in %s\n", term, arg)
+            return "", false
+        }
+        code, ok := resolveCountryCode(country)
+        if !ok {
+            return "", false
+        }
+        for _, member := range countryMembers(code) {
+            if excluded {
+                delete(members, member)
+            } else {
+                members[member] = true
+            }
+        }
+        if excluded {
+            name += "-" + code
+        } else {
+            name += "_" + code
+        }
+    }
+    if len(members) == 0 {
+        errorf("No countries are left in %s\n", arg)
+        return "", false
+    }
+    countryGroups[name] = members
+    statusf("Using %s (%d countries)\n", name, len(members))
+    return name, true
+}
+
+// showAvailableCountryCodes prints the country codes actually present in the RIPE DB,
+// sorted alphabetically by name, together with block and address counts.
+// A non-empty filter keeps only countries whose code or name contains it (case-insensitive).
+func showAvailableCountryCodes(filter string) {
+    summary, err := loadCountrySummary(ripedbPath)
+    if err != nil {
+        errorln("Error reading the RIPE database:", err)
+        return
+    }
+
+    filter = strings.ToLower(strings.TrimSpace(filter))
+    codes := make([]string, 0, len(summary.Countries))
+    for code := range summary.Countries {
+        if filter != "" && !strings.Contains(strings.ToLower(code), filter) &&
+            !strings.Contains(strings.ToLower(countryName(code)), filter) &&
+            !strings.Contains(strings.ToLower(localizedCountryName(code)), filter) {
+            continue
+        }
+        codes = append(codes, code)
+    }
+
+    // Sort by Name (alphabetically)
+    sort.Slice(codes, func(i, j int) bool {
+        return localizedCountryName(codes[i]) < localizedCountryName(codes[j])
+    })
+
+    // Placeholder codes are no countries, so they are listed on their own after the real ones.
+    placeholders := slices.DeleteFunc(slices.Clone(codes), func(code string) bool { return !isPlaceholderCountry(code) })
+    codes = slices.DeleteFunc(codes, isPlaceholderCountry)
+
+    fmt.Println(tr("Available country codes and names (sorted by name):"))
+    for _, code := range codes {
+        stat := summary.Countries[code]
+        fmt.Printf(tr("%s - %s (%d blocks, %d addresses)\n"), code, localizedCountryName(code), stat.Blocks, stat.Addresses)
+    }
+    if len(placeholders) == 0 {
+        return
+    }
+    fmt.Println()
+    fmt.Println(tr("Placeholder codes (no single country, selected only with --include-placeholder):"))
+    for _, code := range placeholders {
+        stat := summary.Countries[code]
+        fmt.Printf(tr("%s - %s (%d blocks, %d addresses)\n"), code, localizedCountryName(code), stat.Blocks, stat.Addresses)
+    }
+}
+
+// resolveCountryCode turns a country code or a full/partial country name into an ISO code.
+// Two-letter arguments are always taken as codes. Ambiguous names are offered for selection
+// when running interactively; otherwise the candidates are printed and false is returned.
+// continent:CODE (continent:EU) selects the countries of a continent and returns the group name;
+// exclusions such as continent:EU,-DE,-FR are resolved by resolveCountryExpression.
+func resolveCountryCode(arg string) (string, bool) {
+    arg = strings.TrimSpace(arg)
+    if strings.Contains(arg, ",") {
+        return resolveCountryExpression(arg)
+    }
+    if prefix, code, found := strings.Cut(arg, ":"); found && strings.EqualFold(prefix, "continent") {
+        return resolveContinent(code)
+    }
+    if strings.EqualFold(arg, "all") {
+        return resolveAllCountries()
+    }
+    if len(arg) == 2 {
+        code := strings.ToUpper(arg)
+        if isPlaceholderCountry(code) && !includePlaceholder {
+            errorf("%s is a RIPE placeholder code (%s), not a country; add --include-placeholder to select its blocks\n",
+                code, localizedCountryName(code))
+            return "", false
+        }
+        return code, true
+    }
+
+    query := strings.ToLower(arg)
+    var exact, prefix, substring []string
+    for code := range countryNames {
+        // Every code is matched by its best-matching name in any supported language.
+        best := 0
+        for _, name := range []string{countryNames[code], countryNamesRU[code]} {
+            lowerName := strings.ToLower(name)
+            switch {
+            case lowerName == query:
+                best = max(best, 3)
+            case strings.HasPrefix(lowerName, query):
+                best = max(best, 2)
+            case strings.Contains(lowerName, query):
+                best = max(best, 1)
+            }
+        }
+        switch best {
+        case 3:
+            exact = append(exact, code)
+        case 2:
+            prefix = append(prefix, code)
+        case 1:
+            substring = append(substring, code)
+        }
+    }
+
+    // The closest kind of match wins: exact name, then name prefix, then substring.
+    candidates := exact
+    if len(candidates) == 0 {
+        candidates = prefix
+    }
+    if len(candidates) == 0 {
+        candidates = substring
+    }
+    sort.Slice(candidates, func(i, j int) bool {
+        return localizedCountryName(candidates[i]) < localizedCountryName(candidates[j])
+    })
+
+    switch len(candidates) {
+    case 0:
+        errorf("Unknown country code or name: %s (see -l)\n", arg)
+        return "", false
+    case 1:
+        statusf("Using country code %s (%s) for '%s'\n", candidates[0], localizedCountryName(candidates[0]), arg)
+        return candidates[0], true
+    }
+
+    statusf("'%s' matches several countries:\n", arg)
+    for i, code := range candidates {
+        fmt.Printf("  %d) %s - %s\n", i+1, code, localizedCountryName(code))
+    }
+    if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+        errorln("Please specify the country code explicitly.")
+        return "", false
+    }
+
+    fmt.Print(tr("Select a number: "))
+    var choice int
+    if _, err := fmt.Scanln(&choice); err != nil || choice < 1 || choice > len(candidates) {
+        errorln("No valid selection made.")
+        return "", false
+    }
+    return candidates[choice-1], true
+}
+
+//-------------------------------------------------------------------------
+// Country summary derived from the database
+//-------------------------------------------------------------------------
+
+// countryStat holds per-country totals of the inetnum blocks in the database.
+type countryStat struct {
+    Blocks    int    `json:"blocks"`    // Number of inetnum blocks with this country.
+    Addresses uint64 `json:"addresses"` // Distinct IPv4 addresses covered by those blocks.
+}
+
+// countrySummary is the cached result of a full country scan; it is valid
+// as long as the database file keeps the same size and modification time.
+type countrySummary struct {
+    DBSize    int64                  `json:"db_size"`
+    DBModTime int64                  `json:"db_mod_time"`
+    Countries map[string]countryStat `json:"countries"`
+}
+
+// countrySummaryPath returns the location of the cached summary next to the database file.
+func countrySummaryPath(dbPath string) string {
+    return dbPath + ".countries.json"
+}
+
+// loadCountrySummary returns the cached country summary, rescanning the database when it changed.
+func loadCountrySummary(dbPath string) (*countrySummary, error) {
+    fi, err := os.Stat(dbPath)
+    if err != nil {
+        return nil, err
+    }
+
+    cachePath := countrySummaryPath(dbPath)
+    if data, err := os.ReadFile(cachePath); err == nil {
+        var cached countrySummary
+        if json.Unmarshal(data, &cached) == nil && cached.DBSize == fi.Size() && cached.DBModTime == fi.ModTime().Unix() {
+            return &cached, nil
+        }
+    }
+
+    statusln("Scanning the RIPE database for country codes...")
+    summary, err := scanCountrySummary(dbPath)
+    if err != nil {
+        return nil, err
+    }
+    summary.DBSize = fi.Size()
+    summary.DBModTime = fi.ModTime().Unix()
+
+    // The summary is only an optimisation, so failing to store it is not fatal.
+    if data, err := json.MarshalIndent(summary, "", "  "); err == nil {
+        _ = os.WriteFile(cachePath, data, 0644)
+    }
+    return summary, nil
+}
+
+// scanCountrySummary counts blocks and distinct addresses for every country value present in the database.
+func scanCountrySummary(dbPath string) (*countrySummary, error) {
+    type span struct{ start, end uint32 }
+    type partial struct {
+        spans  map[string][]span
+        blocks map[string]int
+    }
+    perWorker := make([]partial, max(workers, 1))
+    for i := range perWorker {
+        perWorker[i] = partial{spans: make(map[string][]span), blocks: make(map[string]int)}
+    }
+
+    err := scanRangesParallel(dbPath, false, func(worker int, r dbRange) {
+        if r.Country == "" {
+            return
+        }
+        part := perWorker[worker]
+        part.blocks[r.Country]++
+        part.spans[r.Country] = append(part.spans[r.Country], span{r.Start, r.End})
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    spans, blocks := perWorker[0].spans, perWorker[0].blocks
+    for _, part := range perWorker[1:] {
+        for country, list := range part.spans {
+            spans[country] = append(spans[country], list...)
+            blocks[country] += part.blocks[country]
+        }
+    }
+
+    summary := &countrySummary{Countries: make(map[string]countryStat)}
+    for country, list := range spans {
+        sort.Slice(list, func(i, j int) bool { return list[i].start < list[j].start })
+
+        // Nested blocks must not be counted twice, so only the union of all spans is summed.
+        var total uint64
+        curStart, curEnd := uint64(list[0].start), uint64(list[0].end)
+        for _, sp := range list[1:] {
+            if uint64(sp.start) <= curEnd+1 {
+                if uint64(sp.end) > curEnd {
+                    curEnd = uint64(sp.end)
+                }
+                continue
+            }
+            total += curEnd - curStart + 1
+            curStart, curEnd = uint64(sp.start), uint64(sp.end)
+        }
+        total += curEnd - curStart + 1
+
+        summary.Countries[country] = countryStat{Blocks: blocks[country], Addresses: total}
+    }
+    return summary, nil
+}
+
+//-------------------------------------------------------------------------
+// Block scanning helpers shared by the newer commands
+//-------------------------------------------------------------------------
+
+// dbReader is a (possibly decompressing) reader over an open database file.
+type dbReader struct {
+    io.Reader
+    file *os.File
+}
+
+// Close closes the underlying database file.
+func (r *dbReader) Close() error {
+    return r.file.Close()
+}
+
+// openDatabase opens a database dump for reading. gzip and bzip2 compressed dumps, as published
+// by the RIPE NCC and its mirrors, are recognized by their magic bytes and decompressed on the fly.
+func openDatabase(dbPath string) (io.ReadCloser, error) {
+    file, err := os.Open(dbPath)
+    if err != nil {
+        return nil, err
+    }
+    buffered := bufio.NewReaderSize(file, 64*1024)
+    magic, _ := buffered.Peek(3)
+
+    switch {
+    case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+        gz, err := gzip.NewReader(buffered)
+        if err != nil {
+            file.Close()
+            return nil, fmt.Errorf("%s: %v", dbPath, err)
+        }
+        return &dbReader{Reader: gz, file: file}, nil
+    case bytes.Equal(magic, []byte("BZh")):
+        return &dbReader{Reader: bzip2.NewReader(buffered), file: file}, nil
+    default:
+        return &dbReader{Reader: buffered, file: file}, nil
+    }
+}
+
+// scanBlocks reads the RIPE DB file block by block (blocks are separated by blank lines)
+// and calls fn for every non-empty block.
+func scanBlocks(dbPath string, fn func(blockLines []string)) error {
+    file, err := openDatabase(dbPath)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    var blockLines []string
+    for scanner.Scan() {
+        line := scanner.Text()
+        if line == "" {
+            if len(blockLines) > 0 {
+                fn(blockLines)
+                blockLines = nil
+            }
+            continue
+        }
+        blockLines = append(blockLines, line)
+    }
+    if len(blockLines) > 0 {
+        fn(blockLines)
+    }
+    return scanner.Err()
+}
+
+// scanBlocksParallel is scanBlocks with the blocks handed to several workers (see --workers).
+// fn receives the index of the worker (0..workers-1), so callers can keep per-worker results
+// without locking; the order in which blocks are processed is not defined.
+func scanBlocksParallel(dbPath string, fn func(worker int, blockLines []string)) error {
+    if workers <= 1 {
+        return scanBlocks(dbPath, func(blockLines []string) { fn(0, blockLines) })
+    }
+
+    // Blocks are handed over in batches, so the channel is not the bottleneck.
+    const batchSize = 512
+    batches := make(chan [][]string, workers*2)
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func(worker int) {
+            defer wg.Done()
+            for batch := range batches {
+                for _, blockLines := range batch {
+                    fn(worker, blockLines)
+                }
+            }
+        }(w)
+    }
+
+    batch := make([][]string, 0, batchSize)
+    err := scanBlocks(dbPath, func(blockLines []string) {
+        batch = append(batch, blockLines)
+        if len(batch) == batchSize {
+            batches <- batch
+            batch = make([][]string, 0, batchSize)
+        }
+    })
+    if len(batch) > 0 {
+        batches <- batch
+    }
+    close(batches)
+    wg.Wait()
+    return err
+}
+
+// blockAttr returns the value of the first "name:" attribute in a block, or "" if it is absent.
+func blockAttr(blockLines []string, name string) string {
+    prefix := name + ":"
+    for _, line := range blockLines {
+        line = strings.TrimSpace(line)
+        if strings.HasPrefix(line, prefix) {
+            return strings.TrimSpace(line[len(prefix):])
+        }
+    }
+    return ""
+}
+
+// blockAttrs returns every value of an attribute of a block, in order (descr and mnt-by may repeat).
+func blockAttrs(blockLines []string, name string) []string {
+    prefix := name + ":"
+    var values []string
+    for _, line := range blockLines {
+        line = strings.TrimSpace(line)
+        if strings.HasPrefix(line, prefix) {
+            values = append(values, strings.TrimSpace(line[len(prefix):]))
+        }
+    }
+    return values
+}
+
+// parseInetnumRange parses an inetnum value like "1.2.3.0 - 1.2.3.255" into numeric start and end addresses.
+func parseInetnumRange(value string) (uint32, uint32, bool) {
+    parts := strings.Split(value, "-")
+    if len(parts) != 2 {
+        return 0, 0, false
+    }
+    startIP := net.ParseIP(strings.TrimSpace(parts[0])).To4()
+    endIP := net.ParseIP(strings.TrimSpace(parts[1])).To4()
+    if startIP == nil || endIP == nil {
+        return 0, 0, false
+    }
+    start := binary.BigEndian.Uint32(startIP)
+    end := binary.BigEndian.Uint32(endIP)
+    if end < start {
+        return 0, 0, false
+    }
+    return start, end, true
+}
+
+// uint32ToIP converts a numeric IPv4 address back to net.IP.
+func uint32ToIP(n uint32) net.IP {
+    ip := make(net.IP, 4)
+    binary.BigEndian.PutUint32(ip, n)
+    return ip
+}
+
+//-------------------------------------------------------------------------
+// Binary database
+//-------------------------------------------------------------------------
+
+// The binary database is a compact copy of the inetnum ranges of a dump, written next to it
+// (DBPATH.bin) after every download and on first use, so extraction, statistics and lookups do not
+// parse the text dump again. Commands that need other attributes (search, contacts, geofeeds) still
+// read the dump. All integers are big endian:
+//
+//	header     "CWDB", version uint16, reserved uint16, dump size int64, dump modification time int64 (ns),
+//	           record count uint32, country count uint16, netname table size uint32
+//	countries  country count × (length uint8, bytes)
+//	records    record count × (start uint32, end uint32, country index uint16, netname offset uint32),
+//	           sorted by ascending start and descending end, so parents come before their children
+//	netnames   uvarint length-prefixed strings; offset 0 is the empty netname
+//
+// The file is only used while the dump keeps the size and modification time recorded in the header;
+// a file of another version is rebuilt.
+const (
+    binaryDBMagic      = "CWDB"
+    binaryDBVersion    = 1
+    binaryDBHeaderSize = 34
+    binaryDBRecordSize = 14
+)
+
+// dbRange is an inetnum block reduced to what extraction, statistics and lookups need.
+type dbRange struct {
+    Start   uint32 // First address of the block.
+    End     uint32 // Last address of the block.
+    Country string // Upper case value of the country attribute (may be empty).
+    Netname string // Netname of the block; only filled when requested.
+}
+
+// binaryDBPath returns the location of the binary database next to the dump it was built from.
+func binaryDBPath(dbPath string) string {
+    return dbPath + ".bin"
+}
+
+// scanRanges calls fn for every inetnum block of the dump with a valid IPv4 range. It reads the binary
+// database when it is up to date; otherwise the dump is parsed once and the binary database is written
+// for the next run. In --low-memory mode a missing binary database is not built, the dump is streamed.
+func scanRanges(dbPath string, netnames bool, fn func(r dbRange)) error {
+    return readRanges(dbPath, netnames, false, func(_ int, r dbRange) { fn(r) })
+}
+
+// scanRangesParallel is scanRanges for callers that keep per-worker results (see scanBlocksParallel):
+// a dump that is streamed is parsed by --workers goroutines.
+func scanRangesParallel(dbPath string, netnames bool, fn func(worker int, r dbRange)) error {
+    return readRanges(dbPath, netnames, true, fn)
+}
+
+// readRanges implements scanRanges and scanRangesParallel.
+func readRanges(dbPath string, netnames, parallel bool, fn func(worker int, r dbRange)) error {
+    if db, err := openBinaryDatabase(dbPath); err == nil {
+        defer db.file.Close()
+        return db.scan(netnames, func(r dbRange) { fn(0, r) })
+    }
+
+    if lowMemory {
+        scan := func(worker int, blockLines []string) {
+            if r, ok := parseDBRange(blockLines, netnames); ok {
+                fn(worker, r)
+            }
+        }
+        if parallel {
+            return scanBlocksParallel(dbPath, scan)
+        }
+        return scanBlocks(dbPath, func(blockLines []string) { scan(0, blockLines) })
+    }
+
+    ranges, err := updateBinaryDatabase(dbPath)
+    if err != nil {
+        return err
+    }
+    for _, r := range ranges {
+        if !netnames {
+            r.Netname = ""
+        }
+        fn(0, r)
+    }
+    return nil
+}
+
+// parseDBRange extracts the range, country and (with netnames) netname of an inetnum block.
+func parseDBRange(blockLines []string, netnames bool) (dbRange, bool) {
+    start, end, ok := parseInetnumRange(blockAttr(blockLines, "inetnum"))
+    if !ok {
+        return dbRange{}, false
+    }
+    r := dbRange{Start: start, End: end, Country: strings.ToUpper(blockAttr(blockLines, "country"))}
+    if netnames {
+        r.Netname = blockAttr(blockLines, "netname")
+    }
+    return r, true
+}
+
+// updateBinaryDatabase parses the dump and writes its binary database, returning the ranges in
+// binary database order. The binary database is only an optimisation, so failing to write it is
+// reported as a warning and the ranges are still returned.
+func updateBinaryDatabase(dbPath string) ([]dbRange, error) {
+    // The dump is identified before it is read: if it is replaced meanwhile, the recorded identity
+    // no longer matches and the next run rebuilds the binary database.
+    fi, err := os.Stat(dbPath)
+    if err != nil {
+        return nil, err
+    }
+
+    perWorker := make([][]dbRange, max(workers, 1))
+    interned := make([]map[string]string, len(perWorker))
+    for i := range interned {
+        interned[i] = make(map[string]string)
+    }
+    err = scanBlocksParallel(dbPath, func(worker int, blockLines []string) {
+        r, ok := parseDBRange(blockLines, true)
+        if !ok {
+            return
+        }
+        if country, found := interned[worker][r.Country]; found {
+            r.Country = country
+        } else {
+            r.Country = strings.Clone(r.Country)
+            interned[worker][r.Country] = r.Country
+        }
+        r.Netname = strings.Clone(r.Netname)
+        perWorker[worker] = append(perWorker[worker], r)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    ranges := perWorker[0]
+    for _, part := range perWorker[1:] {
+        ranges = append(ranges, part...)
+    }
+    sort.Slice(ranges, func(i, j int) bool {
+        if ranges[i].Start != ranges[j].Start {
+            return ranges[i].Start < ranges[j].Start
+        }
+        return ranges[i].End > ranges[j].End
+    })
+
+    if err := writeBinaryDatabase(dbPath, fi, ranges); err != nil {
+        warnf("Warning: could not write the binary database %s: %v\n", binaryDBPath(dbPath), err)
+    }
+    return ranges, nil
+}
+
+// writeBinaryDatabase writes sorted ranges as the binary database of the dump described by fi.
+// The file is written under a temporary name and renamed, so readers never see a partial file.
+func writeBinaryDatabase(dbPath string, fi os.FileInfo, ranges []dbRange) error {
+    countryIndex := make(map[string]uint16)
+    var countries []string
+    netnameOffset := map[string]uint32{"": 0}
+    netnames := []byte{0}
+    records := make([]byte, 0, len(ranges)*binaryDBRecordSize)
+    for _, r := range ranges {
+        country := r.Country
+        if len(country) > 255 {
+            country = country[:255]
+        }
+        ci, ok := countryIndex[country]
+        if !ok {
+            if len(countries) > math.MaxUint16 {
+                return fmt.Errorf("too many distinct country values")
+            }
+            ci = uint16(len(countries))
+            countryIndex[country] = ci
+            countries = append(countries, country)
+        }
+        ni, ok := netnameOffset[r.Netname]
+        if !ok {
+            ni = uint32(len(netnames))
+            netnameOffset[r.Netname] = ni
+            netnames = binary.AppendUvarint(netnames, uint64(len(r.Netname)))
+            netnames = append(netnames, r.Netname...)
+        }
+        records = binary.BigEndian.AppendUint32(records, r.Start)
+        records = binary.BigEndian.AppendUint32(records, r.End)
+        records = binary.BigEndian.AppendUint16(records, ci)
+        records = binary.BigEndian.AppendUint32(records, ni)
+    }
+    if uint64(len(netnames)) > math.MaxUint32 || uint64(len(ranges)) > math.MaxUint32 {
+        return fmt.Errorf("database too large for the binary format")
+    }
+
+    header := make([]byte, 0, binaryDBHeaderSize)
+    header = append(header, binaryDBMagic...)
+    header = binary.BigEndian.AppendUint16(header, binaryDBVersion)
+    header = binary.BigEndian.AppendUint16(header, 0)
+    header = binary.BigEndian.AppendUint64(header, uint64(fi.Size()))
+    header = binary.BigEndian.AppendUint64(header, uint64(fi.ModTime().UnixNano()))
+    header = binary.BigEndian.AppendUint32(header, uint32(len(ranges)))
+    header = binary.BigEndian.AppendUint16(header, uint16(len(countries)))
+    header = binary.BigEndian.AppendUint32(header, uint32(len(netnames)))
+    for _, country := range countries {
+        header = append(header, byte(len(country)))
+        header = append(header, country...)
+    }
+
+    path := binaryDBPath(dbPath)
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    defer tmp.Close()
+    if err := tmp.Chmod(0644); err != nil {
+        return err
+    }
+    for _, part := range [][]byte{header, records, netnames} {
+        if _, err := tmp.Write(part); err != nil {
+            return err
+        }
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+// binaryDB is an open binary database positioned at its first record.
+type binaryDB struct {
+    file           *os.File
+    reader         *bufio.Reader
+    count          uint32   // Number of records.
+    countries      []string // Country values by index.
+    netnamesOffset int64    // File offset of the netname table.
+    netnamesSize   uint32   // Size of the netname table.
+}
+
+// openBinaryDatabase opens the binary database of dbPath if it exists, has the current version and
+// was built from the dump as it is now.
+func openBinaryDatabase(dbPath string) (*binaryDB, error) {
+    fi, err := os.Stat(dbPath)
+    if err != nil {
+        return nil, err
+    }
+    file, err := os.Open(binaryDBPath(dbPath))
+    if err != nil {
+        return nil, err
+    }
+    db := &binaryDB{file: file, reader: bufio.NewReaderSize(file, 256*1024)}
+    if err := db.readHeader(fi); err != nil {
+        file.Close()
+        return nil, err
+    }
+    return db, nil
+}
+
+// readHeader reads and checks the header and the country table.
+func (db *binaryDB) readHeader(dump os.FileInfo) error {
+    header := make([]byte, binaryDBHeaderSize)
+    if _, err := io.ReadFull(db.reader, header); err != nil {
+        return err
+    }
+    if string(header[:4]) != binaryDBMagic || binary.BigEndian.Uint16(header[4:]) != binaryDBVersion {
+        return fmt.Errorf("%s: not a binary database of version %d", db.file.Name(), binaryDBVersion)
+    }
+    if int64(binary.BigEndian.Uint64(header[8:])) != dump.Size() || int64(binary.BigEndian.Uint64(header[16:])) != dump.ModTime().UnixNano() {
+        return fmt.Errorf("%s: built from another version of the dump", db.file.Name())
+    }
+    db.count = binary.BigEndian.Uint32(header[24:])
+    db.countries = make([]string, binary.BigEndian.Uint16(header[28:]))
+    db.netnamesSize = binary.BigEndian.Uint32(header[30:])
+
+    offset := int64(binaryDBHeaderSize)
+    for i := range db.countries {
+        length, err := db.reader.ReadByte()
+        if err != nil {
+            return err
+        }
+        country := make([]byte, length)
+        if _, err := io.ReadFull(db.reader, country); err != nil {
+            return err
+        }
+        db.countries[i] = string(country)
+        offset += 1 + int64(length)
+    }
+    db.netnamesOffset = offset + int64(db.count)*binaryDBRecordSize
+
+    // A truncated file is rebuilt rather than read up to the point where it breaks off.
+    fi, err := db.file.Stat()
+    if err != nil {
+        return err
+    }
+    if fi.Size() != db.netnamesOffset+int64(db.netnamesSize) {
+        return fmt.Errorf("%s: truncated binary database", db.file.Name())
+    }
+    return nil
+}
+
+// scan calls fn for every record; netnames are only loaded when requested.
+func (db *binaryDB) scan(netnames bool, fn func(r dbRange)) error {
+    var table []byte
+    names := make(map[uint32]string) // Netnames already converted, by offset.
+    if netnames {
+        table = make([]byte, db.netnamesSize)
+        if _, err := db.file.ReadAt(table, db.netnamesOffset); err != nil {
+            return err
+        }
+    }
+
+    const chunkRecords = 4096
+    chunk := make([]byte, chunkRecords*binaryDBRecordSize)
+    for left := int(db.count); left > 0; {
+        n := min(left, chunkRecords)
+        if _, err := io.ReadFull(db.reader, chunk[:n*binaryDBRecordSize]); err != nil {
+            return err
+        }
+        for i := 0; i < n; i++ {
+            record := chunk[i*binaryDBRecordSize:]
+            r := dbRange{Start: binary.BigEndian.Uint32(record), End: binary.BigEndian.Uint32(record[4:])}
+            country := int(binary.BigEndian.Uint16(record[8:]))
+            if country >= len(db.countries) {
+                return fmt.Errorf("%s: corrupt record", db.file.Name())
+            }
+            r.Country = db.countries[country]
+            if netnames {
+                offset := binary.BigEndian.Uint32(record[10:])
+                if name, ok := names[offset]; ok {
+                    r.Netname = name
+                    fn(r)
+                    continue
+                }
+                if offset >= uint32(len(table)) {
+                    return fmt.Errorf("%s: corrupt record", db.file.Name())
+                }
+                length, size := binary.Uvarint(table[offset:])
+                if size <= 0 || uint64(len(table))-uint64(offset)-uint64(size) < length {
+                    return fmt.Errorf("%s: corrupt record", db.file.Name())
+                }
+                from := uint64(offset) + uint64(size)
+                r.Netname = string(table[from : from+length])
+                names[offset] = r.Netname
+            }
+            fn(r)
+        }
+        left -= n
+    }
+    return nil
+}
+
+//-------------------------------------------------------------------------
+// Search keywords
+//-------------------------------------------------------------------------
+
+// isRegexKeyword reports whether a search keyword is a regular expression written as /EXPR/.
+func isRegexKeyword(kw string) bool {
+    return len(kw) > 2 && strings.HasPrefix(kw, "/") && strings.HasSuffix(kw, "/")
+}
+
+// searchMatcher matches the lower case text of a block against the keywords of a search: plain
+// keywords are substrings, /EXPR/ keywords are case-insensitive regular expressions in which ^ and $
+// match at the start and end of every line of the block.
+type searchMatcher struct {
+    substrings []string
+    regexes    []*regexp.Regexp
+    required   [][]string // For each keyword, strings that every matching block contains; nil if unknown.
+}
+
+// newSearchMatcher compiles the keywords of a search; empty keywords are ignored.
+func newSearchMatcher(keywords []string) (*searchMatcher, error) {
+    m := &searchMatcher{}
+    for _, kw := range keywords {
+        switch {
+        case kw == "":
+            continue
+        case isRegexKeyword(kw):
+            // The expression is parsed on its own first, so errors quote it as written.
+            expr := kw[1 : len(kw)-1]
+            parsed, err := syntax.Parse(expr, syntax.Perl)
+            if err != nil {
+                return nil, fmt.Errorf("%s: %v", kw, err)
+            }
+            re, err := regexp.Compile("(?im)" + expr)
+            if err != nil {
+                return nil, fmt.Errorf("%s: %v", kw, err)
+            }
+            m.regexes = append(m.regexes, re)
+            m.required = append(m.required, regexLiterals(parsed.Simplify()))
+        default:
+            kw = strings.ToLower(kw)
+            m.substrings = append(m.substrings, kw)
+            m.required = append(m.required, []string{kw})
+        }
+    }
+    return m, nil
+}
+
+// empty reports whether there is no keyword to match.
+func (m *searchMatcher) empty() bool {
+    return len(m.substrings) == 0 && len(m.regexes) == 0
+}
+
+// match reports whether the lower case block text contains one of the keywords.
+func (m *searchMatcher) match(text string) bool {
+    for _, kw := range m.substrings {
+        if strings.Contains(text, kw) {
+            return true
+        }
+    }
+    for _, re := range m.regexes {
+        if re.MatchString(text) {
+            return true
+        }
+    }
+    return false
+}
+
+// regexLiterals returns lower case strings of three or more bytes that every match of a regular
+// expression contains, so the search indexes can skip text without them. It only looks at
+// concatenations, groups and repetitions of at least one; alternations yield nothing.
+func regexLiterals(re *syntax.Regexp) []string {
+    switch re.Op {
+    case syntax.OpLiteral:
+        if literal := strings.ToLower(string(re.Rune)); len(literal) >= 3 {
+            return []string{literal}
+        }
+    case syntax.OpCapture, syntax.OpPlus:
+        return regexLiterals(re.Sub[0])
+    case syntax.OpRepeat:
+        if re.Min >= 1 {
+            return regexLiterals(re.Sub[0])
+        }
+    case syntax.OpConcat:
+        var literals []string
+        var run strings.Builder
+        flush := func() {
+            if literal := strings.ToLower(run.String()); len(literal) >= 3 {
+                literals = append(literals, literal)
+            }
+            run.Reset()
+        }
+        for _, sub := range re.Sub {
+            if sub.Op == syntax.OpLiteral {
+                run.WriteString(string(sub.Rune))
+                continue
+            }
+            flush()
+            literals = append(literals, regexLiterals(sub)...)
+        }
+        flush()
+        return literals
+    }
+    return nil
+}
+
+// requiredTrigrams returns, for each keyword, the trigrams every matching block contains, or false
+// if some keyword has none (or there is no keyword), as then no part of the dump can be skipped.
+func (m *searchMatcher) requiredTrigrams() ([][]uint32, bool) {
+    var result [][]uint32
+    for _, literals := range m.required {
+        seen := make(map[uint32]bool)
+        var grams []uint32
+        for _, literal := range literals {
+            for i := 0; i+3 <= len(literal); i++ {
+                g := uint32(literal[i])<<16 | uint32(literal[i+1])<<8 | uint32(literal[i+2])
+                if !seen[g] {
+                    seen[g] = true
+                    grams = append(grams, g)
+                }
+            }
+        }
+        if len(grams) == 0 {
+            return nil, false
+        }
+        result = append(result, grams)
+    }
+    return result, len(result) > 0
+}
+
+//-------------------------------------------------------------------------
+// Search index (bloom filters)
+//-------------------------------------------------------------------------
+
+// The search index (DBPATH.bloom) splits an uncompressed dump into chunks of whole blocks and keeps a
+// bloom filter of the byte trigrams of each chunk's lower case text. -search matches keywords as
+// substrings, so trigrams (rather than words) keep the result exact: a chunk can only contain a
+// keyword if it contains all of its trigrams, and the other chunks are skipped without being read.
+// Keywords shorter than three bytes (or /regex/ keywords without such a literal part) cannot be
+// checked and make the search read the whole dump.
+// All integers are big endian:
+//
+//	header  "CWBF", version uint16, reserved uint16, dump size int64, dump modification time int64 (ns),
+//	        chunk count uint32
+//	chunks  chunk count × (dump offset int64, length uint32, filter bits uint32, filter bytes)
+const (
+    searchIndexMagic      = "CWBF"
+    searchIndexVersion    = 1
+    searchIndexHeaderSize = 28
+    searchChunkSize       = 256 << 10 // Dump bytes per chunk.
+    bloomBitsPerTrigram   = 8         // With bloomHashes, about 2% false positives per trigram.
+    bloomHashes           = 5
+)
+
+// searchIndexPath returns the location of the search index next to the dump it was built from.
+func searchIndexPath(dbPath string) string {
+    return dbPath + ".bloom"
+}
+
+// trigramSet collects the distinct trigrams of a chunk; every trigram is a 24-bit number,
+// so membership is a bit in a fixed 2 MB bitmap that is cleared through the list after each chunk.
+type trigramSet struct {
+    seen     []uint64
+    distinct []uint32
+}
+
+// newTrigramSet returns an empty trigram set.
+func newTrigramSet() *trigramSet {
+    return &trigramSet{seen: make([]uint64, 1<<24/64)}
+}
+
+// add records the trigrams of text.
+func (t *trigramSet) add(text string) {
+    for i := 0; i+3 <= len(text); i++ {
+        g := uint32(text[i])<<16 | uint32(text[i+1])<<8 | uint32(text[i+2])
+        if t.seen[g>>6]&(1<<(g&63)) == 0 {
+            t.seen[g>>6] |= 1 << (g & 63)
+            t.distinct = append(t.distinct, g)
+        }
+    }
+}
+
+// reset empties the set.
+func (t *trigramSet) reset() {
+    for _, g := range t.distinct {
+        t.seen[g>>6] &^= 1 << (g & 63)
+    }
+    t.distinct = t.distinct[:0]
+}
+
+// filter returns the bloom filter of the recorded trigrams and its size in bits.
+func (t *trigramSet) filter() ([]byte, uint32) {
+    bits := uint32(max(64, (len(t.distinct)*bloomBitsPerTrigram+63)/64*64))
+    filter := make([]byte, bits/8)
+    for _, g := range t.distinct {
+        h1, h2 := bloomHash(g)
+        for i := uint32(0); i < bloomHashes; i++ {
+            p := (h1 + i*h2) % bits
+            filter[p>>3] |= 1 << (p & 7)
+        }
+    }
+    return filter, bits
+}
+
+// bloomHash returns the two hashes of a trigram the filter positions are derived from.
+func bloomHash(g uint32) (uint32, uint32) {
+    h := uint64(g) * 0x9E3779B97F4A7C15
+    return uint32(h >> 32), uint32(h) | 1
+}
+
+// bloomContains reports whether a filter may contain all the given trigrams.
+func bloomContains(filter []byte, bits uint32, trigrams []uint32) bool {
+    for _, g := range trigrams {
+        h1, h2 := bloomHash(g)
+        for i := uint32(0); i < bloomHashes; i++ {
+            p := (h1 + i*h2) % bits
+            if filter[p>>3]&(1<<(p&7)) == 0 {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+// plainDatabase reports whether the dump is an uncompressed file, as only those can be read by offset.
+func plainDatabase(dbPath string) bool {
+    file, err := os.Open(dbPath)
+    if err != nil {
+        return false
+    }
+    defer file.Close()
+    magic := make([]byte, 3)
+    n, _ := io.ReadFull(file, magic)
+    magic = magic[:n]
+    return !bytes.HasPrefix(magic, []byte{0x1f, 0x8b}) && !bytes.Equal(magic, []byte("BZh"))
+}
+
+// scanSearchBlocks calls fn for the blocks of the dump that may match one of the keywords: only the
+// candidate chunks of the trigram index (if built with -search-index) or of an up to date search
+// index are read. Without a usable index the whole dump is scanned, and the search index is built
+// in the same pass (not in --low-memory mode).
+func scanSearchBlocks(dbPath string, matcher *searchMatcher, fn func(blockLines []string)) error {
+    trigrams, ok := matcher.requiredTrigrams()
+    if !ok || !plainDatabase(dbPath) {
+        return scanBlocks(dbPath, fn)
+    }
+    if used, err := scanTrigramIndex(dbPath, trigrams, fn); used {
+        return err
+    }
+    if used, err := scanSearchIndex(dbPath, trigrams, fn); used {
+        return err
+    }
+    if lowMemory {
+        return scanBlocks(dbPath, fn)
+    }
+    return buildSearchIndex(dbPath, fn)
+}
+
+// scanSearchIndex reads the candidate chunks of the search index; it returns false if the index is
+// missing, of another version or built from another version of the dump.
+func scanSearchIndex(dbPath string, trigrams [][]uint32, fn func(blockLines []string)) (bool, error) {
+    fi, err := os.Stat(dbPath)
+    if err != nil {
+        return false, nil
+    }
+    index, err := os.Open(searchIndexPath(dbPath))
+    if err != nil {
+        return false, nil
+    }
+    defer index.Close()
+    reader := bufio.NewReaderSize(index, 256*1024)
+    header := make([]byte, searchIndexHeaderSize)
+    if _, err := io.ReadFull(reader, header); err != nil ||
+        string(header[:4]) != searchIndexMagic || binary.BigEndian.Uint16(header[4:]) != searchIndexVersion ||
+        int64(binary.BigEndian.Uint64(header[8:])) != fi.Size() || int64(binary.BigEndian.Uint64(header[16:])) != fi.ModTime().UnixNano() {
+        return false, nil
+    }
+
+    dump, err := os.Open(dbPath)
+    if err != nil {
+        return true, err
+    }
+    defer dump.Close()
+
+    entry := make([]byte, 16)
+    var filter, chunk []byte
+    for n := binary.BigEndian.Uint32(header[24:]); n > 0; n-- {
+        if _, err := io.ReadFull(reader, entry); err != nil {
+            return true, fmt.Errorf("%s: %v", index.Name(), err)
+        }
+        offset := int64(binary.BigEndian.Uint64(entry))
+        length := binary.BigEndian.Uint32(entry[8:])
+        bits := binary.BigEndian.Uint32(entry[12:])
+        if bits == 0 || bits%64 != 0 {
+            return true, fmt.Errorf("%s: corrupt chunk", index.Name())
+        }
+        filter = slices.Grow(filter[:0], int(bits/8))[:bits/8]
+        if _, err := io.ReadFull(reader, filter); err != nil {
+            return true, fmt.Errorf("%s: %v", index.Name(), err)
+        }
+
+        candidate := false
+        for _, grams := range trigrams {
+            if bloomContains(filter, bits, grams) {
+                candidate = true
+                break
+            }
+        }
+        if !candidate {
+            continue
+        }
+
+        if chunk, err = scanChunk(dump, offset, length, chunk, fn); err != nil {
+            return true, err
+        }
+    }
+    return true, nil
+}
+
+// updateSearchIndex rebuilds the search index of an uncompressed dump.
+func updateSearchIndex(dbPath string) error {
+    if !plainDatabase(dbPath) {
+        return nil
+    }
+    return buildSearchIndex(dbPath, nil)
+}
+
+// buildSearchIndex scans the dump, calling fn (if not nil) for every block, and writes its search
+// index. The index is only an optimisation, so failing to write it is reported as a warning.
+func buildSearchIndex(dbPath string, fn func(blockLines []string)) error {
+    fi, err := os.Stat(dbPath)
+    if err != nil {
+        return err
+    }
+
+    // The index is written under a temporary name and renamed once complete; if it cannot be
+    // created, the blocks are still passed to fn.
+    path := searchIndexPath(dbPath)
+    tmp, tmpErr := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+    if tmpErr == nil {
+        defer os.Remove(tmp.Name())
+        defer tmp.Close()
+        tmpErr = tmp.Chmod(0644)
+    }
+    var out *bufio.Writer
+    if tmpErr == nil {
+        out = bufio.NewWriterSize(tmp, 256*1024)
+        header := []byte(searchIndexMagic)
+        header = binary.BigEndian.AppendUint16(header, searchIndexVersion)
+        header = binary.BigEndian.AppendUint16(header, 0)
+        header = binary.BigEndian.AppendUint64(header, uint64(fi.Size()))
+        header = binary.BigEndian.AppendUint64(header, uint64(fi.ModTime().UnixNano()))
+        header = binary.BigEndian.AppendUint32(header, 0) // Chunk count, filled in at the end.
+        _, tmpErr = out.Write(header)
+    }
+
+    var chunks uint32
+    err = scanDumpChunks(dbPath, searchChunkSize, fn, func(offset int64, length uint32, trigrams *trigramSet) {
+        if tmpErr != nil {
+            return
+        }
+        filter, bits := trigrams.filter()
+        entry := binary.BigEndian.AppendUint64(nil, uint64(offset))
+        entry = binary.BigEndian.AppendUint32(entry, length)
+        entry = binary.BigEndian.AppendUint32(entry, bits)
+        if _, tmpErr = out.Write(append(entry, filter...)); tmpErr == nil {
+            chunks++
+        }
+    })
+    if err != nil {
+        return err
+    }
+
+    if tmpErr == nil {
+        tmpErr = out.Flush()
+    }
+    if tmpErr == nil {
+        _, tmpErr = tmp.WriteAt(binary.BigEndian.AppendUint32(nil, chunks), 24)
+    }
+    if tmpErr == nil {
+        tmpErr = tmp.Close()
+    }
+    if tmpErr == nil {
+        tmpErr = os.Rename(tmp.Name(), path)
+    }
+    if tmpErr != nil {
+        warnf("Warning: could not write the search index %s: %v\n", path, tmpErr)
+    }
+    return nil
+}
+
+// scanDumpChunks reads an uncompressed dump block by block, calling fn (if not nil) for every block,
+// and splits it into chunks of whole blocks of at least chunkSize bytes; chunk receives the position
+// of each chunk in the dump and the trigrams of its lower case block text.
+func scanDumpChunks(dbPath string, chunkSize int64, fn func(blockLines []string), chunk func(offset int64, length uint32, trigrams *trigramSet)) error {
+    dump, err := os.Open(dbPath)
+    if err != nil {
+        return err
+    }
+    defer dump.Close()
+
+    trigrams := newTrigramSet()
+    var offset, chunkStart int64
+    endChunk := func() {
+        if offset > chunkStart {
+            chunk(chunkStart, uint32(offset-chunkStart), trigrams)
+        }
+        trigrams.reset()
+        chunkStart = offset
+    }
+
+    reader := bufio.NewReaderSize(dump, 256*1024)
+    var blockLines []string
+    endBlock := func() {
+        if len(blockLines) == 0 {
+            return
+        }
+        if fn != nil {
+            fn(blockLines)
+        }
+        // Blocks are matched as their lines joined by newlines, so the trigrams are taken the same way.
+        trigrams.add(strings.ToLower(strings.Join(blockLines, "\n")))
+        blockLines = nil
+        if offset-chunkStart >= chunkSize {
+            endChunk()
+        }
+    }
+    for {
+        line, err := reader.ReadString('\n')
+        offset += int64(len(line))
+        if text := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"); text != "" {
+            blockLines = append(blockLines, text)
+        } else if line != "" {
+            endBlock()
+        }
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+    }
+    endBlock()
+    endChunk()
+    return nil
+}
+
+// scanChunk reads a chunk of the dump into buf and calls fn for each of its blocks; it returns the
+// buffer for reuse.
+func scanChunk(dump *os.File, offset int64, length uint32, buf []byte, fn func(blockLines []string)) ([]byte, error) {
+    buf = slices.Grow(buf[:0], int(length))[:length]
+    if _, err := dump.ReadAt(buf, offset); err != nil {
+        return buf, err
+    }
+    scanner := bufio.NewScanner(bytes.NewReader(buf))
+    var blockLines []string
+    for scanner.Scan() {
+        if line := scanner.Text(); line != "" {
+            blockLines = append(blockLines, line)
+        } else if len(blockLines) > 0 {
+            fn(blockLines)
+            blockLines = nil
+        }
+    }
+    if len(blockLines) > 0 {
+        fn(blockLines)
+    }
+    return buf, scanner.Err()
+}
+
+//-------------------------------------------------------------------------
+// Trigram index
+//-------------------------------------------------------------------------
+
+// The trigram index (DBPATH.trigram) is an optional, finer search index built with -search-index:
+// for every trigram of the lower case dump text it lists the chunks of about 64 KB that contain it,
+// so -search reads only the chunks that contain all trigrams of a keyword (or of the literal parts
+// of a /regex/). Once built, it is rebuilt after every download and whenever the dump no longer has
+// the serial, size and modification time recorded in it. All integers are big endian:
+//
+//	header     "CWTI", version uint16, reserved uint16, dump size int64, dump modification time int64 (ns),
+//	           serial length uint16, serial, chunk count uint32, trigram count uint32
+//	chunks     chunk count × (dump offset int64, length uint32)
+//	trigrams   trigram count × (trigram uint32, postings offset uint64, chunk count uint32), by trigram
+//	postings   per trigram, the ascending chunk numbers as uvarint deltas
+const (
+    trigramIndexMagic    = "CWTI"
+    trigramIndexVersion  = 1
+    trigramChunkSize     = 64 << 10 // Dump bytes per chunk.
+    trigramPostingsPass  = 32 << 20 // Postings inverted in memory at a time (128 MB).
+    trigramDirectorySize = 16       // Bytes per trigram entry.
+)
+
+// trigramIndexPath returns the location of the trigram index next to the dump it was built from.
+func trigramIndexPath(dbPath string) string {
+    return dbPath + ".trigram"
+}
+
+// trigramIndex is an open trigram index.
+type trigramIndex struct {
+    file      *os.File
+    chunks    []trigramChunk
+    directory int64  // File offset of the trigram entries.
+    trigrams  uint32 // Number of trigram entries.
+    postings  int64  // File offset of the postings.
+}
+
+// trigramChunk is the position of a chunk in the dump.
+type trigramChunk struct {
+    offset int64
+    length uint32
+}
+
+// trigramIndexHeader returns the header identifying the dump as it is now.
+func trigramIndexHeader(fi os.FileInfo) []byte {
+    header := []byte(trigramIndexMagic)
+    header = binary.BigEndian.AppendUint16(header, trigramIndexVersion)
+    header = binary.BigEndian.AppendUint16(header, 0)
+    header = binary.BigEndian.AppendUint64(header, uint64(fi.Size()))
+    header = binary.BigEndian.AppendUint64(header, uint64(fi.ModTime().UnixNano()))
+    serial := loadDatabaseInfo().Serial
+    header = binary.BigEndian.AppendUint16(header, uint16(len(serial)))
+    return append(header, serial...)
+}
+
+// openTrigramIndex opens the trigram index of dbPath; it fails if there is none or it is out of date.
+func openTrigramIndex(dbPath string) (*trigramIndex, error) {
+    fi, err := os.Stat(dbPath)
+    if err != nil {
+        return nil, err
+    }
+    file, err := os.Open(trigramIndexPath(dbPath))
+    if err != nil {
+        return nil, err
+    }
+    idx := &trigramIndex{file: file}
+    if err := idx.readHeader(trigramIndexHeader(fi)); err != nil {
+        file.Close()
+        return nil, err
+    }
+    return idx, nil
+}
+
+// readHeader checks the header against the expected one and reads the chunk table.
+func (idx *trigramIndex) readHeader(expected []byte) error {
+    reader := bufio.NewReaderSize(idx.file, 256*1024)
+    header := make([]byte, len(expected)+8)
+    if _, err := io.ReadFull(reader, header); err != nil {
+        return err
+    }
+    if !bytes.Equal(header[:len(expected)], expected) {
+        return fmt.Errorf("%s: built from another version of the dump", idx.file.Name())
+    }
+    count := binary.BigEndian.Uint32(header[len(expected):])
+    idx.trigrams = binary.BigEndian.Uint32(header[len(expected)+4:])
+    idx.directory = int64(len(header)) + int64(count)*12
+    idx.postings = idx.directory + int64(idx.trigrams)*trigramDirectorySize
+
+    // A truncated index is rebuilt rather than read up to the point where it breaks off; checking the
+    // size first also keeps a damaged chunk count from being allocated.
+    fi, err := idx.file.Stat()
+    if err != nil {
+        return err
+    }
+    if fi.Size() < idx.postings {
+        return fmt.Errorf("%s: truncated trigram index", idx.file.Name())
+    }
+
+    idx.chunks = make([]trigramChunk, count)
+    entry := make([]byte, 12)
+    for i := range idx.chunks {
+        if _, err := io.ReadFull(reader, entry); err != nil {
+            return err
+        }
+        idx.chunks[i] = trigramChunk{int64(binary.BigEndian.Uint64(entry)), binary.BigEndian.Uint32(entry[8:])}
+    }
+    return nil
+}
+
+// chunksWith returns the ascending numbers of the chunks that contain the trigram.
+func (idx *trigramIndex) chunksWith(g uint32) ([]uint32, error) {
+    entry := make([]byte, trigramDirectorySize)
+    var readErr error
+    i := sort.Search(int(idx.trigrams), func(i int) bool {
+        if _, err := idx.file.ReadAt(entry, idx.directory+int64(i)*trigramDirectorySize); err != nil {
+            readErr = err
+            return true
+        }
+        return binary.BigEndian.Uint32(entry) >= g
+    })
+    if readErr != nil {
+        return nil, readErr
+    }
+    if i == int(idx.trigrams) {
+        return nil, nil
+    }
+    if _, err := idx.file.ReadAt(entry, idx.directory+int64(i)*trigramDirectorySize); err != nil {
+        return nil, err
+    }
+    if binary.BigEndian.Uint32(entry) != g {
+        return nil, nil
+    }
+
+    count := binary.BigEndian.Uint32(entry[12:])
+    if uint64(count) > uint64(len(idx.chunks)) {
+        return nil, fmt.Errorf("%s: corrupt postings", idx.file.Name())
+    }
+    reader := bufio.NewReader(io.NewSectionReader(idx.file, idx.postings+int64(binary.BigEndian.Uint64(entry[4:])), int64(count)*binary.MaxVarintLen32))
+    chunks := make([]uint32, count)
+    var chunk uint64
+    for i := range chunks {
+        delta, err := binary.ReadUvarint(reader)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %v", idx.file.Name(), err)
+        }
+        chunk += delta
+        if chunk >= uint64(len(idx.chunks)) {
+            return nil, fmt.Errorf("%s: corrupt postings", idx.file.Name())
+        }
+        chunks[i] = uint32(chunk)
+    }
+    return chunks, nil
+}
+
+// candidates returns the ascending numbers of the chunks that contain all trigrams of at least one keyword.
+func (idx *trigramIndex) candidates(trigrams [][]uint32) ([]uint32, error) {
+    var all []uint32
+    for _, grams := range trigrams {
+        var matching []uint32
+        for i, g := range grams {
+            chunks, err := idx.chunksWith(g)
+            if err != nil {
+                return nil, err
+            }
+            if i == 0 {
+                matching = chunks
+            } else {
+                matching = intersectSorted(matching, chunks)
+            }
+            if len(matching) == 0 {
+                break
+            }
+        }
+        all = append(all, matching...)
+    }
+    slices.Sort(all)
+    return slices.Compact(all), nil
+}
+
+// intersectSorted returns the values present in both ascending lists.
+func intersectSorted(a, b []uint32) []uint32 {
+    var result []uint32
+    for i, j := 0, 0; i < len(a) && j < len(b); {
+        switch {
+        case a[i] < b[j]:
+            i++
+        case a[i] > b[j]:
+            j++
+        default:
+            result = append(result, a[i])
+            i++
+            j++
+        }
+    }
+    return result
+}
+
+// scanTrigramIndex reads the candidate chunks of the trigram index. It returns false if there is no
+// trigram index; an out of date one is rebuilt first.
+func scanTrigramIndex(dbPath string, trigrams [][]uint32, fn func(blockLines []string)) (bool, error) {
+    if _, err := os.Stat(trigramIndexPath(dbPath)); err != nil {
+        return false, nil
+    }
+    idx, err := openTrigramIndex(dbPath)
+    if err != nil {
+        statusln("The database has changed, rebuilding the trigram index...")
+        if err := buildTrigramIndex(dbPath); err != nil {
+            warnf("Warning: could not write the trigram index %s: %v\n", trigramIndexPath(dbPath), err)
+            return false, nil
+        }
+        if idx, err = openTrigramIndex(dbPath); err != nil {
+            return false, nil
+        }
+    }
+    defer idx.file.Close()
+
+    candidates, err := idx.candidates(trigrams)
+    if err != nil {
+        return true, err
+    }
+    dump, err := os.Open(dbPath)
+    if err != nil {
+        return true, err
+    }
+    defer dump.Close()
+    var buf []byte
+    for _, c := range candidates {
+        if buf, err = scanChunk(dump, idx.chunks[c].offset, idx.chunks[c].length, buf, fn); err != nil {
+            return true, err
+        }
+    }
+    return true, nil
+}
+
+// updateTrigramIndex rebuilds the trigram index of dbPath if one was built with -search-index.
+func updateTrigramIndex(dbPath string) error {
+    if _, err := os.Stat(trigramIndexPath(dbPath)); err != nil {
+        return nil
+    }
+    return buildTrigramIndex(dbPath)
+}
+
+// buildTrigramIndex writes the trigram index of an uncompressed dump. The trigrams of every chunk are
+// first written to a temporary file in dump order; it is then inverted in as many passes as needed
+// to keep at most trigramPostingsPass postings in memory.
+func buildTrigramIndex(dbPath string) error {
+    if !plainDatabase(dbPath) {
+        return fmt.Errorf("%s: the trigram index needs an uncompressed database", dbPath)
+    }
+    fi, err := os.Stat(dbPath)
+    if err != nil {
+        return err
+    }
+    path := trigramIndexPath(dbPath)
+    dir, base := filepath.Dir(path), filepath.Base(path)
+
+    forward, err := os.CreateTemp(dir, base+".fwd-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(forward.Name())
+    defer forward.Close()
+
+    var chunks []trigramChunk
+    var total int64
+    var writeErr error
+    out := bufio.NewWriterSize(forward, 256*1024)
+    err = scanDumpChunks(dbPath, trigramChunkSize, nil, func(offset int64, length uint32, trigrams *trigramSet) {
+        chunks = append(chunks, trigramChunk{offset, length})
+        total += int64(len(trigrams.distinct))
+        record := binary.BigEndian.AppendUint32(nil, uint32(len(trigrams.distinct)))
+        for _, g := range trigrams.distinct {
+            record = append(record, byte(g>>16), byte(g>>8), byte(g))
+        }
+        if writeErr == nil {
+            _, writeErr = out.Write(record)
+        }
+    })
+    if err == nil {
+        err = writeErr
+    }
+    if err == nil {
+        err = out.Flush()
+    }
+    if err != nil {
+        return err
+    }
+
+    postings, err := os.CreateTemp(dir, base+".post-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(postings.Name())
+    defer postings.Close()
+
+    type directoryEntry struct {
+        trigram uint32
+        offset  uint64
+        count   uint32
+    }
+    var directory []directoryEntry
+    var written uint64
+    postingsOut := bufio.NewWriterSize(postings, 256*1024)
+    passes := uint32(max(1, (total+trigramPostingsPass-1)/trigramPostingsPass))
+    for pass := uint32(0); pass < passes; pass++ {
+        lists := make(map[uint32][]uint32)
+        if _, err := forward.Seek(0, io.SeekStart); err != nil {
+            return err
+        }
+        reader := bufio.NewReaderSize(forward, 256*1024)
+        record := make([]byte, 4)
+        for c := range chunks {
+            if _, err := io.ReadFull(reader, record[:4]); err != nil {
+                return err
+            }
+            n := int(binary.BigEndian.Uint32(record))
+            record = slices.Grow(record[:0], 3*n)[:3*n]
+            if _, err := io.ReadFull(reader, record); err != nil {
+                return err
+            }
+            for i := 0; i < n; i++ {
+                g := uint32(record[3*i])<<16 | uint32(record[3*i+1])<<8 | uint32(record[3*i+2])
+                if g%passes == pass {
+                    lists[g] = append(lists[g], uint32(c))
+                }
+            }
+            record = record[:4]
+        }
+
+        grams := make([]uint32, 0, len(lists))
+        for g := range lists {
+            grams = append(grams, g)
+        }
+        slices.Sort(grams)
+        var encoded []byte
+        for _, g := range grams {
+            encoded = encoded[:0]
+            previous := uint32(0)
+            for _, c := range lists[g] {
+                encoded = binary.AppendUvarint(encoded, uint64(c-previous))
+                previous = c
+            }
+            if _, err := postingsOut.Write(encoded); err != nil {
+                return err
+            }
+            directory = append(directory, directoryEntry{g, written, uint32(len(lists[g]))})
+            written += uint64(len(encoded))
+        }
+    }
+    if err := postingsOut.Flush(); err != nil {
+        return err
+    }
+    sort.Slice(directory, func(i, j int) bool { return directory[i].trigram < directory[j].trigram })
+
+    tmp, err := os.CreateTemp(dir, base+".tmp-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    defer tmp.Close()
+    if err := tmp.Chmod(0644); err != nil {
+        return err
+    }
+    final := bufio.NewWriterSize(tmp, 256*1024)
+    header := trigramIndexHeader(fi)
+    header = binary.BigEndian.AppendUint32(header, uint32(len(chunks)))
+    header = binary.BigEndian.AppendUint32(header, uint32(len(directory)))
+    if _, err := final.Write(header); err != nil {
+        return err
+    }
+    for _, c := range chunks {
+        entry := binary.BigEndian.AppendUint64(nil, uint64(c.offset))
+        if _, err := final.Write(binary.BigEndian.AppendUint32(entry, c.length)); err != nil {
+            return err
+        }
+    }
+    for _, d := range directory {
+        entry := binary.BigEndian.AppendUint32(nil, d.trigram)
+        entry = binary.BigEndian.AppendUint64(entry, d.offset)
+        if _, err := final.Write(binary.BigEndian.AppendUint32(entry, d.count)); err != nil {
+            return err
+        }
+    }
+    if _, err := postings.Seek(0, io.SeekStart); err != nil {
+        return err
+    }
+    if _, err := io.Copy(final, postings); err != nil {
+        return err
+    }
+    if err := final.Flush(); err != nil {
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+// runSearchIndex builds (or with remove, deletes) the trigram index of the database for -search-index.
+func runSearchIndex(remove bool) {
+    path := trigramIndexPath(ripedbPath)
+    if remove {
+        if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+            errorf("Error removing the trigram index: %v\n", err)
+            return
+        }
+        statusf("Trigram index removed: %s\n", path)
+        return
+    }
+    if !ensureRIPEdb() {
+        return
+    }
+    started := time.Now()
+    statusf("Building the trigram index %s\n", path)
+    if err := buildTrigramIndex(ripedbPath); err != nil {
+        errorf("Error building the trigram index: %v\n", err)
+        return
+    }
+    size := int64(0)
+    if fi, err := os.Stat(path); err == nil {
+        size = fi.Size()
+    }
+    statusf("Trigram index created at: %s (%d bytes, %s)\n", path, size, time.Since(started).Round(time.Millisecond))
+}
+
+//-------------------------------------------------------------------------
+// In-memory IP-to-country index
+//-------------------------------------------------------------------------
+
+// ipSegment is a non-overlapping IPv4 interval labelled with the most specific inetnum that covers it.
+type ipSegment struct {
+    Start      uint32 // First address of the segment.
+    End        uint32 // Last address of the segment.
+    RangeStart uint32 // First address of the inetnum the segment belongs to.
+    RangeEnd   uint32 // Last address of the inetnum the segment belongs to.
+    Country    string // Country code of the inetnum (may be empty).
+    Netname    string // Netname of the inetnum (may be empty).
+}
+
+// ipIndex is a sorted list of non-overlapping segments used for fast IP lookups.
+type ipIndex struct {
+    segments []ipSegment
+}
+
+// buildIPIndex scans the RIPE DB and flattens the nested inetnum hierarchy into
+// non-overlapping segments, so every address resolves to its most specific block.
+func buildIPIndex(dbPath string) (*ipIndex, error) {
+    var ranges []ipSegment
+    countries := make(map[string]string)
+
+    err := scanRanges(dbPath, true, func(r dbRange) {
+        country := r.Country
+        if interned, found := countries[country]; found {
+            country = interned
+        } else {
+            country = strings.Clone(country)
+            countries[country] = country
+        }
+        ranges = append(ranges, ipSegment{
+            RangeStart: r.Start,
+            RangeEnd:   r.End,
+            Country:    country,
+            Netname:    strings.Clone(r.Netname),
+        })
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    // Parents come before their children: ascending start, then descending end.
+    sort.Slice(ranges, func(i, j int) bool {
+        if ranges[i].RangeStart != ranges[j].RangeStart {
+            return ranges[i].RangeStart < ranges[j].RangeStart
+        }
+        return ranges[i].RangeEnd > ranges[j].RangeEnd
+    })
+
+    idx := &ipIndex{}
+    var stack []ipSegment
+    var pos uint64 // Next address not yet emitted (uint64 so that 255.255.255.255 + 1 does not wrap).
+
+    // emit appends the part of [pos, end] still uncovered, labelled with the given block.
+    emit := func(block ipSegment, end uint32) {
+        if pos > uint64(end) {
+            return
+        }
+        seg := block
+        seg.Start = uint32(pos)
+        seg.End = end
+        idx.segments = append(idx.segments, seg)
+        pos = uint64(end) + 1
+    }
+
+    for _, r := range ranges {
+        // Close every open block that ends before the new one starts.
+        for len(stack) > 0 && stack[len(stack)-1].RangeEnd < r.RangeStart {
+            emit(stack[len(stack)-1], stack[len(stack)-1].RangeEnd)
+            stack = stack[:len(stack)-1]
+        }
+        // The gap between the previous position and the new block belongs to the enclosing block.
+        if len(stack) > 0 && r.RangeStart > 0 {
+            emit(stack[len(stack)-1], r.RangeStart-1)
+        }
+        if pos < uint64(r.RangeStart) {
+            pos = uint64(r.RangeStart)
+        }
+        stack = append(stack, r)
+    }
+    for len(stack) > 0 {
+        emit(stack[len(stack)-1], stack[len(stack)-1].RangeEnd)
+        stack = stack[:len(stack)-1]
+    }
+    return idx, nil
+}
+
+// lookup returns the segment that contains the given IPv4 address.
+func (idx *ipIndex) lookup(ip net.IP) (ipSegment, bool) {
+    ip4 := ip.To4()
+    if ip4 == nil || idx == nil {
+        return ipSegment{}, false
+    }
+    n := binary.BigEndian.Uint32(ip4)
+    i := sort.Search(len(idx.segments), func(i int) bool {
+        return idx.segments[i].End >= n
+    })
+    if i < len(idx.segments) && idx.segments[i].Start <= n {
+        return idx.segments[i], true
+    }
+    return ipSegment{}, false
+}
+
+//-------------------------------------------------------------------------
+// Web access log country summary
+//-------------------------------------------------------------------------
+
+// countryTraffic accumulates access log statistics for one country.
+type countryTraffic struct {
+    Country string
+    Hits    int64
+    Bytes   int64
+    Clients map[string]struct{}
+}
+
+// parseAccessLogLine extracts the client IP and response size from a common/combined log format line.
+func parseAccessLogLine(line string) (net.IP, int64, bool) {
+    fields := strings.Fields(line)
+    if len(fields) == 0 {
+        return nil, 0, false
+    }
+    ip := net.ParseIP(fields[0])
+    if ip == nil {
+        return nil, 0, false
+    }
+
+    // The status code and the byte count follow the quoted request string.
+    var bytesSent int64
+    if open := strings.Index(line, "\""); open >= 0 {
+        if closing := strings.Index(line[open+1:], "\""); closing >= 0 {
+            rest := strings.Fields(line[open+1+closing+1:])
+            if len(rest) >= 2 {
+                fmt.Sscanf(rest[1], "%d", &bytesSent)
+            }
+        }
+    }
+    return ip, bytesSent, true
+}
+
+// summarizeAccessLog reads an access log, resolves client IPs against the RIPE DB cache
+// and prints hits, bytes and unique clients per country.
+func summarizeAccessLog(r io.Reader) {
+    statusln("Building IP index from the RIPE database...")
+    idx, err := buildIPIndex(ripedbPath)
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+
+    stats := make(map[string]*countryTraffic)
+    var totalHits, totalBytes, skipped int64
+
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    for scanner.Scan() {
+        ip, bytesSent, ok := parseAccessLogLine(scanner.Text())
+        if !ok {
+            skipped++
+            continue
+        }
+
+        // Addresses outside the RIPE NCC region (or without a country) are grouped as "??".
+        country := "??"
+        if seg, found := idx.lookup(ip); found && seg.Country != "" {
+            country = seg.Country
+        }
+
+        ct, exists := stats[country]
+        if !exists {
+            ct = &countryTraffic{Country: country, Clients: make(map[string]struct{})}
+            stats[country] = ct
+        }
+        ct.Hits++
+        ct.Bytes += bytesSent
+        ct.Clients[ip.String()] = struct{}{}
+        totalHits++
+        totalBytes += bytesSent
+    }
+    if err := scanner.Err(); err != nil {
+        errorln("Error reading access log:", err)
+        return
+    }
+    if totalHits == 0 {
+        errorln("No parsable access log lines found on stdin.")
+        return
+    }
+
+    var list []*countryTraffic
+    for _, ct := range stats {
+        list = append(list, ct)
+    }
+    // Busiest countries first; ties are broken by country code for stable output.
+    sort.Slice(list, func(i, j int) bool {
+        if list[i].Hits != list[j].Hits {
+            return list[i].Hits > list[j].Hits
+        }
+        return list[i].Country < list[j].Country
+    })
+
+    fmt.Printf("%-8s %12s %8s %16s %8s %10s\n", "Country", "Hits", "Hits%", "Bytes", "Bytes%", "Clients")
+    for _, ct := range list {
+        bytesPercent := 0.0
+        if totalBytes > 0 {
+            bytesPercent = float64(ct.Bytes) / float64(totalBytes) * 100
+        }
+        fmt.Printf("%-8s %12d %7.2f%% %16s %7.2f%% %10d\n",
+            ct.Country, ct.Hits, float64(ct.Hits)/float64(totalHits)*100,
+            humanBytes(ct.Bytes), bytesPercent, len(ct.Clients))
+    }
+    fmt.Printf("Total: %d hits, %s", totalHits, humanBytes(totalBytes))
+    if skipped > 0 {
+        fmt.Printf(", %d unparsable lines skipped", skipped)
+    }
+    fmt.Println()
+}
+
+// humanBytes formats a byte count using binary units (KiB, MiB, ...).
+func humanBytes(n int64) string {
+    const unit = 1024
+    if n < unit {
+        return fmt.Sprintf("%d B", n)
+    }
+    div, exp := int64(unit), 0
+    for m := n / unit; m >= unit; m /= unit {
+        div *= unit
+        exp++
+    }
+    return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+//-------------------------------------------------------------------------
+// CrowdSec decision import export
+//-------------------------------------------------------------------------
+
+// crowdSecDecision is one entry of a "cscli decisions import" JSON file.
+type crowdSecDecision struct {
+    Duration string `json:"duration"`
+    Origin   string `json:"origin"`
+    Reason   string `json:"reason"`
+    Scope    string `json:"scope"`
+    Type     string `json:"type"`
+    Value    string `json:"value"`
+}
+
+// buildCrowdSecDecisions turns a CIDR list into range-scoped CrowdSec decisions.
+func buildCrowdSecDecisions(countryCode string, cidrs []string, duration, decisionType string) []crowdSecDecision {
+    reason := fmt.Sprintf("chicha-whois country %s (RIPE NCC)", strings.ToUpper(countryCode))
+    var decisions []crowdSecDecision
+    for _, cidr := range cidrs {
+        decisions = append(decisions, crowdSecDecision{
+            Duration: duration,
+            Origin:   "cscli",
+            Reason:   reason,
+            Scope:    "range",
+            Type:     decisionType,
+            Value:    cidr,
+        })
+    }
+    return decisions
+}
+
+// renderCrowdSecJSON renders decisions in the JSON layout accepted by "cscli decisions import".
+func renderCrowdSecJSON(decisions []crowdSecDecision) (string, error) {
+    data, err := json.MarshalIndent(decisions, "", "  ")
+    if err != nil {
+        return "", err
+    }
+    return string(data) + "\n", nil
+}
+
+// renderCrowdSecCSV renders decisions in the CSV layout accepted by "cscli decisions import --format csv".
+func renderCrowdSecCSV(decisions []crowdSecDecision) (string, error) {
+    var buf bytes.Buffer
+    w := csv.NewWriter(&buf)
+    if err := w.Write([]string{"duration", "origin", "reason", "scope", "type", "value"}); err != nil {
+        return "", err
+    }
+    for _, d := range decisions {
+        if err := w.Write([]string{d.Duration, d.Origin, d.Reason, d.Scope, d.Type, d.Value}); err != nil {
+            return "", err
+        }
+    }
+    w.Flush()
+    return buf.String(), w.Error()
+}
+
+// createCrowdSecDecisions writes a CrowdSec decision import file with the filtered ranges of a country.
+func createCrowdSecDecisions(countryCode, format, duration, decisionType string) {
+    statusf("Creating CrowdSec decisions (%s) for country code: %s\n", format, countryCode)
+
+    ipRanges := extractCountryCIDRs(countryCode, ripedbPath, false)
+    if len(ipRanges) == 0 {
+        warnf("No IP ranges found for country code: %s\n", countryCode)
+        return
+    }
+
+    ipRanges, summary := preparePrefixes(strings.ToUpper(countryCode), ipRanges, true)
+    printPrefixSummary(summary)
+
+    decisions := buildCrowdSecDecisions(countryCode, ipRanges, duration, decisionType)
+
+    var content string
+    var err error
+    if format == "csv" {
+        content, err = renderCrowdSecCSV(decisions)
+    } else {
+        content, err = renderCrowdSecJSON(decisions)
+    }
+    if err != nil {
+        errorf("Error rendering CrowdSec decisions: %v\n", err)
+        return
+    }
+
+    homeDir, _ := os.UserHomeDir()
+    outFilePath := filepath.Join(homeDir, fmt.Sprintf("crowdsec_%s.%s", strings.ToUpper(countryCode), format))
+    meta := outputMeta{Selection: "country " + strings.ToUpper(countryCode) + " (filtered)", Entries: len(decisions)}
+    if err := writeOutputFile(outFilePath, content, meta); err != nil {
+        errorf("Error writing CrowdSec decisions file: %v\n", err)
+        return
+    }
+    reportCountry(countryCode, len(decisions))
+    statusf("CrowdSec decisions file created at: %s (%d decisions)\n", outFilePath, len(decisions))
+    statusf("Import it with: cscli decisions import -i %s --format %s\n", outFilePath, format)
+}
+
+//-------------------------------------------------------------------------
+// PeerGuardian / P2P blocklist export
+//-------------------------------------------------------------------------
+
+// inetnumRange is an original start-end range as it appears in an inetnum block.
+type inetnumRange struct {
+    Start   uint32
+    End     uint32
+    Netname string
+}
+
+// extractCountryRanges returns the original inetnum ranges (without CIDR conversion) for a country code.
+func extractCountryRanges(countryCode, dbPath string) ([]inetnumRange, error) {
+    countryCode = strings.ToUpper(countryCode)
+    var ranges []inetnumRange
+    if len(sources) > 0 && dbPath == ripedbPath {
+        if _, _, err := mergedView(); err != nil {
+            return nil, err
+        }
+        for _, e := range selectionSegments(mergedCountrySegments(), countryCode) {
+            ranges = append(ranges, inetnumRange{Start: e.Start, End: e.End, Netname: e.Source})
+        }
+        return ranges, nil
+    }
+    err := scanRanges(dbPath, true, func(r dbRange) {
+        if !inCountrySelection(countryCode, r.Country) {
+            return
+        }
+        ranges = append(ranges, inetnumRange{Start: r.Start, End: r.End, Netname: r.Netname})
+    })
+    return ranges, err
+}
+
+// renderP2P renders ranges in the PeerGuardian text format: "Description:start-end", one per line.
+func renderP2P(description string, ranges []inetnumRange) string {
+    // The colon separates the description from the range, so it must not appear inside the description.
+    description = strings.ReplaceAll(description, ":", " ")
+
+    var sb strings.Builder
+    for _, r := range ranges {
+        fmt.Fprintf(&sb, "%s:%s-%s\n", description, uint32ToIP(r.Start), uint32ToIP(r.End))
+    }
+    return sb.String()
+}
+
+// createP2PBlocklist writes a PeerGuardian (.p2p) blocklist for the given country code.
+func createP2PBlocklist(countryCode string) {
+    statusf("Creating P2P blocklist for country code: %s\n", countryCode)
+
+    ranges, err := extractCountryRanges(countryCode, ripedbPath)
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+    if len(ranges) == 0 {
+        warnf("No IP ranges found for country code: %s\n", countryCode)
+        return
+    }
+
+    // Sort by start address and drop exact duplicates; nested ranges are kept as registered.
+    sort.Slice(ranges, func(i, j int) bool {
+        if ranges[i].Start != ranges[j].Start {
+            return ranges[i].Start < ranges[j].Start
+        }
+        return ranges[i].End < ranges[j].End
+    })
+    var unique []inetnumRange
+    for i, r := range ranges {
+        if i > 0 && r.Start == ranges[i-1].Start && r.End == ranges[i-1].End {
+            continue
+        }
+        unique = append(unique, r)
+    }
+
+    homeDir, _ := os.UserHomeDir()
+    outFilePath := filepath.Join(homeDir, fmt.Sprintf("ripe_%s.p2p", strings.ToUpper(countryCode)))
+
+    content := renderP2P(countryName(countryCode), unique)
+    meta := outputMeta{Selection: "country " + strings.ToUpper(countryCode), Entries: len(unique), Comment: "#"}
+    if err := writeOutputFile(outFilePath, content, meta); err != nil {
+        errorf("Error writing P2P blocklist file: %v\n", err)
+        return
+    }
+    reportCountry(countryCode, len(unique))
+    statusf("P2P blocklist file created at: %s (%d ranges)\n", outFilePath, len(unique))
+}
+
+//-------------------------------------------------------------------------
+// FireHOL netset export
+//-------------------------------------------------------------------------
+
+// renderFireHOLNetset renders a FireHOL-style .netset file: a descriptive comment header followed by one CIDR per line.
+func renderFireHOLNetset(countryCode string, cidrs []string, sourceDate, fileDate time.Time) string {
+    countryCode = strings.ToUpper(countryCode)
+    setName := "ripe_country_" + strings.ToLower(countryCode)
+
+    var uniqueIPs uint64
+    for _, cidr := range cidrs {
+        uniqueIPs += cidrAddressCount(cidr)
+    }
+
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "#\n# %s\n#\n# ipv4 hash:net ipset\n#\n", setName)
+    fmt.Fprintf(&sb, "# IPv4 ranges registered to %s (%s) in the RIPE NCC database\n#\n", countryName(countryCode), countryCode)
+    fmt.Fprintf(&sb, "# Maintainer      : chicha-whois %s\n", version)
+    fmt.Fprintf(&sb, "# Maintainer URL  : https://github.com/matveynator/chicha-whois\n")
+    fmt.Fprintf(&sb, "# List source URL : %s\n", ripeDBURL)
+    fmt.Fprintf(&sb, "#\n")
+    if !reproducible {
+        fmt.Fprintf(&sb, "# Source File Date: %s\n", sourceDate.UTC().Format(time.RFC1123))
+        fmt.Fprintf(&sb, "# This File Date  : %s\n", fileDate.UTC().Format(time.RFC1123))
+        fmt.Fprintf(&sb, "#\n")
+    }
+    fmt.Fprintf(&sb, "# This File has %d entries matching %d unique IPs\n#\n", len(cidrs), uniqueIPs)
+    for _, cidr := range cidrs {
+        sb.WriteString(cidr)
+        sb.WriteString("\n")
+    }
+    return sb.String()
+}
+
+// createFireHOLNetset writes a FireHOL-compatible .netset file with the filtered ranges of a country.
+func createFireHOLNetset(countryCode string) {
+    statusf("Creating FireHOL netset for country code: %s\n", countryCode)
+
+    ipRanges := extractCountryCIDRs(countryCode, ripedbPath, false)
+    if len(ipRanges) == 0 {
+        warnf("No IP ranges found for country code: %s\n", countryCode)
+        return
+    }
+
+    ipRanges, summary := preparePrefixes(strings.ToUpper(countryCode), ipRanges, true)
+    printPrefixSummary(summary)
+
+    // The cache file modification time is the best available "source date".
+    sourceDate := time.Now()
+    if fi, err := os.Stat(ripedbPath); err == nil {
+        sourceDate = fi.ModTime()
+    }
+
+    homeDir, _ := os.UserHomeDir()
+    outFilePath := filepath.Join(homeDir, fmt.Sprintf("ripe_country_%s.netset", strings.ToLower(countryCode)))
+
+    content := renderFireHOLNetset(countryCode, ipRanges, sourceDate, time.Now())
+    meta := outputMeta{Selection: "country " + strings.ToUpper(countryCode) + " (filtered)", Entries: len(ipRanges), Comment: "#"}
+    if err := writeOutputFile(outFilePath, content, meta); err != nil {
+        errorf("Error writing FireHOL netset file: %v\n", err)
+        return
+    }
+    reportCountry(countryCode, len(ipRanges))
+    statusf("FireHOL netset file created at: %s (%d entries)\n", outFilePath, len(ipRanges))
+}
+
+//-------------------------------------------------------------------------
+// Redis export
+//-------------------------------------------------------------------------
+
+// redisExportOptions holds connection and layout settings for -redis.
+type redisExportOptions struct {
+    Addr     string // Redis server address (host:port).
+    Password string // Optional AUTH password.
+    DB       string // Optional database number for SELECT.
+    Key      string // Target key; derived from the country code when empty.
+    Ranges   bool   // Store a sorted set keyed by the last address instead of a plain set.
+}
+
+// redisTimeout bounds each exchange with the server: a command (or pipeline) and its replies.
+const redisTimeout = 60 * time.Second
+
+// redisConn is a minimal RESP client, just enough to load sets without external dependencies.
+type redisConn struct {
+    conn net.Conn
+    r    *bufio.Reader
+    w    *bufio.Writer
+}
+
+// dialRedis connects to a Redis server.
+func dialRedis(addr string) (*redisConn, error) {
+    conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+    if err != nil {
+        return nil, err
+    }
+    return &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+// Close closes the underlying connection.
+func (c *redisConn) Close() error {
+    return c.conn.Close()
+}
+
+// send buffers one command encoded as a RESP array of bulk strings.
+func (c *redisConn) send(args ...string) error {
+    if _, err := fmt.Fprintf(c.w, "*%d\r\n", len(args)); err != nil {
+        return err
+    }
+    for _, arg := range args {
+        if _, err := fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// flush transmits the buffered commands and gives them, and the replies to them, redisTimeout to
+// complete, so that a stalled server does not hang the export.
+func (c *redisConn) flush() error {
+    if err := c.conn.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+        return err
+    }
+    return c.w.Flush()
+}
+
+// readReply reads one RESP reply; server errors ("-ERR ...") are returned as Go errors.
+func (c *redisConn) readReply() (interface{}, error) {
+    line, err := c.r.ReadString('\n')
+    if err != nil {
+        return nil, err
+    }
+    line = strings.TrimRight(line, "\r\n")
+    if line == "" {
+        return nil, fmt.Errorf("empty reply from redis")
+    }
+
+    switch line[0] {
+    case '+':
+        return line[1:], nil
+    case '-':
+        return nil, fmt.Errorf("redis: %s", line[1:])
+    case ':':
+        var n int64
+        fmt.Sscanf(line[1:], "%d", &n)
+        return n, nil
+    case '$':
+        var size int
+        fmt.Sscanf(line[1:], "%d", &size)
+        if size < 0 {
+            return nil, nil
+        }
+        buf := make([]byte, size+2)
+        if _, err := io.ReadFull(c.r, buf); err != nil {
+            return nil, err
+        }
+        return string(buf[:size]), nil
+    case '*':
+        var count int
+        fmt.Sscanf(line[1:], "%d", &count)
+        if count < 0 {
+            return nil, nil
+        }
+        items := make([]interface{}, 0, count)
+        for i := 0; i < count; i++ {
+            item, err := c.readReply()
+            if err != nil {
+                return nil, err
+            }
+            items = append(items, item)
+        }
+        return items, nil
+    }
+    return nil, fmt.Errorf("unexpected redis reply: %q", line)
+}
+
+// do sends a single command and waits for its reply.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+    if err := c.send(args...); err != nil {
+        return nil, err
+    }
+    if err := c.flush(); err != nil {
+        return nil, err
+    }
+    return c.readReply()
+}
+
+// pipeline sends several commands at once and checks every reply for errors.
+func (c *redisConn) pipeline(commands [][]string) error {
+    for _, args := range commands {
+        if err := c.send(args...); err != nil {
+            return err
+        }
+    }
+    if err := c.flush(); err != nil {
+        return err
+    }
+    var firstErr error
+    for range commands {
+        if _, err := c.readReply(); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    return firstErr
+}
+
+// exportToRedis loads the filtered CIDRs of a country into a temporary key and atomically renames it over the target key,
+// so readers never observe a half-loaded set.
+func exportToRedis(countryCode string, opts redisExportOptions) {
+    countryCode = strings.ToUpper(countryCode)
+    if opts.Key == "" {
+        opts.Key = "chicha-whois:" + countryCode
+        if opts.Ranges {
+            opts.Key += ":ranges"
+        }
+    }
+    statusf("Loading prefixes for country code %s into Redis %s (key %s)\n", countryCode, opts.Addr, opts.Key)
+
+    ipRanges := extractCountryCIDRs(countryCode, ripedbPath, false)
+    if len(ipRanges) == 0 {
+        warnf("No IP ranges found for country code: %s\n", countryCode)
+        return
+    }
+    ipRanges, summary := preparePrefixes(strings.ToUpper(countryCode), ipRanges, true)
+    printPrefixSummary(summary)
+
+    conn, err := dialRedis(opts.Addr)
+    if err != nil {
+        errorf("Error connecting to Redis: %v\n", err)
+        return
+    }
+    defer conn.Close()
+
+    if opts.Password != "" {
+        if _, err := conn.do("AUTH", opts.Password); err != nil {
+            errorf("Error authenticating to Redis: %v\n", err)
+            return
+        }
+    }
+    if opts.DB != "" {
+        if _, err := conn.do("SELECT", opts.DB); err != nil {
+            errorf("Error selecting Redis database: %v\n", err)
+            return
+        }
+    }
+
+    // --exclude, --intersect or --ignore-smaller-than may leave nothing; an empty set cannot be
+    // renamed over the key, and the key must not keep the old prefixes either.
+    if len(ipRanges) == 0 {
+        if _, err := conn.do("DEL", opts.Key); err != nil {
+            errorf("Error deleting Redis key: %v\n", err)
+            return
+        }
+        warnf("No prefixes left for %s; Redis key %s deleted\n", countryCode, opts.Key)
+        return
+    }
+    if err := loadRedisKey(conn, opts.Key, ipRanges, opts.Ranges); err != nil {
+        errorf("Error loading prefixes into Redis: %v\n", err)
+        return
+    }
+    reportCountry(countryCode, len(ipRanges))
+    statusf("Redis key %s now holds %d prefixes\n", opts.Key, len(ipRanges))
+}
+
+// loadRedisKey loads prefixes into a temporary key in pipelined batches and renames it over key, so
+// readers never observe a half-loaded set. With ranges, the key is a sorted set scored by the last
+// address of each prefix. The temporary key is removed if anything fails.
+func loadRedisKey(conn *redisConn, key string, cidrs []string, ranges bool) error {
+    tmpKey := fmt.Sprintf("%s:tmp:%d", key, os.Getpid())
+    commands := [][]string{{"DEL", tmpKey}}
+
+    // Load in batches to keep individual commands reasonably small.
+    const batchSize = 1000
+    for start := 0; start < len(cidrs); start += batchSize {
+        end := min(start+batchSize, len(cidrs))
+        var args []string
+        if ranges {
+            args = []string{"ZADD", tmpKey}
+            for _, cidr := range cidrs[start:end] {
+                _, ipNet, err := net.ParseCIDR(cidr)
+                if err != nil {
+                    continue
+                }
+                last := binary.BigEndian.Uint32(lastIP(ipNet))
+                args = append(args, fmt.Sprintf("%d", last), cidr)
+            }
+        } else {
+            args = append([]string{"SADD", tmpKey}, cidrs[start:end]...)
+        }
+        commands = append(commands, args)
+    }
+    if err := conn.pipeline(commands); err != nil {
+        _, _ = conn.do("DEL", tmpKey)
+        return err
+    }
+    if _, err := conn.do("RENAME", tmpKey, key); err != nil {
+        _, _ = conn.do("DEL", tmpKey)
+        return fmt.Errorf("swapping the key: %v", err)
+    }
+    return nil
+}
+
+//-------------------------------------------------------------------------
+// PostgreSQL COPY export
+//-------------------------------------------------------------------------
+
+// sqlIdentifierRe matches the (optionally schema-qualified) table names accepted for SQL exports.
+var sqlIdentifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// errPostgresCountry rejects selections whose name does not fit the country char(2) column of the dump:
+// country groups (EUROPE) and searches without a country.
+var errPostgresCountry = errors.New("the PostgreSQL dump labels every row with a country code; select a single country")
+
+// renderPostgresDump renders a self-contained SQL script: table and indexes (if missing),
+// replacement of the country's rows and a COPY block with one prefix per line.
+func renderPostgresDump(table, countryCode string, cidrs []string) (string, error) {
+    countryCode = strings.ToUpper(countryCode)
+    if !countryCodeRe.MatchString(countryCode) {
+        return "", errPostgresCountry
+    }
+    indexBase := strings.ReplaceAll(table, ".", "_")
+
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "-- RIPE NCC registry prefixes for %s (%s), generated by chicha-whois %s\n", countryName(countryCode), countryCode, version)
+    fmt.Fprintf(&sb, "-- Example join: SELECT t.*, p.country FROM traffic t JOIN %s p ON t.ip << p.network;\n\n", table)
+    sb.WriteString("BEGIN;\n\n")
+    fmt.Fprintf(&sb, "CREATE TABLE IF NOT EXISTS %s (\n    network cidr    NOT NULL,\n    country char(2) NOT NULL\n);\n", table)
+    fmt.Fprintf(&sb, "CREATE INDEX IF NOT EXISTS %s_network_idx ON %s USING gist (network inet_ops);\n", indexBase, table)
+    fmt.Fprintf(&sb, "CREATE INDEX IF NOT EXISTS %s_country_idx ON %s (country);\n\n", indexBase, table)
+    fmt.Fprintf(&sb, "DELETE FROM %s WHERE country = '%s';\n\n", table, strings.ReplaceAll(countryCode, "'", "''"))
+    fmt.Fprintf(&sb, "COPY %s (network, country) FROM stdin;\n", table)
+    for _, cidr := range cidrs {
+        fmt.Fprintf(&sb, "%s\t%s\n", cidr, countryCode)
+    }
+    sb.WriteString("\\.\n\nCOMMIT;\n")
+    return sb.String(), nil
+}
+
+// createPostgresDump writes a COPY-ready SQL file for a country and optionally loads it with psql.
+func createPostgresDump(countryCode, table, connString string) {
+    if !sqlIdentifierRe.MatchString(table) {
+        errorf("Invalid table name: %s\n", table)
+        return
+    }
+    if !countryCodeRe.MatchString(strings.ToUpper(countryCode)) {
+        errorf("Cannot create a PostgreSQL dump of %s: %v\n", countryCode, errPostgresCountry)
+        return
+    }
+    statusf("Creating PostgreSQL dump for country code: %s (table %s)\n", countryCode, table)
+
+    ipRanges := extractCountryCIDRs(countryCode, ripedbPath, false)
+    if len(ipRanges) == 0 {
+        warnf("No IP ranges found for country code: %s\n", countryCode)
+        return
+    }
+    ipRanges, summary := preparePrefixes(strings.ToUpper(countryCode), ipRanges, true)
+    printPrefixSummary(summary)
+
+    homeDir, _ := os.UserHomeDir()
+    outFilePath := filepath.Join(homeDir, fmt.Sprintf("pgsql_%s.sql", strings.ToUpper(countryCode)))
+
+    content, err := renderPostgresDump(table, countryCode, ipRanges)
+    if err != nil {
+        errorf("Error writing PostgreSQL dump: %v\n", err)
+        return
+    }
+    meta := outputMeta{Selection: "country " + strings.ToUpper(countryCode) + " (filtered)", Entries: len(ipRanges), Comment: "--"}
+    if err := writeOutputFile(outFilePath, content, meta); err != nil {
+        errorf("Error writing PostgreSQL dump: %v\n", err)
+        return
+    }
+    reportCountry(countryCode, len(ipRanges))
+    statusf("PostgreSQL dump created at: %s (%d prefixes)\n", outFilePath, len(ipRanges))
+
+    if connString == "" {
+        statusf("Load it with: psql \"postgresql://...\" -v ON_ERROR_STOP=1 -f %s\n", outFilePath)
+        return
+    }
+
+    // Loading directly goes through the psql client, so no database driver is needed here.
+    statusln("Loading the dump with psql...")
+    cmd := exec.Command("psql", connString, "-v", "ON_ERROR_STOP=1", "-q", "-f", outFilePath)
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+    if err := cmd.Run(); err != nil {
+        errorf("Error loading the dump with psql: %v\n", err)
+        return
+    }
+    statusln("PostgreSQL table updated successfully.")
+}
+
+//-------------------------------------------------------------------------
+// ClickHouse export
+//-------------------------------------------------------------------------
+
+// renderClickHouseTSV renders one TabSeparated row per prefix: ip_from, ip_to (UInt32), prefix, country.
+func renderClickHouseTSV(countryCode string, cidrs []string) string {
+    countryCode = strings.ToUpper(countryCode)
+    var sb strings.Builder
+    for _, cidr := range cidrs {
+        _, ipNet, err := net.ParseCIDR(cidr)
+        if err != nil || ipNet.IP.To4() == nil {
+            continue
+        }
+        first := binary.BigEndian.Uint32(ipNet.IP.To4())
+        last := binary.BigEndian.Uint32(lastIP(ipNet))
+        fmt.Fprintf(&sb, "%d\t%d\t%s\t%s\n", first, last, cidr, countryCode)
+    }
+    return sb.String()
+}
+
+// renderClickHouseSchema renders the table and dictionary definitions plus the commands to load the TSV file.
+func renderClickHouseSchema(table, countryCode, tsvPath string) string {
+    countryCode = strings.ToUpper(countryCode)
+    dictionary := table + "_dict"
+
+    // A schema-qualified name must be split into DB and TABLE for the dictionary source.
+    source := fmt.Sprintf("TABLE '%s'", table)
+    if db, name, found := strings.Cut(table, "."); found {
+        source = fmt.Sprintf("DB '%s' TABLE '%s'", db, name)
+    }
+
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "-- RIPE NCC registry ranges for %s (%s), generated by chicha-whois %s\n\n", countryName(countryCode), countryCode, version)
+    fmt.Fprintf(&sb, "CREATE TABLE IF NOT EXISTS %s\n(\n    ip_from UInt32,\n    ip_to   UInt32,\n    prefix  String,\n    country LowCardinality(String)\n)\nENGINE = MergeTree\nORDER BY (ip_from, ip_to);\n\n", table)
+    fmt.Fprintf(&sb, "CREATE DICTIONARY IF NOT EXISTS %s\n(\n    prefix  String,\n    country String\n)\nPRIMARY KEY prefix\nSOURCE(CLICKHOUSE(%s))\nLAYOUT(IP_TRIE())\nLIFETIME(3600);\n\n", dictionary, source)
+    fmt.Fprintf(&sb, "-- Replace the rows of this country, then load the data:\n")
+    fmt.Fprintf(&sb, "--   clickhouse-client --query \"ALTER TABLE %s DELETE WHERE country = '%s'\"\n", table, countryCode)
+    fmt.Fprintf(&sb, "--   clickhouse-client --query \"INSERT INTO %s FORMAT TabSeparated\" < %s\n--\n", table, tsvPath)
+    fmt.Fprintf(&sb, "-- Lookups:\n")
+    fmt.Fprintf(&sb, "--   SELECT dictGet('%s', 'country', tuple(toIPv4('1.2.3.4')));\n", dictionary)
+    fmt.Fprintf(&sb, "--   SELECT country FROM %s WHERE ip_from <= IPv4StringToNum('1.2.3.4') AND ip_to >= IPv4StringToNum('1.2.3.4');\n", table)
+    return sb.String()
+}
+
+// createClickHouseExport writes ClickHouse TSV data and the matching schema file for a country.
+func createClickHouseExport(countryCode, table string) {
+    if !sqlIdentifierRe.MatchString(table) {
+        errorf("Invalid table name: %s\n", table)
+        return
+    }
+    statusf("Creating ClickHouse export for country code: %s (table %s)\n", countryCode, table)
+
+    ipRanges := extractCountryCIDRs(countryCode, ripedbPath, false)
+    if len(ipRanges) == 0 {
+        warnf("No IP ranges found for country code: %s\n", countryCode)
+        return
+    }
+    ipRanges, summary := preparePrefixes(strings.ToUpper(countryCode), ipRanges, true)
+    printPrefixSummary(summary)
+
+    homeDir, _ := os.UserHomeDir()
+    tsvPath := filepath.Join(homeDir, fmt.Sprintf("clickhouse_%s.tsv", strings.ToUpper(countryCode)))
+    sqlPath := filepath.Join(homeDir, fmt.Sprintf("clickhouse_%s.sql", strings.ToUpper(countryCode)))
+
+    meta := outputMeta{Selection: "country " + strings.ToUpper(countryCode) + " (filtered)", Entries: len(ipRanges)}
+    if err := writeOutputFile(tsvPath, renderClickHouseTSV(countryCode, ipRanges), meta); err != nil {
+        errorf("Error writing ClickHouse data file: %v\n", err)
+        return
+    }
+    meta.Comment = "--"
+    if err := writeOutputFile(sqlPath, renderClickHouseSchema(table, countryCode, tsvPath), meta); err != nil {
+        errorf("Error writing ClickHouse schema file: %v\n", err)
+        return
+    }
+    reportCountry(countryCode, len(ipRanges))
+    statusf("ClickHouse data file created at: %s (%d ranges)\n", tsvPath, len(ipRanges))
+    statusf("ClickHouse schema file created at: %s\n", sqlPath)
+}
+
+//-------------------------------------------------------------------------
+// pf anchor export
+//-------------------------------------------------------------------------
+
+// countrySetPrefixes returns the filtered, sorted prefixes of several countries combined into one list,
+// printing the summary of each country. It returns false (after printing why) if none has any prefix.
+func countrySetPrefixes(codes []string) ([]string, bool) {
+    defer prefetchCountries(codes)()
+    if invert && len(codes) > 1 {
+        // The complement of several countries is that of their union, not the union of their complements.
+        var ipRanges []string
+        for _, code := range codes {
+            extracted := extractCountryCIDRs(code, ripedbPath, false)
+            if len(extracted) == 0 {
+                warnf("No IP ranges found for country code: %s\n", code)
+            }
+            ipRanges = append(ipRanges, extracted...)
+        }
+        name := strings.Join(codes, "_")
+        combined, summary := preparePrefixes(name, ipRanges, true)
+        printPrefixSummary(summary)
+        reportCountry(name, len(combined))
+        return combined, len(combined) > 0
+    }
+    var combined []string
+    for _, code := range codes {
+        ipRanges := extractCountryCIDRs(code, ripedbPath, false)
+        if len(ipRanges) == 0 {
+            warnf("No IP ranges found for country code: %s\n", code)
+            continue
+        }
+        ipRanges, summary := preparePrefixes(code, ipRanges, true)
+        printPrefixSummary(summary)
+        reportCountry(code, len(ipRanges))
+        combined = append(combined, ipRanges...)
+    }
+    if len(combined) == 0 {
+        return nil, false
+    }
+    combined = removeDuplicates(combined)
+    sort.Strings(combined)
+    return combined, true
+}
+
+// pfAnchorOptions holds the settings of -pf.
+type pfAnchorOptions struct {
+    Anchor string // Anchor name used by -reload and in the load instructions.
+    Table  string // Table name; derived from the country codes when empty.
+    Action string // "block" or "pass" for traffic from the table.
+    Output string // Output file; defaults to ~/pf_CC.conf.
+    Reload bool   // Load the anchor with pfctl after writing it.
+
+    TableFile string // If set, the prefixes are written to this plain table file, which the anchor loads.
+}
+
+// renderPFAnchor renders a pf anchor: a persistent table with the prefixes, inline or loaded from
+// opts.TableFile, and one rule.
+func renderPFAnchor(opts pfAnchorOptions, codes, cidrs []string) string {
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "# pf anchor for %s; load with: pfctl -a %s -f FILE\n", strings.Join(codes, ", "), opts.Anchor)
+    fmt.Fprintf(&sb, "# and reference it from pf.conf with: anchor \"%s\"\n\n", opts.Anchor)
+    if opts.TableFile != "" {
+        fmt.Fprintf(&sb, "table <%s> persist file \"%s\"\n\n", opts.Table, opts.TableFile)
+    } else {
+        // pf.conf statements end at a newline, so the table body continues its lines with a backslash.
+        fmt.Fprintf(&sb, "table <%s> persist { \\\n", opts.Table)
+        for _, cidr := range cidrs {
+            sb.WriteString("  " + cidr + " \\\n")
+        }
+        sb.WriteString("}\n\n")
+    }
+    if opts.Action == "pass" {
+        fmt.Fprintf(&sb, "pass in quick from <%s> to any\n", opts.Table)
+    } else {
+        fmt.Fprintf(&sb, "block drop in quick from <%s> to any\n", opts.Table)
+    }
+    return sb.String()
+}
+
+// renderPFTableFile renders a pf table file: one prefix per line, as pfctl -T and "persist file" read
+// it. Unlike pf.conf, table files need no line continuations; # starts a comment.
+func renderPFTableFile(codes, cidrs []string) string {
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "# pf table for %s\n", strings.Join(codes, ", "))
+    for _, cidr := range cidrs {
+        sb.WriteString(cidr + "\n")
+    }
+    return sb.String()
+}
+
+// pfTableNameMax is the longest pf table name: PF_TABLE_NAME_SIZE is 32 bytes including the NUL.
+const pfTableNameMax = 31
+
+// pfTableName returns the default table name for a set of countries, chicha_CC_CC...; a name too long
+// for pf is cut and ends with a hash of the full code list instead, so that it stays unique.
+func pfTableName(codes []string) string {
+    name := "chicha_" + strings.Join(codes, "_")
+    if len(name) <= pfTableNameMax {
+        return name
+    }
+    sum := sha256.Sum256([]byte(name))
+    return name[:pfTableNameMax-9] + "_" + hex.EncodeToString(sum[:4])
+}
+
+// createPFAnchor writes a pf anchor file for a set of countries and optionally loads it with pfctl.
+func createPFAnchor(codes []string, opts pfAnchorOptions) {
+    statusf("Creating pf anchor for: %s\n", strings.Join(codes, ", "))
+    if opts.Table == "" {
+        opts.Table = pfTableName(codes)
+    } else if len(opts.Table) > pfTableNameMax {
+        errorf("pf table name %s is longer than %d characters\n", opts.Table, pfTableNameMax)
+        return
+    }
+    cidrs, ok := countrySetPrefixes(codes)
+    if !ok {
+        return
+    }
+
+    outFilePath := opts.Output
+    if outFilePath == "" {
+        homeDir, _ := os.UserHomeDir()
+        outFilePath = filepath.Join(homeDir, fmt.Sprintf("pf_%s.conf", strings.Join(codes, "_")))
+    }
+    meta := outputMeta{Selection: "countries " + strings.Join(codes, ", ") + " (filtered)", Entries: len(cidrs), Comment: "#"}
+    if opts.TableFile != "" {
+        // pfctl resolves the file when the anchor is loaded, from its own working directory.
+        if abs, err := filepath.Abs(opts.TableFile); err == nil {
+            opts.TableFile = abs
+        }
+        if err := writeOutputFile(opts.TableFile, renderPFTableFile(codes, cidrs), meta); err != nil {
+            errorf("Error writing pf table file: %v\n", err)
+            return
+        }
+        statusf("pf table file created at: %s (%d prefixes)\n", opts.TableFile, len(cidrs))
+        meta.Entries = 0
+    }
+    if err := writeOutputFile(outFilePath, renderPFAnchor(opts, codes, cidrs), meta); err != nil {
+        errorf("Error writing pf anchor file: %v\n", err)
+        return
+    }
+    if opts.TableFile != "" {
+        statusf("pf anchor file created at: %s (table loaded from %s)\n", outFilePath, opts.TableFile)
+    } else {
+        statusf("pf anchor file created at: %s (%d prefixes)\n", outFilePath, len(cidrs))
+    }
+
+    if !opts.Reload {
+        statusf("Load it with: pfctl -a %s -f %s\n", opts.Anchor, outFilePath)
+        if opts.TableFile != "" {
+            statusf("Refresh only the table with: pfctl -a %s -t %s -T replace -f %s\n", opts.Anchor, opts.Table, opts.TableFile)
+        }
+        return
+    }
+    cmd := exec.Command("pfctl", "-a", opts.Anchor, "-f", outFilePath)
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+    if err := cmd.Run(); err != nil {
+        errorf("Error loading the anchor with pfctl: %v\n", err)
+        return
+    }
+    statusf("pf anchor %s reloaded.\n", opts.Anchor)
+}
+
+//-------------------------------------------------------------------------
+// ipfw table export
+//-------------------------------------------------------------------------
+
+// ipfwBatchSize is the number of prefixes added per ipfw command; one process per prefix
+// would make loading a large country take minutes.
+const ipfwBatchSize = 200
+
+// renderIPFWScript renders a shell script that fills the spare table and swaps it with the live one,
+// so rules referencing the live table never see it half-filled.
+func renderIPFWScript(table, spare int, codes, cidrs []string) string {
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "# ipfw table %d for %s, filled via table %d and swapped atomically; run with: sh FILE\n", table, strings.Join(codes, ", "), spare)
+    fmt.Fprintf(&sb, "# Use it in rules as table(%d), e.g.: ipfw add deny ip from \"table(%d)\" to any\n", table, table)
+    sb.WriteString("set -e\n")
+    fmt.Fprintf(&sb, "ipfw -q table %d destroy 2>/dev/null || true\n", spare)
+    fmt.Fprintf(&sb, "ipfw -q table %d create type addr\n", spare)
+    for start := 0; start < len(cidrs); start += ipfwBatchSize {
+        end := min(start+ipfwBatchSize, len(cidrs))
+        fmt.Fprintf(&sb, "ipfw -q table %d add %s\n", spare, strings.Join(cidrs[start:end], " "))
+    }
+    fmt.Fprintf(&sb, "ipfw -q table %d create type addr 2>/dev/null || true\n", table)
+    fmt.Fprintf(&sb, "ipfw -q table %d swap %d\n", table, spare)
+    fmt.Fprintf(&sb, "ipfw -q table %d destroy\n", spare)
+    return sb.String()
+}
+
+// createIPFWScript writes the ipfw table script for a set of countries.
+func createIPFWScript(codes []string, table, spare int, output string) {
+    statusf("Creating ipfw table script for: %s\n", strings.Join(codes, ", "))
+    cidrs, ok := countrySetPrefixes(codes)
+    if !ok {
+        return
+    }
+    if output == "" {
+        homeDir, _ := os.UserHomeDir()
+        output = filepath.Join(homeDir, fmt.Sprintf("ipfw_%s.sh", strings.Join(codes, "_")))
+    }
+    meta := outputMeta{Selection: "countries " + strings.Join(codes, ", ") + " (filtered)", Entries: len(cidrs), Comment: "#"}
+    if err := writeOutputFile(output, renderIPFWScript(table, spare, codes, cidrs), meta); err != nil {
+        errorf("Error writing ipfw script: %v\n", err)
+        return
+    }
+    statusf("ipfw table script created at: %s (%d prefixes)\n", output, len(cidrs))
+    statusf("Load it with: sh %s\n", output)
+}
+
+//-------------------------------------------------------------------------
+// Ubiquiti EdgeOS config.gateway.json export
+//-------------------------------------------------------------------------
+
+// edgeOSNetworkGroup is one firewall network-group of an EdgeOS configuration.
+type edgeOSNetworkGroup struct {
+    Description string   `json:"description"`
+    Network     []string `json:"network"`
+}
+
+// createEdgeOSConfig writes a config.gateway.json fragment with one firewall network-group per country
+// (PREFIX_CC), to be merged into the provisioning file of an EdgeRouter or UniFi gateway.
+func createEdgeOSConfig(codes []string, prefix, output string) {
+    statusf("Creating EdgeOS firewall groups for: %s\n", strings.Join(codes, ", "))
+    groups := make(map[string]edgeOSNetworkGroup)
+    total := 0
+    defer prefetchCountries(codes)()
+    for _, code := range codes {
+        cidrs, ok := countrySetPrefixes([]string{code})
+        if !ok {
+            continue
+        }
+        groups[prefix+code] = edgeOSNetworkGroup{Description: "chicha-whois " + code, Network: cidrs}
+        total += len(cidrs)
+    }
+    if len(groups) == 0 {
+        return
+    }
+    config := map[string]any{
+        "firewall": map[string]any{
+            "group": map[string]any{
+                "network-group": groups,
+            },
+        },
+    }
+    data, err := json.MarshalIndent(config, "", "  ")
+    if err != nil {
+        errorf("Error rendering EdgeOS configuration: %v\n", err)
+        return
+    }
+    if output == "" {
+        homeDir, _ := os.UserHomeDir()
+        output = filepath.Join(homeDir, fmt.Sprintf("edgeos_%s.json", strings.Join(codes, "_")))
+    }
+    meta := outputMeta{Selection: "countries " + strings.Join(codes, ", ") + " (filtered)", Entries: total}
+    if err := writeOutputFile(output, string(data)+"\n", meta); err != nil {
+        errorf("Error writing EdgeOS configuration: %v\n", err)
+        return
+    }
+    statusf("EdgeOS configuration fragment created at: %s (%d groups, %d prefixes)\n", output, len(groups), total)
+}
+
+//-------------------------------------------------------------------------
+// BIRD and FRRouting export
+//-------------------------------------------------------------------------
+
+// routingOptions holds the settings of -bird and -frr.
+type routingOptions struct {
+    Daemon    string // "bird" or "frr".
+    Name      string // Name of the prefix set or list; derived from the country codes when empty.
+    Blackhole bool   // Add a static blackhole route per prefix.
+    Output    string // Output file; defaults to ~/bird_CC.conf or ~/frr_CC.conf.
+}
+
+// routingNameRe matches the characters BIRD does not accept in symbol names.
+var routingNameRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// renderBIRDConfig renders a BIRD 2 prefix set and, with blackhole, a static protocol with a blackhole
+// route for every prefix.
+func renderBIRDConfig(name string, cidrs []string, blackhole bool) string {
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "define %s = [\n", name)
+    for i, cidr := range cidrs {
+        separator := ","
+        if i == len(cidrs)-1 {
+            separator = ""
+        }
+        fmt.Fprintf(&sb, "  %s%s\n", cidr, separator)
+    }
+    sb.WriteString("];\n")
+    if blackhole {
+        fmt.Fprintf(&sb, "\nprotocol static %s_blackhole {\n  ipv4;\n", name)
+        for _, cidr := range cidrs {
+            fmt.Fprintf(&sb, "  route %s blackhole;\n", cidr)
+        }
+        sb.WriteString("}\n")
+    }
+    return sb.String()
+}
+
+// renderFRRConfig renders an FRRouting prefix-list with numbered entries and, with blackhole, a static
+// blackhole route for every prefix.
+func renderFRRConfig(name string, cidrs []string, blackhole bool) string {
+    var sb strings.Builder
+    for i, cidr := range cidrs {
+        fmt.Fprintf(&sb, "ip prefix-list %s seq %d permit %s\n", name, (i+1)*5, cidr)
+    }
+    if blackhole {
+        sb.WriteString("!\n")
+        for _, cidr := range cidrs {
+            fmt.Fprintf(&sb, "ip route %s blackhole\n", cidr)
+        }
+    }
+    return sb.String()
+}
+
+// createRoutingConfig writes the BIRD or FRRouting configuration of a set of countries.
+func createRoutingConfig(codes []string, opts routingOptions) {
+    daemon, comment, load := "BIRD", "#", "include \"%s\"; in bird.conf, then birdc configure"
+    if opts.Daemon == "frr" {
+        daemon, comment, load = "FRRouting", "!", "vtysh -f %s"
+    }
+    statusf("Creating %s configuration for: %s\n", daemon, strings.Join(codes, ", "))
+    if opts.Name == "" {
+        opts.Name = "chicha_" + strings.Join(codes, "_")
+    }
+    if opts.Daemon == "bird" {
+        opts.Name = routingNameRe.ReplaceAllString(opts.Name, "_")
+    }
+    cidrs, ok := countrySetPrefixes(codes)
+    if !ok {
+        return
+    }
+
+    output := opts.Output
+    if output == "" {
+        homeDir, _ := os.UserHomeDir()
+        output = filepath.Join(homeDir, fmt.Sprintf("%s_%s.conf", opts.Daemon, strings.Join(codes, "_")))
+    }
+    var body string
+    if opts.Daemon == "frr" {
+        body = fmt.Sprintf("! FRRouting configuration for %s; apply with: vtysh -f FILE\n", strings.Join(codes, ", ")) +
+            renderFRRConfig(opts.Name, cidrs, opts.Blackhole)
+    } else {
+        body = fmt.Sprintf("# BIRD 2 configuration for %s; include it from bird.conf\n", strings.Join(codes, ", ")) +
+            renderBIRDConfig(opts.Name, cidrs, opts.Blackhole)
+    }
+    meta := outputMeta{Selection: "countries " + strings.Join(codes, ", ") + " (filtered)", Entries: len(cidrs), Comment: comment}
+    if err := writeOutputFile(output, body, meta); err != nil {
+        errorf("Error writing %s configuration: %v\n", daemon, err)
+        return
+    }
+    statusf("%s configuration created at: %s (%d prefixes)\n", daemon, output, len(cidrs))
+    statusf("Load it with: "+load+"\n", output)
+}
+
+//-------------------------------------------------------------------------
+// nginx access and geo module export
+//-------------------------------------------------------------------------
+
+// nginxOptions holds the settings of -nginx.
+type nginxOptions struct {
+    Mode     string // "allow" or "deny" for an access block, "geo" for a geo map.
+    Variable string // Variable set by the geo map, without the $.
+    Output   string // Output file; defaults to ~/nginx_CC.conf.
+}
+
+// renderNginxAccess renders ngx_http_access_module rules: action ("allow" or "deny") for every prefix
+// and the opposite for all other clients. Rules are checked in order, so the final one must come last.
+func renderNginxAccess(action string, cidrs []string) string {
+    other := "deny"
+    if action == "deny" {
+        other = "allow"
+    }
+    var sb strings.Builder
+    for _, cidr := range cidrs {
+        fmt.Fprintf(&sb, "%s %s;\n", action, cidr)
+    }
+    fmt.Fprintf(&sb, "%s all;\n", other)
+    return sb.String()
+}
+
+// renderNginxGeo renders a geo block that sets $variable to the country code of the client address and
+// to an empty string for other clients. nginx picks the most specific prefix, so nested networks of
+// different countries are resolved as in the database.
+func renderNginxGeo(variable string, codes []string, prefixes map[string][]string) string {
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "geo $%s {\n", variable)
+    sb.WriteString("  default \"\";\n")
+    for _, code := range codes {
+        for _, cidr := range prefixes[code] {
+            fmt.Fprintf(&sb, "  %s %s;\n", cidr, code)
+        }
+    }
+    sb.WriteString("}\n")
+    return sb.String()
+}
+
+// createNginxConfig writes the nginx access rules or geo map of a set of countries.
+func createNginxConfig(codes []string, opts nginxOptions) {
+    statusf("Creating nginx configuration for: %s\n", strings.Join(codes, ", "))
+    // nginx variable names take the same characters as BIRD symbols.
+    opts.Variable = routingNameRe.ReplaceAllString(opts.Variable, "_")
+    var body, load string
+    total := 0
+    if opts.Mode == "geo" {
+        // Each country keeps its own prefixes, so the map can tell them apart.
+        prefixes := make(map[string][]string)
+        var found []string
+        defer prefetchCountries(codes)()
+        for _, code := range codes {
+            cidrs, ok := countrySetPrefixes([]string{code})
+            if !ok {
+                continue
+            }
+            prefixes[code] = cidrs
+            found = append(found, code)
+            total += len(cidrs)
+        }
+        if len(found) == 0 {
+            return
+        }
+        body = fmt.Sprintf("# nginx geo map for %s; include it in the http block, then use $%s\n", strings.Join(codes, ", "), opts.Variable) +
+            renderNginxGeo(opts.Variable, found, prefixes)
+        load = "include %s; in the http block (or copy it to conf.d), use $" + opts.Variable + ", then nginx -s reload"
+    } else {
+        cidrs, ok := countrySetPrefixes(codes)
+        if !ok {
+            return
+        }
+        total = len(cidrs)
+        body = fmt.Sprintf("# nginx access rules for %s; include them in a server or location block\n", strings.Join(codes, ", ")) +
+            renderNginxAccess(opts.Mode, cidrs)
+        load = "include %s; in a server or location block, then nginx -s reload"
+    }
+
+    output := opts.Output
+    if output == "" {
+        homeDir, _ := os.UserHomeDir()
+        output = filepath.Join(homeDir, fmt.Sprintf("nginx_%s.conf", strings.Join(codes, "_")))
+    }
+    meta := outputMeta{Selection: "countries " + strings.Join(codes, ", ") + " (filtered)", Entries: total, Comment: "#"}
+    if err := writeOutputFile(output, body, meta); err != nil {
+        errorf("Error writing nginx configuration: %v\n", err)
+        return
+    }
+    statusf("nginx configuration created at: %s (%d prefixes)\n", output, total)
+    statusf("Load it with: "+load+"\n", output)
+}
+
+//-------------------------------------------------------------------------
+// Squid acl export
+//-------------------------------------------------------------------------
+
+// squidOptions holds the settings of -squid.
+type squidOptions struct {
+    Name     string // acl name; the country codes when empty.
+    Type     string // "src" for client addresses, "dst" for destination addresses.
+    ListFile string // If set, the prefixes are written to this file, which the acl reads.
+    Output   string // Output file; defaults to ~/squid_CC.conf.
+}
+
+// renderSquidACL renders a Squid acl with one line per prefix; Squid joins the lines of an acl
+// name into one list.
+func renderSquidACL(name, aclType string, cidrs []string) string {
+    var sb strings.Builder
+    for _, cidr := range cidrs {
+        fmt.Fprintf(&sb, "acl %s %s %s\n", name, aclType, cidr)
+    }
+    return sb.String()
+}
+
+// createSquidConfig writes the Squid acl of a set of countries and, with opts.ListFile, the list file it reads.
+func createSquidConfig(codes []string, opts squidOptions) {
+    statusf("Creating Squid acl for: %s\n", strings.Join(codes, ", "))
+    if opts.Name == "" {
+        opts.Name = strings.Join(codes, "_")
+    }
+    cidrs, ok := countrySetPrefixes(codes)
+    if !ok {
+        return
+    }
+
+    output := opts.Output
+    if output == "" {
+        homeDir, _ := os.UserHomeDir()
+        output = filepath.Join(homeDir, fmt.Sprintf("squid_%s.conf", strings.Join(codes, "_")))
+    }
+    meta := outputMeta{Selection: "countries " + strings.Join(codes, ", ") + " (filtered)", Entries: len(cidrs), Comment: "#"}
+    body := fmt.Sprintf("# Squid acl for %s; include it in squid.conf and use it as: http_access deny %s\n", strings.Join(codes, ", "), opts.Name)
+    if opts.ListFile != "" {
+        // Squid reads the file relative to its own working directory, so the acl names it absolutely.
+        if abs, err := filepath.Abs(opts.ListFile); err == nil {
+            opts.ListFile = abs
+        }
+        if err := writeOutputFile(opts.ListFile, strings.Join(cidrs, "\n")+"\n", meta); err != nil {
+            errorf("Error writing Squid list file: %v\n", err)
+            return
+        }
+        statusf("Squid list file created at: %s (%d prefixes)\n", opts.ListFile, len(cidrs))
+        body += fmt.Sprintf("acl %s %s \"%s\"\n", opts.Name, opts.Type, opts.ListFile)
+        meta.Entries = 0
+    } else {
+        body += renderSquidACL(opts.Name, opts.Type, cidrs)
+    }
+    if err := writeOutputFile(output, body, meta); err != nil {
+        errorf("Error writing Squid acl: %v\n", err)
+        return
+    }
+    statusf("Squid acl created at: %s (%d prefixes)\n", output, len(cidrs))
+    statusf("Load it with: include %s in squid.conf, then squid -k reconfigure\n", output)
+}
+
+//-------------------------------------------------------------------------
+// Postfix cidr table export
+//-------------------------------------------------------------------------
+
+// renderPostfixTable renders a Postfix cidr: lookup table that gives action for every prefix.
+// Postfix only treats # as a comment at the start of a line, so nothing follows the action.
+func renderPostfixTable(action string, cidrs []string) string {
+    var sb strings.Builder
+    for _, cidr := range cidrs {
+        fmt.Fprintf(&sb, "%s %s\n", cidr, action)
+    }
+    return sb.String()
+}
+
+// createPostfixTable writes the Postfix cidr table of a set of countries.
+func createPostfixTable(codes []string, action, output string) {
+    statusf("Creating Postfix cidr table for: %s\n", strings.Join(codes, ", "))
+    cidrs, ok := countrySetPrefixes(codes)
+    if !ok {
+        return
+    }
+    if output == "" {
+        homeDir, _ := os.UserHomeDir()
+        output = filepath.Join(homeDir, fmt.Sprintf("postfix_%s.cidr", strings.Join(codes, "_")))
+    }
+    body := fmt.Sprintf("# Postfix cidr table for %s; use it in main.cf as:\n# smtpd_client_restrictions = check_client_access cidr:FILE\n", strings.Join(codes, ", ")) +
+        renderPostfixTable(action, cidrs)
+    meta := outputMeta{Selection: "countries " + strings.Join(codes, ", ") + " (filtered)", Entries: len(cidrs), Comment: "#"}
+    if err := writeOutputFile(output, body, meta); err != nil {
+        errorf("Error writing Postfix table: %v\n", err)
+        return
+    }
+    statusf("Postfix cidr table created at: %s (%d prefixes)\n", output, len(cidrs))
+    statusf("Load it with: smtpd_client_restrictions = check_client_access cidr:%s in main.cf, then postfix reload\n", output)
+}
+
+//-------------------------------------------------------------------------
+// YAML and Ansible vars export
+//-------------------------------------------------------------------------
+
+// yamlOptions holds the settings of -yaml and -ansible.
+type yamlOptions struct {
+    Ansible  bool   // Write an Ansible vars file with a country_cc_cidrs variable per country.
+    Variable string // With Ansible, put all countries into this one variable instead.
+    Output   string // Output file; defaults to ~/prefixes_CC.yaml or ~/ansible_CC.yml.
+}
+
+// ansibleVarRe matches valid Ansible variable names.
+var ansibleVarRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ansibleVarName returns the Ansible variable holding the prefixes of a country or country group; characters
+// group names may contain (EUROPE-DE-FR) are replaced as in BIRD symbol names, so the name stays valid.
+func ansibleVarName(countryCode string) string {
+    return "country_" + routingNameRe.ReplaceAllString(strings.ToLower(countryCode), "_") + "_cidrs"
+}
+
+// renderYAMLList renders a YAML mapping entry with a block sequence of prefixes. The key is quoted
+// for country codes, which YAML 1.1 may read as other types (NO is false).
+func renderYAMLList(key string, quoteKey bool, cidrs []string) string {
+    var sb strings.Builder
+    if quoteKey {
+        key = strconv.Quote(key)
+    }
+    if len(cidrs) == 0 {
+        return key + ": []\n"
+    }
+    sb.WriteString(key + ":\n")
+    for _, cidr := range cidrs {
+        fmt.Fprintf(&sb, "  - %s\n", cidr)
+    }
+    return sb.String()
+}
+
+// createYAMLExport writes the prefixes of a set of countries as a YAML mapping from country code to
+// list, or as an Ansible vars file.
+func createYAMLExport(codes []string, opts yamlOptions) {
+    creating := "Creating YAML file for: %s\n"
+    created := "YAML file created at: %s (%d prefixes)\n"
+    writeError := "Error writing YAML file: %v\n"
+    if opts.Ansible {
+        creating = "Creating Ansible vars file for: %s\n"
+        created = "Ansible vars file created at: %s (%d prefixes)\n"
+        writeError = "Error writing Ansible vars file: %v\n"
+    }
+    statusf(creating, strings.Join(codes, ", "))
+    var sb strings.Builder
+    if opts.Ansible {
+        sb.WriteString("---\n")
+    }
+    total := 0
+    if opts.Ansible && opts.Variable != "" {
+        cidrs, ok := countrySetPrefixes(codes)
+        if !ok {
+            return
+        }
+        sb.WriteString(renderYAMLList(opts.Variable, false, cidrs))
+        total = len(cidrs)
+    } else {
+        defer prefetchCountries(codes)()
+        found := 0
+        for _, code := range codes {
+            // A country without prefixes still gets its key, so templates using it do not break.
+            cidrs, ok := countrySetPrefixes([]string{code})
+            if ok {
+                found++
+            }
+            if opts.Ansible {
+                sb.WriteString(renderYAMLList(ansibleVarName(code), false, cidrs))
+            } else {
+                sb.WriteString(renderYAMLList(code, true, cidrs))
+            }
+            total += len(cidrs)
+        }
+        if found == 0 {
+            return
+        }
+    }
+
+    output := opts.Output
+    if output == "" {
+        homeDir, _ := os.UserHomeDir()
+        name := fmt.Sprintf("prefixes_%s.yaml", strings.Join(codes, "_"))
+        if opts.Ansible {
+            name = fmt.Sprintf("ansible_%s.yml", strings.Join(codes, "_"))
+        }
+        output = filepath.Join(homeDir, name)
+    }
+    meta := outputMeta{Selection: "countries " + strings.Join(codes, ", ") + " (filtered)", Entries: total, Comment: "#"}
+    if err := writeOutputFile(output, sb.String(), meta); err != nil {
+        errorf(writeError, err)
+        return
+    }
+    statusf(created, output, total)
+    if opts.Ansible {
+        statusf("Load it with: vars_files: [%s] in a play, or copy it to group_vars/\n", output)
+    }
+}
+
+//-------------------------------------------------------------------------
+// Juniper JunOS prefix-list export
+//-------------------------------------------------------------------------
+
+// junosOptions holds the settings of -junos.
+type junosOptions struct {
+    Prefix string // Prepended to the country code to name its prefix-list.
+    Filter string // Name of the firewall filter to generate; empty writes the prefix-lists only.
+    Action string // "discard" or "accept" for traffic from the countries.
+    Output string // Output file; defaults to ~/junos_CC.set.
+}
+
+// renderJunOSPrefixList renders set-style commands that replace a prefix-list with the prefixes.
+// Deleting a list that does not exist yet only gives a warning on load.
+func renderJunOSPrefixList(name string, cidrs []string) string {
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "delete policy-options prefix-list %s\n", name)
+    for _, cidr := range cidrs {
+        fmt.Fprintf(&sb, "set policy-options prefix-list %s %s\n", name, cidr)
+    }
+    return sb.String()
+}
+
+// renderJunOSFilter renders a firewall filter with one term per prefix-list, matching its source
+// prefixes, and a final term that treats all other traffic the opposite way.
+func renderJunOSFilter(filter, action string, lists []string) string {
+    other := "accept"
+    if action == "accept" {
+        other = "discard"
+    }
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "delete firewall family inet filter %s\n", filter)
+    for _, list := range lists {
+        fmt.Fprintf(&sb, "set firewall family inet filter %s term %s from source-prefix-list %s\n", filter, list, list)
+        fmt.Fprintf(&sb, "set firewall family inet filter %s term %s then %s\n", filter, list, action)
+    }
+    fmt.Fprintf(&sb, "set firewall family inet filter %s term default then %s\n", filter, other)
+    return sb.String()
+}
+
+// createJunOSConfig writes set-style JunOS commands with one prefix-list per country (PREFIXCC)
+// and, with opts.Filter, a firewall filter using them.
+func createJunOSConfig(codes []string, opts junosOptions) {
+    statusf("Creating JunOS prefix-lists for: %s\n", strings.Join(codes, ", "))
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "# JunOS configuration for %s; load with: load set FILE, then commit\n", strings.Join(codes, ", "))
+    var lists []string
+    total := 0
+    defer prefetchCountries(codes)()
+    for _, code := range codes {
+        cidrs, ok := countrySetPrefixes([]string{code})
+        if !ok {
+            continue
+        }
+        name := opts.Prefix + code
+        sb.WriteString(renderJunOSPrefixList(name, cidrs))
+        lists = append(lists, name)
+        total += len(cidrs)
+    }
+    if len(lists) == 0 {
+        return
+    }
+    if opts.Filter != "" {
+        sb.WriteString(renderJunOSFilter(opts.Filter, opts.Action, lists))
+    }
+    output := opts.Output
+    if output == "" {
+        homeDir, _ := os.UserHomeDir()
+        output = filepath.Join(homeDir, fmt.Sprintf("junos_%s.set", strings.Join(codes, "_")))
+    }
+    meta := outputMeta{Selection: "countries " + strings.Join(codes, ", ") + " (filtered)", Entries: total, Comment: "#"}
+    if err := writeOutputFile(output, sb.String(), meta); err != nil {
+        errorf("Error writing JunOS configuration: %v\n", err)
+        return
+    }
+    statusf("JunOS configuration created at: %s (%d prefix-lists, %d prefixes)\n", output, len(lists), total)
+    statusf("Load it with: load set %s\n", output)
+}
+
+//-------------------------------------------------------------------------
+// UniFi Network firewall group sync
+//-------------------------------------------------------------------------
+
+// unifiController is a UniFi Network controller from the "unifi" section of the configuration file.
+type unifiController struct {
+    URL      string `json:"url"`      // e.g. https://192.168.1.1 (UniFi OS) or https://unifi:8443.
+    User     string `json:"user"`     // Local admin account.
+    Password string `json:"password"` // Its password.
+    Site     string `json:"site"`     // Site name; defaults to "default".
+    UniFiOS  bool   `json:"unifi_os"` // Consoles running UniFi OS (UDM, UCG, Cloud Key Gen2) proxy the API under /proxy/network.
+    Insecure bool   `json:"insecure"` // Skip certificate verification (controllers use self-signed certificates by default).
+}
+
+// unifiClient is a logged-in session with a UniFi Network controller.
+type unifiClient struct {
+    http *http.Client
+    api  string // Base URL of the site API, e.g. https://host/proxy/network/api/s/default.
+    csrf string // X-CSRF-Token required by UniFi OS for changes.
+}
+
+// unifiFirewallGroup is a firewall group as returned by rest/firewallgroup.
+type unifiFirewallGroup struct {
+    ID      string   `json:"_id,omitempty"`
+    Name    string   `json:"name"`
+    Type    string   `json:"group_type"`
+    Members []string `json:"group_members"`
+}
+
+// loginUniFi opens a session with a controller.
+func loginUniFi(controller unifiController) (*unifiClient, error) {
+    jar, _ := cookiejar.New(nil)
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: controller.Insecure}
+    c := &unifiClient{http: &http.Client{Timeout: 30 * time.Second, Jar: jar, Transport: transport}}
+
+    base := strings.TrimSuffix(controller.URL, "/")
+    site := controller.Site
+    if site == "" {
+        site = "default"
+    }
+    loginURL := base + "/api/login"
+    c.api = base + "/api/s/" + site
+    if controller.UniFiOS {
+        loginURL = base + "/api/auth/login"
+        c.api = base + "/proxy/network/api/s/" + site
+    }
+    if _, err := c.do(http.MethodPost, loginURL, map[string]string{"username": controller.User, "password": controller.Password}, nil); err != nil {
+        return nil, fmt.Errorf("login failed: %v", err)
+    }
+    return c, nil
+}
+
+// do sends a JSON request and decodes the "data" array of the reply into out (if not nil).
+func (c *unifiClient) do(method, url string, body, out any) (*http.Response, error) {
+    var reader io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return nil, err
+        }
+        reader = bytes.NewReader(data)
+    }
+    req, err := http.NewRequest(method, url, reader)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if c.csrf != "" {
+        req.Header.Set("X-CSRF-Token", c.csrf)
+    }
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if token := resp.Header.Get("X-CSRF-Token"); token != "" {
+        c.csrf = token
+    }
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode/100 != 2 {
+        return nil, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(data)))
+    }
+    if out != nil {
+        var envelope struct {
+            Data json.RawMessage `json:"data"`
+        }
+        if err := json.Unmarshal(data, &envelope); err != nil {
+            return nil, err
+        }
+        if err := json.Unmarshal(envelope.Data, out); err != nil {
+            return nil, err
+        }
+    }
+    return resp, nil
+}
+
+// pushUniFi creates or updates an IPv4 address group on a UniFi controller with the prefixes of a set
+// of countries. The group is only written if its members differ, and the change is printed as a diff count.
+func pushUniFi(codes []string, controllerName, groupName string, dryRun bool) {
+    config, err := loadConfig()
+    if err != nil {
+        errorln(err)
+        return
+    }
+    controller, ok := configEntry(config.UniFi, controllerName, "unifi")
+    if !ok {
+        return
+    }
+    if groupName == "" {
+        groupName = "chicha_" + strings.Join(codes, "_")
+    }
+    cidrs, ok := countrySetPrefixes(codes)
+    if !ok {
+        return
+    }
+
+    statusf("Connecting to %s...\n", controller.URL)
+    c, err := loginUniFi(controller)
+    if err != nil {
+        errorf("Error connecting to the UniFi controller: %v\n", err)
+        return
+    }
+    var groups []unifiFirewallGroup
+    if _, err := c.do(http.MethodGet, c.api+"/rest/firewallgroup", nil, &groups); err != nil {
+        errorf("Error reading UniFi firewall groups: %v\n", err)
+        return
+    }
+    group := unifiFirewallGroup{Name: groupName, Type: "address-group"}
+    for _, g := range groups {
+        if g.Name == groupName {
+            group = g
+            break
+        }
+    }
+    if group.Type != "address-group" {
+        errorf("UniFi group %s exists but is not an IPv4 address group.\n", groupName)
+        return
+    }
+
+    current := make(map[string]bool, len(group.Members))
+    for _, member := range group.Members {
+        current[member] = true
+    }
+    added := 0
+    for _, cidr := range cidrs {
+        if !current[cidr] {
+            added++
+        }
+        delete(current, cidr)
+    }
+    removed := len(current)
+    statusf("UniFi group %s: %d members, %d to remove, %d to add\n", groupName, len(group.Members), removed, added)
+    if dryRun || (group.ID != "" && added == 0 && removed == 0) {
+        return
+    }
+
+    group.Members = cidrs
+    if group.ID == "" {
+        _, err = c.do(http.MethodPost, c.api+"/rest/firewallgroup", group, nil)
+    } else {
+        _, err = c.do(http.MethodPut, c.api+"/rest/firewallgroup/"+group.ID, group, nil)
+    }
+    if err != nil {
+        errorf("Error updating UniFi firewall group: %v\n", err)
+        return
+    }
+    statusf("UniFi group %s updated (%d members).\n", groupName, len(cidrs))
+}
+
+//-------------------------------------------------------------------------
+// OPNsense alias push
+//-------------------------------------------------------------------------
+
+// opnsenseFirewall is an OPNsense API target from the "opnsense" section of the configuration file.
+type opnsenseFirewall struct {
+    URL      string `json:"url"`      // e.g. https://192.168.1.1
+    Key      string `json:"key"`      // API key (System > Access > Users > API keys).
+    Secret   string `json:"secret"`   // API secret.
+    Insecure bool   `json:"insecure"` // Skip certificate verification (OPNsense uses a self-signed certificate by default).
+}
+
+// opnsenseAliasNameRe matches the alias names OPNsense accepts.
+var opnsenseAliasNameRe = regexp.MustCompile(`^[A-Za-z0-9_]{1,32}$`)
+
+// opnsenseCall sends a JSON request to the OPNsense API and decodes the reply into out.
+func opnsenseCall(client *http.Client, firewall opnsenseFirewall, method, path string, body, out any) error {
+    var reader io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        reader = bytes.NewReader(data)
+    }
+    req, err := http.NewRequest(method, strings.TrimSuffix(firewall.URL, "/")+path, reader)
+    if err != nil {
+        return err
+    }
+    req.SetBasicAuth(firewall.Key, firewall.Secret)
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return err
+    }
+    if resp.StatusCode/100 != 2 {
+        return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+    }
+    return json.Unmarshal(data, out)
+}
+
+// pushOPNsense creates or updates a network alias on an OPNsense firewall with the prefixes of a set
+// of countries and applies it with a reconfigure, so rules using the alias pick up the new list.
+func pushOPNsense(codes []string, firewallName, aliasName string) {
+    config, err := loadConfig()
+    if err != nil {
+        errorln(err)
+        return
+    }
+    firewall, ok := configEntry(config.OPNsense, firewallName, "opnsense")
+    if !ok {
+        return
+    }
+    if aliasName == "" {
+        aliasName = "chicha_" + strings.Join(codes, "_")
+    }
+    if !opnsenseAliasNameRe.MatchString(aliasName) {
+        errorf("Invalid alias name: %q (letters, digits and _, at most 32 characters)\n", aliasName)
+        return
+    }
+    cidrs, ok := countrySetPrefixes(codes)
+    if !ok {
+        return
+    }
+
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: firewall.Insecure}
+    client := &http.Client{Timeout: 120 * time.Second, Transport: transport}
+
+    statusf("Connecting to %s...\n", firewall.URL)
+    // getAliasUUID answers {"uuid": "..."} for an existing alias and an empty object or array otherwise.
+    var lookup json.RawMessage
+    if err := opnsenseCall(client, firewall, http.MethodGet, "/api/firewall/alias/getAliasUUID/"+aliasName, nil, &lookup); err != nil {
+        errorf("Error talking to the OPNsense API: %v\n", err)
+        return
+    }
+    var existing struct {
+        UUID string `json:"uuid"`
+    }
+    json.Unmarshal(lookup, &existing)
+
+    alias := map[string]any{"alias": map[string]string{
+        "enabled":     "1",
+        "name":        aliasName,
+        "type":        "network",
+        "content":     strings.Join(cidrs, "\n"),
+        "description": "chicha-whois " + strings.Join(codes, ","),
+    }}
+    path := "/api/firewall/alias/addItem"
+    if existing.UUID != "" {
+        path = "/api/firewall/alias/setItem/" + existing.UUID
+    }
+    var result struct {
+        Result      string         `json:"result"`
+        Validations map[string]any `json:"validations"`
+    }
+    if err := opnsenseCall(client, firewall, http.MethodPost, path, alias, &result); err != nil {
+        errorf("Error saving the OPNsense alias: %v\n", err)
+        return
+    }
+    if result.Result != "saved" {
+        errorf("Error saving the OPNsense alias: %s %v\n", result.Result, result.Validations)
+        return
+    }
+    var status struct {
+        Status string `json:"status"`
+    }
+    if err := opnsenseCall(client, firewall, http.MethodPost, "/api/firewall/alias/reconfigure", map[string]string{}, &status); err != nil {
+        errorf("Error applying the OPNsense aliases: %v\n", err)
+        return
+    }
+    statusf("OPNsense alias %s saved and applied (%d prefixes).\n", aliasName, len(cidrs))
+}
+
+//-------------------------------------------------------------------------
+// pfSense alias push
+//-------------------------------------------------------------------------
+
+// pfsenseFirewall is a pfSense target from the "pfsense" section of the configuration file. It needs
+// the REST API package (pfSense-pkg-RESTAPI, API v2).
+type pfsenseFirewall struct {
+    URL      string `json:"url"`      // e.g. https://192.168.1.1
+    Key      string `json:"key"`      // REST API key (System > REST API > Keys).
+    Insecure bool   `json:"insecure"` // Skip certificate verification (pfSense uses a self-signed certificate by default).
+}
+
+// pfsenseAlias is a firewall alias as exchanged with /api/v2/firewall/alias.
+type pfsenseAlias struct {
+    ID      *int     `json:"id,omitempty"`
+    Name    string   `json:"name"`
+    Type    string   `json:"type"`
+    Descr   string   `json:"descr"`
+    Address []string `json:"address"`
+}
+
+// pfsenseCall sends a JSON request to the pfSense REST API and decodes the "data" member of the reply into out.
+func pfsenseCall(client *http.Client, firewall pfsenseFirewall, method, path string, body, out any) error {
+    var reader io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        reader = bytes.NewReader(data)
+    }
+    req, err := http.NewRequest(method, strings.TrimSuffix(firewall.URL, "/")+path, reader)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("X-API-Key", firewall.Key)
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return err
+    }
+    if resp.StatusCode/100 != 2 {
+        return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+    }
+    if out == nil {
+        return nil
+    }
+    var envelope struct {
+        Data json.RawMessage `json:"data"`
+    }
+    if err := json.Unmarshal(data, &envelope); err != nil {
+        return err
+    }
+    return json.Unmarshal(envelope.Data, out)
+}
+
+// pushPfSense creates or updates a network alias on a pfSense firewall with the prefixes of a set of
+// countries and applies the pending changes, which reloads the filter. The alias is only written if
+// its addresses changed.
+func pushPfSense(codes []string, firewallName, aliasName string) {
+    config, err := loadConfig()
+    if err != nil {
+        errorln(err)
+        return
+    }
+    firewall, ok := configEntry(config.PfSense, firewallName, "pfsense")
+    if !ok {
+        return
+    }
+    if aliasName == "" {
+        aliasName = "chicha_" + strings.Join(codes, "_")
+    }
+    if !opnsenseAliasNameRe.MatchString(aliasName) {
+        errorf("Invalid alias name: %q (letters, digits and _, at most 32 characters)\n", aliasName)
+        return
+    }
+    cidrs, ok := countrySetPrefixes(codes)
+    if !ok {
+        return
+    }
+
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: firewall.Insecure}
+    client := &http.Client{Timeout: 120 * time.Second, Transport: transport}
+
+    statusf("Connecting to %s...\n", firewall.URL)
+    var aliases []pfsenseAlias
+    if err := pfsenseCall(client, firewall, http.MethodGet, "/api/v2/firewall/aliases?name="+url.QueryEscape(aliasName), nil, &aliases); err != nil {
+        errorf("Error talking to the pfSense REST API: %v\n", err)
+        return
+    }
+    alias := pfsenseAlias{Name: aliasName, Type: "network", Descr: "chicha-whois " + strings.Join(codes, ","), Address: cidrs}
+    method := http.MethodPost
+    for _, existing := range aliases {
+        if existing.Name != aliasName {
+            continue
+        }
+        // An alias of another type, host included, is not turned into a network alias behind the rules using it.
+        if existing.Type != "network" {
+            errorf("pfSense alias %s exists with type %s; only network aliases are updated.\n", aliasName, existing.Type)
+            return
+        }
+        if slices.Equal(existing.Address, cidrs) {
+            statusf("pfSense alias %s is up to date (%d prefixes).\n", aliasName, len(cidrs))
+            return
+        }
+        alias.ID = existing.ID
+        method = http.MethodPatch
+    }
+    if err := pfsenseCall(client, firewall, method, "/api/v2/firewall/alias", alias, nil); err != nil {
+        errorf("Error saving the pfSense alias: %v\n", err)
+        return
+    }
+    if err := pfsenseCall(client, firewall, http.MethodPost, "/api/v2/firewall/apply", map[string]any{}, nil); err != nil {
+        errorf("Error applying the pfSense changes: %v\n", err)
+        return
+    }
+    statusf("pfSense alias %s saved and the filter reloaded (%d prefixes).\n", aliasName, len(cidrs))
+}
+
+//-------------------------------------------------------------------------
+// NetBox prefix/aggregate sync
+//-------------------------------------------------------------------------
+
+// netboxInstance is a NetBox API target from the "netbox" section of the configuration file.
+type netboxInstance struct {
+    URL      string `json:"url"`      // e.g. https://netbox.example.com
+    Token    string `json:"token"`    // API token with write access to IPAM and tags.
+    RIR      string `json:"rir"`      // Slug of the RIR assigned to aggregates; defaults to "ripe".
+    Insecure bool   `json:"insecure"` // Skip certificate verification.
+}
+
+// netboxClient talks to the NetBox REST API.
+type netboxClient struct {
+    http     *http.Client
+    instance netboxInstance
+}
+
+// netboxObject is the part of a NetBox prefix or aggregate that the sync looks at.
+type netboxObject struct {
+    ID     int    `json:"id"`
+    Prefix string `json:"prefix"`
+}
+
+// call sends a JSON request to path (relative to /api/ or an absolute "next" URL) and decodes the reply into out.
+func (c *netboxClient) call(method, path string, body, out any) error {
+    var reader io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        reader = bytes.NewReader(data)
+    }
+    target := path
+    if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+        target = strings.TrimSuffix(c.instance.URL, "/") + "/api/" + path
+    }
+    req, err := http.NewRequest(method, target, reader)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", "Token "+c.instance.Token)
+    req.Header.Set("Accept", "application/json")
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return err
+    }
+    if resp.StatusCode/100 != 2 {
+        return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+    }
+    if out == nil || len(data) == 0 {
+        return nil
+    }
+    return json.Unmarshal(data, out)
+}
+
+// list fetches every object of a paginated listing.
+func (c *netboxClient) list(path string) ([]netboxObject, error) {
+    var all []netboxObject
+    for path != "" {
+        var page struct {
+            Next    *string        `json:"next"`
+            Results []netboxObject `json:"results"`
+        }
+        if err := c.call(http.MethodGet, path, nil, &page); err != nil {
+            return nil, err
+        }
+        all = append(all, page.Results...)
+        path = ""
+        if page.Next != nil {
+            path = *page.Next
+        }
+    }
+    return all, nil
+}
+
+// lookupID returns the id of the object with the given slug, or 0 if there is none.
+func (c *netboxClient) lookupID(endpoint, slug string) (int, error) {
+    var page struct {
+        Results []struct {
+            ID int `json:"id"`
+        } `json:"results"`
+    }
+    if err := c.call(http.MethodGet, endpoint+"?slug="+url.QueryEscape(slug), nil, &page); err != nil {
+        return 0, err
+    }
+    if len(page.Results) == 0 {
+        return 0, nil
+    }
+    return page.Results[0].ID, nil
+}
+
+// netboxBatchSize is the number of objects created or deleted per bulk request.
+const netboxBatchSize = 500
+
+// pushNetBox synchronizes the prefixes of each country into NetBox as prefixes or aggregates carrying
+// the tag TAGPREFIX+cc (e.g. country-ru): missing ones are created and tagged objects that are no longer
+// in the registry are deleted, so objects of your own (without the tag) are never touched.
+func pushNetBox(codes []string, instanceName, kind, tagPrefix string, dryRun bool) {
+    config, err := loadConfig()
+    if err != nil {
+        errorln(err)
+        return
+    }
+    instance, ok := configEntry(config.NetBox, instanceName, "netbox")
+    if !ok {
+        return
+    }
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: instance.Insecure}
+    c := &netboxClient{http: &http.Client{Timeout: 120 * time.Second, Transport: transport}, instance: instance}
+    endpoint := "ipam/prefixes/"
+    if kind == "aggregate" {
+        endpoint = "ipam/aggregates/"
+    }
+
+    statusf("Connecting to %s...\n", instance.URL)
+    rirID := 0
+    if kind == "aggregate" {
+        rir := instance.RIR
+        if rir == "" {
+            rir = "ripe"
+        }
+        if rirID, err = c.lookupID("ipam/rirs/", rir); err != nil || rirID == 0 {
+            errorf("NetBox RIR %q not found (set \"rir\" in the configuration file): %v\n", rir, err)
+            return
+        }
+    }
+
+    defer prefetchCountries(codes)()
+    for _, code := range codes {
+        cidrs, ok := countrySetPrefixes([]string{code})
+        if !ok {
+            continue
+        }
+        tag := tagPrefix + strings.ToLower(code)
+        // NetBox rejects a filter on an unknown tag, so the tag is looked up first; without it nothing was synced yet.
+        tagID, err := c.lookupID("extras/tags/", tag)
+        if err != nil {
+            errorf("Error reading NetBox tags: %v\n", err)
+            return
+        }
+        var existing []netboxObject
+        if tagID != 0 {
+            if existing, err = c.list(endpoint + "?tag=" + url.QueryEscape(tag) + "&limit=1000"); err != nil {
+                errorf("Error reading NetBox %s: %v\n", endpoint, err)
+                return
+            }
+        }
+        present := make(map[string]bool, len(existing))
+        var stale []map[string]int
+        wanted := make(map[string]bool, len(cidrs))
+        for _, cidr := range cidrs {
+            wanted[cidr] = true
+        }
+        for _, object := range existing {
+            if wanted[object.Prefix] && !present[object.Prefix] {
+                present[object.Prefix] = true
+            } else {
+                stale = append(stale, map[string]int{"id": object.ID})
+            }
+        }
+        var missing []map[string]any
+        for _, cidr := range cidrs {
+            if present[cidr] {
+                continue
+            }
+            object := map[string]any{
+                "prefix":      cidr,
+                "description": "RIPE NCC country " + code + " (chicha-whois)",
+                "tags":        []map[string]string{{"slug": tag}},
+            }
+            if kind == "aggregate" {
+                object["rir"] = rirID
+            }
+            missing = append(missing, object)
+        }
+        statusf("NetBox %s tagged %s: %d present, %d to delete, %d to create\n", strings.TrimSuffix(strings.TrimPrefix(endpoint, "ipam/"), "/"), tag, len(existing), len(stale), len(missing))
+        if dryRun || (len(stale) == 0 && len(missing) == 0) {
+            continue
+        }
+
+        if tagID == 0 {
+            if err := c.call(http.MethodPost, "extras/tags/", map[string]string{"name": tag, "slug": tag}, nil); err != nil {
+                errorf("Error creating NetBox tag %s: %v\n", tag, err)
+                return
+            }
+        }
+        for start := 0; start < len(stale); start += netboxBatchSize {
+            end := min(start+netboxBatchSize, len(stale))
+            if err := c.call(http.MethodDelete, endpoint, stale[start:end], nil); err != nil {
+                errorf("Error deleting NetBox objects: %v\n", err)
+                return
+            }
+        }
+        for start := 0; start < len(missing); start += netboxBatchSize {
+            end := min(start+netboxBatchSize, len(missing))
+            if err := c.call(http.MethodPost, endpoint, missing[start:end], nil); err != nil {
+                errorf("Error creating NetBox objects: %v\n", err)
+                return
+            }
+        }
+        statusf("NetBox synchronized for %s.\n", code)
+    }
+}
+
+//-------------------------------------------------------------------------
+// MikroTik RouterOS script export
+//-------------------------------------------------------------------------
+
+// routerOSScriptOptions holds the settings of -mikrotik.
+type routerOSScriptOptions struct {
+    List    string // Address-list name; derived from the country codes when empty.
+    Version int    // Target RouterOS major version (6 or 7).
+    Split   int    // If positive, at most this many entries per .rsc file.
+    Output  string // Output file; defaults to ~/mikrotik_CC.rsc.
+}
+
+// routerOSMenu returns the address-list menu path: RouterOS 7 accepts slash-separated paths,
+// RouterOS 6 only the space-separated form.
+func routerOSMenu(version int) string {
+    if version >= 7 {
+        return "/ip/firewall/address-list"
+    }
+    return "/ip firewall address-list"
+}
+
+// renderRouterOSScript renders one .rsc part. The first part empties the list before adding,
+// later parts only add.
+func renderRouterOSScript(opts routerOSScriptOptions, cidrs []string, first bool) string {
+    var sb strings.Builder
+    sb.WriteString(routerOSMenu(opts.Version) + "\n")
+    if first {
+        fmt.Fprintf(&sb, "remove [find list=\"%s\" dynamic=no]\n", opts.List)
+    }
+    for _, cidr := range cidrs {
+        fmt.Fprintf(&sb, "add list=\"%s\" address=%s\n", opts.List, routerOSAddress(cidr))
+    }
+    return sb.String()
+}
+
+// createRouterOSScript writes a RouterOS .rsc script for a set of countries. With Split, the entries go
+// into numbered parts (mikrotik_CC_1.rsc, ...) that stay within import limits, and the main file
+// imports them in order, so uploading all files and running /import on the main one is enough.
+func createRouterOSScript(codes []string, opts routerOSScriptOptions) {
+    statusf("Creating RouterOS %d script for: %s\n", opts.Version, strings.Join(codes, ", "))
+    if opts.List == "" {
+        opts.List = "chicha_" + strings.Join(codes, "_")
+    }
+    cidrs, ok := countrySetPrefixes(codes)
+    if !ok {
+        return
+    }
+    outFilePath := opts.Output
+    if outFilePath == "" {
+        homeDir, _ := os.UserHomeDir()
+        outFilePath = filepath.Join(homeDir, fmt.Sprintf("mikrotik_%s.rsc", strings.Join(codes, "_")))
+    }
+    selection := fmt.Sprintf("countries %s (filtered), address-list %s, RouterOS %d", strings.Join(codes, ", "), opts.List, opts.Version)
+
+    // Parts left over from an earlier run with more (or any) parts would be imported by mistake.
+    base := strings.TrimSuffix(outFilePath, ".rsc")
+    removeStaleParts := func(parts int) {
+        for n := parts + 1; ; n++ {
+            if removeOutput(fmt.Sprintf("%s_%d.rsc", base, n)) != nil {
+                break
+            }
+        }
+    }
+
+    if opts.Split <= 0 || len(cidrs) <= opts.Split {
+        meta := outputMeta{Selection: selection, Entries: len(cidrs), Comment: "#"}
+        if err := writeOutputFile(outFilePath, renderRouterOSScript(opts, cidrs, true), meta); err != nil {
+            errorf("Error writing RouterOS script: %v\n", err)
+            return
+        }
+        removeStaleParts(0)
+        statusf("RouterOS script created at: %s (%d entries)\n", outFilePath, len(cidrs))
+        statusf("Upload it and run: /import file-name=%s\n", filepath.Base(outFilePath))
+        return
+    }
+
+    var index strings.Builder
+    part := 0
+    for start := 0; start < len(cidrs); start += opts.Split {
+        part++
+        end := min(start+opts.Split, len(cidrs))
+        partPath := fmt.Sprintf("%s_%d.rsc", base, part)
+        meta := outputMeta{Selection: fmt.Sprintf("%s, part %d", selection, part), Entries: end - start, Comment: "#"}
+        if err := writeOutputFile(partPath, renderRouterOSScript(opts, cidrs[start:end], part == 1), meta); err != nil {
+            errorf("Error writing RouterOS script: %v\n", err)
+            return
+        }
+        fmt.Fprintf(&index, "/import file-name=%s\n", filepath.Base(partPath))
+    }
+    meta := outputMeta{Selection: selection, Entries: len(cidrs), Comment: "#"}
+    if err := writeOutputFile(outFilePath, index.String(), meta); err != nil {
+        errorf("Error writing RouterOS script: %v\n", err)
+        return
+    }
+    removeStaleParts(part)
+    statusf("RouterOS script created at: %s (%d entries in %d parts)\n", outFilePath, len(cidrs), part)
+    statusf("Upload %s and %s_*.rsc and run: /import file-name=%s\n", filepath.Base(outFilePath), filepath.Base(base), filepath.Base(outFilePath))
+}
+
+//-------------------------------------------------------------------------
+// MikroTik RouterOS API push
+//-------------------------------------------------------------------------
+
+// mikrotikRouter is a RouterOS API target from the "mikrotik" section of the configuration file.
+type mikrotikRouter struct {
+    Address  string `json:"address"`  // host or host:port; the port defaults to 8728 (8729 with TLS).
+    User     string `json:"user"`     // API user; needs write access to /ip firewall address-list.
+    Password string `json:"password"` // API password.
+    TLS      bool   `json:"tls"`      // Use the api-ssl service.
+    Insecure bool   `json:"insecure"` // Skip certificate verification (RouterOS uses self-signed certificates by default).
+    List     string `json:"list"`     // Default address-list name.
+}
+
+// routerOSTimeout bounds each exchange with a router: a command (or batch) and its replies.
+// routerOSMaxWord is the longest reply word accepted; the length prefix comes from the network.
+const (
+    routerOSTimeout = 60 * time.Second
+    routerOSMaxWord = 1 << 20
+)
+
+// routerOSConn is a minimal RouterOS API client: sentences of length-prefixed words.
+type routerOSConn struct {
+    conn net.Conn
+    r    *bufio.Reader
+    w    *bufio.Writer
+}
+
+// dialRouterOS connects to a router and logs in (post-6.43 plain login).
+func dialRouterOS(router mikrotikRouter) (*routerOSConn, error) {
+    addr := router.Address
+    if _, _, err := net.SplitHostPort(addr); err != nil {
+        if router.TLS {
+            addr = net.JoinHostPort(addr, "8729")
+        } else {
+            addr = net.JoinHostPort(addr, "8728")
+        }
+    }
+    dialer := &net.Dialer{Timeout: 10 * time.Second}
+    var conn net.Conn
+    var err error
+    if router.TLS {
+        conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: router.Insecure})
+    } else {
+        conn, err = dialer.Dial("tcp", addr)
+    }
+    if err != nil {
+        return nil, err
+    }
+    c := &routerOSConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+    if err := c.send("/login", "=name="+router.User, "=password="+router.Password); err != nil {
+        c.Close()
+        return nil, err
+    }
+    if err := c.flush(); err != nil {
+        c.Close()
+        return nil, err
+    }
+    if _, err := c.readUntilDone(); err != nil {
+        c.Close()
+        return nil, fmt.Errorf("login failed: %v", err)
+    }
+    return c, nil
+}
+
+// Close closes the underlying connection.
+func (c *routerOSConn) Close() error {
+    return c.conn.Close()
+}
+
+// writeLength writes a word length in the variable-size encoding of the API.
+func (c *routerOSConn) writeLength(n int) {
+    switch {
+    case n < 0x80:
+        c.w.WriteByte(byte(n))
+    case n < 0x4000:
+        c.w.Write([]byte{byte(n>>8) | 0x80, byte(n)})
+    case n < 0x200000:
+        c.w.Write([]byte{byte(n>>16) | 0xC0, byte(n >> 8), byte(n)})
+    case n < 0x10000000:
+        c.w.Write([]byte{byte(n>>24) | 0xE0, byte(n >> 16), byte(n >> 8), byte(n)})
+    default:
+        c.w.Write([]byte{0xF0, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+    }
+}
+
+// send buffers one sentence; call w.Flush to transmit.
+func (c *routerOSConn) send(words ...string) error {
+    for _, word := range words {
+        c.writeLength(len(word))
+        if _, err := c.w.WriteString(word); err != nil {
+            return err
+        }
+    }
+    return c.w.WriteByte(0)
+}
+
+// flush transmits the buffered sentences and gives them, and the replies to them, routerOSTimeout
+// to complete, so that a router that stops answering does not hang the push.
+func (c *routerOSConn) flush() error {
+    if err := c.conn.SetDeadline(time.Now().Add(routerOSTimeout)); err != nil {
+        return err
+    }
+    return c.w.Flush()
+}
+
+// readLength reads a word length.
+func (c *routerOSConn) readLength() (int, error) {
+    first, err := c.r.ReadByte()
+    if err != nil {
+        return 0, err
+    }
+    var extra int
+    n := int(first)
+    switch {
+    case first&0x80 == 0:
+        return n, nil
+    case first&0xC0 == 0x80:
+        n, extra = n&0x3F, 1
+    case first&0xE0 == 0xC0:
+        n, extra = n&0x1F, 2
+    case first&0xF0 == 0xE0:
+        n, extra = n&0x0F, 3
+    default:
+        n, extra = 0, 4
+    }
+    for i := 0; i < extra; i++ {
+        b, err := c.r.ReadByte()
+        if err != nil {
+            return 0, err
+        }
+        n = n<<8 | int(b)
+    }
+    if n > routerOSMaxWord {
+        return 0, fmt.Errorf("routeros reply word of %d bytes exceeds the %d byte limit", n, routerOSMaxWord)
+    }
+    return n, nil
+}
+
+// readSentence reads one reply sentence: its type ("!re", "!done", "!trap", ...) and attributes.
+func (c *routerOSConn) readSentence() (string, map[string]string, error) {
+    var reply string
+    attrs := make(map[string]string)
+    for {
+        n, err := c.readLength()
+        if err != nil {
+            return "", nil, err
+        }
+        if n == 0 {
+            return reply, attrs, nil
+        }
+        buf := make([]byte, n)
+        if _, err := io.ReadFull(c.r, buf); err != nil {
+            return "", nil, err
+        }
+        word := string(buf)
+        if reply == "" {
+            reply = word
+            continue
+        }
+        if key, value, ok := strings.Cut(strings.TrimPrefix(word, "="), "="); ok {
+            attrs[key] = value
+        }
+    }
+}
+
+// readUntilDone collects the !re sentences of one command; a !trap or !fatal is returned as an error.
+func (c *routerOSConn) readUntilDone() ([]map[string]string, error) {
+    var rows []map[string]string
+    var trap error
+    for {
+        reply, attrs, err := c.readSentence()
+        if err != nil {
+            return nil, err
+        }
+        switch reply {
+        case "!re":
+            rows = append(rows, attrs)
+        case "!trap":
+            trap = fmt.Errorf("routeros: %s", attrs["message"])
+        case "!fatal":
+            return nil, fmt.Errorf("routeros: %s", attrs["message"])
+        case "!done":
+            return rows, trap
+        }
+    }
+}
+
+// routerOSAddress normalizes a prefix the way RouterOS shows it: single addresses without /32.
+func routerOSAddress(cidr string) string {
+    return strings.TrimSuffix(cidr, "/32")
+}
+
+// routerOSBatchSize is the number of commands sent before waiting for their replies.
+const routerOSBatchSize = 100
+
+// pushMikroTik synchronizes an address-list on a router with the prefixes of a set of countries.
+// Only the difference is applied (removals first, then additions, pipelined), so a daily update
+// takes seconds instead of the minutes a full .rsc re-import takes. Dynamic entries are left alone.
+func pushMikroTik(codes []string, routerName, list string, dryRun bool) {
+    config, err := loadConfig()
+    if err != nil {
+        errorln(err)
+        return
+    }
+    router, ok := configEntry(config.MikroTik, routerName, "mikrotik")
+    if !ok {
+        return
+    }
+    if list == "" {
+        list = router.List
+    }
+    if list == "" {
+        list = "chicha_" + strings.Join(codes, "_")
+    }
+
+    cidrs, ok := countrySetPrefixes(codes)
+    if !ok {
+        return
+    }
+    desired := make(map[string]bool, len(cidrs))
+    for _, cidr := range cidrs {
+        desired[routerOSAddress(cidr)] = true
+    }
+
+    statusf("Connecting to %s...\n", router.Address)
+    c, err := dialRouterOS(router)
+    if err != nil {
+        errorf("Error connecting to RouterOS: %v\n", err)
+        return
+    }
+    defer c.Close()
+
+    c.send("/ip/firewall/address-list/print", "=.proplist=.id,address,dynamic", "?list="+list)
+    if err := c.flush(); err != nil {
+        errorf("Error talking to RouterOS: %v\n", err)
+        return
+    }
+    rows, err := c.readUntilDone()
+    if err != nil {
+        errorf("Error reading address-list %s: %v\n", list, err)
+        return
+    }
+    var stale []string
+    present := make(map[string]bool, len(rows))
+    for _, row := range rows {
+        if row["dynamic"] == "true" {
+            continue
+        }
+        address := routerOSAddress(row["address"])
+        if desired[address] && !present[address] {
+            present[address] = true
+        } else {
+            stale = append(stale, row[".id"])
+        }
+    }
+    var missing []string
+    for _, cidr := range cidrs {
+        if !present[routerOSAddress(cidr)] {
+            missing = append(missing, routerOSAddress(cidr))
+        }
+    }
+    statusf("Address-list %s: %d entries on the router, %d to remove, %d to add\n", list, len(rows), len(stale), len(missing))
+    if dryRun || (len(stale) == 0 && len(missing) == 0) {
+        return
+    }
+
+    // Commands are pipelined in batches; each one is answered by exactly one !done.
+    var commands [][]string
+    for start := 0; start < len(stale); start += routerOSBatchSize {
+        end := min(start+routerOSBatchSize, len(stale))
+        commands = append(commands, []string{"/ip/firewall/address-list/remove", "=.id=" + strings.Join(stale[start:end], ",")})
+    }
+    comment := "chicha-whois " + strings.Join(codes, ",")
+    for _, address := range missing {
+        commands = append(commands, []string{"/ip/firewall/address-list/add", "=list=" + list, "=address=" + address, "=comment=" + comment})
+    }
+    failed := 0
+    for start := 0; start < len(commands); start += routerOSBatchSize {
+        end := min(start+routerOSBatchSize, len(commands))
+        for _, command := range commands[start:end] {
+            c.send(command...)
+        }
+        if err := c.flush(); err != nil {
+            errorf("Error talking to RouterOS: %v\n", err)
+            return
+        }
+        for range commands[start:end] {
+            if _, err := c.readUntilDone(); err != nil {
+                if !strings.HasPrefix(err.Error(), "routeros: ") {
+                    errorf("Error talking to RouterOS: %v\n", err)
+                    return
+                }
+                warnf("RouterOS rejected a change: %v\n", err)
+                failed++
+            }
+        }
+    }
+    statusf("Address-list %s synchronized (%d changes, %d rejected).\n", list, len(commands)-failed, failed)
+}
+
+//-------------------------------------------------------------------------
+// Full database and object index
+//-------------------------------------------------------------------------
+
+// ripeFullDBURL is the combined dump with every object type in one file.
+const ripeFullDBURL = "https://ftp.ripe.net/ripe/dbase/ripe.db.gz"
+
+// fullDBPath returns the cache file of the combined dump downloaded by -u full.
+func fullDBPath() string {
+    return filepath.Join(cacheDir, "ripe.db")
+}
+
+// objectIndexPath returns the index file kept next to the combined dump.
+func objectIndexPath() string {
+    return fullDBPath() + ".index"
+}
+
+// indexedKeyTypes maps the object types that can be looked up by key to their key attribute.
+// Bulk types (inetnum, route, domain, person...) are only indexed by type, which keeps the index small.
+var indexedKeyTypes = map[string]string{
+    "organisation": "organisation",
+    "aut-num":      "aut-num",
+    "role":         "nic-hdl",
+    "mntner":       "mntner",
+    "as-set":       "as-set",
+    "irt":          "irt",
+}
+
+// objectRef locates one object inside the combined dump.
+type objectRef struct {
+    Offset int64
+    Length int32
+}
+
+// objectIndex lists the objects of each type in file order and the keyed objects by "TYPE KEY".
+type objectIndex struct {
+    DBSize    int64                  // Size of the dump the index was built from.
+    DBModTime time.Time              // Modification time of that dump.
+    Types     map[string][]objectRef // Objects per type, in file order.
+    Keys      map[string]objectRef   // "TYPE KEY" (upper case key) to object, for indexedKeyTypes.
+}
+
+// objectIndexKey builds the key used in objectIndex.Keys.
+func objectIndexKey(objectType, key string) string {
+    return objectType + " " + strings.ToUpper(strings.TrimSpace(key))
+}
+
+// buildObjectIndex reads the combined dump once and records the position of every object.
+func buildObjectIndex(dbPath string) (*objectIndex, error) {
+    file, err := os.Open(dbPath)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    fi, err := file.Stat()
+    if err != nil {
+        return nil, err
+    }
+
+    idx := &objectIndex{DBSize: fi.Size(), DBModTime: fi.ModTime(), Types: make(map[string][]objectRef), Keys: make(map[string]objectRef)}
+    reader := bufio.NewReaderSize(file, 1<<20)
+    var offset, start int64
+    var objectType string
+    var blockLines []string
+
+    finish := func(end int64) {
+        if objectType != "" {
+            ref := objectRef{Offset: start, Length: int32(end - start)}
+            idx.Types[objectType] = append(idx.Types[objectType], ref)
+            if keyAttr, ok := indexedKeyTypes[objectType]; ok {
+                if key := blockAttr(blockLines, keyAttr); key != "" {
+                    idx.Keys[objectIndexKey(objectType, key)] = ref
+                }
+            }
+        }
+        objectType, blockLines = "", nil
+    }
+
+    for {
+        line, err := reader.ReadString('\n')
+        if len(line) > 0 {
+            trimmed := strings.TrimRight(line, "\r\n")
+            switch {
+            case trimmed == "":
+                finish(offset)
+            case strings.HasPrefix(trimmed, "%") || strings.HasPrefix(trimmed, "#"):
+                // Comments between objects are not part of any object.
+            default:
+                if objectType == "" {
+                    name, _, _ := strings.Cut(trimmed, ":")
+                    objectType, start = strings.ToLower(name), offset
+                }
+                if _, keyed := indexedKeyTypes[objectType]; keyed {
+                    blockLines = append(blockLines, trimmed)
+                }
+            }
+            offset += int64(len(line))
+        }
+        if err == io.EOF {
+            finish(offset)
+            return idx, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+}
+
+// saveObjectIndex writes the index next to the combined dump.
+func saveObjectIndex(idx *objectIndex) error {
+    path := objectIndexPath()
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    defer tmp.Close()
+    if err := tmp.Chmod(0644); err != nil {
+        return err
+    }
+    w := bufio.NewWriter(tmp)
+    if err := gob.NewEncoder(w).Encode(idx); err != nil {
+        return err
+    }
+    if err := w.Flush(); err != nil {
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+// loadObjectIndex returns the index of the combined dump, rebuilding it when the dump changed.
+func loadObjectIndex() (*objectIndex, error) {
+    fi, err := os.Stat(fullDBPath())
+    if err != nil {
+        return nil, err
+    }
+    if file, err := os.Open(objectIndexPath()); err == nil {
+        var idx objectIndex
+        decodeErr := gob.NewDecoder(bufio.NewReader(file)).Decode(&idx)
+        file.Close()
+        if decodeErr == nil && idx.DBSize == fi.Size() && idx.DBModTime.Equal(fi.ModTime()) {
+            return &idx, nil
+        }
+    }
+
+    statusln("Indexing the full RIPE database...")
+    idx, err := buildObjectIndex(fullDBPath())
+    if err != nil {
+        return nil, err
+    }
+    if err := saveObjectIndex(idx); err != nil {
+        warnf("Warning: unable to save the object index: %v\n", err)
+    }
+    return idx, nil
+}
+
+// readObject reads one referenced object of the combined dump without reading what precedes it.
+func readObject(ref objectRef) ([]string, error) {
+    file, err := os.Open(fullDBPath())
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    buf := make([]byte, ref.Length)
+    if _, err := file.ReadAt(buf, ref.Offset); err != nil {
+        return nil, err
+    }
+    return strings.Split(strings.TrimRight(string(buf), "\r\n"), "\n"), nil
+}
+
+// readObjects calls fn for each referenced object of the combined dump in one buffered forward
+// pass, as scanObjects needs for whole types; refs must be in file order.
+func readObjects(refs []objectRef, fn func(blockLines []string)) error {
+    file, err := os.Open(fullDBPath())
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    reader := bufio.NewReaderSize(file, 1<<20)
+    var pos int64
+    for _, ref := range refs {
+        if _, err := reader.Discard(int(ref.Offset - pos)); err != nil {
+            return err
+        }
+        buf := make([]byte, ref.Length)
+        if _, err := io.ReadFull(reader, buf); err != nil {
+            return err
+        }
+        pos = ref.Offset + int64(ref.Length)
+        fn(strings.Split(strings.TrimRight(string(buf), "\r\n"), "\n"))
+    }
+    return nil
+}
+
+// scanObjects calls fn for every object of a type. The per-type cache from -u TYPE is preferred;
+// otherwise the combined dump from -u full is read through its index.
+func scanObjects(objectType string, fn func(blockLines []string)) error {
+    if path := objectCachePath(objectType); fileExists(path) {
+        return scanBlocks(path, func(blockLines []string) {
+            name, _, _ := strings.Cut(blockLines[0], ":")
+            if strings.EqualFold(name, objectType) {
+                fn(blockLines)
+            }
+        })
+    }
+    if !fileExists(fullDBPath()) {
+        return fmt.Errorf("no %s objects in the cache; run chicha-whois -u %s or -u full", objectType, objectType)
+    }
+    idx, err := loadObjectIndex()
+    if err != nil {
+        return err
+    }
+    return readObjects(idx.Types[objectType], fn)
+}
+
+// lookupObject finds one object by type and primary key (for person and role objects, the nic-hdl).
+func lookupObject(objectType, key string) ([]string, error) {
+    objectType = strings.ToLower(objectType)
+    normalize := func(value string) string {
+        return strings.ToUpper(strings.Join(strings.Fields(value), " "))
+    }
+    keyAttr := objectType
+    if objectType == "person" || objectType == "role" {
+        keyAttr = "nic-hdl"
+    }
+
+    // Keyed types in the combined dump are found without scanning.
+    if _, keyed := indexedKeyTypes[objectType]; keyed && !fileExists(objectCachePath(objectType)) && fileExists(fullDBPath()) {
+        idx, err := loadObjectIndex()
+        if err != nil {
+            return nil, err
+        }
+        ref, ok := idx.Keys[objectIndexKey(objectType, key)]
+        if !ok {
+            return nil, nil
+        }
+        return readObject(ref)
+    }
+
+    var found []string
+    want := normalize(key)
+    err := scanObjects(objectType, func(blockLines []string) {
+        if found == nil && normalize(blockAttr(blockLines, keyAttr)) == want {
+            found = blockLines
+        }
+    })
+    return found, err
+}
+
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+    _, err := os.Stat(path)
+    return err == nil
+}
+
+// objectReferenceAttrs are the attributes whose values name other objects, with the type they refer to.
+var objectReferenceAttrs = []struct{ Attr, Type string }{
+    {"org", "organisation"},
+    {"abuse-c", "role"},
+    {"admin-c", "role"},
+    {"tech-c", "role"},
+    {"mnt-by", "mntner"},
+    {"mnt-irt", "irt"},
+    {"origin", "aut-num"},
+}
+
+// showObject prints an object, the objects it references and, for inetnum and route objects,
+// the related address objects of the other kind.
+func showObject(objectType, key string) {
+    objectType = strings.ToLower(objectType)
+    blockLines, err := lookupObject(objectType, key)
+    if err != nil {
+        errorln(err)
+        return
+    }
+    if blockLines == nil {
+        statusf("No %s object found for %s\n", objectType, key)
+        return
+    }
+    fmt.Println(strings.Join(blockLines, "\n"))
+
+    seen := make(map[string]bool)
+    for _, ref := range objectReferenceAttrs {
+        for _, line := range blockLines {
+            name, value, found := strings.Cut(line, ":")
+            value = strings.TrimSpace(value)
+            if !found || !strings.EqualFold(strings.TrimSpace(name), ref.Attr) || value == "" || seen[ref.Type+value] {
+                continue
+            }
+            seen[ref.Type+value] = true
+            referenced, err := lookupObject(ref.Type, value)
+            if err != nil || referenced == nil {
+                fmt.Printf(tr("\n%% %s %s: not found in the cache\n"), ref.Attr, value)
+                continue
+            }
+            fmt.Printf("\n%% %s %s\n%s\n", ref.Attr, value, strings.Join(referenced, "\n"))
+        }
+    }
+
+    switch objectType {
+    case "inetnum":
+        // Route objects announced from inside this block.
+        start, end, ok := parseInetnumRange(blockAttr(blockLines, "inetnum"))
+        if !ok {
+            return
+        }
+        fmt.Printf(tr("\n%% Route objects within %s\n"), blockAttr(blockLines, "inetnum"))
+        err = scanObjects("route", func(route []string) {
+            _, ipNet, err := net.ParseCIDR(blockAttr(route, "route"))
+            if err != nil || ipNet.IP.To4() == nil {
+                return
+            }
+            first := binary.BigEndian.Uint32(ipNet.IP.To4())
+            last := binary.BigEndian.Uint32(lastIP(ipNet))
+            if first >= start && last <= end {
+                fmt.Printf("%s %s\n", ipNet, blockAttr(route, "origin"))
+            }
+        })
+    case "route":
+        // Registered blocks that contain the announced prefix.
+        _, ipNet, parseErr := net.ParseCIDR(blockAttr(blockLines, "route"))
+        if parseErr != nil || ipNet.IP.To4() == nil {
+            return
+        }
+        first := binary.BigEndian.Uint32(ipNet.IP.To4())
+        last := binary.BigEndian.Uint32(lastIP(ipNet))
+        fmt.Printf(tr("\n%% Inetnum objects containing %s\n"), ipNet)
+        err = scanObjects("inetnum", func(inetnum []string) {
+            start, end, ok := parseInetnumRange(blockAttr(inetnum, "inetnum"))
+            if ok && start <= first && end >= last {
+                fmt.Printf("%s %s %s\n", blockAttr(inetnum, "inetnum"), blockAttr(inetnum, "netname"), strings.ToUpper(blockAttr(inetnum, "country")))
+            }
+        })
+    }
+    if err != nil {
+        errorln(err)
+    }
+}
+
+//-------------------------------------------------------------------------
+// Contact (person/role) search
+//-------------------------------------------------------------------------
+
+// contactPrivateAttrs are attributes that -contact leaves out unless -full is given.
+var contactPrivateAttrs = map[string]bool{"address": true, "phone": true, "fax-no": true}
+
+// contactEmailAttrs are attributes holding e-mail addresses, masked unless -full is given.
+var contactEmailAttrs = map[string]bool{"e-mail": true, "abuse-mailbox": true}
+
+// maskEmail hides the local part of an e-mail address except its first character: j***@example.com.
+func maskEmail(email string) string {
+    local, domain, found := strings.Cut(email, "@")
+    if !found || local == "" {
+        return "***"
+    }
+    return local[:1] + "***@" + domain
+}
+
+// searchContacts prints person and role objects whose nic-hdl, name or e-mail addresses contain the query.
+// By default postal addresses and phone numbers are left out and e-mail addresses are masked; full shows
+// the objects as stored. With blocks, the country's inetnum blocks referring to each match are listed too.
+func searchContacts(query string, full bool, blocksCountry string) {
+    query = strings.ToLower(strings.TrimSpace(query))
+    matches := make(map[string]string) // nic-hdl to name, for the block listing.
+    var handles []string
+
+    // Either cache is enough; the error is reported only if neither role nor person objects are available.
+    var scanErr error
+    scanned := false
+    for _, objectType := range []string{"role", "person"} {
+        err := scanObjects(objectType, func(blockLines []string) {
+            matched := false
+            for _, line := range blockLines {
+                name, value, found := strings.Cut(line, ":")
+                name = strings.ToLower(strings.TrimSpace(name))
+                if found && (name == objectType || name == "nic-hdl" || contactEmailAttrs[name]) &&
+                    strings.Contains(strings.ToLower(value), query) {
+                    matched = true
+                    break
+                }
+            }
+            if !matched {
+                return
+            }
+
+            for _, line := range blockLines {
+                name, value, _ := strings.Cut(line, ":")
+                name = strings.ToLower(strings.TrimSpace(name))
+                switch {
+                case full:
+                    fmt.Println(line)
+                case contactPrivateAttrs[name]:
+                case contactEmailAttrs[name]:
+                    fmt.Printf("%-16s%s\n", name+":", maskEmail(strings.TrimSpace(value)))
+                default:
+                    fmt.Println(line)
+                }
+            }
+            fmt.Println()
+
+            handle := strings.ToUpper(blockAttr(blockLines, "nic-hdl"))
+            if _, seen := matches[handle]; handle != "" && !seen {
+                matches[handle] = blockAttr(blockLines, objectType)
+                handles = append(handles, handle)
+            }
+        })
+        if err != nil {
+            scanErr = err
+        } else {
+            scanned = true
+        }
+    }
+    if !scanned {
+        errorln("Error reading the cached objects:", scanErr)
+        return
+    }
+    if len(handles) == 0 {
+        statusf("No person or role objects match %q\n", query)
+        return
+    }
+    if blocksCountry == "" {
+        return
+    }
+
+    // Blocks of the country that name one of the matching contacts.
+    fmt.Printf(tr("%% Inetnum objects of %s referring to the matching contacts\n"), blocksCountry)
+    err := scanBlocks(ripedbPath, func(blockLines []string) {
+        if strings.ToUpper(blockAttr(blockLines, "country")) != blocksCountry {
+            return
+        }
+        for _, line := range blockLines {
+            name, value, found := strings.Cut(line, ":")
+            name = strings.ToLower(strings.TrimSpace(name))
+            if !found || (name != "admin-c" && name != "tech-c" && name != "abuse-c") {
+                continue
+            }
+            handle := strings.ToUpper(strings.TrimSpace(value))
+            if contact, ok := matches[handle]; ok {
+                fmt.Printf("%s\t%s\t%s %s (%s)\n", blockAttr(blockLines, "inetnum"), blockAttr(blockLines, "netname"), name, handle, contact)
+            }
+        }
+    })
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+    }
+}
+
+//-------------------------------------------------------------------------
+// Abuse contacts
+//-------------------------------------------------------------------------
+
+// abuseResolver maps inetnum blocks to abuse mailboxes through their abuse-c role or, failing that,
+// the abuse-c (or legacy abuse-mailbox) of their organisation.
+type abuseResolver struct {
+    lookup func(objectType, key string) []string // Returns the object or nil.
+}
+
+// newAbuseResolver returns a resolver that looks each referenced object up in the cache on demand.
+func newAbuseResolver() *abuseResolver {
+    return &abuseResolver{lookup: func(objectType, key string) []string {
+        blockLines, _ := lookupObject(objectType, key)
+        return blockLines
+    }}
+}
+
+// loadAbuseResolver reads the abuse attributes of all role and organisation objects once,
+// for callers that resolve many blocks.
+func loadAbuseResolver() (*abuseResolver, error) {
+    objects := make(map[string][]string)
+    keep := func(objectType, keyAttr string) error {
+        return scanObjects(objectType, func(blockLines []string) {
+            var attrs []string
+            for _, line := range blockLines {
+                name, _, _ := strings.Cut(line, ":")
+                if name = strings.ToLower(strings.TrimSpace(name)); name == "abuse-c" || name == "abuse-mailbox" {
+                    attrs = append(attrs, line)
+                }
+            }
+            if attrs != nil {
+                objects[objectIndexKey(objectType, blockAttr(blockLines, keyAttr))] = attrs
+            }
+        })
+    }
+    if err := keep("role", "nic-hdl"); err != nil {
+        return nil, err
+    }
+    if err := keep("organisation", "organisation"); err != nil {
+        return nil, err
+    }
+    return &abuseResolver{lookup: func(objectType, key string) []string {
+        return objects[objectIndexKey(objectType, key)]
+    }}, nil
+}
+
+// mailbox returns the abuse mailbox of an inetnum block, or "" if none can be resolved.
+func (a *abuseResolver) mailbox(blockLines []string) string {
+    fromRole := func(nicHdl string) string {
+        if nicHdl == "" {
+            return ""
+        }
+        return blockAttr(a.lookup("role", nicHdl), "abuse-mailbox")
+    }
+    if mailbox := fromRole(blockAttr(blockLines, "abuse-c")); mailbox != "" {
+        return mailbox
+    }
+    if org := blockAttr(blockLines, "org"); org != "" {
+        orgLines := a.lookup("organisation", org)
+        if mailbox := fromRole(blockAttr(orgLines, "abuse-c")); mailbox != "" {
+            return mailbox
+        }
+        return blockAttr(orgLines, "abuse-mailbox")
+    }
+    return ""
+}
+
+// showAbuseContact prints the abuse mailbox for an address or prefix. Blocks are tried from the most
+// specific to the least specific, as RIPE inherits abuse-c from the enclosing allocation.
+func showAbuseContact(target string) {
+    var first, last uint32
+    if _, ipNet, err := net.ParseCIDR(target); err == nil && ipNet.IP.To4() != nil {
+        first = binary.BigEndian.Uint32(ipNet.IP.To4())
+        last = binary.BigEndian.Uint32(lastIP(ipNet))
+    } else if ip := net.ParseIP(target).To4(); ip != nil {
+        first = binary.BigEndian.Uint32(ip)
+        last = first
+    } else {
+        errorf("Invalid IPv4 address or prefix: %s\n", target)
+        return
+    }
+
+    type candidate struct {
+        size       uint32
+        blockLines []string
+    }
+    var candidates []candidate
+    err := scanBlocks(ripedbPath, func(blockLines []string) {
+        start, end, ok := parseInetnumRange(blockAttr(blockLines, "inetnum"))
+        if ok && start <= first && end >= last {
+            candidates = append(candidates, candidate{end - start, blockLines})
+        }
+    })
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+    sort.Slice(candidates, func(i, j int) bool { return candidates[i].size < candidates[j].size })
+
+    resolver := newAbuseResolver()
+    for _, c := range candidates {
+        if mailbox := resolver.mailbox(c.blockLines); mailbox != "" {
+            fmt.Printf("%s\t%s\t%s\t%s\n", mailbox, blockAttr(c.blockLines, "inetnum"), blockAttr(c.blockLines, "netname"), strings.ToUpper(blockAttr(c.blockLines, "country")))
+            return
+        }
+    }
+    statusf("No abuse contact found for %s (run chicha-whois -u role,organisation or -u full)\n", target)
+}
+
+// csvExportFields are the columns -csv -fields can choose, in their default order.
+var csvExportFields = []string{"inetnum", "cidr", "cidrs", "country", "netname", "descr", "org", "mnt-by", "last-modified", "status", "abuse_mailbox", "origin_asn"}
+
+// csvExportOptions holds the settings of -csv without -profile.
+type csvExportOptions struct {
+    Abuse  bool     // Add the abuse_mailbox column.
+    Fields []string // Columns to write; nil selects inetnum, cidr, country and netname.
+    TSV    bool     // Separate the columns with tabs; the file is then ripe_CC.tsv.
+    Output string   // Output file; defaults to ~/ripe_CC.csv.
+}
+
+// createCSVExport writes one row per inetnum block of a country, by default with the columns inetnum,
+// cidr (the smallest prefix covering the block), country and netname; the cidrs column holds the
+// space-separated prefixes of the block under --cidr-strategy instead. The resolved abuse mailbox can
+// be added as an extra column, and origin_asn is added when
+// route objects are cached. Attributes that occur several times (descr, mnt-by) are joined with "; ".
+// Blocks without an abuse contact of their own inherit the one of the nearest enclosing block of the
+// same country.
+func createCSVExport(countryCode string, opts csvExportOptions) {
+    // Origin ASNs are added when route objects are cached.
+    origins := loadRouteOrigins()
+    header := opts.Fields
+    switch {
+    case header == nil:
+        header = []string{"inetnum", "cidr", "country", "netname"}
+        if opts.Abuse {
+            header = append(header, "abuse_mailbox")
+        }
+        if origins != nil {
+            header = append(header, "origin_asn")
+        }
+    case opts.Abuse && !slices.Contains(header, "abuse_mailbox"):
+        header = append(header, "abuse_mailbox")
+    }
+    abuseColumn := slices.Index(header, "abuse_mailbox")
+    if origins == nil && slices.Contains(header, "origin_asn") {
+        warnf("No route objects cached; the origin_asn column stays empty (run chicha-whois -u route).\n")
+    }
+
+    var resolver *abuseResolver
+    if abuseColumn >= 0 {
+        var err error
+        if resolver, err = loadAbuseResolver(); err != nil {
+            errorln(err)
+            return
+        }
+    }
+
+    type csvRow struct {
+        start, end uint32
+        fields     []string
+    }
+    var rows []csvRow
+    err := scanBlocks(ripedbPath, func(blockLines []string) {
+        country := strings.ToUpper(blockAttr(blockLines, "country"))
+        if !inCountrySelection(countryCode, country) {
+            return
+        }
+        start, end, ok := parseInetnumRange(blockAttr(blockLines, "inetnum"))
+        if !ok {
+            return
+        }
+        cidr := rangeCIDR(start, end)
+        row := make([]string, len(header))
+        for i, field := range header {
+            switch field {
+            case "inetnum":
+                row[i] = fmt.Sprintf("%s-%s", uint32ToIP(start), uint32ToIP(end))
+            case "cidr":
+                row[i] = cidr
+            case "cidrs":
+                row[i] = strings.Join(rangeCIDRs(start, end), " ")
+            case "country":
+                row[i] = country
+            case "abuse_mailbox":
+                row[i] = resolver.mailbox(blockLines)
+            case "origin_asn":
+                if origins != nil {
+                    row[i] = strings.Join(origins.lookup(cidr), " ")
+                }
+            default:
+                row[i] = strings.Join(blockAttrs(blockLines, field), "; ")
+            }
+        }
+        rows = append(rows, csvRow{start, end, row})
+    })
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+
+    // Enclosing blocks sort before the blocks they contain.
+    sort.Slice(rows, func(i, j int) bool {
+        if rows[i].start != rows[j].start {
+            return rows[i].start < rows[j].start
+        }
+        return rows[i].end > rows[j].end
+    })
+    var buf bytes.Buffer
+    w := csv.NewWriter(&buf)
+    if opts.TSV {
+        w.Comma = '\t'
+    }
+    _ = w.Write(header)
+    var enclosing []csvRow
+    for _, row := range rows {
+        for len(enclosing) > 0 && enclosing[len(enclosing)-1].end < row.start {
+            enclosing = enclosing[:len(enclosing)-1]
+        }
+        if abuseColumn >= 0 && row.fields[abuseColumn] == "" && len(enclosing) > 0 {
+            row.fields[abuseColumn] = enclosing[len(enclosing)-1].fields[abuseColumn]
+        }
+        enclosing = append(enclosing, row)
+        _ = w.Write(row.fields)
+    }
+    w.Flush()
+    if err := w.Error(); err != nil {
+        errorf("Error writing CSV file: %v\n", err)
+        return
+    }
+
+    output := opts.Output
+    if output == "" {
+        extension := "csv"
+        if opts.TSV {
+            extension = "tsv"
+        }
+        homeDir, _ := os.UserHomeDir()
+        output = filepath.Join(homeDir, fmt.Sprintf("ripe_%s.%s", countryCode, extension))
+    }
+    meta := outputMeta{Selection: "inetnum blocks of " + countryCode, Entries: len(rows)}
+    if err := writeOutputFile(output, buf.String(), meta); err != nil {
+        errorf("Error writing CSV file: %v\n", err)
+        return
+    }
+    statusf("CSV file created at: %s (%d rows)\n", output, len(rows))
+}
+
+// createPhpIPAMExport writes the inetnum blocks of a country as a phpIPAM subnet import file
+// (Section, Subnet, Mask, Description, VLAN, VRF and a custom country field). Blocks that are not a
+// single CIDR are split exactly, because phpIPAM only stores subnets; nested blocks are kept, so
+// phpIPAM can build the hierarchy from them.
+func createPhpIPAMExport(countryCode, section, field, output string) {
+    type subnetRow struct {
+        network uint32
+        bits    int
+        netname string
+        country string
+    }
+    var rows []subnetRow
+    seen := make(map[string]bool)
+    err := scanRanges(ripedbPath, true, func(r dbRange) {
+        if !inCountrySelection(countryCode, r.Country) {
+            return
+        }
+        for _, cidr := range splitRangeToCIDRs(r.Start, r.End) {
+            if seen[cidr] {
+                continue
+            }
+            seen[cidr] = true
+            _, ipNet, err := net.ParseCIDR(cidr)
+            if err != nil {
+                continue
+            }
+            bits, _ := ipNet.Mask.Size()
+            rows = append(rows, subnetRow{binary.BigEndian.Uint32(ipNet.IP.To4()), bits, r.Netname, r.Country})
+        }
+    })
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+    if len(rows) == 0 {
+        warnf("No IP ranges found for country code: %s\n", countryCode)
+        return
+    }
+
+    // Larger subnets first, so a master subnet is imported before the subnets it contains.
+    sort.Slice(rows, func(i, j int) bool {
+        if rows[i].network != rows[j].network {
+            return rows[i].network < rows[j].network
+        }
+        return rows[i].bits < rows[j].bits
+    })
+    var buf bytes.Buffer
+    w := csv.NewWriter(&buf)
+    _ = w.Write([]string{"Section", "Subnet", "Mask", "Description", "VLAN", "VRF", field})
+    for _, row := range rows {
+        _ = w.Write([]string{section, uint32ToIP(row.network).String(), strconv.Itoa(row.bits), row.netname, "", "", row.country})
+    }
+    w.Flush()
+    if err := w.Error(); err != nil {
+        errorf("Error writing CSV file: %v\n", err)
+        return
+    }
+
+    if output == "" {
+        homeDir, _ := os.UserHomeDir()
+        output = filepath.Join(homeDir, fmt.Sprintf("phpipam_%s.csv", countryCode))
+    }
+    meta := outputMeta{Selection: "inetnum blocks of " + countryCode + " as phpIPAM subnets", Entries: len(rows)}
+    if err := writeOutputFile(output, buf.String(), meta); err != nil {
+        errorf("Error writing CSV file: %v\n", err)
+        return
+    }
+    reportCountry(countryCode, len(rows))
+    statusf("phpIPAM import file created at: %s (%d subnets)\n", output, len(rows))
+}
+
+// csvProfile is a column mapping from the "csv_profiles" section of the configuration file, used by
+// -csv -profile to match the import format of an IPAM or CMDB.
+type csvProfile struct {
+    Columns   []csvProfileColumn `json:"columns"`   // Output columns, in order.
+    Delimiter string             `json:"delimiter"` // Field separator; defaults to ",", "\t" selects TSV.
+    NoHeader  bool               `json:"no_header"` // Omit the header row.
+    Split     bool               `json:"split"`     // Split blocks that are not a single CIDR into exact CIDRs, one row each.
+}
+
+// csvProfileColumn is one column of a CSV profile: the header and a template such as
+// "{network}/{bits}" or "Imported from RIPE ({country})".
+type csvProfileColumn struct {
+    Header string `json:"header"`
+    Value  string `json:"value"`
+}
+
+// csvPlaceholderRe matches the placeholders of a CSV profile template.
+var csvPlaceholderRe = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// csvPlaceholders lists the placeholders a CSV profile template may use.
+var csvPlaceholders = []string{"inetnum", "start", "end", "cidr", "network", "bits", "netmask", "size", "country", "netname", "descr", "status", "abuse_mailbox", "origin_asn"}
+
+// createProfileCSVExport writes the inetnum blocks of a country as CSV laid out by a profile from the
+// configuration file. Without "split", {cidr}, {network} and {bits} describe the smallest CIDR
+// covering the block, as in the plain -csv export.
+func createProfileCSVExport(countryCode, profileName string, withAbuse bool, output string) {
+    config, err := loadConfig()
+    if err != nil {
+        errorln(err)
+        return
+    }
+    profile, ok := configEntry(config.CSVProfiles, profileName, "csv_profiles")
+    if !ok {
+        return
+    }
+    if len(profile.Columns) == 0 {
+        errorln("The CSV profile defines no columns.")
+        return
+    }
+    used := make(map[string]bool)
+    for _, column := range profile.Columns {
+        for _, match := range csvPlaceholderRe.FindAllStringSubmatch(column.Value, -1) {
+            if !slices.Contains(csvPlaceholders, match[1]) {
+                errorf("Unknown placeholder {%s} in the CSV profile (known: %s)\n", match[1], strings.Join(csvPlaceholders, ", "))
+                return
+            }
+            used[match[1]] = true
+        }
+    }
+    delimiter := ','
+    switch profile.Delimiter {
+    case "", ",":
+    case "\t", "\\t", "tab":
+        delimiter = '\t'
+    default:
+        runes := []rune(profile.Delimiter)
+        if len(runes) != 1 {
+            errorf("The CSV delimiter must be a single character: %q\n", profile.Delimiter)
+            return
+        }
+        delimiter = runes[0]
+    }
+
+    var resolver *abuseResolver
+    if withAbuse || used["abuse_mailbox"] {
+        withAbuse = true
+        if resolver, err = loadAbuseResolver(); err != nil {
+            errorln(err)
+            return
+        }
+    }
+    var origins *routeOrigins
+    if used["origin_asn"] {
+        origins = loadRouteOrigins()
+    }
+
+    type profileRow struct {
+        start, end uint32
+        values     map[string]string
+    }
+    var rows []profileRow
+    err = scanBlocks(ripedbPath, func(blockLines []string) {
+        country := strings.ToUpper(blockAttr(blockLines, "country"))
+        if !inCountrySelection(countryCode, country) {
+            return
+        }
+        start, end, ok := parseInetnumRange(blockAttr(blockLines, "inetnum"))
+        if !ok {
+            return
+        }
+        values := map[string]string{
+            "inetnum": fmt.Sprintf("%s-%s", uint32ToIP(start), uint32ToIP(end)),
+            "country": country,
+            "netname": blockAttr(blockLines, "netname"),
+            "descr":   blockAttr(blockLines, "descr"),
+            "status":  blockAttr(blockLines, "status"),
+        }
+        if withAbuse {
+            values["abuse_mailbox"] = resolver.mailbox(blockLines)
+        }
+        cidrs := []string{}
+        if profile.Split {
+            cidrs = splitRangeToCIDRs(start, end)
+        } else {
+            network, prefixLength := rangeToCIDR(start, end)
+            cidrs = append(cidrs, fmt.Sprintf("%s/%d", uint32ToIP(network), prefixLength))
+        }
+        for _, cidr := range cidrs {
+            _, ipNet, err := net.ParseCIDR(cidr)
+            if err != nil {
+                continue
+            }
+            bits, _ := ipNet.Mask.Size()
+            rowValues := maps.Clone(values)
+            rowValues["cidr"] = cidr
+            rowValues["network"] = ipNet.IP.String()
+            rowValues["bits"] = strconv.Itoa(bits)
+            rowValues["netmask"] = net.IP(ipNet.Mask).String()
+            rowStart, rowEnd := start, end
+            if profile.Split {
+                rowStart = binary.BigEndian.Uint32(ipNet.IP.To4())
+                rowEnd = rowStart + uint32(uint64(1)<<(32-bits)-1)
+            }
+            rowValues["start"] = uint32ToIP(rowStart).String()
+            rowValues["end"] = uint32ToIP(rowEnd).String()
+            rowValues["size"] = strconv.FormatUint(uint64(rowEnd-rowStart)+1, 10)
+            if origins != nil {
+                rowValues["origin_asn"] = strings.Join(origins.lookup(cidr), " ")
+            }
+            rows = append(rows, profileRow{rowStart, rowEnd, rowValues})
+        }
+    })
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+    if len(rows) == 0 {
+        warnf("No IP ranges found for country code: %s\n", countryCode)
+        return
+    }
+
+    // Enclosing blocks sort before the blocks they contain; a block without an abuse mailbox
+    // inherits the mailbox of the block enclosing it, as in the plain -csv export.
+    sort.Slice(rows, func(i, j int) bool {
+        if rows[i].start != rows[j].start {
+            return rows[i].start < rows[j].start
+        }
+        return rows[i].end > rows[j].end
+    })
+    var buf bytes.Buffer
+    w := csv.NewWriter(&buf)
+    w.Comma = delimiter
+    if !profile.NoHeader {
+        header := make([]string, len(profile.Columns))
+        for i, column := range profile.Columns {
+            header[i] = column.Header
+        }
+        _ = w.Write(header)
+    }
+    var enclosing []profileRow
+    for _, row := range rows {
+        for len(enclosing) > 0 && enclosing[len(enclosing)-1].end < row.start {
+            enclosing = enclosing[:len(enclosing)-1]
+        }
+        if withAbuse && row.values["abuse_mailbox"] == "" && len(enclosing) > 0 {
+            row.values["abuse_mailbox"] = enclosing[len(enclosing)-1].values["abuse_mailbox"]
+        }
+        enclosing = append(enclosing, row)
+        record := make([]string, len(profile.Columns))
+        for i, column := range profile.Columns {
+            record[i] = csvPlaceholderRe.ReplaceAllStringFunc(column.Value, func(placeholder string) string {
+                return row.values[placeholder[1:len(placeholder)-1]]
+            })
+        }
+        _ = w.Write(record)
+    }
+    w.Flush()
+    if err := w.Error(); err != nil {
+        errorf("Error writing CSV file: %v\n", err)
+        return
+    }
+
+    if output == "" {
+        if profileName == "" {
+            for name := range config.CSVProfiles {
+                profileName = name
+            }
+        }
+        homeDir, _ := os.UserHomeDir()
+        output = filepath.Join(homeDir, fmt.Sprintf("%s_%s.csv", profileName, countryCode))
+    }
+    meta := outputMeta{Selection: "inetnum blocks of " + countryCode + " (CSV profile " + profileName + ")", Entries: len(rows)}
+    if err := writeOutputFile(output, buf.String(), meta); err != nil {
+        errorf("Error writing CSV file: %v\n", err)
+        return
+    }
+    statusf("CSV file created at: %s (%d rows)\n", output, len(rows))
+}
+
+//-------------------------------------------------------------------------
+// Route object consistency audit
+//-------------------------------------------------------------------------
+
+// asnRe matches an AS number as written in RPSL, e.g. AS3333 (a bare "AS" is American Samoa).
+var asnRe = regexp.MustCompile(`^AS[0-9]+$`)
+
+// addrInterval is an inclusive IPv4 address interval.
+type addrInterval struct {
+    Start, End uint32
+}
+
+// mergeIntervals sorts intervals and joins the overlapping or adjacent ones.
+func mergeIntervals(intervals []addrInterval) []addrInterval {
+    sort.Slice(intervals, func(i, j int) bool { return intervals[i].Start < intervals[j].Start })
+    var merged []addrInterval
+    for _, iv := range intervals {
+        if n := len(merged); n > 0 && uint64(iv.Start) <= uint64(merged[n-1].End)+1 {
+            merged[n-1].End = max(merged[n-1].End, iv.End)
+            continue
+        }
+        merged = append(merged, iv)
+    }
+    return merged
+}
+
+// intervalCoverage reports whether merged intervals overlap start-end at all and whether they cover all of it.
+func intervalCoverage(merged []addrInterval, start, end uint32) (overlaps, covers bool) {
+    i := sort.Search(len(merged), func(i int) bool { return merged[i].End >= start })
+    if i == len(merged) || merged[i].Start > end {
+        return false, false
+    }
+    return true, merged[i].Start <= start && merged[i].End >= end
+}
+
+// intervalOverlap returns how many addresses of iv the merged intervals cover; a full RIB is checked
+// route by route, so the first candidate is found by binary search instead of a scan from the start.
+func intervalOverlap(merged []addrInterval, iv addrInterval) uint64 {
+    var n uint64
+    for i := sort.Search(len(merged), func(i int) bool { return merged[i].End >= iv.Start }); i < len(merged) && merged[i].Start <= iv.End; i++ {
+        n += uint64(min(merged[i].End, iv.End)-max(merged[i].Start, iv.Start)) + 1
+    }
+    return n
+}
+
+// auditRoutes compares inetnum and route objects for a country code or an ASN. It lists blocks that no
+// route object covers (registered but not announceable), blocks only partly covered, and route objects
+// reaching outside registered address space (announced without registration).
+// For an ASN, the blocks are those of the aut-num's organisation and only that ASN's routes count.
+func auditRoutes(target string) {
+    asn := ""
+    if asnRe.MatchString(target) {
+        asn = target
+    }
+
+    type auditBlock struct {
+        addrInterval
+        Inetnum, Netname string
+    }
+    org := ""
+    if asn != "" {
+        autNum, err := lookupObject("aut-num", asn)
+        if err != nil || autNum == nil {
+            warnf("aut-num %s not found in the cache; blocks of its organisation are not audited\n", asn)
+        } else if org = strings.ToUpper(blockAttr(autNum, "org")); org == "" {
+            warnf("aut-num %s has no org attribute; blocks of its organisation are not audited\n", asn)
+        }
+    }
+
+    var registered []addrInterval
+    var blocks []auditBlock
+    err := scanBlocks(ripedbPath, func(blockLines []string) {
+        value := blockAttr(blockLines, "inetnum")
+        start, end, ok := parseInetnumRange(value)
+        if !ok {
+            return
+        }
+        registered = append(registered, addrInterval{start, end})
+        selected := false
+        if asn != "" {
+            selected = org != "" && strings.ToUpper(blockAttr(blockLines, "org")) == org
+        } else {
+            selected = strings.ToUpper(blockAttr(blockLines, "country")) == target
+        }
+        if selected {
+            blocks = append(blocks, auditBlock{addrInterval{start, end}, value, blockAttr(blockLines, "netname")})
+        }
+    })
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+    registered = mergeIntervals(registered)
+    selectedSpace := make([]addrInterval, len(blocks))
+    for i, b := range blocks {
+        selectedSpace[i] = b.addrInterval
+    }
+    selectedSpace = mergeIntervals(selectedSpace)
+
+    // Routes of the ASN, or routes touching the country's blocks.
+    type auditRoute struct {
+        addrInterval
+        Prefix, Origin string
+    }
+    var routes []auditRoute
+    var announced []addrInterval
+    err = scanObjects("route", func(blockLines []string) {
+        _, ipNet, err := net.ParseCIDR(blockAttr(blockLines, "route"))
+        if err != nil || ipNet.IP.To4() == nil {
+            return
+        }
+        r := auditRoute{
+            addrInterval{binary.BigEndian.Uint32(ipNet.IP.To4()), binary.BigEndian.Uint32(lastIP(ipNet))},
+            ipNet.String(), strings.ToUpper(blockAttr(blockLines, "origin")),
+        }
+        if asn != "" {
+            if r.Origin != asn {
+                return
+            }
+        } else if overlaps, _ := intervalCoverage(selectedSpace, r.Start, r.End); !overlaps {
+            return
+        }
+        routes = append(routes, r)
+        announced = append(announced, r.addrInterval)
+    })
+    if err != nil {
+        errorln(err)
+        return
+    }
+    announced = mergeIntervals(announced)
+
+    var uncovered, partial []auditBlock
+    for _, b := range blocks {
+        switch overlaps, covers := intervalCoverage(announced, b.Start, b.End); {
+        case !overlaps:
+            uncovered = append(uncovered, b)
+        case !covers:
+            partial = append(partial, b)
+        }
+    }
+    var unregistered []auditRoute
+    for _, r := range routes {
+        if _, covers := intervalCoverage(registered, r.Start, r.End); !covers {
+            unregistered = append(unregistered, r)
+        }
+    }
+    sort.Slice(unregistered, func(i, j int) bool { return unregistered[i].Start < unregistered[j].Start })
+
+    fmt.Printf(tr("%% Route audit for %s: %d inetnum blocks, %d route objects\n"), target, len(blocks), len(routes))
+    fmt.Printf(tr("\n%% Inetnum blocks without route objects (registered, not announceable): %d\n"), len(uncovered))
+    for _, b := range uncovered {
+        fmt.Printf("%s\t%s\n", b.Inetnum, b.Netname)
+    }
+    fmt.Printf(tr("\n%% Inetnum blocks only partly covered by route objects: %d\n"), len(partial))
+    for _, b := range partial {
+        fmt.Printf("%s\t%s\n", b.Inetnum, b.Netname)
+    }
+    fmt.Printf(tr("\n%% Route objects outside registered address space (announced without registration): %d\n"), len(unregistered))
+    for _, r := range unregistered {
+        fmt.Printf("%s\t%s\n", r.Prefix, r.Origin)
+    }
+}
+
+//-------------------------------------------------------------------------
+// Origin ASN annotation
+//-------------------------------------------------------------------------
+
+// routeOrigins maps IPv4 prefixes to the origin ASNs of the route objects announcing them.
+type routeOrigins struct {
+    routes   []routeOrigin  // Sorted by start, then by descending end.
+    byPrefix map[uint64]int // start<<8 | prefix length to the index in routes.
+}
+
+// routeOrigin is one route prefix with all the origins registered for it (several for MOAS prefixes).
+type routeOrigin struct {
+    Start, End uint32
+    Origins    []string
+}
+
+// routeCacheAvailable reports whether route objects are cached, either split or in the combined dump.
+func routeCacheAvailable() bool {
+    return fileExists(objectCachePath("route")) || fileExists(fullDBPath())
+}
+
+// loadRouteOrigins reads all IPv4 route objects. It returns nil if no route objects are cached,
+// so that outputs are annotated only when the data is there.
+func loadRouteOrigins() *routeOrigins {
+    if !routeCacheAvailable() {
+        return nil
+    }
+    type routeKey struct {
+        start  uint32
+        length int
+    }
+    origins := make(map[routeKey][]string)
+    err := scanObjects("route", func(blockLines []string) {
+        _, ipNet, err := net.ParseCIDR(blockAttr(blockLines, "route"))
+        origin := strings.ToUpper(blockAttr(blockLines, "origin"))
+        if err != nil || ipNet.IP.To4() == nil || origin == "" {
+            return
+        }
+        ones, _ := ipNet.Mask.Size()
+        key := routeKey{binary.BigEndian.Uint32(ipNet.IP.To4()), ones}
+        if !slices.Contains(origins[key], origin) {
+            origins[key] = append(origins[key], origin)
+        }
+    })
+    if err != nil {
+        warnf("Route objects could not be read, outputs are not annotated with origin ASNs: %v\n", err)
+        return nil
+    }
+
+    ro := &routeOrigins{byPrefix: make(map[uint64]int, len(origins))}
+    for key, asns := range origins {
+        sort.Strings(asns)
+        ro.routes = append(ro.routes, routeOrigin{key.start, key.start | uint32(uint64(1)<<(32-key.length)-1), asns})
+    }
+    sort.Slice(ro.routes, func(i, j int) bool {
+        if ro.routes[i].Start != ro.routes[j].Start {
+            return ro.routes[i].Start < ro.routes[j].Start
+        }
+        return ro.routes[i].End > ro.routes[j].End
+    })
+    for i, r := range ro.routes {
+        _, length := rangeToCIDR(r.Start, r.End)
+        ro.byPrefix[uint64(r.Start)<<8|uint64(length)] = i
+    }
+    return ro
+}
+
+// lookup returns the origin ASNs of a CIDR: those of the most specific route object covering it or,
+// if there is none, those of the more specific route objects inside it.
+func (ro *routeOrigins) lookup(cidr string) []string {
+    if ro == nil {
+        return nil
+    }
+    _, ipNet, err := net.ParseCIDR(cidr)
+    if err != nil || ipNet.IP.To4() == nil {
+        return nil
+    }
+    start := binary.BigEndian.Uint32(ipNet.IP.To4())
+    ones, _ := ipNet.Mask.Size()
+
+    // Route prefixes nest like CIDRs, so the covering ones are found by shortening the prefix.
+    for length := ones; length >= 0; length-- {
+        network := start &^ uint32(uint64(1)<<(32-length)-1)
+        if i, ok := ro.byPrefix[uint64(network)<<8|uint64(length)]; ok {
+            return ro.routes[i].Origins
+        }
+    }
+
+    end := binary.BigEndian.Uint32(lastIP(ipNet))
+    var asns []string
+    for i := sort.Search(len(ro.routes), func(i int) bool { return ro.routes[i].Start >= start }); i < len(ro.routes) && ro.routes[i].Start <= end; i++ {
+        for _, asn := range ro.routes[i].Origins {
+            if !slices.Contains(asns, asn) {
+                asns = append(asns, asn)
+            }
+        }
+    }
+    sort.Strings(asns)
+    return asns
+}
+
+// annotate maps each CIDR to its origin ASNs, leaving out the ones without a route object.
+func (ro *routeOrigins) annotate(cidrs []string) map[string][]string {
+    annotated := make(map[string][]string)
+    for _, cidr := range cidrs {
+        if asns := ro.lookup(cidr); len(asns) > 0 {
+            annotated[cidr] = asns
+        }
+    }
+    return annotated
+}
+
+//-------------------------------------------------------------------------
+// RPSL filter evaluation
+//-------------------------------------------------------------------------
+
+// prefixRange is an RPSL address prefix range: the prefixes inside Start/Length whose length is
+// Min..Max. 1.2.0.0/16^+ has lengths 16-32; an exact prefix has Min = Max = Length.
+type prefixRange struct {
+    Start    uint32
+    Length   int
+    Min, Max int
+}
+
+// covers reports whether the prefix start/length lies inside the prefix of r (or is that prefix).
+func (r prefixRange) covers(start uint32, length int) bool {
+    return length >= r.Length && start&^uint32(uint64(1)<<(32-r.Length)-1) == r.Start
+}
+
+// String formats a prefix range in RPSL notation.
+func (r prefixRange) String() string {
+    prefix := fmt.Sprintf("%s/%d", uint32ToIP(r.Start), r.Length)
+    switch {
+    case r.Min == r.Length && r.Max == r.Length:
+        return prefix
+    case r.Min == r.Length && r.Max == 32:
+        return prefix + "^+"
+    case r.Min == r.Length+1 && r.Max == 32:
+        return prefix + "^-"
+    case r.Min == r.Max:
+        return fmt.Sprintf("%s^%d", prefix, r.Min)
+    }
+    return fmt.Sprintf("%s^%d-%d", prefix, r.Min, r.Max)
+}
+
+// rpslRangeOpRe matches the RPSL range operators ^-, ^+, ^n and ^n-m.
+var rpslRangeOpRe = regexp.MustCompile(`^\^(-|\+|[0-9]{1,2}(-[0-9]{1,2})?)$`)
+
+// applyRangeOp applies a range operator to the prefix of r, replacing any operator it had. Lengths
+// shorter than the prefix cannot match, so ^n-m is clipped to the prefix length; false means empty.
+func applyRangeOp(r prefixRange, op string) (prefixRange, bool) {
+    switch op {
+    case "":
+        return r, true
+    case "^-":
+        r.Min, r.Max = r.Length+1, 32
+    case "^+":
+        r.Min, r.Max = r.Length, 32
+    default:
+        low, high, found := strings.Cut(op[1:], "-")
+        r.Min, _ = strconv.Atoi(low)
+        r.Max = r.Min
+        if found {
+            r.Max, _ = strconv.Atoi(high)
+        }
+        r.Min = max(r.Min, r.Length)
+    }
+    return r, r.Min <= r.Max && r.Max <= 32
+}
+
+// parsePrefixRange parses a prefix with an optional range operator, e.g. 1.2.0.0/16^24-32.
+func parsePrefixRange(value string) (prefixRange, bool) {
+    prefix, op := value, ""
+    if i := strings.Index(value, "^"); i >= 0 {
+        prefix, op = value[:i], value[i:]
+        if !rpslRangeOpRe.MatchString(op) {
+            return prefixRange{}, false
+        }
+    }
+    _, ipNet, err := net.ParseCIDR(prefix)
+    if err != nil || ipNet.IP.To4() == nil {
+        return prefixRange{}, false
+    }
+    length, _ := ipNet.Mask.Size()
+    r := prefixRange{Start: binary.BigEndian.Uint32(ipNet.IP.To4()), Length: length, Min: length, Max: length}
+    return applyRangeOp(r, op)
+}
+
+// intersectRanges returns the prefixes in both a and b: nothing unless one prefix lies inside the
+// other, then the more specific prefix with the common lengths.
+func intersectRanges(a, b prefixRange) (prefixRange, bool) {
+    if b.Length < a.Length {
+        a, b = b, a
+    }
+    if !a.covers(b.Start, b.Length) {
+        return prefixRange{}, false
+    }
+    r := prefixRange{Start: b.Start, Length: b.Length, Min: max(a.Min, b.Min), Max: min(a.Max, b.Max)}
+    return r, r.Min <= r.Max
+}
+
+// subtractRange returns the prefixes of a that are not in b, as prefix ranges.
+func subtractRange(a, b prefixRange) []prefixRange {
+    low, high := max(a.Min, b.Min), min(a.Max, b.Max)
+    if low > high {
+        return []prefixRange{a}
+    }
+    // withoutLengths drops the lengths low..high from r.
+    withoutLengths := func(r prefixRange) []prefixRange {
+        var out []prefixRange
+        if r.Min < low {
+            out = append(out, prefixRange{r.Start, r.Length, r.Min, min(r.Max, low-1)})
+        }
+        if r.Max > high {
+            out = append(out, prefixRange{r.Start, r.Length, max(r.Min, high+1), r.Max})
+        }
+        return out
+    }
+    switch {
+    case b.covers(a.Start, a.Length):
+        return withoutLengths(a)
+    case a.covers(b.Start, b.Length):
+        // Prefixes of a shorter than b's prefix are never inside it, nor are the longer ones in the
+        // siblings along the path from a's prefix down to b's; inside b's prefix some lengths go.
+        var out []prefixRange
+        if a.Min < b.Length {
+            out = append(out, prefixRange{a.Start, a.Length, a.Min, min(a.Max, b.Length-1)})
+        }
+        for length := a.Length + 1; length <= b.Length; length++ {
+            sibling := (b.Start &^ uint32(uint64(1)<<(32-length)-1)) ^ uint32(1)<<(32-length)
+            if r := (prefixRange{sibling, length, max(a.Min, b.Length), a.Max}); r.Min <= r.Max {
+                out = append(out, r)
+            }
+        }
+        if inside := (prefixRange{b.Start, b.Length, max(a.Min, b.Length), a.Max}); inside.Min <= inside.Max {
+            out = append(out, withoutLengths(inside)...)
+        }
+        return out
+    }
+    return []prefixRange{a}
+}
+
+// prefixRangeIndex finds the ranges of a set whose prefix overlaps a given prefix. Prefixes are either
+// nested or disjoint, so the overlapping ones are the (at most 33) covering prefixes and the ones inside.
+type prefixRangeIndex struct {
+    byPrefix map[uint64][]prefixRange // start<<8 | length to the ranges with that prefix.
+    sorted   []prefixRange            // Sorted by start.
+}
+
+// newPrefixRangeIndex indexes a set of prefix ranges.
+func newPrefixRangeIndex(ranges []prefixRange) *prefixRangeIndex {
+    idx := &prefixRangeIndex{byPrefix: make(map[uint64][]prefixRange), sorted: slices.Clone(ranges)}
+    for _, r := range ranges {
+        key := uint64(r.Start)<<8 | uint64(r.Length)
+        idx.byPrefix[key] = append(idx.byPrefix[key], r)
+    }
+    sort.Slice(idx.sorted, func(i, j int) bool { return idx.sorted[i].Start < idx.sorted[j].Start })
+    return idx
+}
+
+// overlapping returns the indexed ranges whose prefix covers or lies inside the prefix of r.
+func (idx *prefixRangeIndex) overlapping(r prefixRange) []prefixRange {
+    var out []prefixRange
+    for length := 0; length < r.Length; length++ {
+        start := r.Start &^ uint32(uint64(1)<<(32-length)-1)
+        out = append(out, idx.byPrefix[uint64(start)<<8|uint64(length)]...)
+    }
+    end := r.Start | uint32(uint64(1)<<(32-r.Length)-1)
+    i := sort.Search(len(idx.sorted), func(i int) bool { return idx.sorted[i].Start >= r.Start })
+    for ; i < len(idx.sorted) && idx.sorted[i].Start <= end; i++ {
+        if idx.sorted[i].Length >= r.Length {
+            out = append(out, idx.sorted[i])
+        }
+    }
+    return out
+}
+
+// normalizePrefixRanges sorts a set of prefix ranges, joins the length ranges of each prefix and
+// drops ranges contained in a range of a covering prefix.
+func normalizePrefixRanges(ranges []prefixRange) []prefixRange {
+    sort.Slice(ranges, func(i, j int) bool {
+        a, b := ranges[i], ranges[j]
+        if a.Start != b.Start {
+            return a.Start < b.Start
+        }
+        if a.Length != b.Length {
+            return a.Length < b.Length
+        }
+        return a.Min < b.Min
+    })
+    var joined []prefixRange
+    for _, r := range ranges {
+        if n := len(joined); n > 0 && joined[n-1].Start == r.Start && joined[n-1].Length == r.Length && r.Min <= joined[n-1].Max+1 {
+            joined[n-1].Max = max(joined[n-1].Max, r.Max)
+            continue
+        }
+        joined = append(joined, r)
+    }
+    idx := newPrefixRangeIndex(joined)
+    var out []prefixRange
+    for _, r := range joined {
+        redundant := false
+        for _, o := range idx.overlapping(r) {
+            if o.Length < r.Length && o.Min <= r.Min && r.Max <= o.Max {
+                redundant = true
+                break
+            }
+        }
+        if !redundant {
+            out = append(out, r)
+        }
+    }
+    return out
+}
+
+// rpslFilter is a parsed RPSL filter expression (RFC 2622, section 5.4).
+type rpslFilter struct {
+    Kind        string        // "or", "and", "not", "any", "asn", "as-set", "country" or "prefixes".
+    Left, Right *rpslFilter   // Operands of "or", "and" and "not" (Left only).
+    Name        string        // ASN, as-set name or country selection.
+    Op          string        // Range operator applied to the prefixes of the term, e.g. "^+".
+    Prefixes    []prefixRange // Members of a literal prefix set.
+}
+
+// rpslFilterParser parses a filter with the precedence NOT > AND > OR; juxtaposed terms are joined
+// with OR, as in "AS1 AS2".
+type rpslFilterParser struct {
+    tokens []string
+    pos    int
+}
+
+// parseRPSLFilter parses an RPSL filter such as "AS-FOO AND NOT {10.0.0.0/8^+}".
+func parseRPSLFilter(text string) (*rpslFilter, error) {
+    var tokens []string
+    word := ""
+    for _, c := range text + " " {
+        if strings.ContainsRune("(){}, \t\n", c) {
+            if word != "" {
+                tokens = append(tokens, word)
+                word = ""
+            }
+            if !strings.ContainsRune(" \t\n", c) {
+                tokens = append(tokens, string(c))
+            }
+            continue
+        }
+        word += string(c)
+    }
+    p := &rpslFilterParser{tokens: tokens}
+    f, err := p.or()
+    if err == nil && p.pos < len(tokens) {
+        err = fmt.Errorf("unexpected %q", tokens[p.pos])
+    }
+    if err != nil {
+        return nil, fmt.Errorf("invalid RPSL filter: %v", err)
+    }
+    return f, nil
+}
+
+// peek returns the next token in upper case, or "" at the end.
+func (p *rpslFilterParser) peek() string {
+    if p.pos < len(p.tokens) {
+        return strings.ToUpper(p.tokens[p.pos])
+    }
+    return ""
+}
+
+func (p *rpslFilterParser) or() (*rpslFilter, error) {
+    left, err := p.and()
+    for err == nil {
+        switch next := p.peek(); {
+        case next == "OR":
+            p.pos++
+        case next == "" || next == ")" || next == "AND":
+            return left, nil
+        }
+        var right *rpslFilter
+        if right, err = p.and(); err == nil {
+            left = &rpslFilter{Kind: "or", Left: left, Right: right}
+        }
+    }
+    return nil, err
+}
+
+func (p *rpslFilterParser) and() (*rpslFilter, error) {
+    left, err := p.not()
+    for err == nil && p.peek() == "AND" {
+        p.pos++
+        var right *rpslFilter
+        if right, err = p.not(); err == nil {
+            left = &rpslFilter{Kind: "and", Left: left, Right: right}
+        }
+    }
+    return left, err
+}
+
+func (p *rpslFilterParser) not() (*rpslFilter, error) {
+    if p.peek() != "NOT" {
+        return p.term()
+    }
+    p.pos++
+    operand, err := p.not()
+    if err != nil {
+        return nil, err
+    }
+    return &rpslFilter{Kind: "not", Left: operand}, nil
+}
+
+// rangeOp consumes a range operator following a prefix set, e.g. the ^+ of {1.2.0.0/16}^+.
+func (p *rpslFilterParser) rangeOp() string {
+    if p.pos < len(p.tokens) && rpslRangeOpRe.MatchString(p.tokens[p.pos]) {
+        p.pos++
+        return p.tokens[p.pos-1]
+    }
+    return ""
+}
+
+func (p *rpslFilterParser) term() (*rpslFilter, error) {
+    if p.pos >= len(p.tokens) {
+        return nil, fmt.Errorf("unexpected end of the filter")
+    }
+    token := p.tokens[p.pos]
+    p.pos++
+    switch token {
+    case "(":
+        f, err := p.or()
+        if err != nil {
+            return nil, err
+        }
+        if p.peek() != ")" {
+            return nil, fmt.Errorf("missing )")
+        }
+        p.pos++
+        return f, nil
+    case "{":
+        f := &rpslFilter{Kind: "prefixes"}
+        for p.peek() != "}" {
+            if p.peek() == "" {
+                return nil, fmt.Errorf("missing }")
+            }
+            if value := p.tokens[p.pos]; value != "," {
+                r, ok := parsePrefixRange(value)
+                if !ok {
+                    return nil, fmt.Errorf("invalid prefix %q", value)
+                }
+                f.Prefixes = append(f.Prefixes, r)
+            }
+            p.pos++
+        }
+        p.pos++
+        f.Op = p.rangeOp()
+        return f, nil
+    case ")", "}", ",":
+        return nil, fmt.Errorf("unexpected %q", token)
+    }
+
+    name, op := token, ""
+    if i := strings.Index(token, "^"); i >= 0 {
+        name, op = token[:i], token[i:]
+        if !rpslRangeOpRe.MatchString(op) {
+            return nil, fmt.Errorf("invalid range operator %q", op)
+        }
+    }
+    upper := strings.ToUpper(name)
+    switch {
+    case upper == "ANY" && op == "":
+        return &rpslFilter{Kind: "any"}, nil
+    case asnRe.MatchString(upper):
+        return &rpslFilter{Kind: "asn", Name: upper, Op: op}, nil
+    case strings.HasPrefix(upper, "AS-") || strings.Contains(upper, ":AS-"):
+        return &rpslFilter{Kind: "as-set", Name: upper, Op: op}, nil
+    case strings.HasPrefix(strings.ToLower(name), "country:"):
+        return &rpslFilter{Kind: "country", Name: name[len("country:"):], Op: op}, nil
+    case strings.HasPrefix(upper, "RS-") || strings.Contains(upper, ":RS-"):
+        return nil, fmt.Errorf("route-sets (%s) are not supported", name)
+    }
+    return nil, fmt.Errorf("unknown term %q", token)
+}
+
+// rpslData holds the cached objects a filter refers to: the routes of each ASN and the expanded as-sets.
+type rpslData struct {
+    routes  map[string][]prefixRange // Exact route prefixes by origin ASN.
+    asSets  map[string][]string      // ASNs of each as-set, nested sets included.
+    country map[string][]prefixRange // Exact inetnum CIDRs by country selection.
+}
+
+// collect gathers the names the filter refers to.
+func (f *rpslFilter) collect(asns, asSets, countries map[string]bool) {
+    if f == nil {
+        return
+    }
+    switch f.Kind {
+    case "asn":
+        asns[f.Name] = true
+    case "as-set":
+        asSets[f.Name] = true
+    case "country":
+        countries[f.Name] = true
+    }
+    f.Left.collect(asns, asSets, countries)
+    f.Right.collect(asns, asSets, countries)
+}
+
+// loadRPSLData reads the as-set, route and inetnum data a filter needs, each type in one pass.
+func loadRPSLData(f *rpslFilter) (*rpslData, bool) {
+    asns, asSets, countries := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+    f.collect(asns, asSets, countries)
+    data := &rpslData{routes: make(map[string][]prefixRange), asSets: make(map[string][]string), country: make(map[string][]prefixRange)}
+
+    if len(asSets) > 0 {
+        members := make(map[string][]string)
+        err := scanObjects("as-set", func(blockLines []string) {
+            name := strings.ToUpper(blockAttr(blockLines, "as-set"))
+            inMembers := false
+            for _, line := range blockLines {
+                attr, value := "", line
+                if line != "" && !strings.ContainsRune(" \t+", rune(line[0])) {
+                    attr, value, _ = strings.Cut(line, ":")
+                    inMembers = strings.EqualFold(attr, "members")
+                } else if line != "" && line[0] == '+' {
+                    value = line[1:]
+                }
+                if !inMembers {
+                    continue
+                }
+                value, _, _ = strings.Cut(value, "#")
+                for _, member := range strings.Split(value, ",") {
+                    if member = strings.ToUpper(strings.TrimSpace(member)); member != "" {
+                        members[name] = append(members[name], member)
+                    }
+                }
+            }
+        })
+        if err != nil {
+            errorln(err)
+            return nil, false
+        }
+        // expand resolves nested sets; seen breaks membership cycles.
+        var expand func(name string, seen map[string]bool, out map[string]bool)
+        expand = func(name string, seen map[string]bool, out map[string]bool) {
+            if seen[name] {
+                return
+            }
+            seen[name] = true
+            if _, ok := members[name]; !ok {
+                warnf("as-set %s not found in the cache; it matches nothing\n", name)
+            }
+            for _, member := range members[name] {
+                if asnRe.MatchString(member) {
+                    out[member] = true
+                } else {
+                    expand(member, seen, out)
+                }
+            }
+        }
+        for name := range asSets {
+            set := make(map[string]bool)
+            expand(name, make(map[string]bool), set)
+            data.asSets[name] = slices.Sorted(maps.Keys(set))
+            for asn := range set {
+                asns[asn] = true
+            }
+        }
+    }
+
+    if len(asns) > 0 {
+        err := scanObjects("route", func(blockLines []string) {
+            origin := strings.ToUpper(blockAttr(blockLines, "origin"))
+            if !asns[origin] {
+                return
+            }
+            if r, ok := parsePrefixRange(blockAttr(blockLines, "route")); ok {
+                data.routes[origin] = append(data.routes[origin], r)
+            }
+        })
+        if err != nil {
+            errorln(err)
+            return nil, false
+        }
+    }
+
+    for selection := range countries {
+        countryCode, ok := resolveCountryCode(selection)
+        if !ok || !ensureRIPEdb() {
+            return nil, false
+        }
+        for _, cidr := range extractCountryCIDRs(countryCode, ripedbPath, false) {
+            if r, ok := parsePrefixRange(cidr); ok {
+                data.country[selection] = append(data.country[selection], r)
+            }
+        }
+    }
+    return data, true
+}
+
+// withRangeOp applies the range operator of a term to each of its prefixes.
+func withRangeOp(ranges []prefixRange, op string) []prefixRange {
+    var out []prefixRange
+    for _, r := range ranges {
+        if r, ok := applyRangeOp(r, op); ok {
+            out = append(out, r)
+        }
+    }
+    return out
+}
+
+// eval returns the prefix ranges matched by the filter.
+func (f *rpslFilter) eval(data *rpslData) []prefixRange {
+    switch f.Kind {
+    case "any":
+        return []prefixRange{{Start: 0, Length: 0, Min: 0, Max: 32}}
+    case "asn":
+        return withRangeOp(data.routes[f.Name], f.Op)
+    case "as-set":
+        var ranges []prefixRange
+        for _, asn := range data.asSets[f.Name] {
+            ranges = append(ranges, data.routes[asn]...)
+        }
+        return withRangeOp(ranges, f.Op)
+    case "country":
+        return withRangeOp(data.country[f.Name], f.Op)
+    case "prefixes":
+        return withRangeOp(f.Prefixes, f.Op)
+    case "or":
+        return append(f.Left.eval(data), f.Right.eval(data)...)
+    case "not":
+        return subtractPrefixRanges([]prefixRange{{Start: 0, Length: 0, Min: 0, Max: 32}}, f.Left.eval(data))
+    }
+
+    // "A AND NOT B" is a difference, which keeps the result finite; other conjunctions intersect.
+    left := f.Left.eval(data)
+    if f.Right.Kind == "not" {
+        return subtractPrefixRanges(left, f.Right.Left.eval(data))
+    }
+    idx := newPrefixRangeIndex(f.Right.eval(data))
+    var out []prefixRange
+    for _, a := range left {
+        for _, b := range idx.overlapping(a) {
+            if r, ok := intersectRanges(a, b); ok {
+                out = append(out, r)
+            }
+        }
+    }
+    return out
+}
+
+// subtractPrefixRanges returns the prefixes of a that are in none of the ranges of b.
+func subtractPrefixRanges(a, b []prefixRange) []prefixRange {
+    idx := newPrefixRangeIndex(b)
+    var out []prefixRange
+    for _, r := range a {
+        pieces := []prefixRange{r}
+        for _, o := range idx.overlapping(r) {
+            var next []prefixRange
+            for _, piece := range pieces {
+                next = append(next, subtractRange(piece, o)...)
+            }
+            pieces = next
+        }
+        out = append(out, pieces...)
+    }
+    return out
+}
+
+// createRPSLFilterList evaluates an RPSL filter against the cached route, as-set and inetnum objects
+// and prints the matching prefix ranges in RPSL notation or, with prefixListName, as Cisco/FRR
+// prefix-list entries (ge/le); with output the list is written to that file instead.
+func createRPSLFilterList(filterText, prefixListName, output string) {
+    f, err := parseRPSLFilter(filterText)
+    if err != nil {
+        errorln(err)
+        return
+    }
+    data, ok := loadRPSLData(f)
+    if !ok {
+        return
+    }
+    ranges := normalizePrefixRanges(f.eval(data))
+
+    var sb strings.Builder
+    comment := "#"
+    for _, r := range ranges {
+        if prefixListName == "" {
+            sb.WriteString(r.String() + "\n")
+            continue
+        }
+        comment = "!"
+        fmt.Fprintf(&sb, "ip prefix-list %s permit %s/%d", prefixListName, uint32ToIP(r.Start), r.Length)
+        if r.Min > r.Length {
+            fmt.Fprintf(&sb, " ge %d", r.Min)
+        }
+        if r.Max > r.Length && (r.Max < 32 || r.Min == r.Length) {
+            fmt.Fprintf(&sb, " le %d", r.Max)
+        }
+        sb.WriteString("\n")
+    }
+
+    if output == "" {
+        fmt.Print(sb.String())
+        statusf("%d prefix ranges match %s\n", len(ranges), filterText)
+        return
+    }
+    meta := outputMeta{Selection: "RPSL filter " + filterText, Entries: len(ranges), Comment: comment}
+    if err := writeOutputFile(output, sb.String(), meta); err != nil {
+        errorf("Error writing prefix list: %v\n", err)
+        return
+    }
+    statusf("Prefix list created at: %s (%d prefix ranges)\n", output, len(ranges))
+}
+
+//-------------------------------------------------------------------------
+// Announced prefixes from RIPEstat
+//-------------------------------------------------------------------------
+
+// ripestatURL is the base of the RIPEstat Data API.
+const ripestatURL = "https://stat.ripe.net/data/"
+
+// ripestatConnections bounds the parallel requests of -announced-country; RIPEstat throttles
+// clients that open many connections at once.
+const ripestatConnections = 4
+
+// fetchRIPEstat calls a RIPEstat Data API endpoint for a resource and decodes the "data" member of
+// the answer into v. Answers are cached under the cache directory, so --offline and failed requests
+// fall back to the last answer.
+func fetchRIPEstat(endpoint, resource string, v any) error {
+    cachePath := filepath.Join(cacheDir, "ripestat", endpoint+"_"+strings.ReplaceAll(resource, "/", "_")+".json")
+    var body []byte
+    var err error
+    if offline {
+        if body, err = os.ReadFile(cachePath); err != nil {
+            return fmt.Errorf("RIPEstat %s for %s is not in the cache and --offline is set", endpoint, resource)
+        }
+    } else {
+        query := url.Values{"resource": {resource}, "sourceapp": {"chicha-whois"}}
+        client := &http.Client{Timeout: 120 * time.Second}
+        resp, getErr := client.Get(ripestatURL + endpoint + "/data.json?" + query.Encode())
+        if err = getErr; err == nil {
+            defer resp.Body.Close()
+            if resp.StatusCode != http.StatusOK {
+                err = fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+            } else {
+                body, err = io.ReadAll(io.LimitReader(resp.Body, 256<<20))
+            }
+        }
+        if err != nil {
+            cached, cacheErr := os.ReadFile(cachePath)
+            if cacheErr != nil {
+                return fmt.Errorf("RIPEstat %s for %s: %v", endpoint, resource, err)
+            }
+            warnf("Could not query RIPEstat %s for %s (%v), using the cached answer\n", endpoint, resource, err)
+            body = cached
+        } else if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err == nil {
+            _ = os.WriteFile(cachePath, body, 0644)
+        }
+    }
+
+    var answer struct {
+        Status string          `json:"status"`
+        Data   json.RawMessage `json:"data"`
+    }
+    if err := json.Unmarshal(body, &answer); err != nil {
+        return fmt.Errorf("RIPEstat %s for %s: %v", endpoint, resource, err)
+    }
+    if answer.Status != "ok" {
+        return fmt.Errorf("RIPEstat %s for %s: status %q", endpoint, resource, answer.Status)
+    }
+    return json.Unmarshal(answer.Data, v)
+}
+
+// announcedPrefixes returns the IPv4 prefixes an ASN announces, as seen by the RIPE RIS collectors
+// over the last two weeks (the RIPEstat default window).
+func announcedPrefixes(asn string) ([]string, error) {
+    var data struct {
+        Prefixes []struct {
+            Prefix string `json:"prefix"`
+        } `json:"prefixes"`
+    }
+    if err := fetchRIPEstat("announced-prefixes", asn, &data); err != nil {
+        return nil, err
+    }
+    var cidrs []string
+    for _, p := range data.Prefixes {
+        if _, ipNet, err := net.ParseCIDR(p.Prefix); err == nil && ipNet.IP.To4() != nil {
+            cidrs = append(cidrs, ipNet.String())
+        }
+    }
+    return cidrs, nil
+}
+
+// countryASNs returns the ASNs registered to a country, from RIPEstat's country resource list.
+func countryASNs(countryCode string) ([]string, error) {
+    var data struct {
+        Resources struct {
+            ASN []string `json:"asn"`
+        } `json:"resources"`
+    }
+    if err := fetchRIPEstat("country-resource-list", countryCode, &data); err != nil {
+        return nil, err
+    }
+    asns := make([]string, 0, len(data.Resources.ASN))
+    for _, asn := range data.Resources.ASN {
+        asns = append(asns, "AS"+strings.TrimPrefix(strings.ToUpper(asn), "AS"))
+    }
+    return asns, nil
+}
+
+// announcedByASNs fetches the announced prefixes of several ASNs in parallel. ASNs whose query
+// fails are reported and skipped; ok is false only if every query failed. With --mrt, the prefixes
+// are taken from the RIB dump instead.
+func announcedByASNs(asns []string) ([]string, bool) {
+    if mrtPath != "" {
+        routes, err := loadMRTRIB()
+        if err != nil {
+            errorln(err)
+            return nil, false
+        }
+        wanted := make(map[string]bool, len(asns))
+        for _, asn := range asns {
+            wanted[asn] = true
+        }
+        var cidrs []string
+        for _, route := range routes {
+            for _, origin := range route.Origins {
+                if wanted[origin] {
+                    cidrs = append(cidrs, route.cidr())
+                    break
+                }
+            }
+        }
+        return cidrs, true
+    }
+    var mu sync.Mutex
+    var cidrs []string
+    failed := 0
+    jobs := make(chan string)
+    var wg sync.WaitGroup
+    for range min(ripestatConnections, len(asns)) {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for asn := range jobs {
+                prefixes, err := announcedPrefixes(asn)
+                mu.Lock()
+                if err != nil {
+                    failed++
+                    warnf("%v\n", err)
+                }
+                cidrs = append(cidrs, prefixes...)
+                mu.Unlock()
+            }
+        }()
+    }
+    for _, asn := range asns {
+        jobs <- asn
+    }
+    close(jobs)
+    wg.Wait()
+    return cidrs, len(asns) == 0 || failed < len(asns)
+}
+
+// createAnnouncedList writes the prefixes currently announced by the given ASNs, or by the ASNs registered
+// to a country when countryCode is set, to announced_NAME.txt (one CIDR per line), so lists can follow
+// live BGP visibility instead of registry assignments. Prefixes of a country's ASNs may be announced
+// for address space registered elsewhere. With --mrt, a country gets the prefixes of the RIB that lie
+// in space registered to it, so the list is built offline.
+func createAnnouncedList(asns []string, countryCode string, filtered bool, output string) {
+    name := strings.Join(asns, "_")
+    selection := "prefixes announced by " + strings.Join(asns, ", ")
+    var cidrs []string
+    if countryCode != "" && mrtPath != "" {
+        name = countryCode
+        selection = "prefixes of " + filepath.Base(mrtPath) + " in space registered to " + countryCode
+        routes, err := loadMRTRIB()
+        if err != nil {
+            errorln(err)
+            return
+        }
+        var registered []addrInterval
+        for _, cidr := range extractCountryCIDRs(countryCode, ripedbPath, false) {
+            if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+                registered = append(registered, addrInterval{binary.BigEndian.Uint32(ipNet.IP.To4()), binary.BigEndian.Uint32(lastIP(ipNet))})
+            }
+        }
+        registered = mergeIntervals(registered)
+        for _, route := range routes {
+            iv := route.interval()
+            if _, covers := intervalCoverage(registered, iv.Start, iv.End); covers {
+                cidrs = append(cidrs, route.cidr())
+            }
+        }
+    } else if countryCode != "" {
+        name = countryCode
+        selection = "prefixes announced by ASNs registered to " + countryCode
+        for _, code := range countryMembers(countryCode) {
+            codeASNs, err := countryASNs(code)
+            if err != nil {
+                errorln(err)
+                return
+            }
+            asns = append(asns, codeASNs...)
+        }
+        statusf("Fetching the announced prefixes of %d ASNs registered to %s from RIPEstat...\n", len(asns), countryCode)
+    }
+
+    if countryCode == "" || mrtPath == "" {
+        var ok bool
+        if cidrs, ok = announcedByASNs(asns); !ok {
+            return
+        }
+    }
+    if len(cidrs) == 0 {
+        warnf("No announced prefixes found for %s\n", name)
+        return
+    }
+    cidrs, summary := preparePrefixes(name, cidrs, filtered)
+    printPrefixSummary(summary)
+
+    if output == "" {
+        homeDir, _ := os.UserHomeDir()
+        output = filepath.Join(homeDir, fmt.Sprintf("announced_%s.txt", name))
+    }
+    if filtered {
+        selection += " (filtered)"
+    }
+    meta := outputMeta{Selection: selection, Entries: len(cidrs), Comment: "#"}
+    if err := writeOutputFile(output, strings.Join(cidrs, "\n")+"\n", meta); err != nil {
+        errorf("Error writing prefix list: %v\n", err)
+        return
+    }
+    if countryCode != "" {
+        reportCountry(countryCode, len(cidrs))
+    }
+    statusf("Announced prefix list created at: %s (%d prefixes)\n", output, len(cidrs))
+}
+
+// subtractIntervals returns the parts of the merged intervals a that are not in the merged intervals b.
+func subtractIntervals(a, b []addrInterval) []addrInterval {
+    var out []addrInterval
+    j := 0
+    for _, iv := range a {
+        start := uint64(iv.Start)
+        for j < len(b) && b[j].End < iv.Start {
+            j++
+        }
+        for k := j; k < len(b) && b[k].Start <= iv.End && start <= uint64(iv.End); k++ {
+            if uint64(b[k].Start) > start {
+                out = append(out, addrInterval{uint32(start), b[k].Start - 1})
+            }
+            start = uint64(b[k].End) + 1
+        }
+        if start <= uint64(iv.End) {
+            out = append(out, addrInterval{uint32(start), iv.End})
+        }
+    }
+    return out
+}
+
+// compareAnnounced compares the registry with BGP for a country: address space registered to the
+// country that none of its ASNs announces, and space its ASNs announce that is registered to another
+// country (or to none in the RIPE database). Announcements come from RIPEstat or, with --mrt, from the
+// RIB dump: then unannounced space is what nobody announces, and the country's ASNs are those that
+// announce mostly its space (see mrtCountryASNs). The report has one "prefix, status, registry country"
+// line per prefix and is printed or written to output.
+func compareAnnounced(countryCode, output string) {
+    var asns []string
+    var visible []addrInterval
+    if mrtPath != "" {
+        routes, err := loadMRTRIB()
+        if err != nil {
+            errorln(err)
+            return
+        }
+        asns = mrtCountryASNs(countryCode, routes)
+        for _, route := range routes {
+            visible = append(visible, route.interval())
+        }
+        visible = mergeIntervals(visible)
+        statusf("%d ASNs announce mostly space registered to %s\n", len(asns), countryCode)
+    } else {
+        for _, code := range countryMembers(countryCode) {
+            codeASNs, err := countryASNs(code)
+            if err != nil {
+                errorln(err)
+                return
+            }
+            asns = append(asns, codeASNs...)
+        }
+        statusf("Fetching the announced prefixes of %d ASNs registered to %s from RIPEstat...\n", len(asns), countryCode)
+    }
+    cidrs, ok := announcedByASNs(asns)
+    if !ok {
+        return
+    }
+    var announced []addrInterval
+    for _, cidr := range cidrs {
+        if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+            announced = append(announced, addrInterval{binary.BigEndian.Uint32(ipNet.IP.To4()), binary.BigEndian.Uint32(lastIP(ipNet))})
+        }
+    }
+    announced = mergeIntervals(announced)
+    if visible == nil {
+        visible = announced
+    }
+
+    ripe, err := ripeGeoLayer(countryCode, announced)
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+    registry, _ := paintGeoLayers([][]geoEntry{ripe})
+
+    var sb strings.Builder
+    entries := 0
+    var unannounced, foreign uint64
+    // emit writes the exact CIDRs of an interval with its status.
+    emit := func(iv addrInterval, status, country string) {
+        for _, cidr := range splitRangeToCIDRs(iv.Start, iv.End) {
+            fmt.Fprintf(&sb, "%s\t%s\t%s\n", cidr, status, country)
+            entries++
+        }
+    }
+
+    var registered []addrInterval
+    for _, e := range registry {
+        if inCountrySelection(countryCode, e.Country) {
+            registered = append(registered, e.addrInterval)
+        }
+    }
+    for _, iv := range subtractIntervals(mergeIntervals(registered), visible) {
+        unannounced += uint64(iv.End-iv.Start) + 1
+        emit(iv, "unannounced", countryCode)
+    }
+
+    // Announced space is walked against the registry view, which has no overlaps.
+    var covered []addrInterval
+    for _, e := range registry {
+        overlaps, _ := intervalCoverage(announced, e.Start, e.End)
+        if !overlaps {
+            continue
+        }
+        covered = append(covered, e.addrInterval)
+        if inCountrySelection(countryCode, e.Country) {
+            continue
+        }
+        for _, iv := range subtractIntervals([]addrInterval{e.addrInterval}, subtractIntervals([]addrInterval{e.addrInterval}, announced)) {
+            foreign += uint64(iv.End-iv.Start) + 1
+            emit(iv, "registered-elsewhere", e.Country)
+        }
+    }
+    for _, iv := range subtractIntervals(announced, mergeIntervals(covered)) {
+        foreign += uint64(iv.End-iv.Start) + 1
+        emit(iv, "unregistered", "-")
+    }
+
+    header := "# prefix\tstatus\tregistry country\n"
+    if output == "" {
+        fmt.Print(header + sb.String())
+    } else {
+        meta := outputMeta{Selection: "registered vs announced space of " + countryCode, Entries: entries, Comment: "#"}
+        if err := writeOutputFile(output, header+sb.String(), meta); err != nil {
+            errorf("Error writing comparison report: %v\n", err)
+            return
+        }
+        statusf("Comparison report created at: %s\n", output)
+    }
+    statusf("%s: %d addresses registered but not announced, %d announced by its ASNs but registered elsewhere or not at all\n", countryCode, unannounced, foreign)
+}
+
+//-------------------------------------------------------------------------
+// MRT RIB dumps
+//-------------------------------------------------------------------------
+
+// ribRoute is an IPv4 prefix of an MRT RIB dump with the origin ASNs of its paths.
+type ribRoute struct {
+    Start   uint32
+    Length  int
+    Origins []string // Sorted; several for MOAS prefixes.
+}
+
+// MRT record types and TABLE_DUMP_V2 subtypes (RFC 6396, RFC 8050) read by loadMRT.
+const (
+    mrtTableDumpV2        = 13
+    mrtRIBIPv4Unicast     = 2
+    mrtRIBIPv4UnicastPath = 8 // RIB_IPV4_UNICAST_ADDPATH: entries carry a path identifier.
+)
+
+// loadMRT reads the IPv4 unicast RIB of a TABLE_DUMP_V2 file, as published by RIPE RIS (bview.*.gz)
+// and RouteViews (rib.*.bz2), and returns every prefix with the origins seen by any peer.
+// Compressed files are detected from their contents; other record types are skipped.
+func loadMRT(path string) ([]ribRoute, error) {
+    file, err := openDatabase(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    r := bufio.NewReaderSize(file, 1<<20)
+
+    type ribKey struct {
+        start  uint32
+        length int
+    }
+    origins := make(map[ribKey]map[uint32]bool)
+    header := make([]byte, 12)
+    var body []byte
+    for {
+        if _, err := io.ReadFull(r, header); err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("%s: truncated MRT record: %v", path, err)
+        }
+        recordType := binary.BigEndian.Uint16(header[4:6])
+        subtype := binary.BigEndian.Uint16(header[6:8])
+        length := binary.BigEndian.Uint32(header[8:12])
+        if recordType != mrtTableDumpV2 || (subtype != mrtRIBIPv4Unicast && subtype != mrtRIBIPv4UnicastPath) {
+            if _, err := r.Discard(int(length)); err != nil {
+                return nil, fmt.Errorf("%s: truncated MRT record: %v", path, err)
+            }
+            continue
+        }
+        if cap(body) < int(length) {
+            body = make([]byte, length)
+        }
+        body = body[:length]
+        if _, err := io.ReadFull(r, body); err != nil {
+            return nil, fmt.Errorf("%s: truncated MRT record: %v", path, err)
+        }
+
+        // Sequence number, prefix length and the significant bytes of the prefix, then the entries.
+        if len(body) < 5 || body[4] > 32 {
+            continue
+        }
+        prefixLength := int(body[4])
+        n := (prefixLength + 7) / 8
+        if len(body) < 7+n {
+            continue
+        }
+        var ip [4]byte
+        copy(ip[:], body[5:5+n])
+        key := ribKey{binary.BigEndian.Uint32(ip[:]), prefixLength}
+        entries := int(binary.BigEndian.Uint16(body[5+n:]))
+        pos := 7 + n
+        for range entries {
+            // Peer index and originated time, the path identifier with ADD-PATH, then the attributes.
+            pos += 6
+            if subtype == mrtRIBIPv4UnicastPath {
+                pos += 4
+            }
+            if pos+2 > len(body) {
+                break
+            }
+            attrLength := int(binary.BigEndian.Uint16(body[pos:]))
+            pos += 2
+            if pos+attrLength > len(body) {
+                break
+            }
+            if origin, ok := mrtOrigin(body[pos : pos+attrLength]); ok {
+                if origins[key] == nil {
+                    origins[key] = make(map[uint32]bool)
+                }
+                origins[key][origin] = true
+            }
+            pos += attrLength
+        }
+    }
+
+    routes := make([]ribRoute, 0, len(origins))
+    for key, asns := range origins {
+        route := ribRoute{Start: key.start, Length: key.length}
+        for _, asn := range slices.Sorted(maps.Keys(asns)) {
+            route.Origins = append(route.Origins, fmt.Sprintf("AS%d", asn))
+        }
+        routes = append(routes, route)
+    }
+    sort.Slice(routes, func(i, j int) bool {
+        if routes[i].Start != routes[j].Start {
+            return routes[i].Start < routes[j].Start
+        }
+        return routes[i].Length < routes[j].Length
+    })
+    return routes, nil
+}
+
+// mrtOrigin returns the origin ASN of a path from its BGP path attributes: the last ASN of an
+// AS_SEQUENCE, or the only member of a final AS_SET. AS_PATH in TABLE_DUMP_V2 uses 4-byte ASNs.
+func mrtOrigin(attrs []byte) (uint32, bool) {
+    for len(attrs) >= 3 {
+        flags, attrType := attrs[0], attrs[1]
+        length, headerLength := int(attrs[2]), 3
+        if flags&0x10 != 0 { // Extended length.
+            if len(attrs) < 4 {
+                return 0, false
+            }
+            length, headerLength = int(binary.BigEndian.Uint16(attrs[2:4])), 4
+        }
+        if headerLength+length > len(attrs) {
+            return 0, false
+        }
+        if attrType != 2 { // AS_PATH
+            attrs = attrs[headerLength+length:]
+            continue
+        }
+        path := attrs[headerLength : headerLength+length]
+        var origin uint32
+        found := false
+        for len(path) >= 2 {
+            segmentType, count := path[0], int(path[1])
+            if len(path) < 2+4*count {
+                return 0, false
+            }
+            found = count > 0 && (segmentType == 2 || count == 1)
+            if found {
+                origin = binary.BigEndian.Uint32(path[2+4*(count-1):])
+            }
+            path = path[2+4*count:]
+        }
+        return origin, found
+    }
+    return 0, false
+}
+
+// mrtRIB is the RIB given with --mrt, loaded on first use.
+var mrtRIB struct {
+    once   sync.Once
+    routes []ribRoute
+    err    error
+}
+
+// loadMRTRIB returns the routes of the --mrt file, reading it only once per run.
+func loadMRTRIB() ([]ribRoute, error) {
+    mrtRIB.once.Do(func() {
+        statusf("Reading the MRT RIB dump %s...\n", mrtPath)
+        mrtRIB.routes, mrtRIB.err = loadMRT(mrtPath)
+    })
+    return mrtRIB.routes, mrtRIB.err
+}
+
+// cidr formats the prefix of a RIB route.
+func (r ribRoute) cidr() string {
+    return fmt.Sprintf("%s/%d", uint32ToIP(r.Start), r.Length)
+}
+
+// interval returns the addresses of a RIB route.
+func (r ribRoute) interval() addrInterval {
+    return addrInterval{r.Start, r.Start | uint32(uint64(1)<<(32-r.Length)-1)}
+}
+
+// createMRTOriginTable writes the prefix-to-origin table of an MRT RIB dump: "prefix<TAB>origin ASNs"
+// per IPv4 prefix, printed or written to output.
+func createMRTOriginTable(path, output string) {
+    routes, err := loadMRT(path)
+    if err != nil {
+        errorln(err)
+        return
+    }
+    var sb strings.Builder
+    sb.WriteString("# prefix\torigins\n")
+    for _, route := range routes {
+        fmt.Fprintf(&sb, "%s\t%s\n", route.cidr(), strings.Join(route.Origins, " "))
+    }
+    if output == "" {
+        fmt.Print(sb.String())
+        return
+    }
+    meta := outputMeta{Selection: "origins of the IPv4 prefixes in " + filepath.Base(path), Entries: len(routes), Comment: "#"}
+    if err := writeOutputFile(output, sb.String(), meta); err != nil {
+        errorf("Error writing prefix list: %v\n", err)
+        return
+    }
+    statusf("Origin table created at: %s (%d prefixes)\n", output, len(routes))
+}
+
+// mrtCountryASNs returns the origins in a RIB that operate mainly in the space registered to a country:
+// more than half of the addresses they announce are registered to it. Transit networks announcing a
+// few customer blocks of the country are therefore not counted as its ASNs.
+func mrtCountryASNs(countryCode string, routes []ribRoute) []string {
+    var registered []addrInterval
+    for _, cidr := range extractCountryCIDRs(countryCode, ripedbPath, false) {
+        if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+            registered = append(registered, addrInterval{binary.BigEndian.Uint32(ipNet.IP.To4()), binary.BigEndian.Uint32(lastIP(ipNet))})
+        }
+    }
+    registered = mergeIntervals(registered)
+
+    total := make(map[string]uint64)
+    inside := make(map[string]uint64)
+    for _, route := range routes {
+        iv := route.interval()
+        size := uint64(iv.End-iv.Start) + 1
+        covered := intervalOverlap(registered, iv)
+        for _, origin := range route.Origins {
+            total[origin] += size
+            inside[origin] += covered
+        }
+    }
+    var asns []string
+    for asn, n := range inside {
+        if 2*n > total[asn] {
+            asns = append(asns, asn)
+        }
+    }
+    sort.Strings(asns)
+    return asns
+}
+
+//-------------------------------------------------------------------------
+// Geolocation layers and geofeeds (RFC 8805 / RFC 9632)
+//-------------------------------------------------------------------------
+
+// geoEntry is an address interval with the location one source gives for it.
+type geoEntry struct {
+    addrInterval
+    Country, Region, City, Postal string
+    Source                        string // Where the entry comes from, e.g. "ripe" or a geofeed URL.
+}
+
+// geoConflict is an interval on which a lower precedence source names a different country than the winner.
+type geoConflict struct {
+    addrInterval
+    Winner, Loser *geoEntry
+}
+
+// paintGeoLayers merges location layers into one non-overlapping view. An entry of an earlier layer wins
+// over later layers; within a layer the more specific entry wins, as a nested inetnum does over its parent.
+// Intervals where a losing layer disagrees about the country are returned as conflicts.
+func paintGeoLayers(layers [][]geoEntry) ([]geoEntry, []geoConflict) {
+    type ranked struct {
+        entry *geoEntry
+        layer int
+    }
+    var all []ranked
+    var bounds []uint64
+    for layer := range layers {
+        for i := range layers[layer] {
+            e := &layers[layer][i]
+            all = append(all, ranked{e, layer})
+            bounds = append(bounds, uint64(e.Start), uint64(e.End)+1)
+        }
+    }
+    sort.Slice(all, func(i, j int) bool { return all[i].entry.Start < all[j].entry.Start })
+    slices.Sort(bounds)
+    bounds = slices.Compact(bounds)
+
+    // outranks reports whether a takes precedence over b.
+    outranks := func(a, b ranked) bool {
+        if a.layer != b.layer {
+            return a.layer < b.layer
+        }
+        return a.entry.End-a.entry.Start < b.entry.End-b.entry.Start
+    }
+
+    var painted []geoEntry
+    var conflicts []geoConflict
+    var active []ranked
+    var lastWinner *geoEntry
+    next := 0
+    for b := 0; b+1 < len(bounds); b++ {
+        start, end := uint32(bounds[b]), uint32(bounds[b+1]-1)
+        kept := active[:0]
+        for _, r := range active {
+            if r.entry.End >= start {
+                kept = append(kept, r)
+            }
+        }
+        active = kept
+        for next < len(all) && all[next].entry.Start <= start {
+            active = append(active, all[next])
+            next++
+        }
+        if len(active) == 0 {
+            lastWinner = nil
+            continue
+        }
+
+        winner := active[0]
+        for _, r := range active[1:] {
+            if outranks(r, winner) {
+                winner = r
+            }
+        }
+        if n := len(painted); n > 0 && lastWinner == winner.entry {
+            painted[n-1].End = end
+        } else {
+            seg := *winner.entry
+            seg.Start, seg.End = start, end
+            painted = append(painted, seg)
+        }
+        lastWinner = winner.entry
+
+        // Each losing layer is represented by its own most specific entry, as the layer alone would paint it.
+        losers := make(map[int]ranked)
+        for _, r := range active {
+            if best, ok := losers[r.layer]; r.layer != winner.layer && (!ok || outranks(r, best)) {
+                losers[r.layer] = r
+            }
+        }
+        for layer := range layers {
+            r, ok := losers[layer]
+            if !ok || r.entry.Country == "" || strings.EqualFold(r.entry.Country, winner.entry.Country) {
+                continue
+            }
+            if n := len(conflicts); n > 0 && conflicts[n-1].Winner == winner.entry && conflicts[n-1].Loser == r.entry && uint64(conflicts[n-1].End)+1 == uint64(start) {
+                conflicts[n-1].End = end
+                continue
+            }
+            conflicts = append(conflicts, geoConflict{addrInterval{start, end}, winner.entry, r.entry})
+        }
+    }
+    return painted, conflicts
+}
+
+// splitRangeToCIDRs returns the exact list of CIDRs covering start-end, without adding any address.
+func splitRangeToCIDRs(start, end uint32) []string {
+    var cidrs []string
+    for cur := uint64(start); cur <= uint64(end); {
+        size := alignedBlock(cur, end)
+        cidrs = append(cidrs, fmt.Sprintf("%s/%d", uint32ToIP(uint32(cur)), 32-bits.TrailingZeros64(size)))
+        cur += size
+    }
+    return cidrs
+}
+
+// geofeedRemarkRe finds a geofeed URL announced in a remarks: line ("remarks: Geofeed https://...").
+var geofeedRemarkRe = regexp.MustCompile(`(?i)^geofeed\s+(https://\S+)`)
+
+// geofeedRef is a geofeed URL together with the inetnum that refers to it, which limits what the feed may locate.
+type geofeedRef struct {
+    URL     string
+    Inetnum string
+    Scope   addrInterval
+}
+
+// discoverGeofeeds lists the geofeed URLs referenced by the inetnum blocks of a country (of all countries
+// if countryCode is empty), from geofeed: attributes or "remarks: Geofeed URL" lines.
+func discoverGeofeeds(countryCode string) ([]geofeedRef, error) {
+    var refs []geofeedRef
+    err := scanBlocks(ripedbPath, func(blockLines []string) {
+        if countryCode != "" && !inCountrySelection(countryCode, strings.ToUpper(blockAttr(blockLines, "country"))) {
+            return
+        }
+        value := blockAttr(blockLines, "inetnum")
+        start, end, ok := parseInetnumRange(value)
+        if !ok {
+            return
+        }
+        for _, url := range geofeedURLs(blockLines) {
+            refs = append(refs, geofeedRef{URL: url, Inetnum: value, Scope: addrInterval{start, end}})
+        }
+    })
+    return refs, err
+}
+
+// geofeedURLs returns the geofeed URLs an object announces in geofeed: attributes or "remarks: Geofeed URL" lines.
+func geofeedURLs(blockLines []string) []string {
+    var urls []string
+    for _, line := range blockLines {
+        name, attr, found := strings.Cut(line, ":")
+        if !found {
+            continue
+        }
+        attr = strings.TrimSpace(attr)
+        switch strings.ToLower(strings.TrimSpace(name)) {
+        case "geofeed":
+            if attr != "" && !slices.Contains(urls, attr) {
+                urls = append(urls, attr)
+            }
+        case "remarks":
+            if m := geofeedRemarkRe.FindStringSubmatch(attr); m != nil && !slices.Contains(urls, m[1]) {
+                urls = append(urls, m[1])
+            }
+        }
+    }
+    return urls
+}
+
+// discoveredGeofeed is a geofeed URL found by -geofeeds with the objects that refer to it and,
+// with -fetch, the result of checking the feed.
+type discoveredGeofeed struct {
+    URL       string
+    Countries []string       // Countries of the referring objects, sorted.
+    Orgs      []string       // Organisations (org: handles) of the referring objects, sorted.
+    Objects   int            // Number of referring inetnum and inet6num objects.
+    Scope     []addrInterval // IPv4 ranges of the referring inetnums.
+    Check     *geofeedCheck  // Set by -fetch.
+}
+
+// geofeedCheck is the result of fetching and validating a geofeed.
+type geofeedCheck struct {
+    Err     error // Why the feed could not be fetched or parsed.
+    Entries int   // Valid entries.
+    Invalid int   // Lines with an unparsable prefix or a malformed country or region.
+    Outside int   // IPv4 entries outside the inetnums that refer to the feed (ignored per RFC 9632), if any do.
+}
+
+// geofeedRegionRe matches an ISO 3166-2 region code as required by RFC 8805 (e.g. RU-MOW).
+var geofeedRegionRe = regexp.MustCompile(`^[A-Z]{2}-[A-Z0-9]{1,3}$`)
+
+// discoverAllGeofeeds lists the geofeed URLs announced in the cached inetnum objects, and in the
+// inet6num objects if they are cached, grouped by URL and sorted by country and URL. With countryCode,
+// only objects of that country (or country group) are considered.
+func discoverAllGeofeeds(countryCode string) ([]*discoveredGeofeed, error) {
+    byURL := make(map[string]*discoveredGeofeed)
+    countries := make(map[string]map[string]bool)
+    orgs := make(map[string]map[string]bool)
+    collect := func(blockLines []string) {
+        urls := geofeedURLs(blockLines)
+        if len(urls) == 0 {
+            return
+        }
+        country := strings.ToUpper(blockAttr(blockLines, "country"))
+        if countryCode != "" && !inCountrySelection(countryCode, country) {
+            return
+        }
+        start, end, isIPv4 := parseInetnumRange(blockAttr(blockLines, "inetnum"))
+        org := blockAttr(blockLines, "org")
+        for _, url := range urls {
+            feed, ok := byURL[url]
+            if !ok {
+                feed = &discoveredGeofeed{URL: url}
+                byURL[url] = feed
+                countries[url] = make(map[string]bool)
+                orgs[url] = make(map[string]bool)
+            }
+            feed.Objects++
+            if isIPv4 {
+                feed.Scope = append(feed.Scope, addrInterval{start, end})
+            }
+            if country != "" {
+                countries[url][country] = true
+            }
+            if org != "" {
+                orgs[url][org] = true
+            }
+        }
+    }
+    if err := scanObjects("inetnum", collect); err != nil {
+        return nil, err
+    }
+    if fileExists(objectCachePath("inet6num")) || fileExists(fullDBPath()) {
+        if err := scanObjects("inet6num", collect); err != nil {
+            warnf("Warning: inet6num objects not scanned: %v\n", err)
+        }
+    }
+
+    feeds := make([]*discoveredGeofeed, 0, len(byURL))
+    for url, feed := range byURL {
+        feed.Countries = slices.Sorted(maps.Keys(countries[url]))
+        feed.Orgs = slices.Sorted(maps.Keys(orgs[url]))
+        feed.Scope = mergeIntervals(feed.Scope)
+        feeds = append(feeds, feed)
+    }
+    sort.Slice(feeds, func(i, j int) bool {
+        a, b := strings.Join(feeds[i].Countries, ","), strings.Join(feeds[j].Countries, ",")
+        if a != b {
+            return a < b
+        }
+        return feeds[i].URL < feeds[j].URL
+    })
+    return feeds, nil
+}
+
+// checkGeofeed validates a fetched feed line by line: the prefix must parse, the country must be empty
+// or a two-letter code and the region empty or an ISO 3166-2 code; IPv4 entries must lie inside scope.
+// An empty scope (a feed referred to only by inet6num objects) is not checked.
+func checkGeofeed(data []byte, scope []addrInterval) *geofeedCheck {
+    r := csv.NewReader(bytes.NewReader(data))
+    r.Comment = '#'
+    r.FieldsPerRecord = -1
+    r.TrimLeadingSpace = true
+    records, err := r.ReadAll()
+    if err != nil {
+        return &geofeedCheck{Err: err}
+    }
+    check := &geofeedCheck{}
+    for _, record := range records {
+        for len(record) < 3 {
+            record = append(record, "")
+        }
+        _, ipNet, err := net.ParseCIDR(strings.TrimSpace(record[0]))
+        country := strings.ToUpper(strings.TrimSpace(record[1]))
+        region := strings.ToUpper(strings.TrimSpace(record[2]))
+        if err != nil || country != "" && !countryCodeRe.MatchString(country) || region != "" && !geofeedRegionRe.MatchString(region) {
+            check.Invalid++
+            continue
+        }
+        if ip := ipNet.IP.To4(); ip != nil && len(scope) > 0 {
+            if _, covers := intervalCoverage(scope, binary.BigEndian.Uint32(ip), binary.BigEndian.Uint32(lastIP(ipNet))); !covers {
+                check.Outside++
+                continue
+            }
+        }
+        check.Entries++
+    }
+    return check
+}
+
+// listGeofeeds prints (or writes to output) the geofeed URLs found in the cached objects, one per line
+// with the number of referring objects, their countries and organisations; with fetch, every feed is
+// downloaded by --workers goroutines (cached for --offline) and validated.
+func listGeofeeds(countryCode string, fetch bool, output string) {
+    feeds, err := discoverAllGeofeeds(countryCode)
+    if err != nil {
+        errorln("Error reading the cached objects:", err)
+        return
+    }
+    if len(feeds) == 0 {
+        statusln("No geofeed URLs found in the cached objects.")
+        return
+    }
+
+    if fetch {
+        jobs := make(chan *discoveredGeofeed)
+        var wg sync.WaitGroup
+        for w := 0; w < max(workers, 1); w++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                for feed := range jobs {
+                    data, err := fetchGeofeed(feed.URL)
+                    if err != nil {
+                        feed.Check = &geofeedCheck{Err: err}
+                        continue
+                    }
+                    feed.Check = checkGeofeed(data, feed.Scope)
+                }
+            }()
+        }
+        for _, feed := range feeds {
+            jobs <- feed
+        }
+        close(jobs)
+        wg.Wait()
+    }
+
+    var sb strings.Builder
+    header := "# url\tobjects\tcountries\torgs"
+    if fetch {
+        header += "\tstatus\tentries\tinvalid\toutside"
+    }
+    sb.WriteString(header + "\n")
+    failed, broken := 0, 0
+    for _, feed := range feeds {
+        fmt.Fprintf(&sb, "%s\t%d\t%s\t%s", feed.URL, feed.Objects, strings.Join(feed.Countries, ","), strings.Join(feed.Orgs, ","))
+        if c := feed.Check; c != nil {
+            switch {
+            case c.Err != nil:
+                failed++
+                fmt.Fprintf(&sb, "\terror: %s\t\t\t", strings.ReplaceAll(c.Err.Error(), "\t", " "))
+            case c.Invalid > 0 || c.Outside > 0:
+                broken++
+                fmt.Fprintf(&sb, "\tinvalid\t%d\t%d\t%d", c.Entries, c.Invalid, c.Outside)
+            default:
+                fmt.Fprintf(&sb, "\tok\t%d\t%d\t%d", c.Entries, c.Invalid, c.Outside)
+            }
+        }
+        sb.WriteString("\n")
+    }
+
+    if output == "" {
+        fmt.Print(sb.String())
+    } else {
+        selection := "geofeed URLs in cached objects"
+        if countryCode != "" {
+            selection += ", country " + countryCode
+        }
+        meta := outputMeta{Selection: selection, Entries: len(feeds), Comment: "#"}
+        if err := writeOutputFile(output, sb.String(), meta); err != nil {
+            errorf("Error writing geofeed list: %v\n", err)
+            return
+        }
+        statusf("Geofeed list created at: %s\n", output)
+    }
+    statusf("%d geofeed URLs found\n", len(feeds))
+    if fetch {
+        statusf("%d feeds could not be fetched, %d have invalid or out-of-scope entries\n", failed, broken)
+    }
+}
+
+// geofeedCachePath returns where a fetched geofeed is kept, so that --offline runs can reuse it.
+func geofeedCachePath(url string) string {
+    return filepath.Join(cacheDir, "geofeeds", sha256Hex(url)[:16]+".csv")
+}
+
+// fetchGeofeed downloads a geofeed and caches it. With --offline, or if the download fails,
+// the cached copy is used instead.
+func fetchGeofeed(url string) ([]byte, error) {
+    cachePath := geofeedCachePath(url)
+    if offline {
+        cached, err := os.ReadFile(cachePath)
+        if err != nil {
+            return nil, fmt.Errorf("not in the cache and --offline is set")
+        }
+        return cached, nil
+    }
+
+    client := &http.Client{Timeout: 30 * time.Second}
+    resp, err := client.Get(url)
+    if err == nil {
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            err = fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+        }
+    }
+    var body []byte
+    if err == nil {
+        body, err = io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+    }
+    if err != nil {
+        cached, cacheErr := os.ReadFile(cachePath)
+        if cacheErr != nil {
+            return nil, err
+        }
+        warnf("Could not fetch geofeed %s (%v), using the cached copy\n", url, err)
+        return cached, nil
+    }
+
+    if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err == nil {
+        _ = os.WriteFile(cachePath, body, 0644)
+    }
+    return body, nil
+}
+
+// parseGeofeed parses an RFC 8805 feed: "prefix,country,region,city,postal" per line, # for comments.
+// For hand-written override files the prefix may also be a "start-end" range.
+// IPv6 prefixes are skipped, as everything else in chicha-whois is IPv4.
+func parseGeofeed(data []byte, source string) ([]geoEntry, error) {
+    r := csv.NewReader(bytes.NewReader(data))
+    r.Comment = '#'
+    r.FieldsPerRecord = -1
+    r.TrimLeadingSpace = true
+    records, err := r.ReadAll()
+    if err != nil {
+        return nil, err
+    }
+
+    var entries []geoEntry
+    for _, record := range records {
+        for len(record) < 5 {
+            record = append(record, "")
+        }
+        var interval addrInterval
+        if _, ipNet, err := net.ParseCIDR(strings.TrimSpace(record[0])); err == nil {
+            if ipNet.IP.To4() == nil {
+                continue
+            }
+            interval = addrInterval{binary.BigEndian.Uint32(ipNet.IP.To4()), binary.BigEndian.Uint32(lastIP(ipNet))}
+        } else if start, end, ok := parseInetnumRange(record[0]); ok {
+            interval = addrInterval{start, end}
+        } else {
+            continue
+        }
+        entries = append(entries, geoEntry{
+            addrInterval: interval,
+            Country:      strings.ToUpper(strings.TrimSpace(record[1])),
+            Region:       strings.TrimSpace(record[2]),
+            City:         strings.TrimSpace(record[3]),
+            Postal:       strings.TrimSpace(record[4]),
+            Source:       source,
+        })
+    }
+    return entries, nil
+}
+
+// loadGeofeeds fetches and parses every referenced feed once. Following RFC 9632, entries of a feed found
+// through an inetnum are only kept inside that inetnum; feeds given explicitly (empty Inetnum) are trusted as is.
+func loadGeofeeds(refs []geofeedRef) []geoEntry {
+    scopes := make(map[string][]addrInterval)
+    var urls []string
+    for _, ref := range refs {
+        if _, seen := scopes[ref.URL]; !seen {
+            urls = append(urls, ref.URL)
+            scopes[ref.URL] = nil
+        }
+        if ref.Inetnum == "" {
+            scopes[ref.URL] = append(scopes[ref.URL], addrInterval{0, ^uint32(0)})
+        } else {
+            scopes[ref.URL] = append(scopes[ref.URL], ref.Scope)
+        }
+    }
+
+    var entries []geoEntry
+    for _, url := range urls {
+        data, err := fetchGeofeed(url)
+        if err != nil {
+            warnf("Skipping geofeed %s: %v\n", url, err)
+            continue
+        }
+        feed, err := parseGeofeed(data, url)
+        if err != nil {
+            warnf("Skipping geofeed %s: %v\n", url, err)
+            continue
+        }
+        scope := mergeIntervals(scopes[url])
+        dropped := 0
+        for _, e := range feed {
+            if _, covers := intervalCoverage(scope, e.Start, e.End); covers {
+                entries = append(entries, e)
+            } else {
+                dropped++
+            }
+        }
+        if dropped > 0 {
+            warnf("Geofeed %s: ignored %d entries outside the inetnum that references it\n", url, dropped)
+        }
+    }
+    return entries
+}
+
+// ripeGeoLayer returns the inetnum blocks of a country (or country group), plus the blocks of other
+// countries that overlap the given intervals, as a location layer.
+func ripeGeoLayer(countryCode string, extra []addrInterval) ([]geoEntry, error) {
+    extra = mergeIntervals(extra)
+    var entries []geoEntry
+    err := scanRanges(ripedbPath, false, func(r dbRange) {
+        if !inCountrySelection(countryCode, r.Country) {
+            if overlaps, _ := intervalCoverage(extra, r.Start, r.End); !overlaps {
+                return
+            }
+        }
+        entries = append(entries, geoEntry{addrInterval: addrInterval{r.Start, r.End}, Country: r.Country, Source: "ripe"})
+    })
+    return entries, err
+}
+
+// geofeedOptions holds the settings of -geofeed.
+type geofeedOptions struct {
+    URLs         []string // Feeds given explicitly with -url.
+    PreferRIPE   bool     // Keep the RIPE country where a feed disagrees; feeds then only add detail.
+    Region, City string   // Only export entries located in this region / city.
+    Output       string
+}
+
+// createGeofeedExport merges the geofeeds of a country with its inetnum data and writes the result in the
+// RFC 8805 format, so that exports can be narrowed down to a region or city.
+func createGeofeedExport(countryCode string, opts geofeedOptions) {
+    refs, err := discoverGeofeeds(countryCode)
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+    for _, url := range opts.URLs {
+        refs = append(refs, geofeedRef{URL: url})
+    }
+    feeds := loadGeofeeds(refs)
+
+    var feedSpace []addrInterval
+    for _, e := range feeds {
+        feedSpace = append(feedSpace, e.addrInterval)
+    }
+    ripe, err := ripeGeoLayer(countryCode, feedSpace)
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+
+    painted, conflicts := paintGeoLayers([][]geoEntry{feeds, ripe})
+    if opts.PreferRIPE {
+        // The registry decides the country: space RIPE gives to other countries is painted first, so feeds
+        // claiming it are ignored, and feeds only add region and city inside the country's own space.
+        registry, _ := paintGeoLayers([][]geoEntry{ripe})
+        var others, agreeing []geoEntry
+        for _, e := range registry {
+            if !inCountrySelection(countryCode, e.Country) {
+                others = append(others, e)
+            }
+        }
+        for _, e := range feeds {
+            if inCountrySelection(countryCode, e.Country) {
+                agreeing = append(agreeing, e)
+            }
+        }
+        painted, _ = paintGeoLayers([][]geoEntry{others, agreeing, registry})
+    }
+
+    var sb strings.Builder
+    entries := 0
+    for _, e := range painted {
+        if !inCountrySelection(countryCode, e.Country) ||
+            (opts.Region != "" && !strings.EqualFold(e.Region, opts.Region)) ||
+            (opts.City != "" && !strings.EqualFold(e.City, opts.City)) {
+            continue
+        }
+        for _, cidr := range splitRangeToCIDRs(e.Start, e.End) {
+            fmt.Fprintf(&sb, "%s,%s,%s,%s,%s\n", cidr, e.Country, e.Region, e.City, e.Postal)
+            entries++
+        }
+    }
+
+    if opts.Output == "" {
+        homeDir, _ := os.UserHomeDir()
+        opts.Output = filepath.Join(homeDir, fmt.Sprintf("geofeed_%s.csv", countryCode))
+    }
+    selection := "geofeed-merged locations of " + countryCode
+    if opts.Region != "" {
+        selection += ", region " + opts.Region
+    }
+    if opts.City != "" {
+        selection += ", city " + opts.City
+    }
+    meta := outputMeta{Selection: selection, Entries: entries, Comment: "#"}
+    if err := writeOutputFile(opts.Output, sb.String(), meta); err != nil {
+        errorf("Error writing geofeed file: %v\n", err)
+        return
+    }
+    statusf("Geofeed file created at: %s (%d prefixes from %d feed entries, %d disagreements with RIPE)\n",
+        opts.Output, entries, len(feeds), len(conflicts))
+}
+
+//-------------------------------------------------------------------------
+// Merged view of several data sources (--sources)
+//-------------------------------------------------------------------------
+
+// sourceSpec is one entry of --sources.
+type sourceSpec struct {
+    Kind string // "ripe", "geofeed", "csv", "rir" or "geolite2".
+    Path string // File or directory (csv, rir, geolite2) or URL (geofeed); empty for ripe and for geofeeds found in inetnums.
+}
+
+// String returns the spec as written on the command line.
+func (s sourceSpec) String() string {
+    if s.Path == "" {
+        return s.Kind
+    }
+    return s.Kind + ":" + s.Path
+}
+
+// parseSources parses a --sources list such as "geofeed,csv:/etc/overrides.csv,ripe,rir:delegated-arin".
+// The first source has the highest precedence.
+func parseSources(value string) ([]sourceSpec, error) {
+    var specs []sourceSpec
+    for _, item := range strings.Split(value, ",") {
+        item = strings.TrimSpace(item)
+        if item == "" {
+            continue
+        }
+        kind, path, _ := strings.Cut(item, ":")
+        spec := sourceSpec{Kind: strings.ToLower(kind), Path: path}
+        switch spec.Kind {
+        case "ripe":
+            if spec.Path != "" {
+                return nil, fmt.Errorf("source ripe takes no path (use --db)")
+            }
+        case "geofeed":
+        case "csv", "rir", "geolite2":
+            if spec.Path == "" {
+                return nil, fmt.Errorf("source %s needs a path: %s:FILE", spec.Kind, spec.Kind)
+            }
+        default:
+            return nil, fmt.Errorf("unknown source %q (known: ripe, geofeed[:URL], csv:FILE, rir:FILE, geolite2:PATH)", item)
+        }
+        specs = append(specs, spec)
+    }
+    if len(specs) == 0 {
+        return nil, fmt.Errorf("--sources needs at least one source")
+    }
+    return specs, nil
+}
+
+// parseDelegatedStats reads an RIR statistics file ("registry|cc|ipv4|start|count|date|status"),
+// as published by every RIR, keeping allocated and assigned IPv4 records.
+func parseDelegatedStats(data []byte, source string) []geoEntry {
+    var entries []geoEntry
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    for scanner.Scan() {
+        fields := strings.Split(strings.TrimSpace(scanner.Text()), "|")
+        if len(fields) < 7 || fields[2] != "ipv4" || (fields[6] != "allocated" && fields[6] != "assigned") {
+            continue
+        }
+        ip := net.ParseIP(fields[3]).To4()
+        count, err := strconv.ParseUint(fields[4], 10, 32)
+        if ip == nil || err != nil || count == 0 {
+            continue
+        }
+        start := binary.BigEndian.Uint32(ip)
+        if uint64(start)+count-1 > uint64(^uint32(0)) {
+            continue
+        }
+        entries = append(entries, geoEntry{
+            addrInterval: addrInterval{start, start + uint32(count-1)},
+            Country:      strings.ToUpper(fields[1]),
+            Source:       source,
+        })
+    }
+    return entries
+}
+
+// geoLite2Files finds the IPv4 blocks file and the English locations file of a GeoLite2 (or GeoIP2)
+// Country or City CSV database, given its directory or the blocks file itself.
+func geoLite2Files(path string) (blocks, locations string, err error) {
+    blocks = path
+    if fi, statErr := os.Stat(path); statErr != nil {
+        return "", "", statErr
+    } else if fi.IsDir() {
+        matches, _ := filepath.Glob(filepath.Join(path, "*-Blocks-IPv4.csv"))
+        if len(matches) == 0 {
+            return "", "", fmt.Errorf("no *-Blocks-IPv4.csv file in %s", path)
+        }
+        blocks = matches[0]
+    }
+    locations = strings.Replace(blocks, "Blocks-IPv4", "Locations-en", 1)
+    if locations == blocks || !fileExists(locations) {
+        return "", "", fmt.Errorf("locations file for %s not found (expected %s)", blocks, filepath.Base(locations))
+    }
+    return blocks, locations, nil
+}
+
+// readCSVWithHeader reads a CSV file and calls fn for each row with a column lookup by header name.
+func readCSVWithHeader(path string, fn func(col func(name string) string)) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    r := csv.NewReader(bufio.NewReader(file))
+    r.FieldsPerRecord = -1
+    header, err := r.Read()
+    if err != nil {
+        return fmt.Errorf("%s: %w", path, err)
+    }
+    columns := make(map[string]int, len(header))
+    for i, name := range header {
+        columns[strings.TrimPrefix(name, "\ufeff")] = i
+    }
+    for {
+        record, err := r.Read()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return fmt.Errorf("%s: %w", path, err)
+        }
+        fn(func(name string) string {
+            if i, ok := columns[name]; ok && i < len(record) {
+                return record[i]
+            }
+            return ""
+        })
+    }
+}
+
+// parseGeoLite2 loads a GeoLite2 Country or City CSV database into a location layer. A block's country is
+// that of its geoname_id, or of the registered country when MaxMind gives no location (e.g. anycast).
+// City databases also provide the region (as ISO 3166-2, e.g. RU-MOW), city and postal code.
+func parseGeoLite2(path string) ([]geoEntry, error) {
+    blocksPath, locationsPath, err := geoLite2Files(path)
+    if err != nil {
+        return nil, err
+    }
+
+    type location struct{ Country, Region, City string }
+    locations := make(map[string]location)
+    err = readCSVWithHeader(locationsPath, func(col func(string) string) {
+        loc := location{Country: strings.ToUpper(col("country_iso_code")), City: col("city_name")}
+        if subdivision := col("subdivision_1_iso_code"); subdivision != "" && loc.Country != "" {
+            loc.Region = loc.Country + "-" + subdivision
+        }
+        locations[col("geoname_id")] = loc
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var entries []geoEntry
+    err = readCSVWithHeader(blocksPath, func(col func(string) string) {
+        _, ipNet, err := net.ParseCIDR(col("network"))
+        if err != nil || ipNet.IP.To4() == nil {
+            return
+        }
+        loc, ok := locations[col("geoname_id")]
+        if !ok || loc.Country == "" {
+            loc, ok = locations[col("registered_country_geoname_id")]
+        }
+        if !ok || loc.Country == "" {
+            return
+        }
+        entries = append(entries, geoEntry{
+            addrInterval: addrInterval{binary.BigEndian.Uint32(ipNet.IP.To4()), binary.BigEndian.Uint32(lastIP(ipNet))},
+            Country:      loc.Country,
+            Region:       loc.Region,
+            City:         loc.City,
+            Postal:       col("postal_code"),
+            Source:       "geolite2",
+        })
+    })
+    return entries, err
+}
+
+// loadSourceLayer reads one source into a location layer.
+func loadSourceLayer(spec sourceSpec) ([]geoEntry, error) {
+    switch spec.Kind {
+    case "ripe":
+        var entries []geoEntry
+        err := scanRanges(ripedbPath, false, func(r dbRange) {
+            entries = append(entries, geoEntry{addrInterval: addrInterval{r.Start, r.End}, Country: r.Country, Source: "ripe"})
+        })
+        return entries, err
+    case "geofeed":
+        if spec.Path != "" {
+            return loadGeofeeds([]geofeedRef{{URL: spec.Path}}), nil
+        }
+        refs, err := discoverGeofeeds("")
+        if err != nil {
+            return nil, err
+        }
+        return loadGeofeeds(refs), nil
+    case "csv":
+        data, err := os.ReadFile(spec.Path)
+        if err != nil {
+            return nil, err
+        }
+        return parseGeofeed(data, spec.String())
+    case "rir":
+        data, err := os.ReadFile(spec.Path)
+        if err != nil {
+            return nil, err
+        }
+        return parseDelegatedStats(data, spec.String()), nil
+    case "geolite2":
+        return parseGeoLite2(spec.Path)
+    }
+    return nil, fmt.Errorf("unknown source %q", spec.Kind)
+}
+
+// merged holds the merged view; it is built once per run, on first use.
+var merged struct {
+    once      sync.Once
+    segments  []geoEntry
+    conflicts []geoConflict
+    err       error
+}
+
+// mergedView returns the disjoint segments of all --sources painted by precedence, and their conflicts.
+func mergedView() ([]geoEntry, []geoConflict, error) {
+    merged.once.Do(func() {
+        var layers [][]geoEntry
+        for _, spec := range sources {
+            layer, err := loadSourceLayer(spec)
+            if err != nil {
+                merged.err = fmt.Errorf("source %s: %w", spec, err)
+                return
+            }
+            layers = append(layers, layer)
+        }
+        merged.segments, merged.conflicts = paintGeoLayers(layers)
+        if len(merged.conflicts) > 0 {
+            warnf("Sources disagree on %d ranges; the higher precedence source was used (see -merge-report)\n", len(merged.conflicts))
+        }
+    })
+    return merged.segments, merged.conflicts, merged.err
+}
+
+// mergedCountrySegments returns the merged view grouped by country code, for the extraction functions.
+// Problems with a source are printed and yield an empty result, like a failed database read.
+func mergedCountrySegments() map[string][]geoEntry {
+    segments, _, err := mergedView()
+    if err != nil {
+        errorln(err)
+        return nil
+    }
+    byCountry := make(map[string][]geoEntry)
+    for _, e := range segments {
+        if countryCodeRe.MatchString(e.Country) {
+            byCountry[e.Country] = append(byCountry[e.Country], e)
+        }
+    }
+    return byCountry
+}
+
+// mergedCIDRs converts merged segments to the exact CIDRs covering them.
+func mergedCIDRs(segments []geoEntry) []string {
+    var cidrs []string
+    for _, e := range segments {
+        cidrs = append(cidrs, splitRangeToCIDRs(e.Start, e.End)...)
+    }
+    return cidrs
+}
+
+// compareSources reports the prefixes on which the RIPE country differs from another source, such as a
+// GeoLite2 database, with a summary of the disagreeing country pairs. countryCode limits the report
+// to ranges where either side names that country.
+func compareSources(spec sourceSpec, countryCode, output string) {
+    ripe, err := loadSourceLayer(sourceSpec{Kind: "ripe"})
+    if err != nil {
+        errorln("Error opening the RIPE database:", err)
+        return
+    }
+    other, err := loadSourceLayer(spec)
+    if err != nil {
+        errorf("Error loading source %s: %v\n", spec, err)
+        return
+    }
+    _, conflicts := paintGeoLayers([][]geoEntry{ripe, other})
+
+    type pair struct{ RIPE, Other string }
+    pairs := make(map[pair]uint64)
+    var sb strings.Builder
+    entries := 0
+    var addresses uint64
+    for _, c := range conflicts {
+        if countryCode != "" && c.Winner.Country != countryCode && c.Loser.Country != countryCode {
+            continue
+        }
+        size := uint64(c.End-c.Start) + 1
+        pairs[pair{c.Winner.Country, c.Loser.Country}] += size
+        addresses += size
+        for _, cidr := range splitRangeToCIDRs(c.Start, c.End) {
+            fmt.Fprintf(&sb, "%s\t%s\t%s\n", cidr, c.Winner.Country, c.Loser.Country)
+            entries++
+        }
+    }
+
+    if output == "" {
+        fmt.Printf("# prefix\tripe\t%s\n", spec)
+        fmt.Print(sb.String())
+    } else {
+        selection := "prefixes where ripe and " + spec.String() + " disagree"
+        if countryCode != "" {
+            selection += ", country " + countryCode
+        }
+        meta := outputMeta{Selection: selection, Entries: entries, Comment: "#"}
+        if err := writeOutputFile(output, fmt.Sprintf("# prefix\tripe\t%s\n", spec)+sb.String(), meta); err != nil {
+            errorf("Error writing comparison report: %v\n", err)
+            return
+        }
+        statusf("Comparison report created at: %s\n", output)
+    }
+
+    // The largest disagreements first, so the networks worth a closer look stand out.
+    keys := make([]pair, 0, len(pairs))
+    for p := range pairs {
+        keys = append(keys, p)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if pairs[keys[i]] != pairs[keys[j]] {
+            return pairs[keys[i]] > pairs[keys[j]]
+        }
+        return keys[i].RIPE+keys[i].Other < keys[j].RIPE+keys[j].Other
+    })
+    statusf("%d disagreeing prefixes covering %d addresses\n", entries, addresses)
+    for i, p := range keys {
+        if i == 20 {
+            break
+        }
+        fmt.Printf("  ripe %s / %s %s: %d\n", p.RIPE, spec.Kind, p.Other, pairs[p])
+    }
+}
+
+// writeMergeReport lists the ranges on which the sources disagree: range, winning source and country,
+// losing source and country.
+func writeMergeReport(output string) {
+    _, conflicts, err := mergedView()
+    if err != nil {
+        errorln(err)
+        return
+    }
+    var sb strings.Builder
+    for _, c := range conflicts {
+        fmt.Fprintf(&sb, "%s-%s\t%s\t%s\t%s\t%s\n", uint32ToIP(c.Start), uint32ToIP(c.End),
+            c.Winner.Source, c.Winner.Country, c.Loser.Source, c.Loser.Country)
+    }
+    if output == "" {
+        fmt.Print(sb.String())
+        statusf("%d conflicting ranges\n", len(conflicts))
+        return
+    }
+    var names []string
+    for _, spec := range sources {
+        names = append(names, spec.String())
+    }
+    meta := outputMeta{Selection: "conflicts between sources " + strings.Join(names, ","), Entries: len(conflicts), Comment: "#"}
+    if err := writeOutputFile(output, sb.String(), meta); err != nil {
+        errorf("Error writing merge report: %v\n", err)
+        return
+    }
+    statusf("Merge report created at: %s (%d conflicting ranges)\n", output, len(conflicts))
+}
+
+//-------------------------------------------------------------------------
+// Reverse DNS (domain objects)
+//-------------------------------------------------------------------------
+
+// reverseDelegation is an in-addr.arpa domain object with the IPv4 range it covers.
+type reverseDelegation struct {
+    Domain   string
+    Start    uint32
+    End      uint32
+    Country  string   // Country of the inetnum holding the range, if known.
+    Nservers []string // Name server host names.
+    Glue     []string // Glue addresses given on nserver lines.
+}
+
+// reverseDomainRange converts an in-addr.arpa domain such as "0.193.in-addr.arpa" or the RIPE
+// range form "1-5.0.193.in-addr.arpa" to the IPv4 addresses it delegates.
+func reverseDomainRange(domain string) (uint32, uint32, bool) {
+    domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+    rest, ok := strings.CutSuffix(domain, ".in-addr.arpa")
+    if !ok || rest == "" {
+        return 0, 0, false
+    }
+    labels := strings.Split(rest, ".")
+    if len(labels) > 4 {
+        return 0, 0, false
+    }
+
+    // Labels are least significant first; only the first one may be a range.
+    var start, end uint32
+    for i := len(labels) - 1; i >= 0; i-- {
+        lo, hi := labels[i], labels[i]
+        if i == 0 {
+            if a, b, found := strings.Cut(labels[i], "-"); found {
+                lo, hi = a, b
+            }
+        }
+        loValue, errLo := strconv.ParseUint(lo, 10, 8)
+        hiValue, errHi := strconv.ParseUint(hi, 10, 8)
+        if errLo != nil || errHi != nil || hiValue < loValue {
+            return 0, 0, false
+        }
+        start = start<<8 | uint32(loValue)
+        end = end<<8 | uint32(hiValue)
+    }
+    shift := uint(8 * (4 - len(labels)))
+    return start << shift, end<<shift | (1<<shift - 1), true
+}
+
+// loadReverseDelegations reads all in-addr.arpa domain objects and tags them with the country of their range.
+func loadReverseDelegations() ([]reverseDelegation, error) {
+    idx, err := buildIPIndex(ripedbPath)
+    if err != nil {
+        return nil, err
+    }
+    var delegations []reverseDelegation
+    err = scanObjects("domain", func(blockLines []string) {
+        domain := blockAttr(blockLines, "domain")
+        start, end, ok := reverseDomainRange(domain)
+        if !ok {
+            return
+        }
+        d := reverseDelegation{Domain: strings.ToLower(strings.TrimSuffix(domain, ".")), Start: start, End: end}
+        if seg, found := idx.lookup(uint32ToIP(start)); found {
+            d.Country = strings.ToUpper(seg.Country)
+        }
+        for _, line := range blockLines {
+            name, value, found := strings.Cut(line, ":")
+            if !found || !strings.EqualFold(strings.TrimSpace(name), "nserver") {
+                continue
+            }
+            fields := strings.Fields(value)
+            if len(fields) == 0 {
+                continue
+            }
+            d.Nservers = append(d.Nservers, strings.ToLower(strings.TrimSuffix(fields[0], ".")))
+            d.Glue = append(d.Glue, fields[1:]...)
+        }
+        delegations = append(delegations, d)
+    })
+    sort.Slice(delegations, func(i, j int) bool {
+        if delegations[i].Start != delegations[j].Start {
+            return delegations[i].Start < delegations[j].Start
+        }
+        return delegations[i].End > delegations[j].End
+    })
+    return delegations, err
+}
+
+// renderReverseZones renders stub or forward zone configuration for Unbound or BIND.
+// BIND needs addresses, so name servers without glue are resolved at generation time.
+func renderReverseZones(delegations []reverseDelegation, forward, bind bool) string {
+    var sb strings.Builder
+    for _, d := range delegations {
+        if !bind {
+            if forward {
+                fmt.Fprintf(&sb, "forward-zone:\n    name: \"%s.\"\n", d.Domain)
+                for _, ns := range d.Nservers {
+                    fmt.Fprintf(&sb, "    forward-host: \"%s\"\n", ns)
+                }
+            } else {
+                fmt.Fprintf(&sb, "stub-zone:\n    name: \"%s.\"\n", d.Domain)
+                for _, ns := range d.Nservers {
+                    fmt.Fprintf(&sb, "    stub-host: \"%s\"\n", ns)
+                }
+            }
+            sb.WriteString("\n")
+            continue
+        }
+
+        addrs := append([]string(nil), d.Glue...)
+        if len(addrs) == 0 {
+            for _, ns := range d.Nservers {
+                resolved, err := net.LookupHost(ns)
+                if err != nil {
+                    warnf("Skipping name server %s of %s: %v\n", ns, d.Domain, err)
+                    continue
+                }
+                addrs = append(addrs, resolved...)
+            }
+        }
+        if len(addrs) == 0 {
+            warnf("Skipping %s: no name server address\n", d.Domain)
+            continue
+        }
+        if forward {
+            fmt.Fprintf(&sb, "zone \"%s\" {\n    type forward;\n    forward only;\n    forwarders { %s; };\n};\n\n", d.Domain, strings.Join(addrs, "; "))
+        } else {
+            fmt.Fprintf(&sb, "zone \"%s\" {\n    type stub;\n    masters { %s; };\n};\n\n", d.Domain, strings.Join(addrs, "; "))
+        }
+    }
+    return sb.String()
+}
+
+// reverseDNS lists the reverse delegations of a country or prefix, or writes zone configuration for them.
+// zoneType is "" (list), "stub" or "forward".
+func reverseDNS(target, zoneType string, bind bool, output string) {
+    delegations, err := loadReverseDelegations()
+    if err != nil {
+        errorln(err)
+        return
+    }
+
+    // A prefix selects the delegations inside it, anything else is a country code.
+    var selected []reverseDelegation
+    if _, ipNet, err := net.ParseCIDR(target); err == nil && ipNet.IP.To4() != nil {
+        first := binary.BigEndian.Uint32(ipNet.IP.To4())
+        last := binary.BigEndian.Uint32(lastIP(ipNet))
+        for _, d := range delegations {
+            if d.Start >= first && d.End <= last {
+                selected = append(selected, d)
+            }
+        }
+    } else {
+        for _, d := range delegations {
+            if d.Country == strings.ToUpper(target) {
+                selected = append(selected, d)
+            }
+        }
+    }
+    if len(selected) == 0 {
+        statusf("No reverse delegations found for %s\n", target)
+        return
+    }
+
+    if zoneType == "" {
+        for _, d := range selected {
+            fmt.Printf("%s\t%s-%s\t%s\t%s\n", d.Domain, uint32ToIP(d.Start), uint32ToIP(d.End), d.Country, strings.Join(d.Nservers, ","))
+        }
+        return
+    }
+
+    comment, flavour := "#", "unbound"
+    if bind {
+        comment, flavour = "//", "bind"
+    }
+    if output == "" {
+        homeDir, _ := os.UserHomeDir()
+        name := strings.NewReplacer("/", "_", ".", "_").Replace(strings.ToUpper(target))
+        output = filepath.Join(homeDir, fmt.Sprintf("rdns_%s_%s_%s.conf", name, zoneType, flavour))
+    }
+    content := renderReverseZones(selected, zoneType == "forward", bind)
+    meta := outputMeta{Selection: "reverse delegations of " + target, Entries: len(selected), Comment: comment}
+    if err := writeOutputFile(output, content, meta); err != nil {
+        errorf("Error writing reverse DNS zone file: %v\n", err)
+        return
+    }
+    statusf("Reverse DNS zone file created at: %s (%d zones)\n", output, len(selected))
+}
+
+//-------------------------------------------------------------------------
+// Server / daemon mode
+//-------------------------------------------------------------------------
+
+// serverOptions holds the settings of -serve.
+type serverOptions struct {
+    Listen     string              // HTTP listen address.
+    GRPCListen string              // gRPC (cleartext HTTP/2) listen address; empty disables gRPC.
+    Interval   time.Duration       // How often the database is refreshed (0 disables updates).
+    Track      []string            // Country codes whose statistics are exported as metrics.
+    Email      *emailSettings      // Where scheduled updates are reported; nil disables reports.
+    Notify     map[string]notifier // Chat notifiers told about each update, by name.
+}
+
+// serverState is the shared, mutex-protected state of the daemon.
+type serverState struct {
+    mu       sync.Mutex
+    updateMu sync.Mutex // Serializes runUpdate, so the initial download and the ticker never overlap.
+
+    opts serverOptions
+
+    lastUpdate         time.Time     // When the last update attempt finished.
+    lastUpdateDuration time.Duration // How long the last update attempt took.
+    lastUpdateOK       bool          // Whether the last update attempt succeeded.
+    updatesOK          int64         // Number of successful updates since start.
+    updatesFailed      int64         // Number of failed updates since start.
+
+    index       *ipIndex      // IP-to-country index used by lookups.
+    origins     *routeOrigins // Origin ASNs of route objects, nil unless route objects are cached.
+    indexLoaded time.Time     // When index and origins were last swapped in.
+    reloads     int64         // Number of index swaps since start.
+
+    countryPrefixes  map[string]int      // Unique prefixes per tracked country.
+    countryAddresses map[string]uint64   // Covered IPv4 addresses per tracked country.
+    countryCIDRs     map[string][]string // The prefixes themselves, for the email report diffs.
+    generationErrors int64               // Failed statistics/output generations since start.
+
+    listsMu     sync.Mutex             // Guards lists and listRenders; never held while a list is rendered.
+    lists       map[string]*hostedList // Rendered /lists/ responses by URL path and filter, at most maxHostedLists.
+    listRenders map[string]*listRender // Renders in progress by the same key, so polls do not render twice.
+}
+
+// maxHostedLists bounds the rendered /lists/ responses kept in memory; the least recently served
+// list is dropped first and rendered again when it is next requested.
+const maxHostedLists = 256
+
+// hostedList is a rendered list served under /lists/ with its caching validators.
+type hostedList struct {
+    identity string    // databaseIdentity() the list was rendered from.
+    content  []byte    // The list without metadata header, so identical lists are byte-identical.
+    etag     string    // Quoted SHA-256 prefix of content.
+    modified time.Time // When the content last changed; kept across database updates that do not change it.
+    served   time.Time // When the list was last served; guarded by listsMu and used for eviction.
+}
+
+// listRender is a render of a /lists/ response in progress; done is closed when list or err is set.
+type listRender struct {
+    done chan struct{}
+    list *hostedList
+    err  error
+}
+
+// runServer starts the HTTP server and the periodic update loop; it only returns on a listen error
+// or when --offline is set and there is no cache to serve.
+func runServer(opts serverOptions) {
+    quiet = true
+    state := &serverState{opts: opts}
+
+    // An initial download happens only when there is no cache yet (or with --force-update);
+    // later updates follow the interval. With --offline the server only serves the existing cache.
+    _, err := os.Stat(ripedbPath)
+    missing := os.IsNotExist(err)
+    if customDB && err != nil {
+        errorf("Error opening the database given with --db: %v\n", err)
+        return
+    }
+    if offline && missing {
+        errorf("RIPE database cache not found at %s and --offline is set; run chicha-whois -u first.\n", ripedbPath)
+        return
+    }
+    // An existing cache is served right away; the initial download runs in the background and the
+    // lookup API answers 503 until its index is swapped in, so the listener is up from the start.
+    if !missing {
+        state.refreshIndex()
+        state.refreshStatistics()
+    }
+    if !customDB && (forceUpdate || missing) {
+        if missing {
+            statusln("RIPE database cache not found. Attempting to update...")
+        }
+        go state.runUpdate()
+    }
+
+    if opts.Interval > 0 && !offline && !customDB {
+        go func() {
+            ticker := time.NewTicker(opts.Interval)
+            defer ticker.Stop()
+            for range ticker.C {
+                state.runUpdate()
+            }
+        }()
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/metrics", state.handleMetrics)
+    mux.HandleFunc("/api/v1/lookup", state.handleAPILookup)
+    mux.HandleFunc("/api/v1/country/", state.handleAPICountry)
+    mux.HandleFunc("/api/v1/search", state.handleAPISearch)
+    mux.HandleFunc("/api/v1/countries", handleAPICountries)
+    mux.HandleFunc("/api/v1/formats", handleAPIFormats)
+    mux.HandleFunc("/api/v1/download", handleAPIDownload)
+    mux.HandleFunc("/lists/", state.handleList)
+    mux.HandleFunc("/", handleWebUI)
+
+    if opts.GRPCListen != "" {
+        go state.serveGRPC(opts.GRPCListen)
+    }
+
+    fmt.Printf("chicha-whois %s listening on %s (update interval: %s, tracked countries: %v)\n",
+        version, opts.Listen, opts.Interval, opts.Track)
+    if err := http.ListenAndServe(opts.Listen, mux); err != nil {
+        errorln("Error starting HTTP server:", err)
+    }
+}
+
+// runUpdate downloads a fresh database, records the outcome and recomputes the index and statistics;
+// after a successful update the statistics are also recorded in the trend database. A failed update leaves
+// the cache untouched, so the data already loaded keeps being served. With -email and -notify the
+// update is reported by email and to chat, compared with the statistics before it.
+func (s *serverState) runUpdate() {
+    s.updateMu.Lock()
+    defer s.updateMu.Unlock()
+
+    s.mu.Lock()
+    before := s.countryCIDRs
+    s.mu.Unlock()
+
+    started := time.Now()
+    err := updateRIPEdb()
+    duration := time.Since(started)
+
+    s.mu.Lock()
+    s.lastUpdate = time.Now()
+    s.lastUpdateDuration = duration
+    s.lastUpdateOK = err == nil
+    if err == nil {
+        s.updatesOK++
+    } else {
+        s.updatesFailed++
+    }
+    loaded := s.index != nil
+    s.mu.Unlock()
+
+    if err == nil || !loaded {
+        s.refreshIndex()
+        s.refreshStatistics()
+    }
+    if err == nil {
+        s.recordTrend()
+    }
+    if s.opts.Email != nil || len(s.opts.Notify) > 0 {
+        s.mu.Lock()
+        after := s.countryCIDRs
+        s.mu.Unlock()
+        if before == nil {
+            // The initial download has nothing to compare with.
+            before = after
+        }
+        if s.opts.Email != nil {
+            sendUpdateReport(s.opts.Email, err, before, after)
+        }
+        notify(s.opts.Notify, updateNotification(err, before, after))
+    }
+}
+
+// refreshIndex rebuilds the IP-to-country index used by lookups and the origin ASN table in the
+// background and swaps both in at once; requests keep using the previous index until then, and
+// keep it if the rebuild fails.
+func (s *serverState) refreshIndex() {
+    idx, err := buildIPIndex(ripedbPath)
+    origins := loadRouteOrigins()
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if err != nil {
+        errorln("Error building IP index:", err)
+        s.generationErrors++
+        return
+    }
+    s.index = idx
+    s.origins = origins
+    s.indexLoaded = time.Now()
+    s.reloads++
+}
+
+// refreshStatistics recomputes prefix and address counts for the tracked countries in a single database pass.
+func (s *serverState) refreshStatistics() {
+    if len(s.opts.Track) == 0 {
+        return
+    }
+
+    prefixes, addresses, cidrs, err := countCountryPrefixes(ripedbPath, s.opts.Track)
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if err != nil {
+        errorln("Error computing country statistics:", err)
+        s.generationErrors++
+        return
+    }
+    s.countryPrefixes = prefixes
+    s.countryAddresses = addresses
+    s.countryCIDRs = cidrs
+}
+
+// countCountryPrefixes returns the number of unique prefixes and covered IPv4 addresses for each requested
+// country, and the prefixes themselves.
+func countCountryPrefixes(dbPath string, countries []string) (map[string]int, map[string]uint64, map[string][]string, error) {
+    wanted := make(map[string][]string)
+    for _, cc := range countries {
+        wanted[strings.ToUpper(cc)] = nil
+    }
+
+    err := scanRanges(dbPath, false, func(r dbRange) {
+        if _, ok := wanted[r.Country]; ok {
+            wanted[r.Country] = append(wanted[r.Country], rangeCIDRs(r.Start, r.End)...)
+        }
+    })
+    if err != nil {
+        return nil, nil, nil, err
+    }
+
+    prefixes := make(map[string]int)
+    addresses := make(map[string]uint64)
+    for cc, cidrs := range wanted {
+        cidrs = removeDuplicates(cidrs)
+        wanted[cc] = cidrs
+        prefixes[cc] = len(cidrs)
+        addresses[cc] = countAddresses(cidrs)
+    }
+    return prefixes, addresses, wanted, nil
+}
+
+// trendSample is one row of the trend database: the size of a tracked country after a database update.
+type trendSample struct {
+    Time      time.Time
+    Serial    string
+    Country   string
+    Prefixes  int
+    Addresses uint64
+}
+
+// trendPath returns the trend database, an SQLite time series that -serve adds a row to per tracked
+// country after every update. It is written and read with the sqlite3 command-line client, as the
+// PostgreSQL export loads its dump with psql, so no database driver is linked into the binary.
+func trendPath() string {
+    return filepath.Join(cacheDir, "trend.sqlite")
+}
+
+// trendSchema creates the trend table if it is missing; times are RFC 3339 in UTC, so they sort as text.
+const trendSchema = `CREATE TABLE IF NOT EXISTS trend (
+    time      TEXT    NOT NULL,
+    serial    TEXT    NOT NULL,
+    country   TEXT    NOT NULL,
+    prefixes  INTEGER NOT NULL,
+    addresses INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS trend_country_time ON trend (country, time);
+`
+
+// sqlString quotes a value as an SQL string literal.
+func sqlString(value string) string {
+    return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// runSQLite runs an SQL script with the sqlite3 client against a database and returns what it printed.
+func runSQLite(path, script string, options ...string) (string, error) {
+    cmd := exec.Command("sqlite3", append(append([]string{"-batch", "-bail"}, options...), path)...)
+    cmd.Stdin = strings.NewReader(script)
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    out, err := cmd.Output()
+    if err != nil {
+        if message := strings.TrimSpace(stderr.String()); message != "" {
+            return "", fmt.Errorf("sqlite3: %s", message)
+        }
+        return "", fmt.Errorf("sqlite3: %v", err)
+    }
+    return string(out), nil
+}
+
+// recordTrend adds the statistics of the tracked countries after a successful update to the trend database.
+func (s *serverState) recordTrend() {
+    // The serial is read from disk before taking s.mu so that lookups are not held up by the file I/O.
+    now := time.Now().UTC().Truncate(time.Second).Format(time.RFC3339)
+    serial := loadDatabaseInfo().Serial
+    if serial == "" {
+        serial = "-"
+    }
+    var b strings.Builder
+    s.mu.Lock()
+    countries := slices.Sorted(maps.Keys(s.countryPrefixes))
+    for _, cc := range countries {
+        fmt.Fprintf(&b, "INSERT INTO trend VALUES (%s, %s, %s, %d, %d);\n", sqlString(now), sqlString(serial), sqlString(cc), s.countryPrefixes[cc], s.countryAddresses[cc])
+    }
+    s.mu.Unlock()
+    if b.Len() == 0 {
+        return
+    }
+
+    // An empty file is an empty SQLite database; creating it here gives it the mode and owner of
+    // --mode and --owner, which sqlite3 keeps (and gives its journal) when it fills the file.
+    if _, err := os.Stat(trendPath()); os.IsNotExist(err) {
+        if err := writeGeneratedFile(trendPath(), nil); err != nil {
+            errorln("Error recording the trend:", err)
+            return
+        }
+    }
+    if _, err := runSQLite(trendPath(), trendSchema+"BEGIN;\n"+b.String()+"COMMIT;\n"); err != nil {
+        errorln("Error recording the trend:", err)
+    }
+}
+
+// loadTrend reads the trend database, keeping the samples of the given countries (all if none) taken
+// after since, in time order.
+func loadTrend(countries []string, since time.Time) ([]trendSample, error) {
+    if _, err := os.Stat(trendPath()); err != nil {
+        return nil, err
+    }
+    query := fmt.Sprintf("SELECT time, serial, country, prefixes, addresses FROM trend WHERE time >= %s ORDER BY time, country;\n",
+        sqlString(since.UTC().Truncate(time.Second).Format(time.RFC3339)))
+    out, err := runSQLite(trendPath(), query, "-readonly", "-separator", "\t")
+    if err != nil {
+        return nil, err
+    }
+
+    var samples []trendSample
+    for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+        fields := strings.Split(line, "\t")
+        if len(fields) != 5 {
+            continue
+        }
+        t, err1 := time.Parse(time.RFC3339, fields[0])
+        prefixes, err2 := strconv.Atoi(fields[3])
+        addresses, err3 := strconv.ParseUint(fields[4], 10, 64)
+        if err1 != nil || err2 != nil || err3 != nil {
+            continue
+        }
+        if len(countries) > 0 && !slices.Contains(countries, fields[2]) {
+            continue
+        }
+        samples = append(samples, trendSample{t, fields[1], fields[2], prefixes, addresses})
+    }
+    return samples, nil
 }
 
-// ensureRIPEdb checks whether the RIPE DB cache file exists; if not, triggers an update.
-func ensureRIPEdb() {
-    if _, err := os.Stat(ripedbPath); os.IsNotExist(err) {
-        fmt.Println("RIPE database cache not found. Attempting to update...")
-        updateRIPEdb()
+// printTrend prints the recorded trend of countries with the change against the previous sample of
+// the same country, marking changes of the address count above threshold percent with "!" so sudden
+// registry changes stand out. With asCSV the raw samples are written instead, for spreadsheets or plotting.
+func printTrend(countries []string, since time.Time, threshold float64, asCSV bool, output string) {
+    samples, err := loadTrend(countries, since)
+    if os.IsNotExist(err) {
+        statusf("No trend recorded yet at %s; run -serve with -track to record one.\n", trendPath())
+        return
+    }
+    if err != nil {
+        errorln("Error reading the trend:", err)
+        return
+    }
+
+    var sb strings.Builder
+    meta := outputMeta{Selection: "trend of all tracked countries", Entries: len(samples), Comment: "#"}
+    if len(countries) > 0 {
+        meta.Selection = "trend of " + strings.Join(countries, ", ")
+    }
+    if !since.IsZero() {
+        meta.Selection += " since " + since.UTC().Format(time.RFC3339)
+    }
+    if asCSV {
+        meta.Comment = ""
+        sb.WriteString("time,serial,country,prefixes,addresses\n")
+        for _, s := range samples {
+            fmt.Fprintf(&sb, "%s,%s,%s,%d,%d\n", s.Time.Format(time.RFC3339), s.Serial, s.Country, s.Prefixes, s.Addresses)
+        }
+    } else {
+        sort.SliceStable(samples, func(i, j int) bool { return samples[i].Country < samples[j].Country })
+        fmt.Fprintf(&sb, "%-20s  %-7s  %9s  %8s  %12s  %12s  %8s\n", "time", "country", "prefixes", "change", "addresses", "change", "%")
+        for i, s := range samples {
+            if i == 0 || samples[i-1].Country != s.Country {
+                fmt.Fprintf(&sb, "%-20s  %-7s  %9d  %8s  %12d\n", s.Time.Format(time.RFC3339), s.Country, s.Prefixes, "", s.Addresses)
+                continue
+            }
+            prev := samples[i-1]
+            change := int64(s.Addresses) - int64(prev.Addresses)
+            percent := 0.0
+            if prev.Addresses > 0 {
+                percent = 100 * float64(change) / float64(prev.Addresses)
+            }
+            mark := ""
+            if math.Abs(percent) > threshold {
+                mark = " !"
+            }
+            fmt.Fprintf(&sb, "%-20s  %-7s  %9d  %+8d  %12d  %+12d  %+7.2f%s\n", s.Time.Format(time.RFC3339), s.Country, s.Prefixes, s.Prefixes-prev.Prefixes, s.Addresses, change, percent, mark)
+        }
+    }
+
+    if output == "" {
+        fmt.Print(sb.String())
+        return
+    }
+    if err := writeOutputFile(output, sb.String(), meta); err != nil {
+        errorf("Error writing trend: %v\n", err)
+        return
     }
+    statusf("Trend written to: %s (%d samples)\n", output, len(samples))
 }
 
-// updateRIPEdb downloads the RIPE database from a public URL, then decompresses it.
-func updateRIPEdb() {
-    downloadURL := "https://ftp.ripe.net/ripe/dbase/split/ripe.db.inetnum.gz"
+// handleMetrics serves the daemon state in the Prometheus text exposition format.
+func (s *serverState) handleMetrics(w http.ResponseWriter, r *http.Request) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
 
-    homeDir, err := os.UserHomeDir()
-    if err != nil {
-        fmt.Println("Error getting home directory:", err)
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+    metric := func(name, help, kind string) {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+    }
+
+    metric("chicha_whois_build_info", "Build information.", "gauge")
+    fmt.Fprintf(w, "chicha_whois_build_info{version=%q} 1\n", version)
+
+    fi, statErr := os.Stat(ripedbPath)
+    metric("chicha_whois_database_present", "Whether the cached RIPE database exists.", "gauge")
+    if statErr == nil {
+        fmt.Fprintln(w, "chicha_whois_database_present 1")
+        metric("chicha_whois_database_age_seconds", "Seconds since the cached RIPE database was last written.", "gauge")
+        fmt.Fprintf(w, "chicha_whois_database_age_seconds %.0f\n", time.Since(fi.ModTime()).Seconds())
+        metric("chicha_whois_database_last_modified_timestamp_seconds", "Modification time of the cached RIPE database.", "gauge")
+        fmt.Fprintf(w, "chicha_whois_database_last_modified_timestamp_seconds %d\n", fi.ModTime().Unix())
+        metric("chicha_whois_database_size_bytes", "Size of the cached RIPE database.", "gauge")
+        fmt.Fprintf(w, "chicha_whois_database_size_bytes %d\n", fi.Size())
+    } else {
+        fmt.Fprintln(w, "chicha_whois_database_present 0")
+    }
+
+    if !s.lastUpdate.IsZero() {
+        success := 0
+        if s.lastUpdateOK {
+            success = 1
+        }
+        metric("chicha_whois_last_update_success", "Whether the last database update succeeded.", "gauge")
+        fmt.Fprintf(w, "chicha_whois_last_update_success %d\n", success)
+        metric("chicha_whois_last_update_timestamp_seconds", "When the last database update attempt finished.", "gauge")
+        fmt.Fprintf(w, "chicha_whois_last_update_timestamp_seconds %d\n", s.lastUpdate.Unix())
+        metric("chicha_whois_last_update_duration_seconds", "Duration of the last database update attempt.", "gauge")
+        fmt.Fprintf(w, "chicha_whois_last_update_duration_seconds %.3f\n", s.lastUpdateDuration.Seconds())
+    }
+    metric("chicha_whois_updates_total", "Database update attempts by result.", "counter")
+    fmt.Fprintf(w, "chicha_whois_updates_total{result=\"success\"} %d\n", s.updatesOK)
+    fmt.Fprintf(w, "chicha_whois_updates_total{result=\"failure\"} %d\n", s.updatesFailed)
+
+    metric("chicha_whois_generation_errors_total", "Failed statistics or output generations.", "counter")
+    fmt.Fprintf(w, "chicha_whois_generation_errors_total %d\n", s.generationErrors)
+
+    metric("chicha_whois_index_reloads_total", "Lookup index rebuilds swapped in since start.", "counter")
+    fmt.Fprintf(w, "chicha_whois_index_reloads_total %d\n", s.reloads)
+    if !s.indexLoaded.IsZero() {
+        metric("chicha_whois_index_loaded_timestamp_seconds", "When the lookup index in use was swapped in.", "gauge")
+        fmt.Fprintf(w, "chicha_whois_index_loaded_timestamp_seconds %d\n", s.indexLoaded.Unix())
+    }
+
+    if len(s.countryPrefixes) > 0 {
+        codes := make([]string, 0, len(s.countryPrefixes))
+        for cc := range s.countryPrefixes {
+            codes = append(codes, cc)
+        }
+        sort.Strings(codes)
+
+        metric("chicha_whois_country_prefixes", "Unique prefixes registered to a tracked country.", "gauge")
+        for _, cc := range codes {
+            fmt.Fprintf(w, "chicha_whois_country_prefixes{country=%q} %d\n", cc, s.countryPrefixes[cc])
+        }
+        metric("chicha_whois_country_addresses", "IPv4 addresses registered to a tracked country.", "gauge")
+        for _, cc := range codes {
+            fmt.Fprintf(w, "chicha_whois_country_addresses{country=%q} %d\n", cc, s.countryAddresses[cc])
+        }
+    }
+}
+
+//-------------------------------------------------------------------------
+// Email reports
+//-------------------------------------------------------------------------
+
+// emailSettings is an SMTP target from the "email" section of the configuration file.
+type emailSettings struct {
+    Host     string   `json:"host"`     // SMTP server.
+    Port     int      `json:"port"`     // Defaults to 587 (STARTTLS when offered); 465 uses implicit TLS.
+    User     string   `json:"user"`     // Login for PLAIN authentication; empty sends without authentication.
+    Password string   `json:"password"` // Its password.
+    From     string   `json:"from"`     // Sender address.
+    To       []string `json:"to"`       // Recipients.
+    Subject  string   `json:"subject"`  // Subject prefix; defaults to "chicha-whois".
+    Always   bool     `json:"always"`   // Also report updates that changed no tracked country.
+    Insecure bool     `json:"insecure"` // Skip certificate verification.
+}
+
+// countryChange is the difference of a tracked country between two database snapshots.
+type countryChange struct {
+    Country         string
+    PrefixesBefore  int
+    PrefixesAfter   int
+    AddressesBefore uint64
+    AddressesAfter  uint64
+    Added           []string // Sorted.
+    Removed         []string // Sorted.
+}
+
+// diffCountryCIDRs compares the prefixes of the tracked countries before and after an update and
+// returns the countries whose prefixes changed.
+func diffCountryCIDRs(before, after map[string][]string) []countryChange {
+    var changes []countryChange
+    for _, cc := range slices.Sorted(maps.Keys(after)) {
+        old := make(map[string]bool, len(before[cc]))
+        for _, cidr := range before[cc] {
+            old[cidr] = true
+        }
+        change := countryChange{Country: cc, PrefixesBefore: len(before[cc]), PrefixesAfter: len(after[cc]),
+            AddressesBefore: countAddresses(before[cc]), AddressesAfter: countAddresses(after[cc])}
+        for _, cidr := range after[cc] {
+            if !old[cidr] {
+                change.Added = append(change.Added, cidr)
+            }
+            delete(old, cidr)
+        }
+        change.Removed = slices.Sorted(maps.Keys(old))
+        if len(change.Added) > 0 || len(change.Removed) > 0 {
+            sort.Strings(change.Added)
+            changes = append(changes, change)
+        }
+    }
+    return changes
+}
+
+// sendUpdateReport emails the outcome of a scheduled update: the result, the database serial and the
+// tracked countries that changed with their counts, each changed country's prefixes attached as a
+// CC.diff ("+" added, "-" removed). Updates that changed nothing are only reported with "always";
+// failed updates are always reported.
+func sendUpdateReport(settings *emailSettings, updateErr error, before, after map[string][]string) {
+    changes := diffCountryCIDRs(before, after)
+    if updateErr == nil && len(changes) == 0 && !settings.Always {
         return
     }
 
-    // Create a temporary file for the gzip data.
-    tmpFile, err := os.CreateTemp(homeDir, "ripe.db.inetnum-*.gz")
+    subject := settings.Subject
+    if subject == "" {
+        subject = "chicha-whois"
+    }
+    var body strings.Builder
+    switch {
+    case updateErr != nil:
+        subject += ": database update failed"
+        fmt.Fprintf(&body, "The database update failed: %v\n", updateErr)
+    case len(changes) == 0:
+        subject += ": database updated, no tracked country changed"
+        body.WriteString("The database was updated; no tracked country changed.\n")
+    default:
+        codes := make([]string, len(changes))
+        for i, c := range changes {
+            codes[i] = c.Country
+        }
+        subject += ": " + strings.Join(codes, ", ") + " changed"
+        body.WriteString("The database was updated.\n")
+    }
+    info := loadDatabaseInfo()
+    fmt.Fprintf(&body, "\nSource: %s\n", info.SourceURL)
+    if info.Serial != "" {
+        fmt.Fprintf(&body, "Serial: %s\n", info.Serial)
+    }
+    fmt.Fprintf(&body, "Downloaded: %s\n", info.Downloaded.Format(time.RFC3339))
+    if len(changes) > 0 {
+        fmt.Fprintf(&body, "\n%-8s %10s %10s %8s %8s %14s %14s\n", "country", "prefixes", "before", "added", "removed", "addresses", "before")
+        for _, c := range changes {
+            fmt.Fprintf(&body, "%-8s %10d %10d %8d %8d %14d %14d\n", c.Country, c.PrefixesAfter, c.PrefixesBefore,
+                len(c.Added), len(c.Removed), c.AddressesAfter, c.AddressesBefore)
+        }
+    }
+    if unchanged := len(after) - len(changes); updateErr == nil && unchanged > 0 {
+        fmt.Fprintf(&body, "\n%d tracked countries unchanged.\n", unchanged)
+    }
+
+    var message bytes.Buffer
+    mw := multipart.NewWriter(&message)
+    fmt.Fprintf(&message, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+        settings.From, strings.Join(settings.To, ", "), mime.QEncoding.Encode("utf-8", subject), time.Now().Format(time.RFC1123Z), mw.Boundary())
+    part, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+    part.Write([]byte(strings.ReplaceAll(body.String(), "\n", "\r\n")))
+    for _, c := range changes {
+        var diff strings.Builder
+        fmt.Fprintf(&diff, "# %s: %d added, %d removed\r\n", c.Country, len(c.Added), len(c.Removed))
+        for _, cidr := range c.Removed {
+            diff.WriteString("-" + cidr + "\r\n")
+        }
+        for _, cidr := range c.Added {
+            diff.WriteString("+" + cidr + "\r\n")
+        }
+        part, _ := mw.CreatePart(textproto.MIMEHeader{
+            "Content-Type":        {"text/plain; charset=utf-8"},
+            "Content-Disposition": {fmt.Sprintf("attachment; filename=%q", c.Country+".diff")},
+        })
+        part.Write([]byte(diff.String()))
+    }
+    mw.Close()
+
+    if err := sendMail(settings, message.Bytes()); err != nil {
+        errorln("Error sending the email report:", err)
+    }
+}
+
+// sendMail delivers a message over SMTP. Port 465 uses implicit TLS; other ports upgrade with STARTTLS
+// when the server offers it.
+func sendMail(settings *emailSettings, message []byte) error {
+    port := settings.Port
+    if port == 0 {
+        port = 587
+    }
+    address := net.JoinHostPort(settings.Host, strconv.Itoa(port))
+    tlsConfig := &tls.Config{ServerName: settings.Host, InsecureSkipVerify: settings.Insecure}
+
+    var conn net.Conn
+    var err error
+    if port == 465 {
+        conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", address, tlsConfig)
+    } else {
+        conn, err = net.DialTimeout("tcp", address, 30*time.Second)
+    }
     if err != nil {
-        fmt.Println("Error creating temporary file:", err)
-        return
+        return err
     }
-    defer func() {
-        _ = os.Remove(tmpFile.Name())
-        fmt.Println("Temporary file removed:", tmpFile.Name())
-    }()
-    defer tmpFile.Close()
+    client, err := smtp.NewClient(conn, settings.Host)
+    if err != nil {
+        conn.Close()
+        return err
+    }
+    defer client.Close()
+    if ok, _ := client.Extension("STARTTLS"); ok && port != 465 {
+        if err := client.StartTLS(tlsConfig); err != nil {
+            return err
+        }
+    }
+    if settings.User != "" {
+        if err := client.Auth(smtp.PlainAuth("", settings.User, settings.Password, settings.Host)); err != nil {
+            return err
+        }
+    }
+    if err := client.Mail(settings.From); err != nil {
+        return err
+    }
+    for _, to := range settings.To {
+        if err := client.Rcpt(to); err != nil {
+            return err
+        }
+    }
+    w, err := client.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := w.Write(message); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+    return client.Quit()
+}
+
+//-------------------------------------------------------------------------
+// Chat notifications (Slack, Telegram)
+//-------------------------------------------------------------------------
+
+// notifier is a chat target from the "notifiers" section of the configuration file.
+type notifier struct {
+    Type     string `json:"type"`      // "slack" or "telegram".
+    Webhook  string `json:"webhook"`   // Slack incoming webhook URL.
+    BotToken string `json:"bot_token"` // Telegram bot token from @BotFather.
+    ChatID   string `json:"chat_id"`   // Telegram chat ID, or @channelname.
+}
+
+// telegramAPI is the Telegram Bot API endpoint; the bot token and method are appended.
+const telegramAPI = "https://api.telegram.org/bot"
+
+// send posts a plain-text message.
+func (n notifier) send(text string) error {
+    var endpoint string
+    var payload any
+    switch n.Type {
+    case "slack":
+        endpoint, payload = n.Webhook, map[string]string{"text": text}
+    case "telegram":
+        endpoint = telegramAPI + n.BotToken + "/sendMessage"
+        payload = map[string]any{"chat_id": n.ChatID, "text": text, "disable_web_page_preview": true}
+    default:
+        return fmt.Errorf("unknown notifier type %q (supported: slack, telegram)", n.Type)
+    }
+    data, _ := json.Marshal(payload)
+    client := &http.Client{Timeout: 30 * time.Second}
+    resp, err := client.Post(endpoint, "application/json", bytes.NewReader(data))
+    if err != nil {
+        // Do not leak the bot token, which is part of the URL, into logs.
+        if urlErr, ok := err.(*url.Error); ok {
+            err = urlErr.Err
+        }
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("unexpected HTTP status: %s %s", resp.Status, strings.TrimSpace(string(body)))
+    }
+    return nil
+}
+
+// loadNotifiers looks up notifiers by name in the configuration file.
+func loadNotifiers(names []string) (map[string]notifier, bool) {
+    config, err := loadConfig()
+    if err != nil {
+        errorln(err)
+        return nil, false
+    }
+    notifiers := make(map[string]notifier)
+    for _, name := range names {
+        n, ok := configEntry(config.Notifiers, name, "notifiers")
+        if !ok {
+            return nil, false
+        }
+        notifiers[name] = n
+    }
+    return notifiers, true
+}
+
+// notify posts a message to every notifier; failures are reported and do not stop the others.
+func notify(notifiers map[string]notifier, text string) {
+    for _, name := range slices.Sorted(maps.Keys(notifiers)) {
+        if err := notifiers[name].send(text); err != nil {
+            warnf("Warning: notifier %s failed: %v\n", name, err)
+        }
+    }
+}
+
+// updateNotification is the short message posted after a daemon update: the result and, for each
+// tracked country whose prefixes changed, the number of prefixes added and removed.
+func updateNotification(updateErr error, before, after map[string][]string) string {
+    if updateErr != nil {
+        return "chicha-whois: database update failed: " + updateErr.Error()
+    }
+    text := "chicha-whois: database updated"
+    if serial := loadDatabaseInfo().Serial; serial != "" {
+        text += " (serial " + serial + ")"
+    }
+    changes := diffCountryCIDRs(before, after)
+    if len(changes) == 0 {
+        if len(after) > 0 {
+            text += "; no tracked country changed"
+        }
+        return text
+    }
+    parts := make([]string, len(changes))
+    for i, c := range changes {
+        parts[i] = fmt.Sprintf("%s +%d/-%d prefixes (%d addresses)", c.Country, len(c.Added), len(c.Removed), c.AddressesAfter)
+    }
+    return text + "; changed: " + strings.Join(parts, ", ")
+}
+
+//-------------------------------------------------------------------------
+// Shared query helpers (REST and gRPC)
+//-------------------------------------------------------------------------
+
+// lookupResult describes the most specific inetnum block containing an address.
+type lookupResult struct {
+    IP      string `json:"ip"`
+    Found   bool   `json:"found"`
+    Country string `json:"country,omitempty"`
+    Inetnum string `json:"inetnum,omitempty"`
+    Netname string `json:"netname,omitempty"`
+}
+
+// lookupAddress resolves one address against an IP index.
+func lookupAddress(idx *ipIndex, ipStr string) (lookupResult, error) {
+    ip := net.ParseIP(strings.TrimSpace(ipStr))
+    if ip == nil {
+        return lookupResult{}, fmt.Errorf("invalid IP address: %q", ipStr)
+    }
+    result := lookupResult{IP: ip.String()}
+    if seg, found := idx.lookup(ip); found {
+        result.Found = true
+        result.Country = seg.Country
+        result.Inetnum = fmt.Sprintf("%s - %s", uint32ToIP(seg.RangeStart), uint32ToIP(seg.RangeEnd))
+        result.Netname = seg.Netname
+    }
+    return result, nil
+}
+
+// countryPrefixList extracts, deduplicates, optionally filters (or aggregates) and sorts the prefixes of a
+// country, or with --invert those of the rest of the address space.
+func countryPrefixList(countryCode string, filtered bool) []string {
+    ipRanges, _ := dropSmallPrefixes(removeDuplicates(extractCountryCIDRs(countryCode, ripedbPath, false)))
+    if granularity > 0 {
+        ipRanges = widenPrefixes(ipRanges)
+    }
+    if filtered || granularity > 0 {
+        ipRanges = filterRedundantCIDRs(ipRanges)
+    }
+    if invert {
+        ipRanges = invertPrefixes(ipRanges, false)
+    }
+    ipRanges = applySetOperations(ipRanges)
+    if aggregate {
+        ipRanges = aggregatePrefixes(ipRanges)
+    }
+    sort.Strings(ipRanges)
+    return ipRanges
+}
+
+// searchCacheTTL bounds how long a cached search result is reused, even if the database stays the same.
+const searchCacheTTL = 24 * time.Hour
+
+// searchAttrs are the attributes of the block a search result came from.
+type searchAttrs struct {
+    Netname string `json:"netname,omitempty"`
+    Country string `json:"country,omitempty"`
+}
+
+// searchCacheEntry is a stored search result together with the database it was computed from.
+type searchCacheEntry struct {
+    Country    string                 `json:"country"`
+    Keywords   []string               `json:"keywords"`
+    Database   string                 `json:"database"`
+    Created    time.Time              `json:"created"`
+    Prefixes   []string               `json:"prefixes"`
+    Attributes map[string]searchAttrs `json:"attributes"`
+}
+
+// databaseIdentity identifies the current database by path, RIPE serial, size and modification time,
+// so that any update invalidates cached results.
+func databaseIdentity() string {
+    fi, err := os.Stat(ripedbPath)
+    if err != nil {
+        return ""
+    }
+    absPath, _ := filepath.Abs(ripedbPath)
+    return fmt.Sprintf("%s|%s|%d|%d", absPath, loadDatabaseInfo().Serial, fi.Size(), fi.ModTime().UnixNano())
+}
+
+// normalizeSearchQuery returns the upper case country code and the sorted, lower case keywords
+// (/regex/ keywords are kept as they are), as the search itself is case-insensitive and does not
+// depend on keyword order.
+func normalizeSearchQuery(countryCode string, keywords []string) (string, []string) {
+    normalized := make([]string, 0, len(keywords))
+    for _, kw := range keywords {
+        // Regular expressions are case-insensitive anyway, but lowering \S would change their meaning.
+        if kw = strings.TrimSpace(kw); !isRegexKeyword(kw) {
+            kw = strings.ToLower(kw)
+        }
+        if kw != "" {
+            normalized = append(normalized, kw)
+        }
+    }
+    sort.Strings(normalized)
+    return strings.ToUpper(countryCode), slices.Compact(normalized)
+}
+
+// searchCachePath returns the cache file of a normalized query.
+func searchCachePath(countryCode string, keywords []string) string {
+    query := countryCode + "\x00" + strings.Join(keywords, "\x00")
+    if cidrStrategy != "cover" {
+        // Results of other strategies are other lists; cover keeps the key of earlier versions.
+        query += "\x00" + cidrStrategy
+    }
+    key := sha256Hex(query)
+    return filepath.Join(cacheDir, "search", key[:32]+".json")
+}
+
+// renderAdGuardClients renders the disallowed_clients list of AdGuardHome.yaml.
+func renderAdGuardClients(cidrs []string) string {
+    var sb strings.Builder
+    sb.WriteString("disallowed_clients:\n")
+    for _, cidr := range cidrs {
+        fmt.Fprintf(&sb, "  - %s\n", cidr)
+    }
+    return sb.String()
+}
+
+// searchDomainRe matches keywords that are domain names (such as ok.ru), which dnsmasq can resolve into sets.
+var searchDomainRe = regexp.MustCompile(`^(?i)([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z][a-z0-9-]*[a-z0-9]$`)
+
+// searchDomains returns the keywords of a search that are domain names, lowercased.
+func searchDomains(keywords []string) []string {
+    var domains []string
+    for _, kw := range keywords {
+        if searchDomainRe.MatchString(kw) {
+            domains = append(domains, strings.ToLower(kw))
+        }
+    }
+    return domains
+}
+
+// cachedSearchPrefixList runs a search, reusing the stored result of an identical query if the database
+// has not changed since and the result is younger than searchCacheTTL. It also returns the block attributes
+// of each prefix and reports whether the cache was used.
+func cachedSearchPrefixList(countryCode string, keywords []string, useCache bool) ([]string, map[string]searchAttrs, bool) {
+    countryCode, keywords = normalizeSearchQuery(countryCode, keywords)
+    path := searchCachePath(countryCode, keywords)
+    identity := databaseIdentity()
+
+    if useCache && identity != "" {
+        var entry searchCacheEntry
+        if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &entry) == nil &&
+            entry.Database == identity && entry.Country == countryCode && slices.Equal(entry.Keywords, keywords) &&
+            entry.Attributes != nil && time.Since(entry.Created) < searchCacheTTL {
+            return entry.Prefixes, entry.Attributes, true
+        }
+    }
+
+    attrs := make(map[string]searchAttrs)
+    ipRanges := extractCIDRsByKeywordsAndCountry(countryCode, keywords, ripedbPath, false, attrs)
+    if overMemoryCap(len(ipRanges)) {
+        filtered, err := filterCIDRsOnDisk(ipRanges)
+        if err != nil {
+            errorln("Error filtering the search results:", err)
+            return nil, nil, false
+        }
+        ipRanges = filtered
+    } else {
+        ipRanges = filterRedundantCIDRs(removeDuplicates(ipRanges))
+    }
+    sort.Strings(ipRanges)
+    for cidr := range attrs {
+        if _, kept := slices.BinarySearch(ipRanges, cidr); !kept {
+            delete(attrs, cidr)
+        }
+    }
+
+    // The cache is only an optimisation, so failing to store it is not fatal.
+    if identity != "" {
+        entry := searchCacheEntry{Country: countryCode, Keywords: keywords, Database: identity, Created: time.Now().UTC(), Prefixes: ipRanges, Attributes: attrs}
+        if data, err := json.Marshal(entry); err == nil && os.MkdirAll(filepath.Dir(path), 0755) == nil {
+            _ = os.WriteFile(path, data, 0644)
+        }
+    }
+    return ipRanges, attrs, false
+}
+
+// searchPrinter returns the functions that print the header, each CIDR and the footer of -search
+// results in an output mode (print, dns, ovpn, ovpn-push, dnsmasq, nftset or adguard). A sorted
+// listing also shows the attributes it was sorted by.
+func searchPrinter(outputMode, countryCode string, keywords []string, sortKey string, attrs map[string]searchAttrs) (func(), func(cidr string), func()) {
+    switch outputMode {
+    case "dnsmasq", "nftset":
+        // A dnsmasq directive that adds the addresses resolved for the domain keywords to a set, and
+        // the set itself filled with the networks found (ipset restore or nft -f input).
+        setName := "chicha_search"
+        if countryCode != "" {
+            setName = "chicha_" + countryCode
+        }
+        domains := searchDomains(keywords)
+        return func() {
+                switch {
+                case len(domains) == 0:
+                    warnf("No domain among the search keywords; the dnsmasq %s= directive is omitted.\n", strings.Replace(outputMode, "dnsmasq", "ipset", 1))
+                case outputMode == "nftset":
+                    fmt.Println("# dnsmasq.conf: add the addresses resolved for these domains to the nftables set")
+                    fmt.Printf("nftset=/%s/4#inet#fw4#%s\n\n", strings.Join(domains, "/"), setName)
+                default:
+                    fmt.Println("# dnsmasq.conf: add the addresses resolved for these domains to the ipset")
+                    fmt.Printf("ipset=/%s/%s\n\n", strings.Join(domains, "/"), setName)
+                }
+                if outputMode == "nftset" {
+                    fmt.Println("# nftables set with the networks found (table inet fw4 of OpenWrt); load with: nft -f FILE")
+                    fmt.Printf("add set inet fw4 %s { type ipv4_addr; flags interval; auto-merge; }\n", setName)
+                    return
+                }
+                fmt.Println("# ipset with the networks found; load with: ipset restore -exist < FILE")
+                fmt.Printf("create %s hash:net family inet\n", setName)
+            }, func(cidr string) {
+                if outputMode == "nftset" {
+                    fmt.Printf("add element inet fw4 %s { %s }\n", setName, cidr)
+                    return
+                }
+                fmt.Printf("add %s %s\n", setName, cidr)
+            }, func() {}
+
+    case "adguard":
+        // The access settings of AdGuard Home: clients from these networks are refused.
+        return func() {
+                fmt.Println("# AdGuard Home: put this list in the dns section of AdGuardHome.yaml")
+                fmt.Println("disallowed_clients:")
+            }, func(cidr string) {
+                fmt.Printf("  - %s\n", cidr)
+            }, func() {}
+
+    case "dns":
+        // DNS BIND ACL format, but print to the console instead of writing a file.
+        aclName := countryCode
+        if aclName == "" {
+            aclName = "search"
+        }
+        return func() {
+                fmt.Printf("\nacl \"%s\" {\n", aclName)
+            }, func(cidr string) {
+                fmt.Printf("  %s;\n", cidr)
+            }, func() {
+                fmt.Println("};")
+            }
+
+    case "ovpn", "ovpn-push":
+        // OpenVPN client-style format (using net_gateway), or server-style push directives.
+        cc := countryCode
+        if cc == "" {
+            cc = "SEARCH"
+        }
+        push := outputMode == "ovpn-push"
+        return func() {
+                if push {
+                    fmt.Println("# Redirect all traffic through VPN (server pushes these directives)")
+                    fmt.Println("push \"redirect-gateway def1\"")
+                    fmt.Println()
+                    fmt.Printf("# Exclude %s IP ranges from the VPN (pushed to clients)\n", strings.ToUpper(cc))
+                    return
+                }
+                fmt.Println("# Redirect all traffic through VPN")
+                fmt.Println("redirect-gateway def1")
+                fmt.Println()
+                fmt.Printf("# Exclude %s IP ranges from the VPN\n", strings.ToUpper(cc))
+            }, func(cidr string) {
+                startIP, netmask, err := cidrToRoute(cidr)
+                if err != nil {
+                    return
+                }
+                if push {
+                    fmt.Printf("push \"route %s %s net_gateway\"\n", startIP, netmask)
+                    return
+                }
+                fmt.Printf("route %s %s net_gateway\n", startIP, netmask)
+            }, func() {}
+    }
+
+    // If no format specified, just print the final CIDR list.
+    // Origin ASNs are added when route objects are cached.
+    origins := loadRouteOrigins()
+    return func() {
+            fmt.Println(tr("Found CIDR ranges (after filtering):"))
+        }, func(cidr string) {
+            if sortKey != "" {
+                // A sorted listing shows what it was sorted by.
+                _, ipNet, _ := net.ParseCIDR(cidr)
+                ones, _ := ipNet.Mask.Size()
+                line := fmt.Sprintf("  %-18s %10d  %-2s  %-24s %s", cidr, uint64(1)<<(32-ones), attrs[cidr].Country, attrs[cidr].Netname, strings.Join(origins.lookup(cidr), " "))
+                fmt.Println(strings.TrimRight(line, " "))
+                return
+            }
+            if asns := origins.lookup(cidr); len(asns) > 0 {
+                fmt.Printf("  %-18s %s\n", cidr, strings.Join(asns, " "))
+                return
+            }
+            fmt.Println(" ", cidr)
+        }, func() {}
+}
+
+// searchSortKeys are the orders accepted by -search --sort.
+var searchSortKeys = []string{"size", "prefix", "netname", "country"}
+
+// sortSearchResults orders search results in place: size puts the largest blocks first, prefix sorts
+// numerically by address, netname and country group by the block's attribute. Ties are ordered by address.
+func sortSearchResults(cidrs []string, attrs map[string]searchAttrs, key string) {
+    type parsed struct {
+        start uint32
+        ones  int
+    }
+    keys := make(map[string]parsed, len(cidrs))
+    for _, cidr := range cidrs {
+        if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.IP.To4() != nil {
+            ones, _ := ipNet.Mask.Size()
+            keys[cidr] = parsed{binary.BigEndian.Uint32(ipNet.IP.To4()), ones}
+        }
+    }
+    sort.SliceStable(cidrs, func(i, j int) bool {
+        a, b := keys[cidrs[i]], keys[cidrs[j]]
+        switch key {
+        case "size":
+            if a.ones != b.ones {
+                return a.ones < b.ones
+            }
+        case "netname":
+            if na, nb := strings.ToLower(attrs[cidrs[i]].Netname), strings.ToLower(attrs[cidrs[j]].Netname); na != nb {
+                return na < nb
+            }
+        case "country":
+            if ca, cb := attrs[cidrs[i]].Country, attrs[cidrs[j]].Country; ca != cb {
+                return ca < cb
+            }
+        }
+        return a.start < b.start
+    })
+}
+
+// searchPrefixList runs a -search style query and returns the filtered, sorted prefixes.
+func searchPrefixList(countryCode string, keywords []string) []string {
+    ipRanges, _, _ := cachedSearchPrefixList(countryCode, keywords, true)
+    return ipRanges
+}
+
+// parseSearchParam splits a "CC:kw1,kw2" search parameter into a country code and trimmed keywords.
+func parseSearchParam(searchParam string) (string, []string) {
+    var countryCode string
+    var keywords []string
+    parts := strings.SplitN(searchParam, ":", 2)
+    if len(parts) == 2 {
+        countryCode = strings.TrimSpace(parts[0])
+        for _, kw := range strings.Split(parts[1], ",") {
+            if kw = strings.TrimSpace(kw); kw != "" {
+                keywords = append(keywords, kw)
+            }
+        }
+    } else {
+        countryCode = strings.TrimSpace(searchParam)
+    }
+    return countryCode, keywords
+}
+
+//-------------------------------------------------------------------------
+// REST API
+//-------------------------------------------------------------------------
+
+// writeJSON writes a value as an indented JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.WriteHeader(status)
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    _ = enc.Encode(v)
+}
+
+// writeJSONError writes {"error": "..."} with the given status code.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+    writeJSON(w, status, map[string]string{"error": message})
+}
+
+// currentIndex returns the index currently used for lookups.
+func (s *serverState) currentIndex() *ipIndex {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.index
+}
 
-    fmt.Printf("Starting download of the RIPE database from %s\n", downloadURL)
-    fmt.Printf("Saving to temporary file: %s\n", tmpFile.Name())
+// currentOrigins returns the origin ASN table, or nil if route objects are not cached.
+func (s *serverState) currentOrigins() *routeOrigins {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.origins
+}
 
-    resp, err := http.Get(downloadURL)
-    if err != nil {
-        fmt.Printf("Error downloading RIPE database: %v\n", err)
+// handleAPILookup serves GET /api/v1/lookup?ip=1.2.3.4.
+func (s *serverState) handleAPILookup(w http.ResponseWriter, r *http.Request) {
+    idx := s.currentIndex()
+    if idx == nil {
+        writeJSONError(w, http.StatusServiceUnavailable, "IP index is not ready")
         return
     }
-    defer resp.Body.Close()
-
-    totalSize := resp.ContentLength
-    if totalSize <= 0 {
-        fmt.Println("Warning: unable to determine file size for progress display.")
-    } else {
-        fmt.Printf("Total file size: %d bytes\n", totalSize)
-    }
-
-    progressReader := &ProgressReader{
-        Reader:    resp.Body,
-        Total:     totalSize,
-        Operation: "Downloading",
-    }
-
-    // Copy the downloaded bytes to the temporary file, showing progress.
-    _, err = io.Copy(tmpFile, progressReader)
+    result, err := lookupAddress(idx, r.URL.Query().Get("ip"))
     if err != nil {
-        fmt.Println("Error writing to temporary file:", err)
+        writeJSONError(w, http.StatusBadRequest, err.Error())
         return
     }
-    fmt.Println() // New line after final progress output.
+    writeJSON(w, http.StatusOK, result)
+}
 
-    // Now decompress the downloaded .gz into ripedbPath.
-    fmt.Printf("Extracting %s to %s\n", tmpFile.Name(), ripedbPath)
-    if err := gunzipFileWithProgress(tmpFile.Name(), ripedbPath); err != nil {
-        fmt.Println("Error decompressing RIPE database:", err)
+// handleAPICountry serves GET /api/v1/country/CC; nested subnets are removed unless filtered=0.
+func (s *serverState) handleAPICountry(w http.ResponseWriter, r *http.Request) {
+    countryCode := strings.ToUpper(strings.TrimPrefix(r.URL.Path, "/api/v1/country/"))
+    if countryCode == "" || strings.Contains(countryCode, "/") {
+        writeJSONError(w, http.StatusBadRequest, "country code is required: /api/v1/country/CC")
         return
     }
-
-    fmt.Printf("RIPE database updated successfully at %s\n", ripedbPath)
-}
-
-// gunzipFileWithProgress decompresses a .gz file and writes the output to a destination file.
-func gunzipFileWithProgress(source, destination string) error {
-    fi, err := os.Stat(source)
-    if err != nil {
-        return err
+    filtered := r.URL.Query().Get("filtered") != "0"
+    prefixes := countryPrefixList(countryCode, filtered)
+    response := map[string]interface{}{
+        "country":  countryCode,
+        "filtered": filtered,
+        "count":    len(prefixes),
+        "prefixes": prefixes,
     }
-    compressedSize := fi.Size()
-
-    // Ensure the destination directory exists, create if needed.
-    dir := filepath.Dir(destination)
-    if err = os.MkdirAll(dir, os.ModePerm); err != nil {
-        return fmt.Errorf("error creating directory %s: %v", dir, err)
+    if origins := s.currentOrigins(); origins != nil {
+        response["origins"] = origins.annotate(prefixes)
     }
+    writeJSON(w, http.StatusOK, response)
+}
 
-    file, err := os.Open(source)
-    if err != nil {
-        return err
+// handleAPISearch serves GET /api/v1/search?q=CC:kw1,kw2 (or country=CC&keywords=kw1,kw2).
+func (s *serverState) handleAPISearch(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    countryCode, keywords := parseSearchParam(query.Get("q"))
+    if query.Get("q") == "" {
+        countryCode, keywords = parseSearchParam(query.Get("country") + ":" + query.Get("keywords"))
     }
-    defer file.Close()
-
-    progressReader := &ProgressReader{
-        Reader: file,
-        Total:  compressedSize,
+    if countryCode == "" && len(keywords) == 0 {
+        writeJSONError(w, http.StatusBadRequest, "a country code and/or keywords are required")
+        return
     }
-
-    gz, err := gzip.NewReader(progressReader)
-    if err != nil {
-        return err
+    if _, err := newSearchMatcher(keywords); err != nil {
+        writeJSONError(w, http.StatusBadRequest, "invalid keyword "+err.Error())
+        return
     }
-    defer gz.Close()
-
-    out, err := os.Create(destination)
-    if err != nil {
-        return err
+    prefixes := searchPrefixList(countryCode, keywords)
+    response := map[string]interface{}{
+        "country":  strings.ToUpper(countryCode),
+        "keywords": keywords,
+        "count":    len(prefixes),
+        "prefixes": prefixes,
     }
-    defer out.Close()
-
-    _, err = io.Copy(out, gz)
-    if err != nil {
-        return err
+    if origins := s.currentOrigins(); origins != nil {
+        response["origins"] = origins.annotate(prefixes)
     }
-    fmt.Println("\nDecompression completed.")
-    return nil
+    writeJSON(w, http.StatusOK, response)
 }
 
 //-------------------------------------------------------------------------
-// Functions that write DNS/OVPN output to files (for older flags)
+// gRPC API (cleartext HTTP/2, hand-rolled protobuf encoding)
 //-------------------------------------------------------------------------
 
-// createBindACL creates an unfiltered DNS BIND ACL file for the specified country code.
-func createBindACL(countryCode string) {
-    fmt.Printf("Creating BIND ACL file for country code: %s\n", countryCode)
+// grpcProtoDefinition describes the gRPC service implemented below; clients generate their stubs from it.
+const grpcProtoDefinition = `syntax = "proto3";
 
-    ipRanges := extractCountryCIDRs(countryCode, ripedbPath, false)
-    if len(ipRanges) == 0 {
-        fmt.Printf("No IP ranges found for country code: %s\n", countryCode)
-        return
-    }
+package chicha.whois.v1;
 
-    ipRanges = removeDuplicates(ipRanges)
-    sort.Strings(ipRanges)
+option java_multiple_files = true;
 
-    homeDir, _ := os.UserHomeDir()
-    aclFilePath := filepath.Join(homeDir, fmt.Sprintf("acl_%s.conf", countryCode))
+// Whois exposes lookups and extractions over the locally cached RIPE NCC database.
+service Whois {
+  // Lookup returns the most specific inetnum block containing an address.
+  rpc Lookup(LookupRequest) returns (LookupReply);
+  // ExtractCountry streams all prefixes registered to a country.
+  rpc ExtractCountry(ExtractCountryRequest) returns (stream Prefix);
+  // Search streams prefixes matching an optional country and any of the keywords.
+  rpc Search(SearchRequest) returns (stream Prefix);
+}
 
-    var entries []string
-    for _, cidr := range ipRanges {
-        entries = append(entries, fmt.Sprintf("  %s;", cidr))
-    }
-    aclContent := fmt.Sprintf("acl \"%s\" {\n%s\n};\n", countryCode, strings.Join(entries, "\n"))
+message LookupRequest {
+  string ip = 1;
+}
 
-    if err := os.WriteFile(aclFilePath, []byte(aclContent), 0644); err != nil {
-        fmt.Printf("Error writing BIND ACL file: %v\n", err)
-        return
-    }
-    fmt.Printf("BIND ACL file created at: %s\n", aclFilePath)
+message LookupReply {
+  string ip = 1;
+  bool found = 2;
+  string country = 3;
+  string inetnum = 4;
+  string netname = 5;
 }
 
-// createBindACLFiltered creates a DNS BIND ACL file after removing nested subnets.
-func createBindACLFiltered(countryCode string) {
-    fmt.Printf("Creating BIND ACL file (filtered) for country code: %s\n", countryCode)
+message ExtractCountryRequest {
+  string country = 1;
+  optional bool filtered = 2; // Remove subnets nested in larger ones (default true).
+}
 
-    ipRanges := extractCountryCIDRs(countryCode, ripedbPath, false)
-    if len(ipRanges) == 0 {
-        fmt.Printf("No IP ranges found for country code: %s\n", countryCode)
-        return
-    }
+message SearchRequest {
+  string country = 1;
+  repeated string keywords = 2;
+}
 
-    ipRanges = removeDuplicates(ipRanges)
-    ipRanges = filterRedundantCIDRs(ipRanges)
-    sort.Strings(ipRanges)
+message Prefix {
+  string cidr = 1;
+  string country = 2;
+}
+`
 
-    homeDir, _ := os.UserHomeDir()
-    aclFilePath := filepath.Join(homeDir, fmt.Sprintf("acl_%s.conf", countryCode))
+// gRPC status codes used by the service.
+const (
+    grpcStatusOK                = 0
+    grpcStatusInvalidArgument   = 3
+    grpcStatusResourceExhausted = 8
+    grpcStatusUnavailable       = 14
+    grpcStatusUnimplemented     = 12
+)
 
-    var entries []string
-    for _, cidr := range ipRanges {
-        entries = append(entries, fmt.Sprintf("  %s;", cidr))
-    }
-    aclContent := fmt.Sprintf("acl \"%s\" {\n%s\n};\n", countryCode, strings.Join(entries, "\n"))
+// grpcMaxRequestSize limits request messages; every request of the service is a few short strings,
+// and the length prefix must not make the server allocate whatever size a client announces.
+const grpcMaxRequestSize = 4 << 20
 
-    if err := os.WriteFile(aclFilePath, []byte(aclContent), 0644); err != nil {
-        fmt.Printf("Error writing filtered BIND ACL file: %v\n", err)
-        return
-    }
-    fmt.Printf("Filtered BIND ACL file created at: %s\n", aclFilePath)
+// pbField is one decoded protobuf field: either a varint or a length-delimited payload.
+type pbField struct {
+    Number int
+    Varint uint64
+    Bytes  []byte
 }
 
-// createOpenVPNExclude creates an unfiltered OpenVPN exclude-route file for the given country code.
-func createOpenVPNExclude(countryCode string) {
-    fmt.Printf("Creating an unfiltered OpenVPN exclude-route file for country code: %s\n", countryCode)
-
-    ipRanges := extractCountryCIDRs(countryCode, ripedbPath, false)
-    if len(ipRanges) == 0 {
-        fmt.Printf("No IP ranges found for country code: %s\n", countryCode)
-        return
+// pbAppendVarint appends a base-128 varint.
+func pbAppendVarint(b []byte, v uint64) []byte {
+    for v >= 0x80 {
+        b = append(b, byte(v)|0x80)
+        v >>= 7
     }
+    return append(b, byte(v))
+}
 
-    ipRanges = removeDuplicates(ipRanges)
-    sort.Strings(ipRanges)
-
-    var routeLines []string
-    routeLines = append(routeLines,
-        "# Redirect all traffic through VPN",
-        "push \"redirect-gateway def1\"",
-        "",
-        fmt.Sprintf("# Exclude %s IPs from VPN", strings.ToUpper(countryCode)),
-    )
-
-    for _, cidr := range ipRanges {
-        startIP, netmask, err := cidrToRoute(cidr)
-        if err != nil {
-            fmt.Printf("Skipping CIDR (%s): %v\n", cidr, err)
-            continue
-        }
-        line := fmt.Sprintf("push \"route %s %s net_gateway\"", startIP, netmask)
-        routeLines = append(routeLines, line)
+// pbAppendString appends a length-delimited string field (omitted when empty, as in proto3).
+func pbAppendString(b []byte, field int, s string) []byte {
+    if s == "" {
+        return b
     }
+    b = pbAppendVarint(b, uint64(field)<<3|2)
+    b = pbAppendVarint(b, uint64(len(s)))
+    return append(b, s...)
+}
 
-    homeDir, _ := os.UserHomeDir()
-    outFilePath := filepath.Join(homeDir, fmt.Sprintf("openvpn_exclude_%s.txt", strings.ToUpper(countryCode)))
-
-    content := strings.Join(routeLines, "\n") + "\n"
-    if err := os.WriteFile(outFilePath, []byte(content), 0644); err != nil {
-        fmt.Printf("Error writing OpenVPN exclude file: %v\n", err)
-        return
+// pbAppendBool appends a varint-encoded bool field (omitted when false, as in proto3).
+func pbAppendBool(b []byte, field int, v bool) []byte {
+    if !v {
+        return b
     }
-    fmt.Printf("OpenVPN exclude-route file created at: %s\n", outFilePath)
+    b = pbAppendVarint(b, uint64(field)<<3)
+    return append(b, 1)
 }
 
-// createOpenVPNExcludeFiltered creates a filtered OpenVPN exclude-route file for the given country code.
-func createOpenVPNExcludeFiltered(countryCode string) {
-    fmt.Printf("Creating a filtered OpenVPN exclude-route file for country code: %s\n", countryCode)
-
-    ipRanges := extractCountryCIDRs(countryCode, ripedbPath, false)
-    if len(ipRanges) == 0 {
-        fmt.Printf("No IP ranges found for country code: %s\n", countryCode)
-        return
+// pbReadVarint decodes a varint and returns it together with the number of bytes consumed.
+func pbReadVarint(b []byte) (uint64, int, error) {
+    var v uint64
+    for i := 0; i < len(b) && i < 10; i++ {
+        v |= uint64(b[i]&0x7f) << (7 * uint(i))
+        if b[i] < 0x80 {
+            return v, i + 1, nil
+        }
     }
+    return 0, 0, fmt.Errorf("malformed varint")
+}
 
-    ipRanges = removeDuplicates(ipRanges)
-    ipRanges = filterRedundantCIDRs(ipRanges)
-    sort.Strings(ipRanges)
-
-    var routeLines []string
-    routeLines = append(routeLines,
-        "# Redirect all traffic through VPN",
-        "push \"redirect-gateway def1\"",
-        "",
-        fmt.Sprintf("# Exclude %s IPs from VPN (filtered)", strings.ToUpper(countryCode)),
-    )
-
-    for _, cidr := range ipRanges {
-        startIP, netmask, err := cidrToRoute(cidr)
+// pbDecode splits a protobuf message into fields; fixed32/fixed64 fields are skipped.
+func pbDecode(b []byte) ([]pbField, error) {
+    var fields []pbField
+    for len(b) > 0 {
+        key, n, err := pbReadVarint(b)
         if err != nil {
-            fmt.Printf("Skipping CIDR (%s): %v\n", cidr, err)
+            return nil, err
+        }
+        b = b[n:]
+        field := pbField{Number: int(key >> 3)}
+        switch key & 7 {
+        case 0:
+            if field.Varint, n, err = pbReadVarint(b); err != nil {
+                return nil, err
+            }
+            b = b[n:]
+        case 1:
+            if len(b) < 8 {
+                return nil, fmt.Errorf("truncated fixed64 field")
+            }
+            b = b[8:]
+            continue
+        case 2:
+            size, n, err := pbReadVarint(b)
+            if err != nil || uint64(len(b)-n) < size {
+                return nil, fmt.Errorf("truncated length-delimited field")
+            }
+            field.Bytes = b[n : n+int(size)]
+            b = b[n+int(size):]
+        case 5:
+            if len(b) < 4 {
+                return nil, fmt.Errorf("truncated fixed32 field")
+            }
+            b = b[4:]
             continue
+        default:
+            return nil, fmt.Errorf("unsupported wire type %d", key&7)
         }
-        line := fmt.Sprintf("push \"route %s %s net_gateway\"", startIP, netmask)
-        routeLines = append(routeLines, line)
+        fields = append(fields, field)
     }
+    return fields, nil
+}
 
-    homeDir, _ := os.UserHomeDir()
-    outFilePath := filepath.Join(homeDir, fmt.Sprintf("openvpn_exclude_%s.txt", strings.ToUpper(countryCode)))
+// grpcStream writes length-prefixed gRPC messages and the final status trailers.
+type grpcStream struct {
+    w       http.ResponseWriter
+    flusher http.Flusher
+}
 
-    content := strings.Join(routeLines, "\n") + "\n"
-    if err := os.WriteFile(outFilePath, []byte(content), 0644); err != nil {
-        fmt.Printf("Error writing filtered OpenVPN exclude file: %v\n", err)
-        return
+// send writes one uncompressed message frame and flushes it to the client immediately.
+func (gs *grpcStream) send(msg []byte) error {
+    header := make([]byte, 5)
+    binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+    if _, err := gs.w.Write(append(header, msg...)); err != nil {
+        return err
+    }
+    if gs.flusher != nil {
+        gs.flusher.Flush()
     }
-    fmt.Printf("Filtered OpenVPN exclude-route file created at: %s\n", outFilePath)
+    return nil
 }
 
-//-------------------------------------------------------------------------
-// Parsing CIDRs and converting net.IPMask to dotted notation
-//-------------------------------------------------------------------------
+// finish sets the grpc-status and grpc-message trailers.
+func (gs *grpcStream) finish(code int, message string) {
+    gs.w.Header().Set(http.TrailerPrefix+"Grpc-Status", fmt.Sprintf("%d", code))
+    if message != "" {
+        gs.w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(message))
+    }
+}
 
-// cidrToRoute parses a string like "192.168.0.0/24" into "192.168.0.0" and "255.255.255.0".
-func cidrToRoute(cidr string) (string, string, error) {
-    _, ipNet, err := net.ParseCIDR(cidr)
-    if err != nil {
-        return "", "", fmt.Errorf("failed to parse CIDR: %v", err)
+// serveGRPC runs the gRPC service on a separate cleartext HTTP/2 listener.
+func (s *serverState) serveGRPC(addr string) {
+    var protocols http.Protocols
+    protocols.SetUnencryptedHTTP2(true)
+
+    server := &http.Server{
+        Addr:      addr,
+        Handler:   http.HandlerFunc(s.handleGRPC),
+        Protocols: &protocols,
     }
-    networkAddr := ipNet.IP.To4()
-    if networkAddr == nil {
-        return "", "", fmt.Errorf("IPv6 addresses are not supported in this example")
+    fmt.Printf("gRPC service chicha.whois.v1.Whois listening on %s\n", addr)
+    if err := server.ListenAndServe(); err != nil {
+        errorln("Error starting gRPC server:", err)
     }
-    netmask := ipMaskToDotted(ipNet.Mask)
-    return networkAddr.String(), netmask, nil
 }
 
-// ipMaskToDotted converts a net.IPMask (e.g., /24) to its dotted-decimal string (e.g., "255.255.255.0").
-func ipMaskToDotted(mask net.IPMask) string {
-    if len(mask) != 4 {
-        return ""
+// handleGRPC dispatches unary and server-streaming calls of the Whois service.
+func (s *serverState) handleGRPC(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+        http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+        return
     }
-    return fmt.Sprintf("%d.%d.%d.%d", mask[0], mask[1], mask[2], mask[3])
-}
 
-//-------------------------------------------------------------------------
-// Searching by country code or keywords
-//-------------------------------------------------------------------------
+    w.Header().Set("Content-Type", "application/grpc")
+    w.WriteHeader(http.StatusOK)
+    flusher, _ := w.(http.Flusher)
+    stream := &grpcStream{w: w, flusher: flusher}
 
-// extractCountryCIDRs returns a list of CIDRs for inetnum blocks that match the given country code exactly.
-func extractCountryCIDRs(countryCode, dbPath string, debugPrint bool) []string {
-    file, err := os.Open(dbPath)
+    // Every method takes exactly one request message.
+    header := make([]byte, 5)
+    if _, err := io.ReadFull(r.Body, header); err != nil {
+        stream.finish(grpcStatusInvalidArgument, "missing request message")
+        return
+    }
+    if header[0] != 0 {
+        stream.finish(grpcStatusUnimplemented, "compressed messages are not supported")
+        return
+    }
+    size := binary.BigEndian.Uint32(header[1:])
+    if size > grpcMaxRequestSize {
+        stream.finish(grpcStatusResourceExhausted, fmt.Sprintf("request message larger than %d bytes", grpcMaxRequestSize))
+        return
+    }
+    payload := make([]byte, size)
+    if _, err := io.ReadFull(r.Body, payload); err != nil {
+        stream.finish(grpcStatusInvalidArgument, "truncated request message")
+        return
+    }
+    fields, err := pbDecode(payload)
     if err != nil {
-        fmt.Println("Error opening the RIPE database:", err)
-        return nil
+        stream.finish(grpcStatusInvalidArgument, err.Error())
+        return
     }
-    defer file.Close()
 
-    countryCode = strings.ToUpper(countryCode)
-    scanner := bufio.NewScanner(file)
-    var ipRanges []string
-    var blockLines []string
-
-    for {
-        blockLines = nil
-        for scanner.Scan() {
-            line := scanner.Text()
-            if line == "" {
-                break
+    switch r.URL.Path {
+    case "/chicha.whois.v1.Whois/Lookup":
+        var ipStr string
+        for _, f := range fields {
+            if f.Number == 1 {
+                ipStr = string(f.Bytes)
             }
-            blockLines = append(blockLines, line)
         }
-        if len(blockLines) == 0 {
-            // End of file
-            break
+        idx := s.currentIndex()
+        if idx == nil {
+            stream.finish(grpcStatusUnavailable, "IP index is not ready")
+            return
+        }
+        result, err := lookupAddress(idx, ipStr)
+        if err != nil {
+            stream.finish(grpcStatusInvalidArgument, err.Error())
+            return
+        }
+        var msg []byte
+        msg = pbAppendString(msg, 1, result.IP)
+        msg = pbAppendBool(msg, 2, result.Found)
+        msg = pbAppendString(msg, 3, result.Country)
+        msg = pbAppendString(msg, 4, result.Inetnum)
+        msg = pbAppendString(msg, 5, result.Netname)
+        if err := stream.send(msg); err != nil {
+            return
         }
 
-        var inetnumLine, countryLine string
-        for _, line := range blockLines {
-            line = strings.TrimSpace(line)
-            if strings.HasPrefix(line, "inetnum:") {
-                inetnumLine = line
-            } else if strings.HasPrefix(line, "country:") {
-                countryLine = line
+    case "/chicha.whois.v1.Whois/ExtractCountry":
+        // Nested subnets are removed unless the client explicitly sets filtered to false, as in REST.
+        var countryCode string
+        filtered := true
+        for _, f := range fields {
+            switch f.Number {
+            case 1:
+                countryCode = strings.ToUpper(string(f.Bytes))
+            case 2:
+                filtered = f.Varint != 0
             }
         }
+        if countryCode == "" {
+            stream.finish(grpcStatusInvalidArgument, "country is required")
+            return
+        }
+        if !sendGRPCPrefixes(stream, countryPrefixList(countryCode, filtered), countryCode) {
+            return
+        }
 
-        if inetnumLine != "" && countryLine != "" {
-            fields := strings.Fields(countryLine)
-            if len(fields) >= 2 {
-                blockCountryCode := strings.ToUpper(fields[1])
-                if blockCountryCode == countryCode {
-                    // This block matches the specified country code.
-                    inetnumFields := strings.Fields(inetnumLine)
-                    if len(inetnumFields) >= 2 {
-                        ipRangeStr := strings.Join(inetnumFields[1:], " ")
-                        ipRangeParts := strings.Split(ipRangeStr, "-")
-                        if len(ipRangeParts) == 2 {
-                            start := strings.TrimSpace(ipRangeParts[0])
-                            end := strings.TrimSpace(ipRangeParts[1])
-
-                            if debugPrint {
-                                fmt.Printf("Found inetnum entry: %s - %s\n", start, end)
-                            }
-
-                            cidr := generateCIDR(start, end)
-                            if cidr != "" {
-                                if debugPrint {
-                                    fmt.Printf("Converted to CIDR: %s\n", cidr)
-                                }
-                                ipRanges = append(ipRanges, cidr)
-                            }
-                        }
-                    }
+    case "/chicha.whois.v1.Whois/Search":
+        var countryCode string
+        var keywords []string
+        for _, f := range fields {
+            switch f.Number {
+            case 1:
+                countryCode = strings.ToUpper(string(f.Bytes))
+            case 2:
+                if kw := strings.TrimSpace(string(f.Bytes)); kw != "" {
+                    keywords = append(keywords, kw)
                 }
             }
         }
+        if countryCode == "" && len(keywords) == 0 {
+            stream.finish(grpcStatusInvalidArgument, "country and/or keywords are required")
+            return
+        }
+        if _, err := newSearchMatcher(keywords); err != nil {
+            stream.finish(grpcStatusInvalidArgument, "invalid keyword "+err.Error())
+            return
+        }
+        if !sendGRPCPrefixes(stream, searchPrefixList(countryCode, keywords), countryCode) {
+            return
+        }
+
+    default:
+        stream.finish(grpcStatusUnimplemented, "unknown method "+r.URL.Path)
+        return
     }
-    return ipRanges
+    stream.finish(grpcStatusOK, "")
 }
 
-// extractCIDRsByKeywordsAndCountry searches the RIPE DB for inetnum blocks that optionally match a country code
-// and contain at least one of the provided keywords. 
-func extractCIDRsByKeywordsAndCountry(countryCode string, keywords []string, dbPath string, debugPrint bool) []string {
-    file, err := os.Open(dbPath)
-    if err != nil {
-        fmt.Println("Error opening the RIPE database:", err)
-        return nil
+// sendGRPCPrefixes streams Prefix messages; it returns false if the client went away.
+func sendGRPCPrefixes(stream *grpcStream, prefixes []string, countryCode string) bool {
+    for _, cidr := range prefixes {
+        var msg []byte
+        msg = pbAppendString(msg, 1, cidr)
+        msg = pbAppendString(msg, 2, countryCode)
+        if err := stream.send(msg); err != nil {
+            return false
+        }
     }
-    defer file.Close()
-
-    // Convert country code to uppercase for matching "country: XX".
-    countryCode = strings.ToUpper(countryCode)
+    return true
+}
 
-    // Convert all keywords to lowercase for case-insensitive search.
-    for i := range keywords {
-        keywords[i] = strings.ToLower(keywords[i])
-    }
+//-------------------------------------------------------------------------
+// Output format registry (used by the web UI downloads)
+//-------------------------------------------------------------------------
 
-    scanner := bufio.NewScanner(file)
-    var ipRanges []string
-    var blockLines []string
+// outputFormat describes a text format that a prefix list can be rendered to.
+type outputFormat struct {
+    Name        string                                            // Identifier used in URLs, e.g. "bind".
+    Description string                                            // Human readable description.
+    Extension   string                                            // File extension for downloads.
+    Comment     string                                            // Line comment marker for the metadata header; empty if none.
+    Render      func(name string, cidrs []string) (string, error) // Renders the list; name is a country code or "search".
+}
 
-    for {
-        blockLines = nil
-        for scanner.Scan() {
-            line := scanner.Text()
-            if line == "" {
-                // Blank line signals the end of a block
-                break
-            }
-            blockLines = append(blockLines, line)
-        }
-        if len(blockLines) == 0 {
-            // End of file
-            break
+// outputFormats lists every format a prefix list can be downloaded in, in display order.
+var outputFormats = []outputFormat{
+    {"plain", "Plain CIDR list", "txt", "#", func(name string, cidrs []string) (string, error) {
+        return strings.Join(cidrs, "\n") + "\n", nil
+    }},
+    {"bind", "BIND ACL", "conf", "//", func(name string, cidrs []string) (string, error) {
+        return renderBindACL(name, cidrs), nil
+    }},
+    {"ovpn", "OpenVPN client routes (net_gateway)", "txt", "#", func(name string, cidrs []string) (string, error) {
+        return renderOpenVPNRoutes(name, cidrs, false), nil
+    }},
+    {"ovpn-push", "OpenVPN server push routes", "txt", "#", func(name string, cidrs []string) (string, error) {
+        return renderOpenVPNRoutes(name, cidrs, true), nil
+    }},
+    {"bird", "BIRD 2 prefix set", "conf", "#", func(name string, cidrs []string) (string, error) {
+        return renderBIRDConfig("chicha_"+routingNameRe.ReplaceAllString(name, "_"), cidrs, false), nil
+    }},
+    {"frr", "FRRouting prefix-list", "conf", "!", func(name string, cidrs []string) (string, error) {
+        return renderFRRConfig("chicha_"+name, cidrs, false), nil
+    }},
+    {"junos", "Juniper JunOS prefix-list (set commands)", "set", "#", func(name string, cidrs []string) (string, error) {
+        return renderJunOSPrefixList(name, cidrs), nil
+    }},
+    {"nginx", "nginx allow rules", "conf", "#", func(name string, cidrs []string) (string, error) {
+        return renderNginxAccess("allow", cidrs), nil
+    }},
+    {"squid", "Squid acl (src)", "conf", "#", func(name string, cidrs []string) (string, error) {
+        return renderSquidACL(name, "src", cidrs), nil
+    }},
+    {"postfix", "Postfix cidr table (REJECT)", "cidr", "#", func(name string, cidrs []string) (string, error) {
+        return renderPostfixTable("REJECT country-blocked", cidrs), nil
+    }},
+    {"adguard", "AdGuard Home disallowed_clients", "yaml", "#", func(name string, cidrs []string) (string, error) {
+        return renderAdGuardClients(cidrs), nil
+    }},
+    {"yaml", "YAML list", "yaml", "#", func(name string, cidrs []string) (string, error) {
+        return renderYAMLList(name, true, cidrs), nil
+    }},
+    {"ansible", "Ansible vars file", "yml", "#", func(name string, cidrs []string) (string, error) {
+        return "---\n" + renderYAMLList(ansibleVarName(name), false, cidrs), nil
+    }},
+    {"nginx-geo", "nginx geo map ($country)", "conf", "#", func(name string, cidrs []string) (string, error) {
+        return renderNginxGeo("country", []string{name}, map[string][]string{name: cidrs}), nil
+    }},
+    {"crowdsec-json", "CrowdSec decisions (JSON)", "json", "", func(name string, cidrs []string) (string, error) {
+        return renderCrowdSecJSON(buildCrowdSecDecisions(name, cidrs, "24h", "ban"))
+    }},
+    {"crowdsec-csv", "CrowdSec decisions (CSV)", "csv", "", func(name string, cidrs []string) (string, error) {
+        return renderCrowdSecCSV(buildCrowdSecDecisions(name, cidrs, "24h", "ban"))
+    }},
+    {"netset", "FireHOL netset", "netset", "#", func(name string, cidrs []string) (string, error) {
+        sourceDate := time.Now()
+        if fi, err := os.Stat(ripedbPath); err == nil {
+            sourceDate = fi.ModTime()
         }
-
-        var inetnumLine, countryLine string
-        for _, line := range blockLines {
-            trimLine := strings.TrimSpace(line)
-            if strings.HasPrefix(trimLine, "inetnum:") {
-                inetnumLine = trimLine
-            } else if strings.HasPrefix(trimLine, "country:") {
-                countryLine = trimLine
+        return renderFireHOLNetset(name, cidrs, sourceDate, time.Now()), nil
+    }},
+    {"p2p", "PeerGuardian P2P blocklist", "p2p", "#", func(name string, cidrs []string) (string, error) {
+        var ranges []inetnumRange
+        for _, cidr := range cidrs {
+            if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.IP.To4() != nil {
+                ranges = append(ranges, inetnumRange{
+                    Start: binary.BigEndian.Uint32(ipNet.IP.To4()),
+                    End:   binary.BigEndian.Uint32(lastIP(ipNet)),
+                })
             }
         }
+        return renderP2P(countryName(name), ranges), nil
+    }},
+    {"pgsql", "PostgreSQL COPY dump", "sql", "--", func(name string, cidrs []string) (string, error) {
+        return renderPostgresDump("ripe_country_prefixes", name, cidrs)
+    }},
+    {"clickhouse", "ClickHouse TabSeparated data", "tsv", "", func(name string, cidrs []string) (string, error) {
+        return renderClickHouseTSV(name, cidrs), nil
+    }},
+}
 
-        // If a country code was specified, check if the block matches it.
-        if countryCode != "" {
-            if countryLine == "" {
-                // No country line in this block, skip it.
-                continue
-            }
-            fields := strings.Fields(countryLine)
-            if len(fields) < 2 || strings.ToUpper(fields[1]) != countryCode {
-                // The country code in this block doesn't match the desired one.
-                continue
-            }
+// findOutputFormat returns the registered format with the given name.
+func findOutputFormat(name string) (outputFormat, bool) {
+    for _, f := range outputFormats {
+        if f.Name == name {
+            return f, true
         }
+    }
+    return outputFormat{}, false
+}
+
+//-------------------------------------------------------------------------
+// Web UI
+//-------------------------------------------------------------------------
+
+// handleAPICountries serves GET /api/v1/countries: country codes present in the database, with names and counts.
+func handleAPICountries(w http.ResponseWriter, r *http.Request) {
+    type country struct {
+        Code        string `json:"code"`
+        Name        string `json:"name"`
+        Blocks      int    `json:"blocks"`
+        Addresses   uint64 `json:"addresses"`
+        Placeholder bool   `json:"placeholder,omitempty"`
+    }
+    summary, err := loadCountrySummary(ripedbPath)
+    if err != nil {
+        writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+        return
+    }
+    var list []country
+    for code, stat := range summary.Countries {
+        list = append(list, country{Code: code, Name: countryName(code), Blocks: stat.Blocks, Addresses: stat.Addresses,
+            Placeholder: isPlaceholderCountry(code)})
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+    writeJSON(w, http.StatusOK, list)
+}
+
+// handleAPIFormats serves GET /api/v1/formats: the formats accepted by /api/v1/download.
+func handleAPIFormats(w http.ResponseWriter, r *http.Request) {
+    type format struct {
+        Name        string `json:"name"`
+        Description string `json:"description"`
+    }
+    var list []format
+    for _, f := range outputFormats {
+        list = append(list, format{Name: f.Name, Description: f.Description})
+    }
+    writeJSON(w, http.StatusOK, list)
+}
 
-        // If no keywords were given, we accept the block if it has an inetnum line.
-        if len(keywords) == 0 {
-            if inetnumLine != "" {
-                ipRanges = append(ipRanges, inetnumToCIDR(inetnumLine, debugPrint)...)
-            }
-            continue
-        }
+// handleAPIDownload serves GET /api/v1/download?country=CC|q=CC:kw&format=NAME as a file attachment.
+func handleAPIDownload(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    format, ok := findOutputFormat(query.Get("format"))
+    if !ok {
+        writeJSONError(w, http.StatusBadRequest, "unknown format; see /api/v1/formats")
+        return
+    }
 
-        // Otherwise, we check if the block contains any of the keywords (case-insensitive).
-        blockTextLower := strings.ToLower(strings.Join(blockLines, "\n"))
-        match := false
-        for _, kw := range keywords {
-            if kw == "" {
-                continue
-            }
-            if strings.Contains(blockTextLower, kw) {
-                match = true
-                break
-            }
+    var name, selection string
+    var list func() []string
+    if q := query.Get("q"); q != "" {
+        countryCode, keywords := parseSearchParam(q)
+        name = strings.ToUpper(countryCode)
+        if name == "" {
+            name = "search"
         }
-        if match && inetnumLine != "" {
-            ipRanges = append(ipRanges, inetnumToCIDR(inetnumLine, debugPrint)...)
+        selection = "search " + q
+        list = func() []string { return searchPrefixList(countryCode, keywords) }
+    } else if countryCode := strings.ToUpper(query.Get("country")); countryCode != "" {
+        name = countryCode
+        selection = "country " + countryCode
+        filtered := query.Get("filtered") != "0"
+        if filtered {
+            selection += " (filtered)"
         }
+        list = func() []string { return countryPrefixList(countryCode, filtered) }
+    } else {
+        writeJSONError(w, http.StatusBadRequest, "country or q is required")
+        return
+    }
+    // The SQL dump stores the name in a two-letter country column; reject other names before scanning.
+    if format.Name == "pgsql" && !countryCodeRe.MatchString(name) {
+        writeJSONError(w, http.StatusBadRequest, errPostgresCountry.Error())
+        return
     }
+    prefixes := list()
 
-    return ipRanges
+    content, err := format.Render(name, prefixes)
+    if err != nil {
+        writeJSONError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    content = withMetadataHeader(content, outputMeta{Selection: selection, Entries: len(prefixes), Comment: format.Comment})
+    fileName := fmt.Sprintf("%s.%s.%s", strings.NewReplacer("/", "_", "\"", "_").Replace(name), format.Name, format.Extension)
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+    io.WriteString(w, content)
 }
 
-// inetnumToCIDR parses a line like "inetnum: 1.2.3.0 - 1.2.3.255" and converts it to a CIDR range if possible.
-func inetnumToCIDR(inetnumLine string, debugPrint bool) []string {
-    var result []string
-    parts := strings.Fields(inetnumLine)
-    if len(parts) < 2 {
-        return result
+// handleList serves GET /lists/FORMAT/CC.EXT[?filtered=0] (e.g. /lists/plain/RU.txt), a stable URL for
+// appliances that poll URL-based lists. Lists are rendered once per database version and answered with
+// ETag and Last-Modified, so conditional requests get 304 Not Modified until the list really changes.
+func (s *serverState) handleList(w http.ResponseWriter, r *http.Request) {
+    formatName, fileName, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/lists/"), "/")
+    format, found := findOutputFormat(formatName)
+    countryCode, ext, _ := strings.Cut(fileName, ".")
+    countryCode = strings.ToUpper(countryCode)
+    if !ok || !found || countryCode == "" || (ext != "" && ext != format.Extension) {
+        http.Error(w, "expected /lists/FORMAT/CC.EXT, see /api/v1/formats", http.StatusNotFound)
+        return
+    }
+    // Only country codes and the groups given on the command line are served, so arbitrary paths
+    // cannot fill the list cache.
+    if !countryCodeRe.MatchString(countryCode) && countryGroups[countryCode] == nil {
+        http.Error(w, "unknown country code "+countryCode, http.StatusNotFound)
+        return
+    }
+    filtered := r.URL.Query().Get("filtered") != "0"
+    list, err := s.hostedList(format, countryCode, filtered)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
     }
 
-    ipRangeStr := strings.Join(parts[1:], " ")
-    ipRangeParts := strings.Split(ipRangeStr, "-")
-    if len(ipRangeParts) == 2 {
-        start := strings.TrimSpace(ipRangeParts[0])
-        end := strings.TrimSpace(ipRangeParts[1])
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Header().Set("ETag", list.etag)
+    w.Header().Set("Cache-Control", "no-cache")
+    http.ServeContent(w, r, fileName, list.modified, bytes.NewReader(list.content))
+}
 
-        if debugPrint {
-            fmt.Printf("Found inetnum entry: %s - %s\n", start, end)
+// hostedList returns the rendered /lists/ response of a country and format for the current database.
+// The database scan runs without listsMu held; concurrent requests for the same list wait for one render.
+func (s *serverState) hostedList(format outputFormat, countryCode string, filtered bool) (*hostedList, error) {
+    key := fmt.Sprintf("%s/%s/%t", format.Name, countryCode, filtered)
+    identity := databaseIdentity()
+
+    s.listsMu.Lock()
+    if list := s.lists[key]; list != nil && list.identity == identity {
+        list.served = time.Now()
+        s.listsMu.Unlock()
+        return list, nil
+    }
+    if render, ok := s.listRenders[key]; ok {
+        s.listsMu.Unlock()
+        <-render.done
+        return render.list, render.err
+    }
+    previous := s.lists[key]
+    render := &listRender{done: make(chan struct{})}
+    if s.listRenders == nil {
+        s.listRenders = make(map[string]*listRender)
+    }
+    s.listRenders[key] = render
+    s.listsMu.Unlock()
+
+    content, err := format.Render(countryCode, countryPrefixList(countryCode, filtered))
+    if err == nil {
+        now := time.Now()
+        etag := `"` + sha256Hex(content)[:32] + `"`
+        list := &hostedList{identity: identity, content: []byte(content), etag: etag, modified: now.UTC(), served: now}
+        if previous != nil && previous.etag == etag {
+            list.content, list.modified = previous.content, previous.modified
         }
+        render.list = list
+    }
+    render.err = err
 
-        cidr := generateCIDR(start, end)
-        if cidr != "" {
-            if debugPrint {
-                fmt.Printf("Converted to CIDR: %s\n", cidr)
+    s.listsMu.Lock()
+    delete(s.listRenders, key)
+    if render.list != nil {
+        if s.lists == nil {
+            s.lists = make(map[string]*hostedList)
+        }
+        s.lists[key] = render.list
+        for len(s.lists) > maxHostedLists {
+            oldest := ""
+            for k, list := range s.lists {
+                if oldest == "" || list.served.Before(s.lists[oldest].served) {
+                    oldest = k
+                }
             }
-            result = append(result, cidr)
+            delete(s.lists, oldest)
         }
     }
-    return result
+    s.listsMu.Unlock()
+    close(render.done)
+    return render.list, render.err
 }
 
-// generateCIDR attempts to combine a start IP and end IP into a single CIDR notation (e.g., 192.168.0.0/24).
-// This works properly if the range aligns to a power-of-two boundary.
-func generateCIDR(startIPStr, endIPStr string) string {
-    startIP := net.ParseIP(startIPStr).To4()
-    endIP := net.ParseIP(endIPStr).To4()
-    if startIP == nil || endIP == nil {
-        fmt.Printf("Error: Invalid IP range: %s - %s\n", startIPStr, endIPStr)
-        return ""
+// handleWebUI serves the embedded single-page UI.
+func handleWebUI(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path != "/" {
+        http.NotFound(w, r)
+        return
     }
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    io.WriteString(w, webUIPage)
+}
 
-    start := binary.BigEndian.Uint32(startIP)
-    end := binary.BigEndian.Uint32(endIP)
+// webUIPage is a dependency-free page that talks to the REST API.
+const webUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>chicha-whois</title>
+<style>
+  body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 960px; padding: 0 1em; color: #222; }
+  h1 { font-size: 1.4em; }
+  fieldset { border: 1px solid #ccc; border-radius: 6px; margin-bottom: 1em; }
+  label { margin-right: 1em; }
+  input[type=text] { padding: .3em; min-width: 16em; }
+  button, select { padding: .3em .6em; }
+  pre { background: #f5f5f5; padding: 1em; max-height: 28em; overflow: auto; border-radius: 6px; }
+  #status { color: #555; }
+</style>
+</head>
+<body>
+<h1>chicha-whois</h1>
+
+<fieldset>
+  <legend>IP lookup</legend>
+  <input type="text" id="ip" placeholder="1.2.3.4">
+  <button onclick="lookup()">Lookup</button>
+</fieldset>
+
+<fieldset>
+  <legend>Country or search</legend>
+  <label>Country <select id="country"><option value="">(any)</option></select></label>
+  <label>Keywords <input type="text" id="keywords" placeholder="ok.ru,vkontakte"></label>
+  <label><input type="checkbox" id="filtered" checked> remove nested subnets</label>
+  <button onclick="preview()">Preview</button>
+  <br><br>
+  <label>Format <select id="format"></select></label>
+  <button onclick="download()">Download</button>
+</fieldset>
+
+<div id="status"></div>
+<pre id="output"></pre>
 
-    diff := start ^ end
-    prefixLength := 32 - bits.Len32(diff)
+<script>
+function $(id) { return document.getElementById(id); }
 
-    network := start &^ ((1 << (32 - prefixLength)) - 1)
-    networkIP := make(net.IP, 4)
-    binary.BigEndian.PutUint32(networkIP, network)
+function query() {
+  var country = $("country").value, keywords = $("keywords").value.trim();
+  if (keywords !== "") {
+    return "q=" + encodeURIComponent(country + ":" + keywords);
+  }
+  if (country === "") {
+    return null;
+  }
+  return "country=" + encodeURIComponent(country) + "&filtered=" + ($("filtered").checked ? "1" : "0");
+}
+
+function show(status, text) {
+  $("status").textContent = status;
+  $("output").textContent = text;
+}
+
+function lookup() {
+  show("Looking up...", "");
+  fetch("/api/v1/lookup?ip=" + encodeURIComponent($("ip").value.trim()))
+    .then(function (r) { return r.json(); })
+    .then(function (j) { show(j.error ? "Error" : "", JSON.stringify(j, null, 2)); });
+}
+
+function preview() {
+  var q = query();
+  if (q === null) { show("Select a country or enter keywords.", ""); return; }
+  show("Scanning the database, this can take a while...", "");
+  var url = q.indexOf("q=") === 0 ? "/api/v1/search?" + q
+                                   : "/api/v1/country/" + encodeURIComponent($("country").value) + "?" + q;
+  fetch(url)
+    .then(function (r) { return r.json(); })
+    .then(function (j) {
+      if (j.error) { show("Error: " + j.error, ""); return; }
+      var list = j.prefixes || [];
+      var shown = list.slice(0, 5000);
+      show(j.count + " prefixes" + (list.length > shown.length ? " (first " + shown.length + " shown)" : ""), shown.join("\n"));
+    });
+}
 
-    return fmt.Sprintf("%s/%d", networkIP.String(), prefixLength)
+function download() {
+  var q = query();
+  if (q === null) { show("Select a country or enter keywords.", ""); return; }
+  window.location = "/api/v1/download?" + q + "&format=" + encodeURIComponent($("format").value);
 }
 
+fetch("/api/v1/countries").then(function (r) { return r.json(); }).then(function (list) {
+  list.forEach(function (c) {
+    var o = document.createElement("option");
+    o.value = c.code; o.textContent = c.code + " - " + c.name;
+    $("country").appendChild(o);
+  });
+});
+fetch("/api/v1/formats").then(function (r) { return r.json(); }).then(function (list) {
+  list.forEach(function (f) {
+    var o = document.createElement("option");
+    o.value = f.name; o.textContent = f.description;
+    $("format").appendChild(o);
+  });
+});
+</script>
+</body>
+</html>
+`
+
 //-------------------------------------------------------------------------
-// Utility functions to filter out nested subnets, remove duplicates, etc.
+// Configuration file
 //-------------------------------------------------------------------------
 
-// filterRedundantCIDRs removes subnets that are fully contained inside larger subnets.
-func filterRedundantCIDRs(cidrs []string) []string {
-    var parsedCIDRs []*net.IPNet
-    for _, cidrStr := range cidrs {
-        _, ipNet, err := net.ParseCIDR(cidrStr)
-        if err != nil {
-            fmt.Printf("Error parsing CIDR %s: %v\n", cidrStr, err)
-            continue
-        }
-        parsedCIDRs = append(parsedCIDRs, ipNet)
-    }
+// configPath is the file given with --config; empty means the default location (see defaultConfigPath).
+var configPath string
 
-    // Sort by prefix length ascending (bigger networks first), then by IP address ascending.
-    sort.Slice(parsedCIDRs, func(i, j int) bool {
-        onesI, bitsI := parsedCIDRs[i].Mask.Size()
-        onesJ, bitsJ := parsedCIDRs[j].Mask.Size()
+// fileConfig is the JSON configuration file holding settings that do not belong on the command line,
+// such as router credentials.
+type fileConfig struct {
+    MikroTik map[string]mikrotikRouter   `json:"mikrotik"` // RouterOS API targets by name.
+    UniFi    map[string]unifiController `json:"unifi"`    // UniFi Network controllers by name.
+    OPNsense map[string]opnsenseFirewall `json:"opnsense"` // OPNsense firewalls by name.
+    PfSense  map[string]pfsenseFirewall  `json:"pfsense"`  // pfSense firewalls with the REST API package by name.
+    NetBox   map[string]netboxInstance   `json:"netbox"`   // NetBox instances by name.
 
-        // For IPv4, bitsI == 32; but let's keep this for correctness if needed.
-        if bitsI != bitsJ {
-            return bitsI < bitsJ
-        }
-        if onesI != onesJ {
-            return onesI < onesJ
-        }
-        return bytes.Compare(parsedCIDRs[i].IP, parsedCIDRs[j].IP) < 0
-    })
+    CSVProfiles map[string]csvProfile    `json:"csv_profiles"` // Column mappings for -csv -profile by name.
+    Presets     map[string]preset        `json:"presets"`      // Stored invocations for -preset by name.
+    Email       map[string]emailSettings `json:"email"`        // SMTP targets for -serve -email by name.
+    Notifiers   map[string]notifier      `json:"notifiers"`    // Slack/Telegram targets for -serve -notify and presets by name.
+}
 
-    var keptCIDRs []*net.IPNet
-    for _, candidate := range parsedCIDRs {
-        redundant := false
-        for _, keeper := range keptCIDRs {
-            if cidrContains(keeper, candidate) {
-                redundant = true
-                fmt.Printf("Filtered out redundant CIDR: %s (contained in %s)\n",
-                    candidate.String(), keeper.String())
-                break
-            }
-        }
-        if !redundant {
-            keptCIDRs = append(keptCIDRs, candidate)
-        }
+// defaultConfigPath returns <user config dir>/chicha-whois/config.json (~/.config on Linux,
+// %AppData% on Windows, ~/Library/Application Support on macOS).
+func defaultConfigPath() string {
+    dir, err := os.UserConfigDir()
+    if err != nil {
+        return ""
     }
+    return filepath.Join(dir, "chicha-whois", "config.json")
+}
 
-    var results []string
-    for _, net := range keptCIDRs {
-        results = append(results, net.String())
+// loadConfig reads the configuration file given with --config or found at the default location.
+func loadConfig() (*fileConfig, error) {
+    path := configPath
+    if path == "" {
+        path = defaultConfigPath()
     }
-    return results
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("reading the configuration file: %v", err)
+    }
+    var config fileConfig
+    if err := json.Unmarshal(data, &config); err != nil {
+        return nil, fmt.Errorf("parsing %s: %v", path, err)
+    }
+    return &config, nil
 }
 
-// cidrContains checks if 'inner' is fully contained within 'outer'.
-func cidrContains(outer, inner *net.IPNet) bool {
-    if !outer.Contains(inner.IP) {
-        return false
+// configEntry picks the entry called name from a section of the configuration file; an empty name
+// selects the only entry if there is exactly one. It prints why if there is no such entry.
+func configEntry[T any](entries map[string]T, name, section string) (T, bool) {
+    if name == "" && len(entries) == 1 {
+        for key := range entries {
+            name = key
+        }
     }
-    innerLast := lastIP(inner)
-    return outer.Contains(innerLast)
+    entry, ok := entries[name]
+    if len(entries) == 0 {
+        errorf("The configuration file has no \"%s\" section.\n", section)
+    } else if !ok && name == "" {
+        names := slices.Sorted(maps.Keys(entries))
+        errorf("Choose an entry of the \"%s\" section of the configuration file: %s\n", section, strings.Join(names, ", "))
+    } else if !ok {
+        errorf("%q is not defined in the \"%s\" section of the configuration file.\n", name, section)
+    }
+    return entry, ok
 }
 
-// lastIP calculates the broadcast (last) address in a subnet range.
-func lastIP(ipNet *net.IPNet) net.IP {
-    ip := ipNet.IP.To4()
-    if ip == nil {
-        // IPv6 is skipped in this example
-        return nil
+// preset is a stored invocation from the "presets" section of the configuration file, run with -preset NAME.
+type preset struct {
+    Command    string   `json:"command"`     // Command to run, e.g. "-dns-acl-f", "-pf" or "-search".
+    Countries  []string `json:"countries"`   // Country selections (codes, names, continent:EU,-DE).
+    ASNs       []string `json:"asns"`        // ASNs, for commands that take them (-route-audit AS3333).
+    Keywords   []string `json:"keywords"`    // -search keywords; the search parameter becomes CC:kw1,kw2.
+    Output     string   `json:"output"`      // Passed as -o.
+    Args       []string `json:"args"`        // Further flags of the command, e.g. ["-name", "BLOCKED"].
+    Hook       string   `json:"hook"`        // Shell command run after the command if an output changed.
+    HookAlways bool     `json:"hook_always"` // Run the hook (and notify) even if no output changed.
+    Notify     []string `json:"notify"`      // Notifiers told when an output changed.
+}
+
+// expandPreset turns -preset NAME [ARGS...] into the command line stored in the configuration file;
+// ARGS are added to the flags of the preset. The returned function runs the hook of the preset, posts
+// to its notifiers and is meant to be deferred until the command has finished.
+func expandPreset(args []string) ([]string, func(), bool) {
+    name, extra := "", []string(nil)
+    if len(args) > 0 {
+        name, extra = args[0], args[1:]
+    }
+    config, err := loadConfig()
+    if err != nil {
+        errorln(err)
+        return nil, nil, false
+    }
+    p, ok := configEntry(config.Presets, name, "presets")
+    if !ok {
+        return nil, nil, false
+    }
+    if name == "" {
+        for key := range config.Presets {
+            name = key
+        }
+    }
+    if !strings.HasPrefix(p.Command, "-") {
+        errorf("Preset %s: \"command\" must be a command such as -dns-acl-f, not %q\n", name, p.Command)
+        return nil, nil, false
     }
-    mask := ipNet.Mask
-    network := ip.Mask(mask)
-    broadcast := make(net.IP, len(network))
 
-    for i := 0; i < len(network); i++ {
-        broadcast[i] = network[i] | ^mask[i]
+    expanded := []string{os.Args[0], p.Command}
+    expanded = append(expanded, p.Args...)
+    expanded = append(expanded, extra...)
+    if p.Output != "" {
+        expanded = append(expanded, "-o", p.Output)
     }
-    return broadcast
-}
+    if p.Command == "-search" {
+        if len(p.Countries) > 1 {
+            errorf("Preset %s: -search takes one country selection\n", name)
+            return nil, nil, false
+        }
+        param := strings.Join(p.Countries, "")
+        if len(p.Keywords) > 0 {
+            param += ":" + strings.Join(p.Keywords, ",")
+        }
+        expanded = append(expanded, param)
+    } else {
+        expanded = append(expanded, p.Countries...)
+        expanded = append(expanded, p.ASNs...)
+    }
+    notifiers := map[string]notifier{}
+    if len(p.Notify) > 0 {
+        if notifiers, ok = loadNotifiers(p.Notify); !ok {
+            return nil, nil, false
+        }
+    }
+    statusf("Preset %s: %s\n", name, strings.Join(expanded[1:], " "))
 
-// removeDuplicates removes duplicate strings from a slice while preserving order.
-func removeDuplicates(elements []string) []string {
-    seen := make(map[string]bool)
-    var result []string
-    for _, e := range elements {
-        if !seen[e] {
-            seen[e] = true
-            result = append(result, e)
+    hook := func() {
+        reportMu.Lock()
+        changed := outputsChanged
+        reportMu.Unlock()
+        if !changed && !p.HookAlways {
+            return
+        }
+        if len(notifiers) > 0 {
+            text := "chicha-whois: preset " + name + ": no output changed"
+            if changed {
+                target := p.Output
+                if target == "" {
+                    target = "output"
+                }
+                text = "chicha-whois: preset " + name + ": " + target + " updated"
+            }
+            notify(notifiers, text)
+        }
+        if p.Hook == "" {
+            return
+        }
+        var cmd *exec.Cmd
+        if runtime.GOOS == "windows" {
+            cmd = exec.Command("cmd", "/C", p.Hook)
+        } else {
+            cmd = exec.Command("sh", "-c", p.Hook)
+        }
+        cmd.Env = append(os.Environ(), "CHICHA_WHOIS_PRESET="+name, "CHICHA_WHOIS_CHANGED="+strconv.FormatBool(changed))
+        cmd.Stdout = os.Stdout
+        cmd.Stderr = os.Stderr
+        statusf("Running the hook of preset %s: %s\n", name, p.Hook)
+        if err := cmd.Run(); err != nil {
+            warnf("Warning: the hook of preset %s failed: %v\n", name, err)
         }
     }
-    return result
+    return expanded, hook, true
 }
 
 //-------------------------------------------------------------------------
-// List of available country codes
+// Global options and localization
 //-------------------------------------------------------------------------
 
-// showAvailableCountryCodes prints a list of known country codes within the RIPE NCC region, sorted alphabetically by name.
-func showAvailableCountryCodes() {
-    countries := map[string]string{
-        "AL": "Albania", "AM": "Armenia", "AT": "Austria", "AZ": "Azerbaijan",
-        "BA": "Bosnia and Herzegovina", "BE": "Belgium", "BG": "Bulgaria",
-        "BY": "Belarus", "CH": "Switzerland", "CY": "Cyprus", "CZ": "Czech Republic",
-        "DE": "Germany", "DK": "Denmark", "EE": "Estonia", "ES": "Spain",
-        "FI": "Finland", "FR": "France", "GE": "Georgia", "GR": "Greece",
-        "HR": "Croatia", "HU": "Hungary", "IE": "Ireland", "IL": "Israel",
-        "IS": "Iceland", "IT": "Italy", "KG": "Kyrgyzstan", "KZ": "Kazakhstan",
-        "LT": "Lithuania", "LU": "Luxembourg", "LV": "Latvia", "MD": "Moldova",
-        "ME": "Montenegro", "MK": "North Macedonia", "MT": "Malta", "NL": "Netherlands",
-        "NO": "Norway", "PL": "Poland", "PT": "Portugal", "RO": "Romania",
-        "RS": "Serbia", "RU": "Russia", "SE": "Sweden", "SI": "Slovenia",
-        "SK": "Slovakia", "TJ": "Tajikistan", "TM": "Turkmenistan", "TR": "Turkey",
-        "UA": "Ukraine", "UZ": "Uzbekistan",
+// lang is the language of user-facing messages and country names ("en" or "ru").
+var lang = "en"
+
+// supportedLanguages lists the values accepted by --lang.
+var supportedLanguages = []string{"en", "ru"}
+
+// familyCommands lists the commands that write the address families selected with -4, -6 and -46;
+// every other command produces IPv4 prefixes only.
+var familyCommands = []string{"-dns-acl", "-dns-acl-f", "-ovpn", "-ovpn-f"}
+
+// checkAddressFamilies reports whether cmd can honour the selected address families, printing an
+// error if -6 or -46 was given to a command that cannot write IPv6 prefixes.
+func checkAddressFamilies(cmd string) bool {
+    if withIPv4 && !withIPv6 {
+        return true
+    }
+    switch cmd {
+    case "-h", "--help", "-v", "--version":
+        return true
+    }
+    if slices.Contains(familyCommands, cmd) {
+        return true
     }
+    errorf("%s writes IPv4 prefixes only; -6 and -46 apply to %s\n", cmd, strings.Join(familyCommands, ", "))
+    return false
+}
 
-    var countryList []struct {
-        Code string
-        Name string
+// parseGlobalOptions removes options that apply to every command from the argument list,
+// wherever they appear, and returns the remaining arguments. Both "--name value" and
+// "--name=value" are accepted.
+func parseGlobalOptions(args []string) ([]string, error) {
+    var rest []string
+    for i := 0; i < len(args); i++ {
+        arg := args[i]
+        name, value, hasValue := strings.Cut(arg, "=")
+        switch name {
+        case "-4", "-6", "-46":
+            withIPv4, withIPv6 = name != "-6", name != "-4"
+        case "--lang":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            value = strings.ToLower(value)
+            found := false
+            for _, supported := range supportedLanguages {
+                if value == supported {
+                    found = true
+                }
+            }
+            if !found {
+                return nil, fmt.Errorf("unsupported language %q (supported: %s)", value, strings.Join(supportedLanguages, ", "))
+            }
+            lang = value
+        case "--report":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            reportPath = value
+        case "--workers":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 1 {
+                return nil, fmt.Errorf("invalid value %q for %s (expected a positive number)", value, name)
+            }
+            workers = n
+        case "--db":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            ripedbPath = value
+            customDB = true
+        case "--exclude", "--intersect":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            if name == "--exclude" {
+                excludeItems = append(excludeItems, value)
+            } else {
+                intersectItems = append(intersectItems, value)
+            }
+        case "--mrt":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            mrtPath = value
+        case "--status-file":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            statusFile = value
+        case "--config":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            configPath = value
+        case "--sign":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            spec, err := parseSigner(value)
+            if err != nil {
+                return nil, fmt.Errorf("invalid value %q for %s: %v", value, name, err)
+            }
+            signer = spec
+        case "--mode", "--owner":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            if name == "--mode" {
+                mode, err := strconv.ParseUint(value, 8, 32)
+                if err != nil || mode == 0 || mode > 0777 {
+                    return nil, fmt.Errorf("invalid value %q for %s (expected octal permissions such as 0640)", value, name)
+                }
+                fileMode = os.FileMode(mode)
+                break
+            }
+            uid, gid, err := parseOwner(value)
+            if err != nil {
+                return nil, fmt.Errorf("invalid value %q for %s: %v", value, name, err)
+            }
+            ownerUID, ownerGID = uid, gid
+        case "--backups":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 0 {
+                return nil, fmt.Errorf("invalid value %q for %s", value, name)
+            }
+            backups = n
+        case "--retries", "--timeout":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            if name == "--retries" {
+                n, err := strconv.Atoi(value)
+                if err != nil || n < 0 {
+                    return nil, fmt.Errorf("invalid value %q for %s", value, name)
+                }
+                retries = n
+                break
+            }
+            d, err := time.ParseDuration(value)
+            if err != nil || d <= 0 {
+                return nil, fmt.Errorf("invalid value %q for %s", value, name)
+            }
+            httpTimeout = d
+        case "--cidr-strategy":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            if !slices.Contains(cidrStrategies, value) {
+                return nil, fmt.Errorf("invalid value %q for %s (use %s)", value, name, strings.Join(cidrStrategies, ", "))
+            }
+            cidrStrategy = value
+        case "--max-memory":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            n, err := parseByteSize(value)
+            if err != nil || n <= 0 {
+                return nil, fmt.Errorf("invalid value %q for %s", value, name)
+            }
+            maxMemory = n
+            // The garbage collector works harder near the cap instead of letting the heap grow past it.
+            debug.SetMemoryLimit(n)
+        case "--granularity":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            n, err := strconv.Atoi(strings.TrimPrefix(value, "/"))
+            if err != nil || n < 1 || n > 32 {
+                return nil, fmt.Errorf("invalid value %q for %s (expected a prefix length such as /24)", value, name)
+            }
+            granularity = n
+        case "--ignore-smaller-than":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            n, err := strconv.Atoi(strings.TrimPrefix(value, "/"))
+            if err != nil || n < 1 || n > 32 {
+                return nil, fmt.Errorf("invalid value %q for %s (expected a prefix length such as /29)", value, name)
+            }
+            maxPrefix = n
+        case "--sources":
+            if !hasValue {
+                if i+1 >= len(args) {
+                    return nil, fmt.Errorf("option %s requires a value", name)
+                }
+                value = args[i+1]
+                i++
+            }
+            specs, err := parseSources(value)
+            if err != nil {
+                return nil, err
+            }
+            sources = specs
+        case "--reproducible", "--offline", "--force-update", "--low-memory", "--crlf", "--exit-code", "--checksums", "--json-errors", "--machine", "--stream", "--include-placeholder", "--aggregate", "-aggregate", "--invert", "-invert":
+            // A bare flag enables the option; "--name=false" is accepted for scripts.
+            enabled := true
+            if hasValue {
+                parsed, err := strconv.ParseBool(value)
+                if err != nil {
+                    return nil, fmt.Errorf("invalid value %q for %s", value, name)
+                }
+                enabled = parsed
+            }
+            switch name {
+            case "--reproducible":
+                reproducible = enabled
+            case "--offline":
+                offline = enabled
+            case "--force-update":
+                forceUpdate = enabled
+            case "--low-memory":
+                lowMemory = enabled
+            case "--crlf":
+                crlf = enabled
+            case "--exit-code":
+                exitCode = enabled
+            case "--checksums":
+                checksums = enabled
+            case "--json-errors":
+                jsonStatus = enabled
+            case "--machine":
+                // Per-CIDR diagnostics would drown the messages a wrapper is interested in.
+                jsonStatus, quiet = enabled, enabled
+            case "--stream":
+                streamResults = enabled
+            case "--include-placeholder":
+                includePlaceholder = enabled
+            case "--aggregate", "-aggregate":
+                aggregate = enabled
+            case "--invert", "-invert":
+                invert = enabled
+            }
+        default:
+            rest = append(rest, arg)
+        }
+    }
+    if offline && forceUpdate {
+        return nil, fmt.Errorf("--offline and --force-update cannot be used together")
+    }
+    if customDB && forceUpdate {
+        return nil, fmt.Errorf("--db and --force-update cannot be used together")
+    }
+    if len(sources) > 0 && lowMemory {
+        return nil, fmt.Errorf("--sources and --low-memory cannot be used together")
     }
-    for code, name := range countries {
-        countryList = append(countryList, struct {
-            Code string
-            Name string
-        }{Code: code, Name: name})
+    return rest, nil
+}
+
+// detectLanguage picks the message language from the usual locale environment variables.
+func detectLanguage() string {
+    for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+        if value := os.Getenv(env); value != "" {
+            if strings.HasPrefix(strings.ToLower(value), "ru") {
+                return "ru"
+            }
+            return "en"
+        }
     }
+    return "en"
+}
 
-    // Sort by Name (alphabetically)
-    sort.Slice(countryList, func(i, j int) bool {
-        return countryList[i].Name < countryList[j].Name
-    })
+// tr returns the translation of an English message (or format string) for the current language.
+// Messages without a translation are returned unchanged.
+func tr(message string) string {
+    if lang == "ru" {
+        if translated, ok := messagesRU[message]; ok {
+            return translated
+        }
+    }
+    return message
+}
 
-    fmt.Println("Available country codes and names (sorted by name):")
-    for _, c := range countryList {
-        fmt.Printf("%s - %s\n", c.Code, c.Name)
+// localizedCountryName returns the country name in the current language, falling back to English.
+func localizedCountryName(countryCode string) string {
+    countryCode = strings.ToUpper(countryCode)
+    if lang == "ru" {
+        if name, ok := countryNamesRU[countryCode]; ok {
+            return name
+        }
+        if name, ok := placeholderCountries[countryCode]; ok {
+            return tr(name)
+        }
     }
+    return countryName(countryCode)
+}
+
+// countryNamesRU maps ISO 3166-1 alpha-2 country codes to their Russian names.
+var countryNamesRU = map[string]string{
+    "AD": "Андорра", "AE": "ОАЭ", "AF": "Афганистан", "AG": "Антигуа и Барбуда", "AI": "Ангилья",
+    "AL": "Албания", "AM": "Армения", "AO": "Ангола", "AQ": "Антарктида", "AR": "Аргентина",
+    "AS": "Американское Самоа", "AT": "Австрия", "AU": "Австралия", "AW": "Аруба",
+    "AX": "Аландские острова", "AZ": "Азербайджан", "BA": "Босния и Герцеговина", "BB": "Барбадос",
+    "BD": "Бангладеш", "BE": "Бельгия", "BF": "Буркина-Фасо", "BG": "Болгария", "BH": "Бахрейн",
+    "BI": "Бурунди", "BJ": "Бенин", "BL": "Сен-Бартелеми", "BM": "Бермудские острова",
+    "BN": "Бруней", "BO": "Боливия", "BQ": "Карибские Нидерланды", "BR": "Бразилия",
+    "BS": "Багамские острова", "BT": "Бутан", "BV": "Остров Буве", "BW": "Ботсвана",
+    "BY": "Беларусь", "BZ": "Белиз", "CA": "Канада", "CC": "Кокосовые острова", "CD": "ДР Конго",
+    "CF": "ЦАР", "CG": "Конго", "CH": "Швейцария", "CI": "Кот-д'Ивуар", "CK": "Острова Кука",
+    "CL": "Чили", "CM": "Камерун", "CN": "Китай", "CO": "Колумбия", "CR": "Коста-Рика",
+    "CU": "Куба", "CV": "Кабо-Верде", "CW": "Кюрасао", "CX": "Остров Рождества", "CY": "Кипр",
+    "CZ": "Чехия", "DE": "Германия", "DJ": "Джибути", "DK": "Дания", "DM": "Доминика",
+    "DO": "Доминиканская Республика", "DZ": "Алжир", "EC": "Эквадор", "EE": "Эстония",
+    "EG": "Египет", "EH": "Западная Сахара", "ER": "Эритрея", "ES": "Испания", "ET": "Эфиопия",
+    "FI": "Финляндия", "FJ": "Фиджи", "FK": "Фолклендские острова", "FM": "Микронезия",
+    "FO": "Фарерские острова", "FR": "Франция", "GA": "Габон", "GB": "Великобритания",
+    "GD": "Гренада", "GE": "Грузия", "GF": "Французская Гвиана", "GG": "Гернси", "GH": "Гана",
+    "GI": "Гибралтар", "GL": "Гренландия", "GM": "Гамбия", "GN": "Гвинея", "GP": "Гваделупа",
+    "GQ": "Экваториальная Гвинея", "GR": "Греция", "GS": "Южная Георгия и Южные Сандвичевы острова",
+    "GT": "Гватемала", "GU": "Гуам", "GW": "Гвинея-Бисау", "GY": "Гайана", "HK": "Гонконг",
+    "HM": "Остров Херд и острова Макдональд", "HN": "Гондурас", "HR": "Хорватия", "HT": "Гаити",
+    "HU": "Венгрия", "ID": "Индонезия", "IE": "Ирландия", "IL": "Израиль", "IM": "Остров Мэн",
+    "IN": "Индия", "IO": "Британская территория в Индийском океане", "IQ": "Ирак", "IR": "Иран",
+    "IS": "Исландия", "IT": "Италия", "JE": "Джерси", "JM": "Ямайка", "JO": "Иордания",
+    "JP": "Япония", "KE": "Кения", "KG": "Киргизия", "KH": "Камбоджа", "KI": "Кирибати",
+    "KM": "Коморы", "KN": "Сент-Китс и Невис", "KP": "КНДР", "KR": "Южная Корея", "KW": "Кувейт",
+    "KY": "Каймановы острова", "KZ": "Казахстан", "LA": "Лаос", "LB": "Ливан", "LC": "Сент-Люсия",
+    "LI": "Лихтенштейн", "LK": "Шри-Ланка", "LR": "Либерия", "LS": "Лесото", "LT": "Литва",
+    "LU": "Люксембург", "LV": "Латвия", "LY": "Ливия", "MA": "Марокко", "MC": "Монако",
+    "MD": "Молдова", "ME": "Черногория", "MF": "Сен-Мартен", "MG": "Мадагаскар",
+    "MH": "Маршалловы Острова", "MK": "Северная Македония", "ML": "Мали", "MM": "Мьянма",
+    "MN": "Монголия", "MO": "Макао", "MP": "Северные Марианские острова", "MQ": "Мартиника",
+    "MR": "Мавритания", "MS": "Монтсеррат", "MT": "Мальта", "MU": "Маврикий", "MV": "Мальдивы",
+    "MW": "Малави", "MX": "Мексика", "MY": "Малайзия", "MZ": "Мозамбик", "NA": "Намибия",
+    "NC": "Новая Каледония", "NE": "Нигер", "NF": "Остров Норфолк", "NG": "Нигерия",
+    "NI": "Никарагуа", "NL": "Нидерланды", "NO": "Норвегия", "NP": "Непал", "NR": "Науру",
+    "NU": "Ниуэ", "NZ": "Новая Зеландия", "OM": "Оман", "PA": "Панама", "PE": "Перу",
+    "PF": "Французская Полинезия", "PG": "Папуа — Новая Гвинея", "PH": "Филиппины",
+    "PK": "Пакистан", "PL": "Польша", "PM": "Сен-Пьер и Микелон", "PN": "Острова Питкэрн",
+    "PR": "Пуэрто-Рико", "PS": "Палестина", "PT": "Португалия", "PW": "Палау", "PY": "Парагвай",
+    "QA": "Катар", "RE": "Реюньон", "RO": "Румыния", "RS": "Сербия", "RU": "Россия", "RW": "Руанда",
+    "SA": "Саудовская Аравия", "SB": "Соломоновы Острова", "SC": "Сейшельские острова",
+    "SD": "Судан", "SE": "Швеция", "SG": "Сингапур", "SH": "Остров Святой Елены", "SI": "Словения",
+    "SJ": "Шпицберген и Ян-Майен", "SK": "Словакия", "SL": "Сьерра-Леоне", "SM": "Сан-Марино",
+    "SN": "Сенегал", "SO": "Сомали", "SR": "Суринам", "SS": "Южный Судан",
+    "ST": "Сан-Томе и Принсипи", "SV": "Сальвадор", "SX": "Синт-Мартен", "SY": "Сирия",
+    "SZ": "Эсватини", "TC": "Теркс и Кайкос", "TD": "Чад", "TF": "Французские Южные территории",
+    "TG": "Того", "TH": "Таиланд", "TJ": "Таджикистан", "TK": "Токелау", "TL": "Восточный Тимор",
+    "TM": "Туркмения", "TN": "Тунис", "TO": "Тонга", "TR": "Турция", "TT": "Тринидад и Тобаго",
+    "TV": "Тувалу", "TW": "Тайвань", "TZ": "Танзания", "UA": "Украина", "UG": "Уганда",
+    "UM": "Внешние малые острова США", "US": "США", "UY": "Уругвай", "UZ": "Узбекистан",
+    "VA": "Ватикан", "VC": "Сент-Винсент и Гренадины", "VE": "Венесуэла",
+    "VG": "Британские Виргинские острова", "VI": "Виргинские острова США", "VN": "Вьетнам",
+    "VU": "Вануату", "WF": "Уоллис и Футуна", "WS": "Самоа", "YE": "Йемен", "YT": "Майотта",
+    "ZA": "ЮАР", "ZM": "Замбия", "ZW": "Зимбабве",
 }
 
+// messagesRU holds the Russian translations of user-facing messages, keyed by the English text.
+var messagesRU = map[string]string{
+    "No IP ranges found for country code: %s\n":
+        "Не найдено IP-диапазонов для кода страны: %s\n",
+    "Error opening the RIPE database:":
+        "Ошибка открытия базы RIPE:",
+    "RIPE database cache not found. Attempting to update...":
+        "Локальная копия базы RIPE не найдена. Пробуем загрузить...",
+    "Error getting home directory:":
+        "Ошибка определения домашнего каталога:",
+    "Error downloading RIPE database: %v\n":
+        "Ошибка загрузки базы RIPE: %v\n",
+    "Starting download of the RIPE database from %s\n":
+        "Начинаем загрузку базы RIPE с %s\n",
+    "Saving to temporary file: %s\n":
+        "Сохраняем во временный файл: %s\n",
+    "Total file size: %d bytes\n":
+        "Размер файла: %d байт\n",
+    "Warning: unable to determine file size for progress display.":
+        "Предупреждение: не удалось определить размер файла для отображения прогресса.",
+    "Warning: unable to fetch the RIPE database serial: %v\n":
+        "Предупреждение: не удалось получить серийный номер базы RIPE: %v\n",
+    "Warning: unable to save database metadata: %v\n":
+        "Предупреждение: не удалось сохранить метаданные базы: %v\n",
+    "Error creating output directory: %v\n":
+        "Ошибка создания выходного каталога: %v\n",
+    "Extracting all countries from %s\n":
+        "Извлечение всех стран из %s\n",
+    "Error generating file for %s: %v\n":
+        "Ошибка генерации файла для %s: %v\n",
+    "%d of %d country files written to %s\n":
+        "Записано файлов стран: %d из %d в %s\n",
+    "Error writing run report: %v\n":
+        "Ошибка записи отчёта о запуске: %v\n",
+    "Run report written to %s\n":
+        "Отчёт о запуске записан в %s\n",
+    "RIPE database cache not found at %s and --offline is set; run chicha-whois -u first.\n":
+        "Локальная копия базы RIPE не найдена (%s), а указан --offline; сначала выполните chicha-whois -u.\n",
+    "-u downloads the database and cannot be used with --offline.":
+        "-u загружает базу и не может использоваться с --offline.",
+    "Total file size: %d bytes, downloading in %d parallel segments\n":
+        "Размер файла: %d байт, загрузка в %d параллельных сегментов\n",
+    "Error opening the database given with --db: %v\n":
+        "Ошибка открытия базы, указанной в --db: %v\n",
+    "-u updates the downloaded cache and cannot be used with --db.":
+        "-u обновляет загруженный кеш и не может использоваться с --db.",
+    "Failed to update: %s\n":
+        "Не удалось обновить: %s\n",
+    "Indexing the full RIPE database...":
+        "Индексация полной базы RIPE...",
+    "Warning: unable to save the object index: %v\n":
+        "Предупреждение: не удалось сохранить индекс объектов: %v\n",
+    "Warning: unable to index the full RIPE database: %v\n":
+        "Предупреждение: не удалось проиндексировать полную базу RIPE: %v\n",
+    "No %s object found for %s\n":
+        "Объект %s не найден: %s\n",
+    "\n%% %s %s: not found in the cache\n":
+        "\n%% %s %s: не найден в кеше\n",
+    "\n%% Route objects within %s\n":
+        "\n%% Объекты route внутри %s\n",
+    "\n%% Inetnum objects containing %s\n":
+        "\n%% Объекты inetnum, содержащие %s\n",
+    "No reverse delegations found for %s\n":
+        "Обратные делегирования для %s не найдены\n",
+    "Error writing reverse DNS zone file: %v\n":
+        "Ошибка записи файла зон обратного DNS: %v\n",
+    "Reverse DNS zone file created at: %s (%d zones)\n":
+        "Файл зон обратного DNS создан: %s (зон: %d)\n",
+    "Skipping name server %s of %s: %v\n":
+        "Пропуск сервера имён %s зоны %s: %v\n",
+    "Skipping %s: no name server address\n":
+        "Пропуск %s: нет адреса сервера имён\n",
+    "Invalid IPv4 address or prefix: %s\n":
+        "Некорректный IPv4-адрес или префикс: %s\n",
+    "No abuse contact found for %s (run chicha-whois -u role,organisation or -u full)\n":
+        "Контакт abuse для %s не найден (выполните chicha-whois -u role,organisation или -u full)\n",
+    "Error writing CSV file: %v\n":
+        "Ошибка записи CSV-файла: %v\n",
+    "CSV file created at: %s (%d rows)\n":
+        "CSV-файл создан: %s (строк: %d)\n",
+    "No person or role objects match %q\n":
+        "Нет объектов person или role, соответствующих %q\n",
+    "%% Inetnum objects of %s referring to the matching contacts\n":
+        "%% Объекты inetnum страны %s, ссылающиеся на найденные контакты\n",
+    "aut-num %s not found in the cache; blocks of its organisation are not audited\n":
+        "aut-num %s не найден в кеше; блоки его организации не проверяются\n",
+    "aut-num %s has no org attribute; blocks of its organisation are not audited\n":
+        "У aut-num %s нет атрибута org; блоки его организации не проверяются\n",
+    "%% Route audit for %s: %d inetnum blocks, %d route objects\n":
+        "%% Аудит route для %s: блоков inetnum: %d, объектов route: %d\n",
+    "\n%% Inetnum blocks without route objects (registered, not announceable): %d\n":
+        "\n%% Блоки inetnum без объектов route (зарегистрированы, но не могут анонсироваться): %d\n",
+    "\n%% Inetnum blocks only partly covered by route objects: %d\n":
+        "\n%% Блоки inetnum, лишь частично покрытые объектами route: %d\n",
+    "\n%% Route objects outside registered address space (announced without registration): %d\n":
+        "\n%% Объекты route вне зарегистрированного адресного пространства (анонс без регистрации): %d\n",
+    "Route objects could not be read, outputs are not annotated with origin ASNs: %v\n":
+        "Не удалось прочитать объекты route, origin ASN в вывод не добавлены: %v\n",
+    "Could not fetch geofeed %s (%v), using the cached copy\n":
+        "Не удалось загрузить geofeed %s (%v), используется копия из кеша\n",
+    "Skipping geofeed %s: %v\n":
+        "Пропуск geofeed %s: %v\n",
+    "Geofeed %s: ignored %d entries outside the inetnum that references it\n":
+        "Geofeed %s: пропущено записей вне ссылающегося на него inetnum: %d\n",
+    "Invalid -prefer value %q: use geofeed or ripe\n":
+        "Недопустимое значение -prefer %q: используйте geofeed или ripe\n",
+    "Error writing geofeed file: %v\n":
+        "Ошибка записи файла geofeed: %v\n",
+    "Geofeed file created at: %s (%d prefixes from %d feed entries, %d disagreements with RIPE)\n":
+        "Файл geofeed создан: %s (префиксов: %d, записей в фидах: %d, расхождений с RIPE: %d)\n",
+    "Sources disagree on %d ranges; the higher precedence source was used (see -merge-report)\n":
+        "Источники расходятся на %d диапазонах; использован источник с более высоким приоритетом (см. -merge-report)\n",
+    "%d conflicting ranges\n":
+        "Конфликтующих диапазонов: %d\n",
+    "Error writing merge report: %v\n":
+        "Ошибка записи отчёта о слиянии: %v\n",
+    "Merge report created at: %s (%d conflicting ranges)\n":
+        "Отчёт о слиянии создан: %s (конфликтующих диапазонов: %d)\n",
+    "-merge-report needs --sources\n":
+        "-merge-report требует --sources\n",
+    "No trend recorded yet at %s; run -serve with -track to record one.\n":
+        "История ещё не записана (%s); запустите -serve с -track, чтобы её вести.\n",
+    "Error writing trend: %v\n":
+        "Ошибка записи истории: %v\n",
+    "Trend written to: %s (%d samples)\n":
+        "История записана в: %s (записей: %d)\n",
+    "Error writing comparison report: %v\n":
+        "Ошибка записи отчёта сравнения: %v\n",
+    "Comparison report created at: %s\n":
+        "Отчёт сравнения создан: %s\n",
+    "%d disagreeing prefixes covering %d addresses\n":
+        "Расходящихся префиксов: %d, адресов в них: %d\n",
+    "Error loading source %s: %v\n":
+        "Ошибка загрузки источника %s: %v\n",
+    "-compare needs one non-RIPE source, e.g. geolite2:PATH\n":
+        "-compare требует один источник, отличный от RIPE, например geolite2:PATH\n",
+    "Using the cached result of this search (the database has not changed).":
+        "Используется сохранённый результат этого поиска (база не изменилась).",
+    "%s writes IPv4 prefixes only; -6 and -46 apply to %s\n":
+        "%s записывает только IPv4-префиксы; -6 и -46 применяются к %s\n",
+    "pf table name %s is longer than %d characters\n":
+        "Имя таблицы pf %s длиннее %d символов\n",
+    "Invalid sort key %q (use %s)\n":
+        "Недопустимый ключ сортировки %q (используйте %s)\n",
+    "%s: %d prefixes, %d IPv4 addresses\n":
+        "%s: префиксов: %d, IPv4-адресов: %d\n",
+    "%s: %d prefixes (%d IPv4 addresses) extracted, %d prefixes (%d IPv4 addresses) written\n":
+        "%s: извлечено префиксов: %d (IPv4-адресов: %d), записано префиксов: %d (IPv4-адресов: %d)\n",
+    "%s: %d prefixes, %d IPv6 /64 networks\n":
+        "%s: префиксов: %d, IPv6-сетей /64: %d\n",
+    "%s: %d prefixes (%d IPv6 /64 networks) extracted, %d prefixes (%d IPv6 /64 networks) written\n":
+        "%s: извлечено префиксов: %d (IPv6-сетей /64: %d), записано префиксов: %d (IPv6-сетей /64: %d)\n",
+    "  IPv6 /64 networks: %d extracted, %d written\n":
+        "  IPv6-сетей /64: извлечено %d, записано %d\n",
+    "  %d prefixes smaller than /%d ignored\n":
+        "  пропущено префиксов меньше /%[2]d: %[1]d\n",
+    "  %d addresses added by rounding to /%d\n":
+        "  адресов добавлено округлением до /%[2]d: %[1]d\n",
+    "Previous version saved as:":
+        "Предыдущая версия сохранена как:",
+    "Unchanged, not rewritten:":
+        "Без изменений, не перезаписан:",
+    "Invalid chunk size: %s\n":
+        "Неверный размер части: %s\n",
+    "%s: %d prefixes written to %d include files of at most %d\n":
+        "%s: %d префиксов записано в %d подключаемых файлов (не более %d в каждом)\n",
+    "Creating pf anchor for: %s\n":
+        "Создание якоря pf для: %s\n",
+    "pf anchor file created at: %s (%d prefixes)\n":
+        "Файл якоря pf создан: %s (префиксов: %d)\n",
+    "pf anchor %s reloaded.\n":
+        "Якорь pf %s перезагружен.\n",
+    "Creating ipfw table script for: %s\n":
+        "Создание скрипта таблицы ipfw для: %s\n",
+    "ipfw table script created at: %s (%d prefixes)\n":
+        "Скрипт таблицы ipfw создан: %s (префиксов: %d)\n",
+    "Invalid table number: %s\n":
+        "Неверный номер таблицы: %s\n",
+    "The swap table must differ from the table.":
+        "Таблица для подмены должна отличаться от основной.",
+    "%q is not defined in the \"%s\" section of the configuration file.\n":
+        "%q не описан в разделе \"%s\" файла конфигурации.\n",
+    "Connecting to %s...\n":
+        "Подключение к %s...\n",
+    "Address-list %s: %d entries on the router, %d to remove, %d to add\n":
+        "Address-list %s: записей на маршрутизаторе %d, к удалению %d, к добавлению %d\n",
+    "Address-list %s synchronized (%d changes, %d rejected).\n":
+        "Address-list %s синхронизирован (изменений: %d, отклонено: %d).\n",
+    "RouterOS rejected a change: %v\n":
+        "RouterOS отклонил изменение: %v\n",
+    "Creating RouterOS %d script for: %s\n":
+        "Создание скрипта RouterOS %d для: %s\n",
+    "RouterOS script created at: %s (%d entries)\n":
+        "Скрипт RouterOS создан: %s (записей: %d)\n",
+    "RouterOS script created at: %s (%d entries in %d parts)\n":
+        "Скрипт RouterOS создан: %s (записей: %d, частей: %d)\n",
+    "Unsupported RouterOS version: %s (expected 6 or 7)\n":
+        "Неподдерживаемая версия RouterOS: %s (ожидается 6 или 7)\n",
+    "Error writing pf table file: %v\n":
+        "Ошибка записи файла таблицы pf: %v\n",
+    "pf table file created at: %s (%d prefixes)\n":
+        "Файл таблицы pf создан: %s (префиксов: %d)\n",
+    "pf anchor file created at: %s (table loaded from %s)\n":
+        "Файл якоря pf создан: %s (таблица загружается из %s)\n",
+    "Creating nginx configuration for: %s\n":
+        "Создание конфигурации nginx для: %s\n",
+    "Invalid mode: %s (expected allow, deny or geo)\n":
+        "Неверный режим: %s (ожидается allow, deny или geo)\n",
+    "Error writing nginx configuration: %v\n":
+        "Ошибка записи конфигурации nginx: %v\n",
+    "nginx configuration created at: %s (%d prefixes)\n":
+        "Конфигурация nginx создана: %s (префиксов: %d)\n",
+    "Creating Squid acl for: %s\n":
+        "Создание acl Squid для: %s\n",
+    "Error writing Squid list file: %v\n":
+        "Ошибка записи файла списка Squid: %v\n",
+    "Squid list file created at: %s (%d prefixes)\n":
+        "Файл списка Squid создан: %s (префиксов: %d)\n",
+    "Error writing Squid acl: %v\n":
+        "Ошибка записи acl Squid: %v\n",
+    "Squid acl created at: %s (%d prefixes)\n":
+        "acl Squid создан: %s (префиксов: %d)\n",
+    "Invalid action: %q (expected one line, e.g. \"REJECT country-blocked\")\n":
+        "Неверное действие: %q (ожидается одна строка, например \"REJECT country-blocked\")\n",
+    "Creating Postfix cidr table for: %s\n":
+        "Создание таблицы cidr Postfix для: %s\n",
+    "Error writing Postfix table: %v\n":
+        "Ошибка записи таблицы Postfix: %v\n",
+    "Postfix cidr table created at: %s (%d prefixes)\n":
+        "Таблица cidr Postfix создана: %s (префиксов: %d)\n",
+    "The ACL name default is reserved for the default view with -views.":
+        "Имя ACL default зарезервировано для представления по умолчанию при -views.",
+    "No domain among the search keywords; the dnsmasq %s= directive is omitted.\n":
+        "Среди ключевых слов поиска нет доменов; директива dnsmasq %s= не выводится.\n",
+    "Unknown CSV field %q (use %s)\n":
+        "Неизвестное поле CSV %q (допустимы: %s)\n",
+    "-fields and -tsv cannot be combined with -profile; set the columns and delimiter in the profile.":
+        "-fields и -tsv нельзя сочетать с -profile; задайте столбцы и разделитель в профиле.",
+    "No route objects cached; the origin_asn column stays empty (run chicha-whois -u route).\n":
+        "Объекты route не закешированы; столбец origin_asn останется пустым (выполните chicha-whois -u route).\n",
+    "Invalid Ansible variable name: %s\n":
+        "Неверное имя переменной Ansible: %s\n",
+    "Creating YAML file for: %s\n":
+        "Создание файла YAML для: %s\n",
+    "YAML file created at: %s (%d prefixes)\n":
+        "Файл YAML создан: %s (префиксов: %d)\n",
+    "Error writing YAML file: %v\n":
+        "Ошибка записи файла YAML: %v\n",
+    "Creating Ansible vars file for: %s\n":
+        "Создание файла переменных Ansible для: %s\n",
+    "Ansible vars file created at: %s (%d prefixes)\n":
+        "Файл переменных Ansible создан: %s (префиксов: %d)\n",
+    "Error writing Ansible vars file: %v\n":
+        "Ошибка записи файла переменных Ansible: %v\n",
+    "Creating %s configuration for: %s\n":
+        "Создание конфигурации %s для: %s\n",
+    "Error writing %s configuration: %v\n":
+        "Ошибка записи конфигурации %s: %v\n",
+    "%s configuration created at: %s (%d prefixes)\n":
+        "Конфигурация %s создана: %s (префиксов: %d)\n",
+    "Creating JunOS prefix-lists for: %s\n":
+        "Создание списков префиксов JunOS для: %s\n",
+    "Error writing JunOS configuration: %v\n":
+        "Ошибка записи конфигурации JunOS: %v\n",
+    "JunOS configuration created at: %s (%d prefix-lists, %d prefixes)\n":
+        "Конфигурация JunOS создана: %s (списков префиксов: %d, префиксов: %d)\n",
+    "Invalid action: %s (expected discard or accept)\n":
+        "Недопустимое действие: %s (ожидается discard или accept)\n",
+    "Creating EdgeOS firewall groups for: %s\n":
+        "Создание групп межсетевого экрана EdgeOS для: %s\n",
+    "EdgeOS configuration fragment created at: %s (%d groups, %d prefixes)\n":
+        "Фрагмент конфигурации EdgeOS создан: %s (групп: %d, префиксов: %d)\n",
+    "UniFi group %s exists but is not an IPv4 address group.\n":
+        "Группа UniFi %s существует, но не является группой IPv4-адресов.\n",
+    "UniFi group %s: %d members, %d to remove, %d to add\n":
+        "Группа UniFi %s: элементов %d, к удалению %d, к добавлению %d\n",
+    "UniFi group %s updated (%d members).\n":
+        "Группа UniFi %s обновлена (элементов: %d).\n",
+    "Invalid alias name: %q (letters, digits and _, at most 32 characters)\n":
+        "Неверное имя псевдонима: %q (буквы, цифры и _, не более 32 символов)\n",
+    "OPNsense alias %s saved and applied (%d prefixes).\n":
+        "Псевдоним OPNsense %s сохранён и применён (префиксов: %d).\n",
+    "Choose an entry of the \"%s\" section of the configuration file: %s\n":
+        "Выберите запись раздела \"%s\" файла конфигурации: %s\n",
+    "The configuration file has no \"%s\" section.\n":
+        "В файле конфигурации нет раздела \"%s\".\n",
+    "pfSense alias %s exists with type %s; only network aliases are updated.\n":
+        "Псевдоним pfSense %s уже существует с типом %s; обновляются только псевдонимы типа network.\n",
+    "pfSense alias %s is up to date (%d prefixes).\n":
+        "Псевдоним pfSense %s актуален (префиксов: %d).\n",
+    "pfSense alias %s saved and the filter reloaded (%d prefixes).\n":
+        "Псевдоним pfSense %s сохранён, фильтр перезагружен (префиксов: %d).\n",
+    "NetBox RIR %q not found (set \"rir\" in the configuration file): %v\n":
+        "RIR %q не найден в NetBox (задайте \"rir\" в файле конфигурации): %v\n",
+    "NetBox %s tagged %s: %d present, %d to delete, %d to create\n":
+        "NetBox, %s с тегом %s: есть %d, к удалению %d, к созданию %d\n",
+    "NetBox synchronized for %s.\n":
+        "NetBox синхронизирован для %s.\n",
+    "phpIPAM import file created at: %s (%d subnets)\n":
+        "Файл импорта phpIPAM создан: %s (подсетей: %d)\n",
+    "The CSV profile defines no columns.":
+        "В профиле CSV не задано ни одного столбца.",
+    "Unknown placeholder {%s} in the CSV profile (known: %s)\n":
+        "Неизвестная подстановка {%s} в профиле CSV (допустимые: %s)\n",
+    "The CSV delimiter must be a single character: %q\n":
+        "Разделитель CSV должен быть одним символом: %q\n",
+    "Warning: unable to write the status file: %v\n":
+        "Предупреждение: не удалось записать файл состояния: %v\n",
+    "database: %v\n":
+        "база: %v\n",
+    "database: %s (%s)\n":
+        "база: %s (%s)\n",
+    "age: %s, older than %s\n":
+        "возраст: %s, больше допустимого %s\n",
+    "age: %s (maximum %s)\n":
+        "возраст: %s (максимум %s)\n",
+    "parse: %v\n":
+        "разбор: %v\n",
+    "parse: %d inetnum blocks, expected at least %d\n":
+        "разбор: блоков inetnum %d, ожидалось не меньше %d\n",
+    "parse: at least %d inetnum blocks\n":
+        "разбор: не меньше %d блоков inetnum\n",
+    "writable: %s: %v\n":
+        "запись: %s: %v\n",
+    "writable: %s\n":
+        "запись: %s\n",
+    "Invalid -max-age: %s\n":
+        "Неверное значение -max-age: %s\n",
+    "Invalid -min-blocks: %s\n":
+        "Неверное значение -min-blocks: %s\n",
+    "Unknown continent: %s (known: %s)\n":
+        "Неизвестный континент: %s (известные: %s)\n",
+    "Using continent %s (%s, %d countries)\n":
+        "Используется континент %s (%s, стран: %d)\n",
+    "A country selection must start with a country or continent: %s\n":
+        "Выборка стран должна начинаться со страны или континента: %s\n",
+    "Expected a country such as DE or an exclusion such as -DE instead of %q in %s\n":
+        "Ожидалась страна вида DE или исключение вида -DE вместо %q в %s\n",
+    "No countries are left in %s\n":
+        "В выборке %s не осталось стран\n",
+    "Using %s (%d countries)\n":
+        "Используется %s (стран: %d)\n",
+    "Preset %s: \"command\" must be a command such as -dns-acl-f, not %q\n":
+        "Пресет %s: в \"command\" должна быть команда, например -dns-acl-f, а не %q\n",
+    "Preset %s: -search takes one country selection\n":
+        "Пресет %s: -search принимает только одну выборку стран\n",
+    "Preset %s: %s\n":
+        "Пресет %s: %s\n",
+    "Running the hook of preset %s: %s\n":
+        "Запуск хука пресета %s: %s\n",
+    "Warning: the hook of preset %s failed: %v\n":
+        "Предупреждение: хук пресета %s завершился с ошибкой: %v\n",
+    "%d prefix ranges match %s\n":
+        "Под фильтр %[2]s подходит диапазонов префиксов: %[1]d\n",
+    "Error writing prefix list: %v\n":
+        "Ошибка записи списка префиксов: %v\n",
+    "Prefix list created at: %s (%d prefix ranges)\n":
+        "Список префиксов создан: %s (диапазонов префиксов: %d)\n",
+    "as-set %s not found in the cache; it matches nothing\n":
+        "as-set %s не найден в кеше; под него ничего не подходит\n",
+    "Invalid ASN: %s (expected e.g. AS12389)\n":
+        "Неверный ASN: %s (ожидается, например, AS12389)\n",
+    "Fetching the announced prefixes of %d ASNs registered to %s from RIPEstat...\n":
+        "Загрузка из RIPEstat анонсируемых префиксов %d ASN, зарегистрированных в %s...\n",
+    "No announced prefixes found for %s\n":
+        "Анонсируемые префиксы для %s не найдены\n",
+    "Announced prefix list created at: %s (%d prefixes)\n":
+        "Список анонсируемых префиксов создан: %s (префиксов: %d)\n",
+    "Could not query RIPEstat %s for %s (%v), using the cached answer\n":
+        "Не удалось запросить RIPEstat %s для %s (%v), используется сохранённый ответ\n",
+    "%s: %d addresses registered but not announced, %d announced by its ASNs but registered elsewhere or not at all\n":
+        "%s: адресов зарегистрировано, но не анонсируется: %d; анонсируется её ASN, но зарегистрировано в другой стране или нигде: %d\n",
+    "%d ASNs announce mostly space registered to %s\n":
+        "ASN, анонсирующих в основном адреса, зарегистрированные в %[2]s: %[1]d\n",
+    "Reading the MRT RIB dump %s...\n":
+        "Чтение дампа MRT RIB %s...\n",
+    "Origin table created at: %s (%d prefixes)\n":
+        "Таблица origin-ASN создана: %s (префиксов: %d)\n",
+    "Usage:":
+        "Использование:",
+    "Run chicha-whois -h for all commands and global options.":
+        "Все команды и глобальные опции: chicha-whois -h.",
+    "Unknown command %s.\n":
+        "Неизвестная команда %s.\n",
+    "Did you mean %s?\n":
+        "Возможно, имелось в виду %s?\n",
+    "Unknown option %s for %s.\n":
+        "Неизвестная опция %s для %s.\n",
+    "Unexpected extra argument %s for %s.\n":
+        "Лишний аргумент %s для %s.\n",
+    "Option %s of %s requires a value.\n":
+        "Опции %s команды %s нужно значение.\n",
+    "Missing COUNTRYCODE for %s.\n":
+        "Не указан COUNTRYCODE для %s.\n",
+    "Options -%s and %s of -search cannot be combined.\n":
+        "Опции -%s и %s команды -search нельзя использовать вместе.\n",
+    "Building the binary database %s\n":
+        "Построение двоичной базы %s\n",
+    "Warning: could not write the binary database %s: %v\n":
+        "Предупреждение: не удалось записать двоичную базу %s: %v\n",
+    "Building the search index %s\n":
+        "Построение поискового индекса %s\n",
+    "Warning: could not write the search index %s: %v\n":
+        "Предупреждение: не удалось записать поисковый индекс %s: %v\n",
+    "Warning: could not write the trigram index %s: %v\n":
+        "Предупреждение: не удалось записать триграммный индекс %s: %v\n",
+    "Building the trigram index %s\n":
+        "Построение триграммного индекса %s\n",
+    "Trigram index created at: %s (%d bytes, %s)\n":                 "Триграммный индекс создан: %s (%d байт, %s)\n",
+    "Trigram index removed: %s\n":                                   "Триграммный индекс удалён: %s\n",
+    "Error removing the trigram index: %v\n":
+        "Ошибка удаления триграммного индекса: %v\n",
+    "Error building the trigram index: %v\n":
+        "Ошибка построения триграммного индекса: %v\n",
+    "The database has changed, rebuilding the trigram index...":
+        "База изменилась, триграммный индекс перестраивается...",
+    "Invalid search keyword %v\n":
+        "Недопустимое ключевое слово поиска %v\n",
+    "%d blocks would exceed --max-memory %s in memory; using temporary files\n":
+        "%d блоков не поместятся в --max-memory %s; используются временные файлы\n",
+    "Error filtering the search results:":
+        "Ошибка фильтрации результатов поиска:",
+    "No geofeed URLs found in the cached objects.":
+        "В кешированных объектах не найдено ссылок на geofeed.",
+    "Error reading the cached objects:":
+        "Ошибка чтения кешированных объектов:",
+    "Warning: inet6num objects not scanned: %v\n":
+        "Предупреждение: объекты inet6num не просмотрены: %v\n",
+    "Error writing geofeed list: %v\n":
+        "Ошибка записи списка geofeed: %v\n",
+    "Geofeed list created at: %s\n":
+        "Список geofeed создан: %s\n",
+    "%d geofeed URLs found\n":
+        "Найдено ссылок на geofeed: %d\n",
+    "%d feeds could not be fetched, %d have invalid or out-of-scope entries\n":
+        "Не удалось скачать фидов: %d, с ошибочными записями или записями вне inetnum: %d\n",
+    "CIDR strategy cover: %d addresses outside the inetnum ranges were included\n":
+        "Стратегия CIDR cover: включено адресов за пределами диапазонов inetnum: %d\n",
+    "CIDR strategy trim: %d addresses of the inetnum ranges were left out\n":
+        "Стратегия CIDR trim: не включено адресов из диапазонов inetnum: %d\n",
+    "Warning: download failed (%v), retrying in %s (attempt %d of %d)\n":
+        "Предупреждение: загрузка не удалась (%v), повтор через %s (попытка %d из %d)\n",
+    "Skipped placeholder code %s (%s): %d blocks, %d addresses; add --include-placeholder to write them\n":
+        "Пропущен условный код %s (%s): блоков %d, адресов %d; добавьте --include-placeholder, чтобы записать их\n",
+    "%s is a RIPE placeholder code (%s), not a country; add --include-placeholder to select its blocks\n":
+        "%s — условный код RIPE (%s), а не страна; добавьте --include-placeholder, чтобы выбрать его блоки\n",
+    "Placeholder codes (no single country, selected only with --include-placeholder):":
+        "Условные коды (не относятся к одной стране, выбираются только с --include-placeholder):",
+    "European Union":
+        "Европейский союз",
+    "Unknown or unassigned":
+        "Неизвестно или не распределено",
+    "No IPv6 ranges found for country code: %s\n":
+        "IPv6-диапазоны для кода страны %s не найдены\n",
+    "Error reading inet6num objects:":
+        "Ошибка чтения объектов inet6num:",
+    "No inet6num objects in the cache; run chicha-whois -u inet6num first.":
+        "Объектов inet6num нет в кеше; сначала выполните chicha-whois -u inet6num.",
+    "RIPE inet6num cache not found. Attempting to update...":
+        "Кеш inet6num RIPE не найден. Пытаемся загрузить...",
+    "Using all countries (%d)\n":
+        "Используются все страны (%d)\n",
+    "%s: %d prefixes\n":
+        "%s: префиксов: %d\n",
+    "Error reading %s: %v\n":
+        "Ошибка чтения %s: %v\n",
+    "Temporary file removed:":
+        "Временный файл удалён:",
+    "Extracting %s to %s\n":
+        "Распаковка %s в %s\n",
+    "Error decompressing RIPE database:":
+        "Ошибка распаковки базы RIPE:",
+    "Error writing to temporary file:":
+        "Ошибка записи во временный файл:",
+    "Error creating temporary file:":
+        "Ошибка создания временного файла:",
+    "RIPE database updated successfully at %s\n":
+        "База RIPE успешно обновлена: %s\n",
+    "\nDecompression completed.":
+        "\nРаспаковка завершена.",
+    "Creating BIND ACL file for country code: %s\n":
+        "Создание ACL-файла BIND для кода страны: %s\n",
+    "Creating BIND ACL file (filtered) for country code: %s\n":
+        "Создание ACL-файла BIND (с фильтрацией) для кода страны: %s\n",
+    "BIND ACL file created at: %s\n":
+        "ACL-файл BIND создан: %s\n",
+    "Filtered BIND ACL file created at: %s\n":
+        "Фильтрованный ACL-файл BIND создан: %s\n",
+    "Error writing BIND ACL file: %v\n":
+        "Ошибка записи ACL-файла BIND: %v\n",
+    "Error writing filtered BIND ACL file: %v\n":
+        "Ошибка записи фильтрованного ACL-файла BIND: %v\n",
+    "Creating an unfiltered OpenVPN exclude-route file for country code: %s\n":
+        "Создание нефильтрованного файла маршрутов OpenVPN для кода страны: %s\n",
+    "Creating a filtered OpenVPN exclude-route file for country code: %s\n":
+        "Создание фильтрованного файла маршрутов OpenVPN для кода страны: %s\n",
+    "OpenVPN exclude-route file created at: %s\n":
+        "Файл маршрутов OpenVPN создан: %s\n",
+    "Filtered OpenVPN exclude-route file created at: %s\n":
+        "Фильтрованный файл маршрутов OpenVPN создан: %s\n",
+    "Error writing OpenVPN exclude file: %v\n":
+        "Ошибка записи файла маршрутов OpenVPN: %v\n",
+    "Error writing filtered OpenVPN exclude file: %v\n":
+        "Ошибка записи фильтрованного файла маршрутов OpenVPN: %v\n",
+    "Skipping CIDR (%s): %v\n":
+        "Пропускаем CIDR (%s): %v\n",
+    "Performing a RIPE database search:\n  Country code: '%s', Keywords: %v\n":
+        "Поиск по базе RIPE:\n  Код страны: '%s', ключевые слова: %v\n",
+    "Nothing found for the specified criteria.":
+        "По заданным критериям ничего не найдено.",
+    "Found CIDR ranges (after filtering):":
+        "Найденные CIDR-диапазоны (после фильтрации):",
+    "Filtered out redundant CIDR: %s (contained in %s)\n":
+        "Отфильтрован вложенный CIDR: %s (входит в %s)\n",
+    "Available country codes and names (sorted by name):":
+        "Доступные коды и названия стран (по алфавиту):",
+    "%s - %s (%d blocks, %d addresses)\n":
+        "%s - %s (блоков: %d, адресов: %d)\n",
+    "Scanning the RIPE database for country codes...":
+        "Сканируем базу RIPE на коды стран...",
+    "Error reading the RIPE database:":
+        "Ошибка чтения базы RIPE:",
+    "Unknown country code or name: %s (see -l)\n":
+        "Неизвестный код или название страны: %s (см. -l)\n",
+    "Using country code %s (%s) for '%s'\n":
+        "Используется код страны %s (%s) для '%s'\n",
+    "'%s' matches several countries:\n":
+        "'%s' подходит нескольким странам:\n",
+    "Please specify the country code explicitly.":
+        "Укажите код страны явно.",
+    "Select a number: ":
+        "Выберите номер: ",
+    "No valid selection made.":
+        "Выбор не сделан.",
+    "Building IP index from the RIPE database...":
+        "Строим IP-индекс по базе RIPE...",
+    "No parsable access log lines found on stdin.":
+        "В stdin не найдено ни одной распознаваемой строки access-лога.",
+    "Error reading access log:":
+        "Ошибка чтения access-лога:",
+    "Creating CrowdSec decisions (%s) for country code: %s\n":
+        "Создание решений CrowdSec (%s) для кода страны: %s\n",
+    "CrowdSec decisions file created at: %s (%d decisions)\n":
+        "Файл решений CrowdSec создан: %s (решений: %d)\n",
+    "Creating P2P blocklist for country code: %s\n":
+        "Создание P2P-блоклиста для кода страны: %s\n",
+    "P2P blocklist file created at: %s (%d ranges)\n":
+        "P2P-блоклист создан: %s (диапазонов: %d)\n",
+    "Creating FireHOL netset for country code: %s\n":
+        "Создание FireHOL netset для кода страны: %s\n",
+    "FireHOL netset file created at: %s (%d entries)\n":
+        "FireHOL netset создан: %s (записей: %d)\n",
+    "No prefixes left for %s; Redis key %s deleted\n":
+        "Для %s не осталось префиксов; ключ Redis %s удалён\n",
+    "Loading prefixes for country code %s into Redis %s (key %s)\n":
+        "Загрузка префиксов для кода страны %s в Redis %s (ключ %s)\n",
+    "Redis key %s now holds %d prefixes\n":
+        "Ключ Redis %s теперь содержит префиксов: %d\n",
+    "Error connecting to Redis: %v\n":
+        "Ошибка подключения к Redis: %v\n",
+    "Creating PostgreSQL dump for country code: %s (table %s)\n":
+        "Создание дампа PostgreSQL для кода страны: %s (таблица %s)\n",
+    "PostgreSQL dump created at: %s (%d prefixes)\n":
+        "Дамп PostgreSQL создан: %s (префиксов: %d)\n",
+    "Creating ClickHouse export for country code: %s (table %s)\n":
+        "Создание экспорта ClickHouse для кода страны: %s (таблица %s)\n",
+    "ClickHouse data file created at: %s (%d ranges)\n":
+        "Файл данных ClickHouse создан: %s (диапазонов: %d)\n",
+    "ClickHouse schema file created at: %s\n":
+        "Файл схемы ClickHouse создан: %s\n",
+    "-name can only be used with a single country; use CC=NAME for several ACLs.":
+        "-name можно использовать только с одной страной; для нескольких ACL используйте CC=ИМЯ.",
+    "Invalid ACL name: %q\n":
+        "Некорректное имя ACL: %q\n",
+    "ACL index file created at: %s (add include \"%s\"; to named.conf)\n":
+        "Индексный файл ACL создан: %s (добавьте include \"%s\"; в named.conf)\n",
+    "Invalid table name: %s\n":
+        "Некорректное имя таблицы: %s\n",
+}
@@ -0,0 +1,1356 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "io"
+    "maps"
+    "math"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/netip"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "slices"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// testdata/rib_ipv4_unicast.mrt is a TABLE_DUMP_V2 file with a PEER_INDEX_TABLE, three
+// RIB_IPV4_UNICAST records and one RIB_IPV6_UNICAST record:
+//
+//  193.0.0.0/21    paths 3333 and 1299 3333
+//  192.0.2.0/24    paths 174 64496 and 64497 (MOAS)
+//  198.51.100.0/24 path 174 {64500} (origin in a final AS_SET)
+//  2001:db8::/32   no entries; skipped
+func TestLoadMRT(t *testing.T) {
+    routes, err := loadMRT("testdata/rib_ipv4_unicast.mrt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []struct {
+        cidr    string
+        origins []string
+    }{
+        {"192.0.2.0/24", []string{"AS64496", "AS64497"}},
+        {"193.0.0.0/21", []string{"AS3333"}},
+        {"198.51.100.0/24", []string{"AS64500"}},
+    }
+    if len(routes) != len(want) {
+        t.Fatalf("got %d routes, want %d: %v", len(routes), len(want), routes)
+    }
+    for i, w := range want {
+        if got := routes[i].cidr(); got != w.cidr {
+            t.Errorf("route %d: got %s, want %s", i, got, w.cidr)
+        }
+        if !slices.Equal(routes[i].Origins, w.origins) {
+            t.Errorf("route %s: got origins %v, want %v", w.cidr, routes[i].Origins, w.origins)
+        }
+    }
+}
+
+func TestIntervalOverlap(t *testing.T) {
+    merged := []addrInterval{{10, 19}, {30, 39}, {50, 0xffffffff}}
+    tests := []struct {
+        iv   addrInterval
+        want uint64
+    }{
+        {addrInterval{0, 9}, 0},
+        {addrInterval{0, 10}, 1},
+        {addrInterval{15, 34}, 10},
+        {addrInterval{20, 29}, 0},
+        {addrInterval{0, 0xffffffff}, 20 + 0xffffffff - 50 + 1},
+        {addrInterval{0xffffffff, 0xffffffff}, 1},
+    }
+    for _, tt := range tests {
+        if got := intervalOverlap(merged, tt.iv); got != tt.want {
+            t.Errorf("intervalOverlap(%v) = %d, want %d", tt.iv, got, tt.want)
+        }
+    }
+}
+
+func TestCountAddresses(t *testing.T) {
+    tests := []struct {
+        cidrs     []string
+        addresses uint64
+        networks  uint64
+    }{
+        {[]string{"10.0.0.0/8", "10.1.0.0/16", "11.0.0.0/24"}, 1<<24 + 256, 0},
+        {[]string{"0.0.0.0/0"}, 1 << 32, 0},
+        {[]string{"2001:db8::/32", "2001:db8:1::/48", "2001:db9::/64", "2001:db9::1/128"}, 0, 1<<32 + 1},
+        {[]string{"2001:db8::/127", "2001:db8::2/127"}, 0, 1},
+        {[]string{"10.0.0.0/24", "2001:db8::/48"}, 256, 1 << 16},
+        {[]string{"::/1", "8000::/1"}, 0, math.MaxUint64},
+    }
+    for _, tt := range tests {
+        if got := countAddresses(tt.cidrs); got != tt.addresses {
+            t.Errorf("countAddresses(%v) = %d, want %d", tt.cidrs, got, tt.addresses)
+        }
+        if got := countNetworks6(tt.cidrs); got != tt.networks {
+            t.Errorf("countNetworks6(%v) = %d, want %d", tt.cidrs, got, tt.networks)
+        }
+    }
+}
+
+func TestSplitRangeToCIDRs(t *testing.T) {
+    tests := []struct {
+        start, end string
+        want       []string
+    }{
+        {"10.0.0.0", "10.0.0.255", []string{"10.0.0.0/24"}},
+        {"10.0.0.1", "10.0.0.6", []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}},
+        {"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+        {"255.255.255.255", "255.255.255.255", []string{"255.255.255.255/32"}},
+        {"255.255.255.254", "255.255.255.255", []string{"255.255.255.254/31"}},
+        {"0.0.0.0", "0.0.0.0", []string{"0.0.0.0/32"}},
+    }
+    for _, tt := range tests {
+        start := binary.BigEndian.Uint32(net.ParseIP(tt.start).To4())
+        end := binary.BigEndian.Uint32(net.ParseIP(tt.end).To4())
+        got := splitRangeToCIDRs(start, end)
+        if !slices.Equal(got, tt.want) {
+            t.Errorf("splitRangeToCIDRs(%s, %s) = %v, want %v", tt.start, tt.end, got, tt.want)
+        }
+    }
+}
+
+func TestCIDRSetSubtract(t *testing.T) {
+    tests := []struct {
+        set  []string
+        cidr string
+        want []string
+    }{
+        {[]string{"10.0.0.0/25"}, "10.0.0.0/24", []string{"10.0.0.128/25"}},
+        {[]string{"10.0.0.64/26"}, "10.0.0.0/24", []string{"10.0.0.0/26", "10.0.0.128/25"}},
+        {[]string{"10.0.0.1/32"}, "10.0.0.0/30", []string{"10.0.0.0/32", "10.0.0.2/31"}},
+        {[]string{"10.0.0.0/8"}, "10.1.0.0/16", nil},
+        {[]string{"192.168.0.0/16"}, "10.0.0.0/24", []string{"10.0.0.0/24"}},
+        {[]string{"0.0.0.0/1"}, "0.0.0.0/0", []string{"128.0.0.0/1"}},
+        {[]string{"255.255.255.255/32"}, "255.255.255.254/31", []string{"255.255.255.254/32"}},
+        {[]string{"0.0.0.0/32"}, "0.0.0.0/31", []string{"0.0.0.1/32"}},
+        {[]string{"2001:db8::/33"}, "2001:db8::/32", []string{"2001:db8:8000::/33"}},
+        {[]string{"2001:db8:4000::/34"}, "2001:db8::/32", []string{"2001:db8::/34", "2001:db8:8000::/33"}},
+        {[]string{"::/0"}, "2001:db8::/32", nil},
+        {[]string{"2001:db8::/32"}, "2001:db9::/32", []string{"2001:db9::/32"}},
+    }
+    for _, tt := range tests {
+        if got := newCIDRSet(tt.set).subtract(tt.cidr); !slices.Equal(got, tt.want) {
+            t.Errorf("%v.subtract(%s) = %v, want %v", tt.set, tt.cidr, got, tt.want)
+        }
+    }
+}
+
+func TestCIDRSetIntersect(t *testing.T) {
+    tests := []struct {
+        set  []string
+        cidr string
+        want []string
+    }{
+        {[]string{"10.0.0.0/25"}, "10.0.0.0/24", []string{"10.0.0.0/25"}},
+        {[]string{"10.0.0.0/8"}, "10.1.0.0/16", []string{"10.1.0.0/16"}},
+        {[]string{"10.0.0.1/32", "10.0.0.2/31"}, "10.0.0.0/30", []string{"10.0.0.1/32", "10.0.0.2/31"}},
+        {[]string{"192.168.0.0/16"}, "10.0.0.0/24", nil},
+        {[]string{"0.0.0.0/0"}, "255.255.255.255/32", []string{"255.255.255.255/32"}},
+        {[]string{"255.255.255.0/24"}, "0.0.0.0/0", []string{"255.255.255.0/24"}},
+        {[]string{"2001:db8::/48"}, "2001:db8::/32", []string{"2001:db8::/48"}},
+        {[]string{"2001:db8::/32"}, "2001:db8:1::/48", []string{"2001:db8:1::/48"}},
+        {[]string{"2001:db8::/32"}, "2001:db9::/32", nil},
+    }
+    for _, tt := range tests {
+        if got := newCIDRSet(tt.set).intersect(tt.cidr); !slices.Equal(got, tt.want) {
+            t.Errorf("%v.intersect(%s) = %v, want %v", tt.set, tt.cidr, got, tt.want)
+        }
+    }
+}
+
+func TestSubtractIntervals(t *testing.T) {
+    tests := []struct {
+        a, b, want []addrInterval
+    }{
+        {[]addrInterval{{0, 99}}, []addrInterval{{10, 19}, {50, 59}}, []addrInterval{{0, 9}, {20, 49}, {60, 99}}},
+        {[]addrInterval{{0, 9}, {20, 29}}, []addrInterval{{5, 24}}, []addrInterval{{0, 4}, {25, 29}}},
+        {[]addrInterval{{10, 19}}, []addrInterval{{0, 99}}, nil},
+        {[]addrInterval{{10, 19}}, nil, []addrInterval{{10, 19}}},
+        {[]addrInterval{{0, 0xffffffff}}, []addrInterval{{0, 0}, {0xffffffff, 0xffffffff}}, []addrInterval{{1, 0xfffffffe}}},
+        {[]addrInterval{{0, 0xffffffff}}, []addrInterval{{0, 0xfffffffe}}, []addrInterval{{0xffffffff, 0xffffffff}}},
+    }
+    for _, tt := range tests {
+        if got := subtractIntervals(tt.a, tt.b); !slices.Equal(got, tt.want) {
+            t.Errorf("subtractIntervals(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+        }
+    }
+}
+
+func TestInvertPrefixes(t *testing.T) {
+    tests := []struct {
+        cidrs []string
+        ipv6  bool
+        want  []string
+    }{
+        {nil, false, []string{"0.0.0.0/0"}},
+        {[]string{"0.0.0.0/0"}, false, nil},
+        {[]string{"0.0.0.0/1"}, false, []string{"128.0.0.0/1"}},
+        {[]string{"0.0.0.0/32", "255.255.255.255/32"}, false, []string{
+            "0.0.0.1/32", "0.0.0.2/31", "0.0.0.4/30", "0.0.0.8/29", "0.0.0.16/28", "0.0.0.32/27", "0.0.0.64/26",
+            "0.0.0.128/25", "0.0.1.0/24", "0.0.2.0/23", "0.0.4.0/22", "0.0.8.0/21", "0.0.16.0/20", "0.0.32.0/19",
+            "0.0.64.0/18", "0.0.128.0/17", "0.1.0.0/16", "0.2.0.0/15", "0.4.0.0/14", "0.8.0.0/13", "0.16.0.0/12",
+            "0.32.0.0/11", "0.64.0.0/10", "0.128.0.0/9", "1.0.0.0/8", "2.0.0.0/7", "4.0.0.0/6", "8.0.0.0/5",
+            "16.0.0.0/4", "32.0.0.0/3", "64.0.0.0/2", "128.0.0.0/2", "192.0.0.0/3", "224.0.0.0/4", "240.0.0.0/5",
+            "248.0.0.0/6", "252.0.0.0/7", "254.0.0.0/8", "255.0.0.0/9", "255.128.0.0/10", "255.192.0.0/11",
+            "255.224.0.0/12", "255.240.0.0/13", "255.248.0.0/14", "255.252.0.0/15", "255.254.0.0/16",
+            "255.255.0.0/17", "255.255.128.0/18", "255.255.192.0/19", "255.255.224.0/20", "255.255.240.0/21",
+            "255.255.248.0/22", "255.255.252.0/23", "255.255.254.0/24", "255.255.255.0/25", "255.255.255.128/26",
+            "255.255.255.192/27", "255.255.255.224/28", "255.255.255.240/29", "255.255.255.248/30",
+            "255.255.255.252/31", "255.255.255.254/32",
+        }},
+        {nil, true, []string{"::/0"}},
+        {[]string{"8000::/1"}, true, []string{"::/1"}},
+        {[]string{"::/1", "c000::/2"}, true, []string{"8000::/2"}},
+    }
+    for _, tt := range tests {
+        if got := invertPrefixes(tt.cidrs, tt.ipv6); !slices.Equal(got, tt.want) {
+            t.Errorf("invertPrefixes(%v, %t) = %v, want %v", tt.cidrs, tt.ipv6, got, tt.want)
+        }
+    }
+
+    // The complement of ::/128 in ::/1 is one prefix of every length from /128 to /2.
+    var want []string
+    for bits := 128; bits >= 2; bits-- {
+        var a [16]byte
+        a[(bits-1)/8] = 0x80 >> ((bits - 1) % 8)
+        want = append(want, netip.PrefixFrom(netip.AddrFrom16(a), bits).String())
+    }
+    if got := invertPrefixes([]string{"::/128", "8000::/1"}, true); !slices.Equal(got, want) {
+        t.Errorf("invertPrefixes(::/128, 8000::/1) = %v, want %v", got, want)
+    }
+
+    // Inverting twice gives the aggregated input back.
+    many := make([]string, 0, 5000)
+    for i := range 5000 {
+        many = append(many, fmt.Sprintf("2001:db8:%x::/48", i*2))
+    }
+    for _, tt := range []struct {
+        cidrs []string
+        ipv6  bool
+    }{
+        {[]string{"10.0.0.0/8", "10.1.0.0/16", "192.168.0.0/24", "192.168.1.0/24"}, false},
+        {[]string{"1.2.3.4/32", "200.0.0.0/5"}, false},
+        {[]string{"2001:db8::/32", "2001:db8:1::/48", "::1/128", "ffff::/16"}, true},
+        {many, true},
+    } {
+        got, want := invertPrefixes(invertPrefixes(tt.cidrs, tt.ipv6), tt.ipv6), aggregatePrefixes(tt.cidrs)
+        if tt.ipv6 {
+            sortIPv6Prefixes(want)
+        }
+        if !slices.Equal(got, want) {
+            t.Errorf("invertPrefixes twice of %d prefixes = %d prefixes, want %d", len(tt.cidrs), len(got), len(want))
+        }
+    }
+}
+
+// writeTestDump writes a small inetnum dump to a temporary directory and returns its path.
+func writeTestDump(t *testing.T) string {
+    t.Helper()
+    dump := `inetnum:        10.0.0.0 - 10.0.0.255
+netname:        NET-A
+country:        RU
+
+inetnum:        192.168.0.0 - 192.168.1.255
+netname:        NET-B
+country:        de
+
+inetnum:        10.0.0.0 - 10.0.0.127
+netname:        NET-A-SUB
+country:        RU
+`
+    path := filepath.Join(t.TempDir(), "ripe.db.inetnum")
+    if err := os.WriteFile(path, []byte(dump), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func TestBinaryDatabase(t *testing.T) {
+    dbPath := writeTestDump(t)
+    want, err := updateBinaryDatabase(dbPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(want) != 3 || want[0].Netname != "NET-A" || want[1].Netname != "NET-A-SUB" || want[2].Country != "DE" {
+        t.Fatalf("unexpected ranges in binary database order: %+v", want)
+    }
+
+    db, err := openBinaryDatabase(dbPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got []dbRange
+    err = db.scan(true, func(r dbRange) { got = append(got, r) })
+    db.file.Close()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !slices.Equal(got, want) {
+        t.Errorf("read back %+v, want %+v", got, want)
+    }
+
+    // Every truncation, in the header, the country table, the records or the netnames, is rejected.
+    data, err := os.ReadFile(binaryDBPath(dbPath))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, size := range []int{0, 3, binaryDBHeaderSize - 1, binaryDBHeaderSize, binaryDBHeaderSize + 2, len(data) - binaryDBRecordSize, len(data) - 1} {
+        if err := os.WriteFile(binaryDBPath(dbPath), data[:size], 0644); err != nil {
+            t.Fatal(err)
+        }
+        if db, err := openBinaryDatabase(dbPath); err == nil {
+            db.file.Close()
+            t.Errorf("binary database truncated to %d of %d bytes was accepted", size, len(data))
+        }
+    }
+
+    // So is a file of another version.
+    other := slices.Clone(data)
+    other[5]++
+    if err := os.WriteFile(binaryDBPath(dbPath), other, 0644); err != nil {
+        t.Fatal(err)
+    }
+    if db, err := openBinaryDatabase(dbPath); err == nil {
+        db.file.Close()
+        t.Error("binary database of another version was accepted")
+    }
+}
+
+func TestTrigramIndex(t *testing.T) {
+    dbPath := writeTestDump(t)
+    defer func(saved string) { ripedbPath = saved }(ripedbPath)
+    ripedbPath = dbPath
+    if err := buildTrigramIndex(dbPath); err != nil {
+        t.Fatal(err)
+    }
+
+    search := func(keyword string) []string {
+        t.Helper()
+        m, err := newSearchMatcher([]string{keyword})
+        if err != nil {
+            t.Fatal(err)
+        }
+        trigrams, ok := m.requiredTrigrams()
+        if !ok {
+            t.Fatalf("no trigrams for %q", keyword)
+        }
+        var netnames []string
+        found, err := scanTrigramIndex(dbPath, trigrams, func(blockLines []string) {
+            netnames = append(netnames, blockAttr(blockLines, "netname"))
+        })
+        if !found || err != nil {
+            t.Fatalf("scanTrigramIndex(%q) = %t, %v", keyword, found, err)
+        }
+        return netnames
+    }
+    // The dump is a single chunk: a keyword in it selects the chunk, one that is not selects nothing.
+    if got := search("net-b"); len(got) != 3 {
+        t.Errorf("search for net-b read blocks %v, want the whole chunk", got)
+    }
+    if got := search("absent"); len(got) != 0 {
+        t.Errorf("search for absent read blocks %v, want none", got)
+    }
+
+    // Every truncation of the header, the chunk table or the trigram directory is rejected.
+    data, err := os.ReadFile(trigramIndexPath(dbPath))
+    if err != nil {
+        t.Fatal(err)
+    }
+    headerSize := len(trigramIndexHeader(mustStat(t, dbPath))) + 8
+    for _, size := range []int{0, 3, headerSize - 1, headerSize, headerSize + 11, headerSize + 12 + trigramDirectorySize - 1} {
+        if err := os.WriteFile(trigramIndexPath(dbPath), data[:size], 0644); err != nil {
+            t.Fatal(err)
+        }
+        if idx, err := openTrigramIndex(dbPath); err == nil {
+            idx.file.Close()
+            t.Errorf("trigram index truncated to %d of %d bytes was accepted", size, len(data))
+        }
+    }
+
+    // A chunk count far beyond the file is rejected before anything is allocated for it.
+    huge := slices.Clone(data)
+    binary.BigEndian.PutUint32(huge[headerSize-8:], 0xffffffff)
+    if err := os.WriteFile(trigramIndexPath(dbPath), huge, 0644); err != nil {
+        t.Fatal(err)
+    }
+    if idx, err := openTrigramIndex(dbPath); err == nil {
+        idx.file.Close()
+        t.Error("trigram index with a damaged chunk count was accepted")
+    }
+}
+
+// mustStat returns the file information of path.
+func mustStat(t *testing.T, path string) os.FileInfo {
+    t.Helper()
+    fi, err := os.Stat(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return fi
+}
+
+func TestParsePrefixRange(t *testing.T) {
+    tests := []struct {
+        value string
+        want  string // Formatted back; empty if the value is invalid or matches nothing.
+    }{
+        {"10.0.0.0/8", "10.0.0.0/8"},
+        {"10.0.0.0/8^+", "10.0.0.0/8^+"},
+        {"10.0.0.0/8^-", "10.0.0.0/8^-"},
+        {"10.0.0.0/8^16", "10.0.0.0/8^16"},
+        {"10.0.0.0/8^16-24", "10.0.0.0/8^16-24"},
+        {"10.0.0.0/16^8-24", "10.0.0.0/16^16-24"},
+        {"10.0.0.0/16^8", ""},
+        {"10.0.0.0/24^24-33", ""},
+        {"0.0.0.0/0^+", "0.0.0.0/0^+"},
+        {"255.255.255.255/32^-", ""},
+        {"255.255.255.255/32^+", "255.255.255.255/32"},
+        {"10.0.0.0/8^x", ""},
+        {"2001:db8::/32", ""},
+    }
+    for _, tt := range tests {
+        r, ok := parsePrefixRange(tt.value)
+        got := ""
+        if ok {
+            got = r.String()
+        }
+        if got != tt.want {
+            t.Errorf("parsePrefixRange(%q) = %q, want %q", tt.value, got, tt.want)
+        }
+    }
+}
+
+// prefixesIn returns every prefix (start, length) inside base/baseLength with a length up to maxLength
+// that one of the ranges contains, as "start/length" keys.
+func prefixesIn(ranges []prefixRange, base uint32, baseLength, maxLength int) map[string]bool {
+    in := make(map[string]bool)
+    for length := 0; length <= maxLength; length++ {
+        step := uint64(1) << (32 - length)
+        first := uint64(base) &^ (step - 1)
+        if length > baseLength {
+            first = uint64(base)
+        }
+        last := uint64(base) | (uint64(1)<<(32-baseLength) - 1)
+        for start := first; start <= last; start += step {
+            for _, r := range ranges {
+                if length >= r.Min && length <= r.Max && r.covers(uint32(start), length) {
+                    in[fmt.Sprintf("%s/%d", uint32ToIP(uint32(start)), length)] = true
+                }
+            }
+        }
+    }
+    return in
+}
+
+func TestPrefixRangeMath(t *testing.T) {
+    parse := func(values ...string) []prefixRange {
+        var ranges []prefixRange
+        for _, v := range values {
+            r, ok := parsePrefixRange(v)
+            if !ok {
+                t.Fatalf("invalid prefix range %q", v)
+            }
+            ranges = append(ranges, r)
+        }
+        return ranges
+    }
+    // Set operations are checked against every prefix of 10.0.0.0/24 (and its covering prefixes) up to /30.
+    base, _ := parsePrefixRange("10.0.0.0/24")
+    universe := func(ranges []prefixRange) map[string]bool { return prefixesIn(ranges, base.Start, 24, 30) }
+    tests := []struct{ a, b []string }{
+        {[]string{"10.0.0.0/24^+"}, []string{"10.0.0.64/26^+"}},
+        {[]string{"10.0.0.0/24^24-28"}, []string{"10.0.0.64/26^26-27"}},
+        {[]string{"10.0.0.64/26^+"}, []string{"10.0.0.0/24^+"}},
+        {[]string{"10.0.0.0/24^-"}, []string{"10.0.0.0/24^25"}},
+        {[]string{"0.0.0.0/0^+"}, []string{"10.0.0.128/25^-", "10.0.0.4/30"}},
+        {[]string{"10.0.0.0/25^+", "10.0.0.128/25^27"}, []string{"10.0.0.0/24^26-28"}},
+    }
+    for _, tt := range tests {
+        a, b := parse(tt.a...), parse(tt.b...)
+        inA, inB := universe(a), universe(b)
+
+        difference := universe(subtractPrefixRanges(a, b))
+        for key := range inA {
+            if !inB[key] != difference[key] {
+                t.Errorf("%v minus %v: %s in result = %t", tt.a, tt.b, key, difference[key])
+            }
+        }
+        for key := range difference {
+            if !inA[key] {
+                t.Errorf("%v minus %v: %s in result but not in %v", tt.a, tt.b, key, tt.a)
+            }
+        }
+
+        var intersection []prefixRange
+        for _, x := range a {
+            for _, y := range b {
+                if r, ok := intersectRanges(x, y); ok {
+                    intersection = append(intersection, r)
+                }
+            }
+        }
+        both := universe(intersection)
+        for key := range inA {
+            if inB[key] != both[key] {
+                t.Errorf("%v and %v: %s in intersection = %t", tt.a, tt.b, key, both[key])
+            }
+        }
+
+        normalized := universe(normalizePrefixRanges(slices.Clone(a)))
+        if !maps.Equal(normalized, inA) {
+            t.Errorf("normalizePrefixRanges(%v) changed the prefixes it contains", tt.a)
+        }
+    }
+}
+
+func TestRPSLFilterEval(t *testing.T) {
+    tests := []struct {
+        filter string
+        want   []string
+    }{
+        {"{10.0.0.0/8, 192.168.0.0/16}", []string{"10.0.0.0/8", "192.168.0.0/16"}},
+        {"{10.0.0.0/8}^+ AND NOT {10.1.0.0/16}^+", []string{
+            "10.0.0.0/8^8-15", "10.0.0.0/16^+", "10.2.0.0/15^-", "10.4.0.0/14^16-32", "10.8.0.0/13^16-32",
+            "10.16.0.0/12^16-32", "10.32.0.0/11^16-32", "10.64.0.0/10^16-32", "10.128.0.0/9^16-32",
+        }},
+        {"{10.0.0.0/8}^+ AND {10.1.0.0/16^24}", []string{"10.1.0.0/16^24"}},
+        {"{10.0.0.0/8} OR {10.0.0.0/8}^16", []string{"10.0.0.0/8", "10.0.0.0/8^16"}},
+    }
+    for _, tt := range tests {
+        f, err := parseRPSLFilter(tt.filter)
+        if err != nil {
+            t.Fatalf("parseRPSLFilter(%q): %v", tt.filter, err)
+        }
+        var got []string
+        for _, r := range normalizePrefixRanges(f.eval(&rpslData{})) {
+            got = append(got, r.String())
+        }
+        if !slices.Equal(got, tt.want) {
+            t.Errorf("%s = %v, want %v", tt.filter, got, tt.want)
+        }
+    }
+
+    for _, filter := range []string{"", "(AS1", "{10.0.0.0/8", "AS1 AND", "RS-FOO", "{10.0.0.0/33}", "AS1^x"} {
+        if _, err := parseRPSLFilter(filter); err == nil {
+            t.Errorf("parseRPSLFilter(%q) accepted an invalid filter", filter)
+        }
+    }
+}
+
+func TestWriteOutputFileMode(t *testing.T) {
+    defer func(saved os.FileMode) { fileMode = saved }(fileMode)
+    fileMode = 0600
+    dir := t.TempDir()
+    path := filepath.Join(dir, "acl_RU.conf")
+    for _, content := range []string{"10.0.0.0/8;\n", "192.168.0.0/16;\n"} {
+        if err := writeOutputFile(path, content, outputMeta{Selection: "test", Entries: 1, Comment: "//"}); err != nil {
+            t.Fatal(err)
+        }
+        if perm := mustStat(t, path).Mode().Perm(); perm != 0600 {
+            t.Errorf("%s written with mode %o, want 600", path, perm)
+        }
+    }
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(entries) != 1 {
+        t.Errorf("temporary files left next to the output: %v", entries)
+    }
+}
+
+func TestTrendDatabase(t *testing.T) {
+    if _, err := exec.LookPath("sqlite3"); err != nil {
+        t.Skip("sqlite3 client not installed")
+    }
+    defer func(saved string) { cacheDir = saved }(cacheDir)
+    cacheDir = t.TempDir()
+
+    s := &serverState{
+        countryPrefixes:  map[string]int{"RU": 10, "UA": 3},
+        countryAddresses: map[string]uint64{"RU": 1000, "UA": 300},
+    }
+    s.recordTrend()
+    s.countryPrefixes["RU"], s.countryAddresses["RU"] = 11, 1024
+    s.recordTrend()
+
+    samples, err := loadTrend([]string{"RU"}, time.Time{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(samples) != 2 || samples[0].Addresses != 1000 || samples[1].Prefixes != 11 || samples[1].Country != "RU" {
+        t.Errorf("loadTrend(RU) = %+v", samples)
+    }
+    if all, err := loadTrend(nil, time.Time{}); err != nil || len(all) != 4 {
+        t.Errorf("loadTrend() = %d samples, %v; want 4", len(all), err)
+    }
+    if future, err := loadTrend(nil, time.Now().Add(time.Hour)); err != nil || len(future) != 0 {
+        t.Errorf("loadTrend(since an hour ahead) = %d samples, %v; want none", len(future), err)
+    }
+}
+
+func TestObjectIndex(t *testing.T) {
+    defer func(saved string) { cacheDir = saved }(cacheDir)
+    cacheDir = t.TempDir()
+    dump := "% RIPE database dump\n\n" +
+        "inetnum:        193.0.0.0 - 193.0.7.255\nnetname:        RIPE-NCC\ncountry:        NL\n\n" +
+        "role:           RIPE NCC Operations\nnic-hdl:        OPS4-RIPE\n\n" +
+        "aut-num:        AS3333\nas-name:        RIPE-NCC-AS\n"
+    if err := os.WriteFile(fullDBPath(), []byte(dump), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    idx, err := loadObjectIndex()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(idx.Types["inetnum"]) != 1 || len(idx.Types["role"]) != 1 || len(idx.Keys) != 2 {
+        t.Errorf("index = %+v", idx)
+    }
+    if _, err := os.Stat(objectIndexPath()); err != nil {
+        t.Errorf("index not saved: %v", err)
+    }
+
+    role, err := lookupObject("role", "ops4-ripe")
+    if err != nil || blockAttr(role, "role") != "RIPE NCC Operations" {
+        t.Errorf("lookupObject(role) = %q, %v", role, err)
+    }
+    autNum, err := lookupObject("aut-num", "AS3333")
+    if err != nil || len(autNum) != 2 || blockAttr(autNum, "as-name") != "RIPE-NCC-AS" {
+        t.Errorf("lookupObject(aut-num) = %q, %v", autNum, err)
+    }
+    if missing, err := lookupObject("aut-num", "AS1"); err != nil || missing != nil {
+        t.Errorf("lookupObject(AS1) = %q, %v; want nothing", missing, err)
+    }
+    var netnames []string
+    if err := scanObjects("inetnum", func(blockLines []string) { netnames = append(netnames, blockAttr(blockLines, "netname")) }); err != nil || !slices.Equal(netnames, []string{"RIPE-NCC"}) {
+        t.Errorf("scanObjects(inetnum) = %q, %v", netnames, err)
+    }
+}
+
+// fakeRESPServer accepts one connection and answers every RESP command with reply(command);
+// received returns the commands seen so far.
+func fakeRESPServer(t *testing.T, reply func(args []string) string) (addr string, received func() [][]string) {
+    t.Helper()
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { listener.Close() })
+    var mu sync.Mutex
+    var commands [][]string
+    go func() {
+        conn, err := listener.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        r := bufio.NewReader(conn)
+        for {
+            var count int
+            if _, err := fmt.Fscanf(r, "*%d\r\n", &count); err != nil {
+                return
+            }
+            args := make([]string, count)
+            for i := range args {
+                var size int
+                if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+                    return
+                }
+                buf := make([]byte, size+2)
+                if _, err := io.ReadFull(r, buf); err != nil {
+                    return
+                }
+                args[i] = string(buf[:size])
+            }
+            mu.Lock()
+            commands = append(commands, args)
+            mu.Unlock()
+            if _, err := io.WriteString(conn, reply(args)); err != nil {
+                return
+            }
+        }
+    }()
+    return listener.Addr().String(), func() [][]string {
+        mu.Lock()
+        defer mu.Unlock()
+        return slices.Clone(commands)
+    }
+}
+
+func TestLoadRedisKey(t *testing.T) {
+    cidrs := make([]string, 2500)
+    for i := range cidrs {
+        cidrs[i] = fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)
+    }
+    addr, received := fakeRESPServer(t, func(args []string) string {
+        if args[0] == "RENAME" {
+            return "+OK\r\n"
+        }
+        return ":1\r\n"
+    })
+    conn, err := dialRedis(addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    if err := loadRedisKey(conn, "geo:RU", cidrs, false); err != nil {
+        t.Fatal(err)
+    }
+    tmpKey := fmt.Sprintf("geo:RU:tmp:%d", os.Getpid())
+    commands := received()
+    var names []string
+    for _, command := range commands {
+        names = append(names, command[0])
+    }
+    if !slices.Equal(names, []string{"DEL", "SADD", "SADD", "SADD", "RENAME"}) {
+        t.Fatalf("commands = %v", names)
+    }
+    if got := len(commands[1]) + len(commands[2]) + len(commands[3]) - 6; got != len(cidrs) {
+        t.Errorf("SADD carried %d prefixes, want %d", got, len(cidrs))
+    }
+    if !slices.Equal(commands[4], []string{"RENAME", tmpKey, "geo:RU"}) {
+        t.Errorf("RENAME = %q", commands[4])
+    }
+}
+
+func TestLoadRedisKeyErrors(t *testing.T) {
+    addr, received := fakeRESPServer(t, func(args []string) string {
+        switch args[0] {
+        case "ZADD":
+            if len(args) != 6 || args[2] != "167772415" || args[3] != "10.0.0.0/24" {
+                return "-ERR unexpected arguments\r\n"
+            }
+            return ":2\r\n"
+        case "RENAME":
+            return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+        }
+        return ":1\r\n"
+    })
+    conn, err := dialRedis(addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    err = loadRedisKey(conn, "geo:RU:ranges", []string{"10.0.0.0/24", "10.0.1.0/24"}, true)
+    if err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+        t.Fatalf("loadRedisKey() = %v, want the RENAME error", err)
+    }
+    commands := received()
+    if last := commands[len(commands)-1]; last[0] != "DEL" || !strings.HasPrefix(last[1], "geo:RU:ranges:tmp:") {
+        t.Errorf("temporary key not removed after the failure: %q", commands)
+    }
+}
+
+func TestRedisReadReply(t *testing.T) {
+    tests := []struct {
+        reply string
+        want  any
+        err   bool
+    }{
+        {"+OK\r\n", "OK", false},
+        {":42\r\n", int64(42), false},
+        {"$5\r\nhello\r\n", "hello", false},
+        {"$-1\r\n", nil, false},
+        {"*2\r\n$1\r\na\r\n:1\r\n", []any{"a", int64(1)}, false},
+        {"-ERR unknown command\r\n", nil, true},
+        {"$5\r\nhel", nil, true},
+        {"?\r\n", nil, true},
+    }
+    for _, tt := range tests {
+        c := &redisConn{r: bufio.NewReader(strings.NewReader(tt.reply))}
+        got, err := c.readReply()
+        if (err != nil) != tt.err || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+            t.Errorf("readReply(%q) = %v, %v; want %v (error %v)", tt.reply, got, err, tt.want, tt.err)
+        }
+    }
+}
+
+func TestBackupOutputMode(t *testing.T) {
+    defer func(savedMode os.FileMode, savedBackups int) { fileMode, backups = savedMode, savedBackups }(fileMode, backups)
+    backups = 3
+    for _, tt := range []struct {
+        mode, want os.FileMode
+    }{
+        {0, 0600},
+        {0640, 0640},
+    } {
+        fileMode = tt.mode
+        path := filepath.Join(t.TempDir(), "acl_RU.conf")
+        if err := os.WriteFile(path, []byte("10.0.0.0/8;\n"), 0600); err != nil {
+            t.Fatal(err)
+        }
+        if err := backupOutput(path); err != nil {
+            t.Fatal(err)
+        }
+        matches, _ := filepath.Glob(path + ".*")
+        if len(matches) != 1 {
+            t.Fatalf("backups = %v, want one", matches)
+        }
+        if perm := mustStat(t, matches[0]).Mode().Perm(); perm != tt.want {
+            t.Errorf("--mode %o: backup written with mode %o, want %o", tt.mode, perm, tt.want)
+        }
+    }
+}
+
+func TestPBDecode(t *testing.T) {
+    var msg []byte
+    msg = pbAppendString(msg, 1, "RU")
+    msg = pbAppendBool(msg, 2, true)
+    msg = pbAppendString(msg, 3, "")     // Omitted.
+    msg = pbAppendBool(msg, 4, false)    // Omitted.
+    msg = append(msg, 5<<3|5, 1, 2, 3, 4) // fixed32, skipped.
+    msg = append(msg, 6<<3|1, 1, 2, 3, 4, 5, 6, 7, 8)
+    msg = pbAppendString(msg, 300, strings.Repeat("x", 200))
+    fields, err := pbDecode(msg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(fields) != 3 || fields[0].Number != 1 || string(fields[0].Bytes) != "RU" ||
+        fields[1].Number != 2 || fields[1].Varint != 1 || fields[2].Number != 300 || len(fields[2].Bytes) != 200 {
+        t.Errorf("pbDecode() = %+v", fields)
+    }
+
+    for _, tt := range []struct {
+        name string
+        msg  []byte
+    }{
+        {"truncated key", []byte{0x80}},
+        {"truncated varint", []byte{2 << 3, 0xff}},
+        {"overlong varint", append([]byte{2 << 3}, bytes.Repeat([]byte{0xff}, 11)...)},
+        {"truncated string", []byte{1<<3 | 2, 5, 'a'}},
+        {"huge string length", []byte{1<<3 | 2, 0xff, 0xff, 0xff, 0xff, 0x0f}},
+        {"truncated fixed32", []byte{1<<3 | 5, 1}},
+        {"truncated fixed64", []byte{1<<3 | 1, 1, 2, 3}},
+        {"group wire type", []byte{1<<3 | 3}},
+    } {
+        if fields, err := pbDecode(tt.msg); err == nil {
+            t.Errorf("pbDecode(%s) = %+v, want an error", tt.name, fields)
+        }
+    }
+}
+
+// grpcFrame wraps a message in the 5-byte gRPC frame header.
+func grpcFrame(flags byte, size uint32, msg []byte) []byte {
+    header := []byte{flags, 0, 0, 0, 0}
+    binary.BigEndian.PutUint32(header[1:], size)
+    return append(header, msg...)
+}
+
+func TestHandleGRPC(t *testing.T) {
+    s := &serverState{}
+    search := func(keywords ...string) []byte {
+        msg := pbAppendString(nil, 1, "RU")
+        for _, kw := range keywords {
+            msg = pbAppendString(msg, 2, kw)
+        }
+        return grpcFrame(0, uint32(len(msg)), msg)
+    }
+    lookup := pbAppendString(nil, 1, "193.0.0.1")
+    tests := []struct {
+        name   string
+        method string
+        body   []byte
+        status string
+    }{
+        {"empty body", "Lookup", nil, "3"},
+        {"short header", "Lookup", []byte{0, 0, 0}, "3"},
+        {"compressed", "Lookup", grpcFrame(1, uint32(len(lookup)), lookup), "12"},
+        {"oversized", "Lookup", grpcFrame(0, grpcMaxRequestSize+1, nil), "8"},
+        {"truncated", "Lookup", grpcFrame(0, 100, lookup), "3"},
+        {"malformed", "Lookup", grpcFrame(0, 2, []byte{1<<3 | 2, 5}), "3"},
+        {"no index", "Lookup", grpcFrame(0, uint32(len(lookup)), lookup), "14"},
+        {"no country", "ExtractCountry", grpcFrame(0, 0, nil), "3"},
+        {"no search terms", "Search", grpcFrame(0, 0, nil), "3"},
+        {"bad regex", "Search", search("/net[/"), "3"},
+        {"unknown method", "Delete", grpcFrame(0, 0, nil), "12"},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest(http.MethodPost, "/chicha.whois.v1.Whois/"+tt.method, bytes.NewReader(tt.body))
+        r.Header.Set("Content-Type", "application/grpc")
+        w := httptest.NewRecorder()
+        s.handleGRPC(w, r)
+        result := w.Result()
+        if got := result.Trailer.Get("Grpc-Status"); got != tt.status {
+            t.Errorf("%s: grpc-status %q (%s), want %s", tt.name, got, result.Trailer.Get("Grpc-Message"), tt.status)
+        }
+        if w.Body.Len() != 0 {
+            t.Errorf("%s: %d bytes of messages sent with an error status", tt.name, w.Body.Len())
+        }
+    }
+
+    r := httptest.NewRequest(http.MethodGet, "/chicha.whois.v1.Whois/Lookup", nil)
+    w := httptest.NewRecorder()
+    s.handleGRPC(w, r)
+    if w.Code != http.StatusUnsupportedMediaType {
+        t.Errorf("GET: status %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+    }
+}
+
+func TestCheckGeofeed(t *testing.T) {
+    feed := []byte("# prefix,country,region,city\n" +
+        "193.0.0.0/24,NL,NL-NH,Amsterdam\n" +
+        "198.51.100.0/24,US,,\n" +
+        "2001:db8::/32,DE,,\n" +
+        "not-a-prefix,RU,,\n" +
+        "192.0.2.0/24,Russia,,\n")
+    start, end, _ := parseInetnumRange("193.0.0.0 - 193.0.7.255")
+    scope := []addrInterval{{start, end}}
+
+    check := checkGeofeed(feed, scope)
+    if check.Err != nil || check.Entries != 2 || check.Outside != 1 || check.Invalid != 2 {
+        t.Errorf("IPv4 scope: %+v, want 2 entries, 1 outside, 2 invalid", check)
+    }
+    // A feed referred to only by inet6num objects has no IPv4 scope to check against.
+    check = checkGeofeed(feed, nil)
+    if check.Err != nil || check.Entries != 3 || check.Outside != 0 || check.Invalid != 2 {
+        t.Errorf("no scope: %+v, want 3 entries, 0 outside, 2 invalid", check)
+    }
+}
+
+func TestRouterOSWordLength(t *testing.T) {
+    for _, n := range []int{0, 0x7f, 0x80, 0x3fff, 0x4000, routerOSMaxWord} {
+        var buf bytes.Buffer
+        c := &routerOSConn{r: bufio.NewReader(&buf), w: bufio.NewWriter(&buf)}
+        c.writeLength(n)
+        c.w.Flush()
+        size := buf.Len()
+        if got, err := c.readLength(); err != nil || got != n {
+            t.Errorf("length %d (%d bytes) read back as %d, %v", n, size, got, err)
+        }
+    }
+
+    // A length beyond routerOSMaxWord comes from a broken or hostile peer and is not allocated.
+    for _, encoded := range [][]byte{{0xE0, 0x10, 0x00, 0x01}, {0xF0, 0xff, 0xff, 0xff, 0xff}} {
+        c := &routerOSConn{r: bufio.NewReader(bytes.NewReader(encoded))}
+        if n, err := c.readLength(); err == nil {
+            t.Errorf("length % x read as %d, want an error", encoded, n)
+        }
+    }
+}
+
+// fakeRouterOS serves the RouterOS API on a local port: reply gets the words of each sentence
+// received and returns the sentences to answer with. received returns the sentences so far.
+func fakeRouterOS(t *testing.T, reply func(words []string) [][]string) (addr string, received func() [][]string) {
+    t.Helper()
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { listener.Close() })
+    var mu sync.Mutex
+    var sentences [][]string
+    go func() {
+        conn, err := listener.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        c := &routerOSConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+        for {
+            var words []string
+            for {
+                n, err := c.readLength()
+                if err != nil {
+                    return
+                }
+                if n == 0 {
+                    break
+                }
+                buf := make([]byte, n)
+                if _, err := io.ReadFull(c.r, buf); err != nil {
+                    return
+                }
+                words = append(words, string(buf))
+            }
+            mu.Lock()
+            sentences = append(sentences, words)
+            mu.Unlock()
+            for _, answer := range reply(words) {
+                c.send(answer...)
+            }
+            if err := c.w.Flush(); err != nil {
+                return
+            }
+        }
+    }()
+    return listener.Addr().String(), func() [][]string {
+        mu.Lock()
+        defer mu.Unlock()
+        return slices.Clone(sentences)
+    }
+}
+
+func TestRouterOSAPI(t *testing.T) {
+    addr, received := fakeRouterOS(t, func(words []string) [][]string {
+        switch words[0] {
+        case "/login":
+            return [][]string{{"!done"}}
+        case "/ip/firewall/address-list/print":
+            return [][]string{
+                {"!re", "=.id=*1", "=address=10.0.0.0/8", "=dynamic=false"},
+                {"!re", "=.id=*2", "=address=192.0.2.1", "=dynamic=true"},
+                {"!done"},
+            }
+        case "/ip/firewall/address-list/add":
+            return [][]string{{"!trap", "=message=failure: already have such entry"}, {"!done"}}
+        }
+        return [][]string{{"!fatal", "=message=not logged in"}}
+    })
+    c, err := dialRouterOS(mikrotikRouter{Address: addr, User: "admin", Password: "p=ss word"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    if login := received()[0]; !slices.Equal(login, []string{"/login", "=name=admin", "=password=p=ss word"}) {
+        t.Errorf("login sentence = %q", login)
+    }
+
+    c.send("/ip/firewall/address-list/print", "?list=blocked")
+    c.send("/ip/firewall/address-list/add", "=list=blocked", "=address=10.0.0.0/8")
+    c.send("/system/reboot")
+    if err := c.flush(); err != nil {
+        t.Fatal(err)
+    }
+    rows, err := c.readUntilDone()
+    if err != nil || len(rows) != 2 || rows[0][".id"] != "*1" || rows[0]["address"] != "10.0.0.0/8" || rows[1]["dynamic"] != "true" {
+        t.Errorf("print = %v, %v", rows, err)
+    }
+    if _, err := c.readUntilDone(); err == nil || err.Error() != "routeros: failure: already have such entry" {
+        t.Errorf("add = %v, want the !trap message", err)
+    }
+    if _, err := c.readUntilDone(); err == nil || err.Error() != "routeros: not logged in" {
+        t.Errorf("reboot = %v, want the !fatal message", err)
+    }
+}
+
+func TestRouterOSLoginFailure(t *testing.T) {
+    addr, _ := fakeRouterOS(t, func(words []string) [][]string {
+        return [][]string{{"!trap", "=message=invalid user name or password (6)"}, {"!done"}}
+    })
+    c, err := dialRouterOS(mikrotikRouter{Address: addr, User: "admin", Password: "wrong"})
+    if err == nil {
+        c.Close()
+        t.Fatal("login with a rejected password succeeded")
+    }
+    if !strings.Contains(err.Error(), "login failed") || !strings.Contains(err.Error(), "invalid user name") {
+        t.Errorf("dialRouterOS() = %v", err)
+    }
+}
+
+func TestUniFiClient(t *testing.T) {
+    var mu sync.Mutex
+    var requests []string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        mu.Lock()
+        requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-CSRF-Token")+" "+string(body))
+        mu.Unlock()
+        switch r.URL.Path {
+        case "/api/auth/login":
+            var login map[string]string
+            if json.Unmarshal(body, &login) != nil || login["username"] != "admin" || login["password"] != "secret" {
+                http.Error(w, `{"errors":["api.err.Invalid"]}`, http.StatusUnauthorized)
+                return
+            }
+            http.SetCookie(w, &http.Cookie{Name: "TOKEN", Value: "session", Path: "/"})
+            w.Header().Set("X-CSRF-Token", "csrf-1")
+        case "/proxy/network/api/s/branch/rest/firewallgroup":
+            if cookie, err := r.Cookie("TOKEN"); err != nil || cookie.Value != "session" {
+                http.Error(w, `{"meta":{"rc":"error","msg":"api.err.LoginRequired"}}`, http.StatusUnauthorized)
+                return
+            }
+            if r.Method == http.MethodGet {
+                io.WriteString(w, `{"meta":{"rc":"ok"},"data":[{"_id":"g1","name":"chicha_RU","group_type":"address-group","group_members":["10.0.0.0/8"]}]}`)
+                return
+            }
+            io.WriteString(w, `{"meta":{"rc":"ok"},"data":[]}`)
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer server.Close()
+
+    if _, err := loginUniFi(unifiController{URL: server.URL, User: "admin", Password: "wrong", UniFiOS: true}); err == nil || !strings.Contains(err.Error(), "401") {
+        t.Errorf("login with a wrong password = %v, want a 401 error", err)
+    }
+
+    c, err := loginUniFi(unifiController{URL: server.URL + "/", User: "admin", Password: "secret", Site: "branch", UniFiOS: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if c.api != server.URL+"/proxy/network/api/s/branch" || c.csrf != "csrf-1" {
+        t.Errorf("api %q, csrf %q after login", c.api, c.csrf)
+    }
+    var groups []unifiFirewallGroup
+    if _, err := c.do(http.MethodGet, c.api+"/rest/firewallgroup", nil, &groups); err != nil {
+        t.Fatal(err)
+    }
+    if len(groups) != 1 || groups[0].ID != "g1" || groups[0].Type != "address-group" || !slices.Equal(groups[0].Members, []string{"10.0.0.0/8"}) {
+        t.Errorf("firewall groups = %+v", groups)
+    }
+    group := unifiFirewallGroup{ID: "g1", Name: "chicha_RU", Type: "address-group", Members: []string{"10.0.0.0/8", "192.0.2.0/24"}}
+    if _, err := c.do(http.MethodPut, c.api+"/rest/firewallgroup/g1", group, nil); err == nil || !strings.Contains(err.Error(), "404") {
+        t.Errorf("PUT to an unknown path = %v, want a 404 error", err)
+    }
+    mu.Lock()
+    last := requests[len(requests)-1]
+    mu.Unlock()
+    want := `PUT /proxy/network/api/s/branch/rest/firewallgroup/g1 csrf-1 {"_id":"g1","name":"chicha_RU","group_type":"address-group","group_members":["10.0.0.0/8","192.0.2.0/24"]}`
+    if last != want {
+        t.Errorf("last request = %s, want %s", last, want)
+    }
+
+    // The classic controller has the API at the root and no CSRF token.
+    classic, err := loginUniFi(unifiController{URL: server.URL, User: "admin", Password: "secret"})
+    if err == nil || classic != nil || !strings.Contains(err.Error(), "/api/login") {
+        t.Errorf("classic login against a UniFi OS console = %v, want a 404 for /api/login", err)
+    }
+}
+
+// usePushConfig writes config as the configuration file and points the database at writeTestDump,
+// in which DE is 192.168.0.0/23, for the rest of the test. errors returns the number of errors
+// printed since.
+func usePushConfig(t *testing.T, config fileConfig) (errors func() int) {
+    t.Helper()
+    data, err := json.Marshal(config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    path := filepath.Join(t.TempDir(), "config.json")
+    if err := os.WriteFile(path, data, 0600); err != nil {
+        t.Fatal(err)
+    }
+    savedConfig, savedDB, savedCache := configPath, ripedbPath, cacheDir
+    t.Cleanup(func() { configPath, ripedbPath, cacheDir = savedConfig, savedDB, savedCache })
+    configPath, ripedbPath, cacheDir = path, writeTestDump(t), t.TempDir()
+
+    count := func() int {
+        statusMu.Lock()
+        defer statusMu.Unlock()
+        return statusCounts["error"]
+    }
+    before := count()
+    return func() int { return count() - before }
+}
+
+func TestOPNsensePush(t *testing.T) {
+    var mu sync.Mutex
+    var requests []string
+    aliases := map[string]string{"chicha_RU": "6a8b1c2d"}
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        mu.Lock()
+        requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+        mu.Unlock()
+        if key, secret, ok := r.BasicAuth(); !ok || key != "key" || secret != "secret" {
+            http.Error(w, `{"status":401,"message":"Authentication Failed"}`, http.StatusUnauthorized)
+            return
+        }
+        if r.Method == http.MethodPost && r.Header.Get("Content-Type") != "application/json" {
+            http.Error(w, "missing content type", http.StatusBadRequest)
+            return
+        }
+        switch {
+        case strings.HasPrefix(r.URL.Path, "/api/firewall/alias/getAliasUUID/"):
+            if uuid, ok := aliases[strings.TrimPrefix(r.URL.Path, "/api/firewall/alias/getAliasUUID/")]; ok {
+                fmt.Fprintf(w, `{"uuid":%q}`, uuid)
+                return
+            }
+            io.WriteString(w, `[]`)
+        case r.URL.Path == "/api/firewall/alias/addItem", r.URL.Path == "/api/firewall/alias/setItem/6a8b1c2d":
+            io.WriteString(w, `{"result":"saved","uuid":"6a8b1c2d"}`)
+        case r.URL.Path == "/api/firewall/alias/reconfigure":
+            io.WriteString(w, `{"status":"ok"}`)
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer server.Close()
+    errors := usePushConfig(t, fileConfig{OPNsense: map[string]opnsenseFirewall{
+        "fw":  {URL: server.URL, Key: "key", Secret: "secret"},
+        "bad": {URL: server.URL, Key: "key", Secret: "wrong"},
+    }})
+
+    pushOPNsense([]string{"DE"}, "fw", "chicha_RU")
+    if errors() != 0 {
+        t.Fatalf("push reported %d errors", errors())
+    }
+    want := []string{
+        "GET /api/firewall/alias/getAliasUUID/chicha_RU ",
+        `POST /api/firewall/alias/setItem/6a8b1c2d {"alias":{"content":"192.168.0.0/23","description":"chicha-whois DE","enabled":"1","name":"chicha_RU","type":"network"}}`,
+        "POST /api/firewall/alias/reconfigure {}",
+    }
+    if !slices.Equal(requests, want) {
+        t.Errorf("requests = %q, want %q", requests, want)
+    }
+
+    // A new alias is added; a rejected key stops the push before anything is written.
+    requests = nil
+    pushOPNsense([]string{"DE"}, "fw", "chicha_DE")
+    if len(requests) != 3 || !strings.HasPrefix(requests[1], "POST /api/firewall/alias/addItem ") {
+        t.Errorf("requests for a new alias = %q", requests)
+    }
+    requests = nil
+    pushOPNsense([]string{"DE"}, "bad", "chicha_DE")
+    if errors() != 1 || len(requests) != 1 {
+        t.Errorf("push with a rejected key: %d errors after %q", errors(), requests)
+    }
+}
+
+func TestPfSensePush(t *testing.T) {
+    var requests []string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        requests = append(requests, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+        if r.Header.Get("X-API-Key") != "key" {
+            http.Error(w, `{"code":401,"status":"unauthorized","message":"Authentication failed"}`, http.StatusUnauthorized)
+            return
+        }
+        switch r.URL.Path {
+        case "/api/v2/firewall/aliases":
+            switch r.URL.Query().Get("name") {
+            case "chicha_DE":
+                io.WriteString(w, `{"code":200,"data":[{"id":4,"name":"chicha_DE","type":"network","descr":"","address":["10.0.0.0/8"]}]}`)
+            case "chicha_SAME":
+                io.WriteString(w, `{"code":200,"data":[{"id":5,"name":"chicha_SAME","type":"network","descr":"","address":["192.168.0.0/23"]}]}`)
+            case "servers":
+                io.WriteString(w, `{"code":200,"data":[{"id":6,"name":"servers","type":"host","descr":"","address":["192.168.0.10"]}]}`)
+            default:
+                io.WriteString(w, `{"code":200,"data":[]}`)
+            }
+        case "/api/v2/firewall/alias", "/api/v2/firewall/apply":
+            io.WriteString(w, `{"code":200,"data":{}}`)
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer server.Close()
+    errors := usePushConfig(t, fileConfig{PfSense: map[string]pfsenseFirewall{
+        "fw":  {URL: server.URL, Key: "key"},
+        "bad": {URL: server.URL, Key: "wrong"},
+    }})
+
+    pushPfSense([]string{"DE"}, "fw", "chicha_DE")
+    want := []string{
+        "GET /api/v2/firewall/aliases?name=chicha_DE ",
+        `PATCH /api/v2/firewall/alias {"id":4,"name":"chicha_DE","type":"network","descr":"chicha-whois DE","address":["192.168.0.0/23"]}`,
+        "POST /api/v2/firewall/apply {}",
+    }
+    if errors() != 0 || !slices.Equal(requests, want) {
+        t.Errorf("%d errors, requests = %q, want %q", errors(), requests, want)
+    }
+
+    requests = nil
+    pushPfSense([]string{"DE"}, "fw", "chicha_NEW")
+    if len(requests) != 3 || requests[1] != `POST /api/v2/firewall/alias {"name":"chicha_NEW","type":"network","descr":"chicha-whois DE","address":["192.168.0.0/23"]}` {
+        t.Errorf("requests for a new alias = %q", requests)
+    }
+
+    // An alias that is up to date is not written, and neither is one of another type.
+    requests = nil
+    pushPfSense([]string{"DE"}, "fw", "chicha_SAME")
+    if errors() != 0 || len(requests) != 1 {
+        t.Errorf("up-to-date alias: %d errors after %q", errors(), requests)
+    }
+    requests = nil
+    pushPfSense([]string{"DE"}, "fw", "servers")
+    if errors() != 1 || len(requests) != 1 {
+        t.Errorf("host alias: %d errors after %q, want 1 error and no change", errors(), requests)
+    }
+    requests = nil
+    pushPfSense([]string{"DE"}, "bad", "chicha_DE")
+    if errors() != 2 || len(requests) != 1 {
+        t.Errorf("rejected key: %d errors after %q", errors(), requests)
+    }
+}
+
+func TestNetBoxPush(t *testing.T) {
+    var requests []string
+    var server *httptest.Server
+    server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        requests = append(requests, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+        if r.Header.Get("Authorization") != "Token secret" {
+            http.Error(w, `{"detail":"Invalid token"}`, http.StatusForbidden)
+            return
+        }
+        switch r.Method + " " + r.URL.Path {
+        case "GET /api/ipam/rirs/":
+            if r.URL.Query().Get("slug") == "ripe" {
+                io.WriteString(w, `{"count":1,"results":[{"id":7,"slug":"ripe"}]}`)
+                return
+            }
+            io.WriteString(w, `{"count":0,"results":[]}`)
+        case "GET /api/extras/tags/":
+            if r.URL.Query().Get("slug") == "country-de" {
+                io.WriteString(w, `{"count":1,"results":[{"id":3,"slug":"country-de"}]}`)
+                return
+            }
+            io.WriteString(w, `{"count":0,"results":[]}`)
+        case "GET /api/ipam/aggregates/":
+            if r.URL.Query().Get("offset") == "" {
+                fmt.Fprintf(w, `{"count":2,"next":"%s/api/ipam/aggregates/?limit=1000&offset=1000&tag=country-de","results":[{"id":21,"prefix":"10.0.0.0/8"}]}`, server.URL)
+                return
+            }
+            io.WriteString(w, `{"count":2,"next":null,"results":[{"id":22,"prefix":"192.168.0.0/24"}]}`)
+        case "DELETE /api/ipam/aggregates/":
+            w.WriteHeader(http.StatusNoContent)
+        case "POST /api/ipam/aggregates/", "POST /api/ipam/prefixes/", "POST /api/extras/tags/":
+            w.WriteHeader(http.StatusCreated)
+            io.WriteString(w, `[]`)
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer server.Close()
+    errors := usePushConfig(t, fileConfig{NetBox: map[string]netboxInstance{
+        "ipam":  {URL: server.URL, Token: "secret"},
+        "other": {URL: server.URL, Token: "secret", RIR: "arin"},
+    }})
+
+    // Tagged objects on every page that are not in the registry are deleted and the missing ones created.
+    pushNetBox([]string{"DE"}, "ipam", "aggregate", "country-", false)
+    want := []string{
+        "GET /api/ipam/rirs/?slug=ripe ",
+        "GET /api/extras/tags/?slug=country-de ",
+        "GET /api/ipam/aggregates/?tag=country-de&limit=1000 ",
+        "GET /api/ipam/aggregates/?limit=1000&offset=1000&tag=country-de ",
+        `DELETE /api/ipam/aggregates/ [{"id":21},{"id":22}]`,
+        `POST /api/ipam/aggregates/ [{"description":"RIPE NCC country DE (chicha-whois)","prefix":"192.168.0.0/23","rir":7,"tags":[{"slug":"country-de"}]}]`,
+    }
+    if errors() != 0 || !slices.Equal(requests, want) {
+        t.Errorf("%d errors, requests = %q, want %q", errors(), requests, want)
+    }
+
+    // Without the tag nothing was synced yet: the tag is created before the prefixes.
+    requests = nil
+    pushNetBox([]string{"DE"}, "ipam", "prefix", "geo-", false)
+    want = []string{
+        "GET /api/extras/tags/?slug=geo-de ",
+        `POST /api/extras/tags/ {"name":"geo-de","slug":"geo-de"}`,
+        `POST /api/ipam/prefixes/ [{"description":"RIPE NCC country DE (chicha-whois)","prefix":"192.168.0.0/23","tags":[{"slug":"geo-de"}]}]`,
+    }
+    if errors() != 0 || !slices.Equal(requests, want) {
+        t.Errorf("%d errors, requests = %q, want %q", errors(), requests, want)
+    }
+
+    // An unknown RIR stops the aggregate push before anything is read or written.
+    requests = nil
+    pushNetBox([]string{"DE"}, "other", "aggregate", "country-", false)
+    if errors() != 1 || len(requests) != 1 {
+        t.Errorf("unknown RIR: %d errors after %q", errors(), requests)
+    }
+}